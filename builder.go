@@ -0,0 +1,334 @@
+package ego
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// Builder constructs a template programmatically. Blocks are appended in
+// order and positioned as if each was written on the line after the previous
+// one, accounting for newlines in their content.
+//
+// Components, attribute blocks, format regions & yield regions are opened by
+// Component, AttrBlock, Format & Yield and closed by End. Blocks appended
+// while one is open are added to its body. The first error, such as an
+// invalid expression or an unbalanced End, is retained and returned by
+// Template. Directives that read other files, such as include & extends, and
+// component loaders are not supported.
+type Builder struct {
+	path   string
+	lineNo int
+	blocks []Block
+	stack  []Block // open component, attribute, format & yield start blocks
+	err    error
+}
+
+// NewBuilder returns a builder for a template with the given path. The path
+// is used in the compiled template's pragmas.
+func NewBuilder(path string) *Builder {
+	return &Builder{path: path, lineNo: 1}
+}
+
+// Text appends literal text.
+func (b *Builder) Text(s string) *Builder {
+	if s != "" {
+		b.append(&TextBlock{Pos: b.pos(), Content: s}, s)
+	}
+	return b
+}
+
+// Code appends Go source code, such as a package clause or a statement.
+func (b *Builder) Code(src string) *Builder {
+	b.append(&CodeBlock{Pos: b.pos(), Content: src}, src)
+	return b
+}
+
+// Print appends a print block which writes the HTML-escaped value of expr.
+func (b *Builder) Print(expr string) *Builder {
+	if b.checkExpr(expr, "print") {
+		b.append(&PrintBlock{Pos: b.pos(), Content: expr}, expr)
+	}
+	return b
+}
+
+// RawPrint appends a print block which writes the value of expr unescaped.
+func (b *Builder) RawPrint(expr string) *Builder {
+	if b.checkExpr(expr, "print") {
+		b.append(&RawPrintBlock{Pos: b.pos(), Content: expr}, expr)
+	}
+	return b
+}
+
+// Comment appends a template comment, which is removed from the generated
+// code unless the template's Comments option is set.
+func (b *Builder) Comment(text string) *Builder {
+	b.append(&CommentBlock{Pos: b.pos(), Content: text}, text)
+	return b
+}
+
+// Func appends a function directive with the given signature. The top-level
+// blocks that follow it, up to the next function directive, are the body of
+// the function.
+func (b *Builder) Func(signature string) *Builder {
+	pos := b.pos()
+	if b.err != nil {
+		return b
+	} else if len(b.stack) > 0 {
+		b.err = NewSyntaxError(pos, "Function directive found inside of %s", openBlockString(b.top()))
+		return b
+	} else if parseFuncSignature(signature) == nil {
+		b.err = NewSyntaxError(pos, "Invalid function directive: %s", signature)
+		return b
+	}
+	b.append(&FuncBlock{Pos: pos, Content: signature}, signature)
+	return b
+}
+
+// JSONPrint appends a print block which writes the value of expr as JSON that
+// is safe to embed in script elements.
+func (b *Builder) JSONPrint(expr string) *Builder {
+	if b.checkExpr(expr, "print") {
+		b.append(&JSONPrintBlock{Pos: b.pos(), Content: expr}, expr)
+	}
+	return b
+}
+
+// URLPrint appends a print block which writes the value of expr escaped for
+// use in a URL query.
+func (b *Builder) URLPrint(expr string) *Builder {
+	if b.checkExpr(expr, "print") {
+		b.append(&URLPrintBlock{Pos: b.pos(), Content: expr}, expr)
+	}
+	return b
+}
+
+// URLPathPrint appends a print block which writes the value of expr escaped
+// for use in a URL path segment.
+func (b *Builder) URLPathPrint(expr string) *Builder {
+	if b.checkExpr(expr, "print") {
+		b.append(&URLPrintBlock{Pos: b.pos(), Content: expr, Path: true}, expr)
+	}
+	return b
+}
+
+// Translate appends a translate directive which writes the escaped
+// translation of the message key with the given argument expressions.
+func (b *Builder) Translate(key string, args ...string) *Builder {
+	for _, arg := range args {
+		if !b.checkExpr(arg, "translate argument") {
+			return b
+		}
+	}
+	b.append(&TranslateBlock{Pos: b.pos(), Key: key, Args: args}, "")
+	return b
+}
+
+// Component opens a component with the given namespace, name & fields. An
+// empty namespace or "ego" refers to a type in the template's package. The
+// component must be closed by End.
+func (b *Builder) Component(ns, name string, fields ...*Field) *Builder {
+	pos := b.pos()
+	if b.err != nil {
+		return b
+	} else if ns != "" && !token.IsIdentifier(ns) {
+		b.err = NewSyntaxError(pos, "Invalid component namespace: %q", ns)
+		return b
+	} else if !token.IsIdentifier(name) {
+		b.err = NewSyntaxError(pos, "Invalid component name: %q", name)
+		return b
+	}
+	if ns == "ego" {
+		ns = ""
+	}
+
+	blk := &ComponentStartBlock{Pos: pos, Package: ns, Name: name}
+	for _, field := range fields {
+		if !token.IsIdentifier(field.Name) || !token.IsExported(field.Name) {
+			b.err = NewSyntaxError(pos, "Invalid component field name: %q", field.Name)
+			return b
+		} else if !b.checkExpr(field.Value, "field") {
+			return b
+		}
+		blk.Fields = append(blk.Fields, &Field{Name: field.Name, NamePos: pos, Value: field.Value, ValuePos: pos})
+	}
+
+	b.append(blk, "")
+	b.stack = append(b.stack, blk)
+	return b
+}
+
+// Attr adds a passthrough attribute to the innermost open component. The
+// value is a Go expression.
+func (b *Builder) Attr(name, value string) *Builder {
+	pos := b.pos()
+	if b.err != nil {
+		return b
+	}
+	blk, ok := b.top().(*ComponentStartBlock)
+	if !ok {
+		b.err = NewSyntaxError(pos, "Attribute found outside of component: %s", name)
+		return b
+	} else if name == "" || strings.ContainsAny(name, " \t\r\n=/>\"'") {
+		b.err = NewSyntaxError(pos, "Invalid attribute name: %q", name)
+		return b
+	} else if !b.checkExpr(value, "attribute") {
+		return b
+	}
+	blk.Attrs = append(blk.Attrs, &Attr{Name: name, NamePos: pos, Value: value, ValuePos: pos})
+	return b
+}
+
+// AttrBlock opens a named closure on the innermost open component. Blocks
+// appended until End is called are assigned to the field with the given name.
+func (b *Builder) AttrBlock(name string) *Builder {
+	pos := b.pos()
+	if b.err != nil {
+		return b
+	}
+	parent, ok := b.top().(*ComponentStartBlock)
+	if !ok {
+		b.err = NewSyntaxError(pos, "Attribute block found outside of component: %s", name)
+		return b
+	} else if !token.IsIdentifier(name) || !token.IsExported(name) {
+		b.err = NewSyntaxError(pos, "Invalid attribute block name: %q", name)
+		return b
+	}
+
+	blk := &AttrStartBlock{Pos: pos, Package: parent.Package, Name: name}
+	parent.AttrBlocks = append(parent.AttrBlocks, blk)
+	b.stack = append(b.stack, blk)
+	b.lineNo++
+	return b
+}
+
+// Format opens a format region whose blocks are only rendered when the format
+// of the render's context is format, either FormatHTML or FormatJSON. The
+// region must be closed by End.
+func (b *Builder) Format(format string) *Builder {
+	pos := b.pos()
+	if b.err != nil {
+		return b
+	} else if format != FormatHTML && format != FormatJSON {
+		b.err = NewSyntaxError(pos, "Invalid format: %q", format)
+		return b
+	}
+	blk := &FormatStartBlock{Pos: pos, Format: format}
+	b.append(blk, "")
+	b.stack = append(b.stack, blk)
+	return b
+}
+
+// Yield opens a yield region which calls the closure of expr if it is not nil.
+// Otherwise the blocks of the region are rendered as the default content. The
+// region must be closed by End.
+func (b *Builder) Yield(expr string) *Builder {
+	if !b.checkExpr(expr, "yield") {
+		return b
+	}
+	blk := &YieldStartBlock{Pos: b.pos(), Content: expr}
+	b.append(blk, "")
+	b.stack = append(b.stack, blk)
+	return b
+}
+
+// End closes the innermost open component, attribute block, format region or
+// yield region.
+func (b *Builder) End() *Builder {
+	pos := b.pos()
+	if b.err != nil {
+		return b
+	} else if len(b.stack) == 0 {
+		b.err = NewSyntaxError(pos, "End found without open block")
+		return b
+	}
+
+	switch blk := b.stack[len(b.stack)-1].(type) {
+	case *ComponentStartBlock:
+		blk.Yield = normalizeBlocks(blk.Yield)
+		blk.Closed = len(blk.Yield) == 0 && len(blk.AttrBlocks) == 0
+	case *AttrStartBlock:
+		blk.Yield = normalizeBlocks(blk.Yield)
+	case *FormatStartBlock:
+		blk.Yield = normalizeBlocks(blk.Yield)
+	case *YieldStartBlock:
+		blk.Yield = normalizeBlocks(blk.Yield)
+	}
+	b.stack = b.stack[:len(b.stack)-1]
+	b.lineNo++
+	return b
+}
+
+// Template returns the constructed template. Returns an error if an error
+// occurred while building or if an opened block is not closed.
+func (b *Builder) Template() (*Template, error) {
+	if b.err != nil {
+		return nil, b.err
+	} else if len(b.stack) > 0 {
+		blk := b.stack[len(b.stack)-1]
+		return nil, NewSyntaxError(Position(blk), "Expected close of %s, found end of template", openBlockString(blk))
+	}
+	return &Template{Path: b.path, Blocks: normalizeBlocks(b.blocks)}, nil
+}
+
+// append adds blk to the innermost open block and advances the line number
+// past its content.
+func (b *Builder) append(blk Block, content string) {
+	if b.err != nil {
+		return
+	}
+	switch top := b.top().(type) {
+	case *ComponentStartBlock:
+		top.Yield = append(top.Yield, blk)
+	case *AttrStartBlock:
+		top.Yield = append(top.Yield, blk)
+	case *FormatStartBlock:
+		top.Yield = append(top.Yield, blk)
+	case *YieldStartBlock:
+		top.Yield = append(top.Yield, blk)
+	default:
+		b.blocks = append(b.blocks, blk)
+	}
+	if _, ok := blk.(*TextBlock); ok {
+		b.lineNo += strings.Count(content, "\n")
+	} else {
+		b.lineNo += strings.Count(content, "\n") + 1
+	}
+}
+
+// top returns the innermost open block or nil if no block is open.
+func (b *Builder) top() Block {
+	if len(b.stack) == 0 {
+		return nil
+	}
+	return b.stack[len(b.stack)-1]
+}
+
+// openBlockString returns the open tag of a block opened by the builder.
+func openBlockString(blk Block) string {
+	switch blk := blk.(type) {
+	case *FormatStartBlock:
+		return "<%" + blk.Format + " %>"
+	case *YieldStartBlock:
+		return "<%yield " + blk.Content + " %>"
+	default:
+		return shortComponentBlockString(blk)
+	}
+}
+
+// pos returns the position of the next block.
+func (b *Builder) pos() Pos {
+	return Pos{Path: b.path, LineNo: b.lineNo}
+}
+
+// checkExpr sets the builder's error and returns false if expr is not a
+// valid Go expression.
+func (b *Builder) checkExpr(expr, kind string) bool {
+	if b.err != nil {
+		return false
+	} else if _, err := parser.ParseExpr(expr); err != nil {
+		b.err = NewSyntaxError(b.pos(), "Invalid %s expression: %s", kind, expr)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package ego_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that a built template generates the same code as the equivalent
+// parsed template.
+func TestBuilder_Template(t *testing.T) {
+	tmpl, err := ego.NewBuilder("tmpl.ego").
+		Code("package foo\n\nfunc (r *Page) Render(ctx context.Context, w io.Writer) {").
+		Text("<h1>").Print("r.Title").Text("</h1>\n").
+		Component("ego", "Card", &ego.Field{Name: "Title", Value: "r.Title"}).
+		Attr("class", `"card"`).
+		AttrBlock("Header").RawPrint("r.Header").End().
+		Text("Body").
+		Component("", "Icon").End().
+		End().
+		Code("}").
+		Template()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := ego.Parse(strings.NewReader(`<%
+package foo
+
+func (r *Page) Render(ctx context.Context, w io.Writer) { %><h1><%= r.Title %></h1>
+<ego:Card Title=r.Title class="card"><ego::Header><%== r.Header %></ego::Header>Body<ego:Icon /></ego:Card><% } %>`), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, want bytes.Buffer
+	if _, err := tmpl.WriteTo(&got); err != nil {
+		t.Fatalf("%s\n%s", err, got.String())
+	} else if _, err := other.WriteTo(&want); err != nil {
+		t.Fatal(err)
+	}
+	// Ignore line pragmas & blank lines as positions are not the same.
+	re := regexp.MustCompile(`(?m)^(//line .*)?\n`)
+	if a, b := re.ReplaceAllString(got.String(), ""), re.ReplaceAllString(want.String(), ""); a != b {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", a, b)
+	}
+}
+
+// Ensure that the blocks of directives & regions are built the same as the
+// equivalent parsed template.
+func TestBuilder_Template_Directives(t *testing.T) {
+	tmpl, err := ego.NewBuilder("tmpl.ego").
+		Code("package foo\n\nfunc (r *Page) Render(ctx context.Context, w io.Writer) {").
+		Comment(" note ").JSONPrint("r.State").URLPrint("r.Query").URLPathPrint("r.Path").Translate("hi", "r.Name").
+		Format(ego.FormatJSON).Text("json").End().
+		Yield("r.Yield").Text("default").End().
+		Code("}").
+		Func("func render(ctx context.Context, w io.Writer)").
+		Text("body").
+		Template()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := ego.Parse(strings.NewReader(`<%
+package foo
+
+func (r *Page) Render(ctx context.Context, w io.Writer) { %><%# note %><%=json r.State %><%=url r.Query %><%=url:path r.Path %><%t "hi", r.Name %><%json %>json<%/json %><%yield r.Yield %>default<%/yield %><% } %>
+<%: func render(ctx context.Context, w io.Writer) %>
+body`), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, want bytes.Buffer
+	if _, err := tmpl.WriteTo(&got); err != nil {
+		t.Fatalf("%s\n%s", err, got.String())
+	} else if _, err := other.WriteTo(&want); err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`(?m)^(//line .*)?\n`)
+	if a, b := re.ReplaceAllString(got.String(), ""), re.ReplaceAllString(want.String(), ""); a != b {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", a, b)
+	}
+}
+
+// Ensure that blocks are positioned after the newlines of previous blocks.
+func TestBuilder_Template_Pos(t *testing.T) {
+	tmpl, err := ego.NewBuilder("tmpl.ego").Code("package foo\n").Text("a\nb\n").Print("x").Template()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, lineNo := range []int{1, 3, 5} {
+		if pos := ego.Position(tmpl.Blocks[i]); pos.Path != "tmpl.ego" || pos.LineNo != lineNo {
+			t.Fatalf("%d. unexpected position: %+v", i, pos)
+		}
+	}
+}
+
+// Ensure that invalid & unbalanced templates return an error.
+func TestBuilder_Template_Err(t *testing.T) {
+	for _, tt := range []struct {
+		b   *ego.Builder
+		err string
+	}{
+		{ego.NewBuilder("tmpl.ego").Component("ego", "Card"), "Expected close of <ego:Card>, found end of template at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").Component("ego", "Card").AttrBlock("Header"), "Expected close of <ego::Header>, found end of template at tmpl.ego:2"},
+		{ego.NewBuilder("tmpl.ego").Text("x").End(), "End found without open block at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").Print("a +"), "Invalid print expression: a + at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").Component("ego", "my-card"), `Invalid component name: "my-card" at tmpl.ego:1`},
+		{ego.NewBuilder("tmpl.ego").Component("ego", "Card", &ego.Field{Name: "title", Value: "1"}), `Invalid component field name: "title" at tmpl.ego:1`},
+		{ego.NewBuilder("tmpl.ego").Attr("class", `"x"`), "Attribute found outside of component: class at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").AttrBlock("Header"), "Attribute block found outside of component: Header at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").Print("(").Print("x"), "Invalid print expression: ( at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").Format("xml"), `Invalid format: "xml" at tmpl.ego:1`},
+		{ego.NewBuilder("tmpl.ego").Format(ego.FormatHTML), "Expected close of <%html %>, found end of template at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").Yield("r.Yield"), "Expected close of <%yield r.Yield %>, found end of template at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").Yield("r.Yield").Func("func f()"), "Function directive found inside of <%yield r.Yield %> at tmpl.ego:2"},
+		{ego.NewBuilder("tmpl.ego").Func("f()"), "Invalid function directive: f() at tmpl.ego:1"},
+		{ego.NewBuilder("tmpl.ego").Translate("hi", "a +"), "Invalid translate argument expression: a + at tmpl.ego:1"},
+	} {
+		if _, err := tt.b.Template(); err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error: %v, expected %s", err, tt.err)
+		}
+	}
+}
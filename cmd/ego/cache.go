@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cache records the hashes of templates and their generated files so that
+// templates are only regenerated when they, their included files, the
+// generator, or its options change. Generated files that were edited or
+// removed since they were recorded are also regenerated.
+type Cache struct {
+	path  string
+	force bool // regenerate all templates & record their hashes
+
+	mu      sync.Mutex
+	entries map[string]*CacheEntry // by absolute template path
+	dirty   bool
+}
+
+// CacheEntry represents the recorded state of a generated template.
+type CacheEntry struct {
+	Input        string   `json:"input"`
+	Includes     []string `json:"includes,omitempty"`
+	IncludesHash string   `json:"includesHash,omitempty"`
+	Output       string   `json:"output"`
+}
+
+// loadCache reads the cache file at path. A missing or invalid cache file is
+// treated as empty.
+func loadCache(path string, force bool) (*Cache, error) {
+	c := &Cache{path: path, force: force, entries: make(map[string]*CacheEntry)}
+	if buf, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(buf, &c.entries)
+	}
+	return c, nil
+}
+
+// defaultCachePath returns the cache file in the user's cache directory.
+// Returns blank if there is no cache directory.
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ego", "cache.json")
+}
+
+// cacheVersion returns a hash of the generator & the options that affect the
+// generated code. Builds without a version include the modification time of
+// the executable so that rebuilding ego invalidates the cache.
+func cacheVersion(opt *Options) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "ego %s\n", Version)
+	if Version == "" {
+		if path, err := os.Executable(); err == nil {
+			if fi, err := os.Stat(path); err == nil {
+				fmt.Fprintf(h, "%s %d %d\n", path, fi.Size(), fi.ModTime().UnixNano())
+			}
+		}
+	}
+	if err := json.NewEncoder(h).Encode(opt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashInput returns the hash of a template's source with the version of the
+// generator & its options.
+func (c *Cache) hashInput(path string, opt *Options) (string, error) {
+	version, err := cacheVersion(opt)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	io.WriteString(h, version+"\n")
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashIncludes returns the hash of the paths & contents of included files.
+func hashIncludes(paths []string) (string, error) {
+	h := sha256.New()
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", path, len(buf))
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashOutput returns the hash of generated code.
+func hashOutput(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fresh returns true if the template at path has the input hash recorded for
+// it and its generated file has the recorded content.
+func (c *Cache) fresh(path, input string, output []byte) bool {
+	if c.force {
+		return false
+	}
+
+	c.mu.Lock()
+	entry := c.entries[cacheKey(path)]
+	c.mu.Unlock()
+	if entry == nil || entry.Input != input || entry.Output != hashOutput(output) {
+		return false
+	}
+
+	// Included files are hashed with their current contents.
+	if len(entry.Includes) > 0 {
+		if h, err := hashIncludes(entry.Includes); err != nil || h != entry.IncludesHash {
+			return false
+		}
+	}
+	return true
+}
+
+// includes returns the files included by the template at path when it was
+// recorded.
+func (c *Cache) includes(path string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry := c.entries[cacheKey(path)]; entry != nil {
+		return entry.Includes
+	}
+	return nil
+}
+
+// store records the input hash, included files & generated code of the
+// template at path.
+func (c *Cache) store(path, input string, includes []string, output []byte) {
+	entry := &CacheEntry{Input: input, Output: hashOutput(output)}
+	for _, include := range includes {
+		entry.Includes = append(entry.Includes, cacheKey(include))
+	}
+	if len(entry.Includes) > 0 {
+		h, err := hashIncludes(entry.Includes)
+		if err != nil {
+			return
+		}
+		entry.IncludesHash = h
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey(path)] = entry
+	c.dirty = true
+}
+
+// save writes the cache file if any entry changed.
+func (c *Cache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	buf, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	} else if err := os.MkdirAll(filepath.Dir(c.path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, buf, 0666)
+}
+
+// cacheKey returns the absolute path of a file.
+func cacheKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Ensure that a cached template is only fresh while its input, options,
+// included files & generated file are unchanged.
+func TestCache_Fresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, include := filepath.Join(dir, "page.ego"), filepath.Join(dir, "header.ego")
+	if err := ioutil.WriteFile(path, []byte("page"), 0666); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(include, []byte("header"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadCache(filepath.Join(dir, "cache.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := &Options{}
+	input, err := c.hashInput(path, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := []byte("package main\n")
+	c.store(path, input, []string{include}, output)
+
+	if !c.fresh(path, input, output) {
+		t.Fatal("expected fresh")
+	}
+
+	// Changing the generated file invalidates the entry.
+	if c.fresh(path, input, []byte("package main // edited\n")) {
+		t.Fatal("expected stale output")
+	}
+
+	// Changing the options changes the input hash.
+	if other, err := c.hashInput(path, &Options{Strict: true}); err != nil {
+		t.Fatal(err)
+	} else if other == input || c.fresh(path, other, output) {
+		t.Fatal("expected stale options")
+	}
+
+	// Changing the template changes the input hash.
+	if err := ioutil.WriteFile(path, []byte("page2"), 0666); err != nil {
+		t.Fatal(err)
+	} else if other, err := c.hashInput(path, opt); err != nil {
+		t.Fatal(err)
+	} else if other == input || c.fresh(path, other, output) {
+		t.Fatal("expected stale input")
+	}
+
+	// Changing an included file invalidates the entry.
+	if err := ioutil.WriteFile(include, []byte("header2"), 0666); err != nil {
+		t.Fatal(err)
+	} else if c.fresh(path, input, output) {
+		t.Fatal("expected stale include")
+	}
+	c.store(path, input, []string{include}, output)
+
+	// Removing an included file invalidates the entry.
+	if err := os.Remove(include); err != nil {
+		t.Fatal(err)
+	} else if c.fresh(path, input, output) {
+		t.Fatal("expected stale removed include")
+	}
+}
+
+// Ensure that entries are saved & loaded, and that forcing ignores them.
+func TestCache_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cachePath := filepath.Join(dir, "cache", "cache.json")
+	c, err := loadCache(cachePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, output := filepath.Join(dir, "page.ego"), []byte("package main\n")
+	c.store(path, "input", nil, output)
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := loadCache(cachePath, false); err != nil {
+		t.Fatal(err)
+	} else if !c.fresh(path, "input", output) {
+		t.Fatal("expected fresh after load")
+	} else if c.fresh(path, "other", output) {
+		t.Fatal("expected stale input after load")
+	}
+
+	if c, err := loadCache(cachePath, true); err != nil {
+		t.Fatal(err)
+	} else if c.fresh(path, "input", output) {
+		t.Fatal("expected stale when forced")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// reportStale reports the differences between the existing generated file
+// at path and the generated code. It shows the first line that differs along
+// with the line counts of both versions, or reports the line as the position
+// of a diagnostic if writing JSON.
+func reportStale(d *diagnostics, path string, existing []byte, missing bool, generated []byte) {
+	if missing && d.json {
+		d.write(Diagnostic{Path: path, Severity: SeverityError, Message: "missing"})
+		return
+	} else if missing {
+		fmt.Fprintf(d.w, "%s: missing\n", path)
+		return
+	}
+
+	a, b := bytes.Split(existing, []byte("\n")), bytes.Split(generated, []byte("\n"))
+	msg := fmt.Sprintf("out of date (%d lines on disk, %d generated)", len(a), len(b))
+	if d.json {
+		d.write(Diagnostic{Path: path, Line: firstDiff(a, b) + 1, Severity: SeverityError, Message: msg})
+		return
+	}
+	fmt.Fprintf(d.w, "%s: %s\n", path, msg)
+
+	i := firstDiff(a, b)
+	fmt.Fprintf(d.w, "\tline %d on disk:   %s\n", i+1, lineAt(a, i))
+	fmt.Fprintf(d.w, "\tline %d generated: %s\n", i+1, lineAt(b, i))
+}
+
+// firstDiff returns the index of the first line that differs between a & b.
+func firstDiff(a, b [][]byte) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i < len(a) && i < len(b) && bytes.Equal(a[i], b[i]) {
+			continue
+		}
+		return i
+	}
+	return 0
+}
+
+// lineAt returns the trimmed line i of lines or a marker if there is none.
+func lineAt(lines [][]byte, i int) string {
+	if i >= len(lines) {
+		return "(end of file)"
+	}
+	return string(bytes.TrimSpace(lines[i]))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Ensure that -check fails if a generated file is missing or out of date and
+// does not write generated files.
+func TestRun_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "page.ego")
+	if err := ioutil.WriteFile(path, []byte("<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>Hello<% } %>\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"-check", dir}); err == nil || err.Error() != "1 generated file(s) out of date, run ego to regenerate" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := os.Stat(path + ".go"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected generated file: %v", err)
+	}
+
+	if err := run([]string{"-cache=", dir}); err != nil {
+		t.Fatal(err)
+	} else if err := run([]string{"-check", dir}); err != nil {
+		t.Fatalf("unexpected error after generating: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>Bye<% } %>\n"), 0666); err != nil {
+		t.Fatal(err)
+	} else if err := run([]string{"-check", dir}); err == nil {
+		t.Fatal("expected error after editing template")
+	}
+}
+
+// Ensure that stale files are reported with the first differing line.
+func TestReportStale(t *testing.T) {
+	existing, generated := []byte("a\nb\nc\n"), []byte("a\nx\nc\nd\n")
+
+	var buf bytes.Buffer
+	reportStale(&diagnostics{w: &buf}, "page.ego.go", existing, false, generated)
+	if exp := "page.ego.go: out of date (4 lines on disk, 5 generated)\n\tline 2 on disk:   b\n\tline 2 generated: x\n"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+
+	buf.Reset()
+	reportStale(&diagnostics{w: &buf, json: true}, "page.ego.go", existing, false, generated)
+	if exp := `{"path":"page.ego.go","line":2,"severity":"error","message":"out of date (4 lines on disk, 5 generated)"}` + "\n"; buf.String() != exp {
+		t.Fatalf("unexpected JSON: %s", buf.String())
+	}
+
+	buf.Reset()
+	reportStale(&diagnostics{w: &buf}, "page.ego.go", nil, true, generated)
+	if exp := "page.ego.go: missing\n"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runClean removes the generated files of templates that no longer exist.
+// Directories are not searched recursively and files ignored by .egoignore
+// files are kept.
+func runClean(args []string) error {
+	fs := flag.NewFlagSet("ego clean", flag.ContinueOnError)
+	dryRun := fs.Bool("n", false, "print the files that would be removed without removing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	ig := newIgnorer(nil)
+	for _, path := range paths {
+		a, err := orphanedFiles(path, ig)
+		if err != nil {
+			return err
+		}
+		for _, filename := range a {
+			fmt.Println(filename)
+			if *dryRun {
+				continue
+			} else if err := os.Remove(filename); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// orphanedFiles returns the generated files in dir whose templates do not
+// exist, such as after a template is deleted or renamed. Generated files are
+// the ".ego.go" & ".ego_test.go" files that start with the header written by
+// ego so that hand-written files with the same suffix are kept. Files are also
+// kept if they or their templates are ignored by ig.
+func orphanedFiles(dir string, ig *ignorer) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var a []string
+	for _, fi := range fis {
+		var name string
+		if strings.HasSuffix(fi.Name(), ".ego.go") {
+			name = strings.TrimSuffix(fi.Name(), ".go")
+		} else if strings.HasSuffix(fi.Name(), ".ego_test.go") {
+			name = strings.TrimSuffix(fi.Name(), "_test.go")
+		} else {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		filename := filepath.Join(dir, fi.Name())
+		if ig.ignored(filepath.Join(dir, name)) || ig.ignored(filename) {
+			continue
+		} else if ok, err := isGenerated(filename); err != nil {
+			return nil, err
+		} else if ok {
+			a = append(a, filename)
+		}
+	}
+	return a, nil
+}
+
+// isGenerated returns true if the file starts with the header written by ego.
+// Build constraints & blank lines before the header are skipped.
+func isGenerated(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//go:build ") || strings.HasPrefix(line, "// +build ") {
+			continue
+		}
+		return line == "// Generated by ego.", nil
+	}
+	return false, scanner.Err()
+}
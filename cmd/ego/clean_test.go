@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Ensure that only generated files whose templates do not exist are orphaned.
+func TestOrphanedFiles(t *testing.T) {
+	dir := writeCleanFiles(t)
+	defer os.RemoveAll(dir)
+
+	a, err := orphanedFiles(dir, newIgnorer(nil))
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := []string{filepath.Join(dir, "old.ego.go"), filepath.Join(dir, "old.ego_test.go")}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected files: %v", a)
+	}
+}
+
+// Ensure that generated files are detected by their header.
+func TestIsGenerated(t *testing.T) {
+	dir := writeCleanFiles(t)
+	defer os.RemoveAll(dir)
+
+	for name, exp := range map[string]bool{
+		"page.ego.go":     true,
+		"old.ego_test.go": true,
+		"hand.ego.go":     false,
+		"empty.ego.go":    false,
+	} {
+		if ok, err := isGenerated(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		} else if ok != exp {
+			t.Errorf("%s: unexpected result: %v", name, ok)
+		}
+	}
+}
+
+// Ensure that -prune removes orphaned files after generating, except for the
+// files ignored by .egoignore.
+func TestRun_Prune(t *testing.T) {
+	dir := writeCleanFiles(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ".egoignore"), []byte("legacy.ego\n"), 0666); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, "legacy.ego.go"), []byte("// Generated by ego.\n// DO NOT EDIT\n\npackage main\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"-cache=", "-prune", dir}); err != nil {
+		t.Fatal(err)
+	}
+	for name, exp := range map[string]bool{
+		"page.ego.go":     true,
+		"hand.ego.go":     true,
+		"legacy.ego.go":   true,
+		"old.ego.go":      false,
+		"old.ego_test.go": false,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exp {
+			t.Errorf("%s: unexpected existence: %v", name, err)
+		}
+	}
+}
+
+// writeCleanFiles writes a template & generated files to a temporary directory:
+// a generated file of an existing template, generated files without templates,
+// and hand-written & empty files with the same suffix.
+func writeCleanFiles(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		"page.ego":        "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>Hello<% } %>\n",
+		"page.ego.go":     "// Generated by ego.\n// DO NOT EDIT\n\npackage main\n",
+		"old.ego.go":      "// Generated by ego.\n// DO NOT EDIT\n\npackage main\n",
+		"old.ego_test.go": "//go:build test\n// +build test\n\n// Generated by ego.\n// DO NOT EDIT\n\npackage main\n",
+		"hand.ego.go":     "// Package main is written by hand.\npackage main\n",
+		"empty.ego.go":    "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// configNames are the file names of config files. A directory may only have
+// one of them.
+var configNames = []string{"ego.toml", "ego.yaml", "ego.yml"}
+
+// configPathFlags are the flags whose values are paths. Relative paths in a
+// config file are relative to the directory of the file.
+var configPathFlags = map[string]bool{"cache": true, "manifest": true, "schemas": true}
+
+// setting represents a flag set by a config file. A table or map, such as
+// "filter", sets the flag once for each entry as "key=value".
+type setting struct {
+	name   string
+	value  string
+	lineNo int
+}
+
+// configFile represents the settings of an ego.toml or ego.yaml file.
+type configFile struct {
+	path     string
+	settings []setting
+}
+
+// config resolves the options of the templates in each directory. Settings
+// are applied from the config files of the directory and its parents,
+// farthest first, and then from the command line, which takes precedence.
+type config struct {
+	args []string // command-line arguments
+
+	// base holds the options shared by all directories, such as the cache.
+	base *Options
+
+	// types are the types declared by the package in each directory, shared
+	// by the options of all directories.
+	types *packageTypes
+
+	mu    sync.Mutex
+	files map[string]*configFile // by directory, nil if it has none
+	opts  map[string]*Options    // by directory
+}
+
+func newConfig(args []string) *config {
+	return &config{
+		args:  args,
+		types: &packageTypes{},
+		files: make(map[string]*configFile),
+		opts:  make(map[string]*Options),
+	}
+}
+
+// parse returns the command settings & options for the templates in dir.
+func (c *config) parse(dir string) (*command, *Options, *flag.FlagSet, error) {
+	var cmd command
+	opt := Options{Linter: defaultLinter}
+	fs := newFlagSet(&cmd, &opt)
+
+	files, err := c.configFiles(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, f := range files {
+		for _, s := range f.settings {
+			value := s.value
+			if configPathFlags[s.name] && value != "" && !filepath.IsAbs(value) {
+				value = filepath.Join(filepath.Dir(f.path), value)
+			}
+			if fs.Lookup(s.name) == nil {
+				return nil, nil, nil, fmt.Errorf("%s:%d: unknown option: %s", f.path, s.lineNo, s.name)
+			} else if err := fs.Set(s.name, value); err != nil {
+				return nil, nil, nil, fmt.Errorf("%s:%d: invalid value for %s: %s", f.path, s.lineNo, s.name, err)
+			}
+		}
+	}
+
+	if err := fs.Parse(c.args); err != nil {
+		return nil, nil, nil, err
+	} else if err := cmd.finish(&opt); err != nil {
+		return nil, nil, nil, err
+	}
+	return &cmd, &opt, fs, nil
+}
+
+// reset forgets the config files & options that were read so that changed
+// config files are read again.
+func (c *config) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files = make(map[string]*configFile)
+	c.opts = make(map[string]*Options)
+}
+
+// options returns the options for the templates in dir.
+func (c *config) options(dir string) (*Options, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	opt := c.opts[dir]
+	c.mu.Unlock()
+	if opt != nil {
+		return opt, nil
+	}
+
+	_, opt, fs, err := c.parse(dir)
+	if err != nil {
+		return nil, err
+	}
+	fs.SetOutput(ioutil.Discard)
+	if c.base != nil {
+		opt.Check, opt.Cache = c.base.Check, c.base.Cache
+	}
+	opt.types = c.types
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts[dir] = opt
+	return opt, nil
+}
+
+// configFiles returns the config files of dir & its parents, farthest first.
+func (c *config) configFiles(dir string) ([]*configFile, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var a []*configFile
+	for {
+		f, err := c.configFile(dir)
+		if err != nil {
+			return nil, err
+		} else if f != nil {
+			a = append([]*configFile{f}, a...)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return a, nil
+		}
+		dir = parent
+	}
+}
+
+// configFile returns the config file of dir. Returns nil if it has none.
+func (c *config) configFile(dir string) (*configFile, error) {
+	c.mu.Lock()
+	f, ok := c.files[dir]
+	c.mu.Unlock()
+	if ok {
+		return f, nil
+	}
+
+	for _, name := range configNames {
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		} else if f != nil {
+			return nil, fmt.Errorf("%s: multiple config files in directory", path)
+		}
+
+		f = &configFile{path: path}
+		if filepath.Ext(name) == ".toml" {
+			f.settings, err = parseTOML(data)
+		} else {
+			f.settings, err = parseYAML(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s", path, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[dir] = f
+	return f, nil
+}
+
+// configError returns an error at a line of a config file.
+func configError(lineNo int, format string, args ...interface{}) error {
+	return fmt.Errorf("%d: %s", lineNo, fmt.Sprintf(format, args...))
+}
+
+// parseTOML returns the settings of a TOML config file. Only a subset of TOML
+// is supported: keys with string, boolean, integer & float values, arrays of
+// them on a single line, and tables of keys with such values.
+//
+//	tabwidth = 4
+//	filter = ["upper=strings.ToUpper"]
+//
+//	[deprecated]
+//	"ego:Button" = "use ego:Btn"
+func parseTOML(data []byte) ([]setting, error) {
+	var a []setting
+	var table string
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		if j := indexComment(line); j != -1 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// Table header.
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return nil, configError(lineNo, "invalid table: %s", line)
+			}
+			name, err := unquoteKey(strings.TrimSpace(line[1 : len(line)-1]))
+			if err != nil {
+				return nil, configError(lineNo, "invalid table: %s", line)
+			}
+			table = name
+			continue
+		}
+
+		j := indexUnquoted(line, '=')
+		if j == -1 {
+			return nil, configError(lineNo, "expected key = value: %s", line)
+		}
+		key, err := unquoteKey(strings.TrimSpace(line[:j]))
+		if err != nil {
+			return nil, configError(lineNo, "invalid key: %s", line[:j])
+		}
+		values, err := parseConfigValues(strings.TrimSpace(line[j+1:]), false)
+		if err != nil {
+			return nil, configError(lineNo, "%s", err)
+		}
+		a = append(a, newSettings(table, key, values, lineNo)...)
+	}
+	return a, nil
+}
+
+// parseYAML returns the settings of a YAML config file. Only a subset of YAML
+// is supported: top-level keys with scalar values or flow sequences, and keys
+// with an indented block sequence or mapping of scalar values.
+//
+//	tabwidth: 4
+//	filter:
+//	  - upper=strings.ToUpper
+//	deprecated:
+//	  "ego:Button": use ego:Btn
+func parseYAML(data []byte) ([]setting, error) {
+	var a []setting
+	var parent string // key of the current block
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		if j := indexComment(line); j != -1 {
+			line = line[:j]
+		}
+		text := strings.TrimSpace(line)
+		if text == "" || text == "---" {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+
+		// Items of a block sequence or mapping.
+		if indented {
+			if parent == "" {
+				return nil, configError(lineNo, "unexpected indentation")
+			} else if strings.HasPrefix(text, "- ") || text == "-" {
+				values, err := parseConfigValues(strings.TrimSpace(text[1:]), true)
+				if err != nil {
+					return nil, configError(lineNo, "%s", err)
+				}
+				a = append(a, newSettings("", parent, values, lineNo)...)
+				continue
+			}
+			key, values, err := parseYAMLPair(text)
+			if err != nil {
+				return nil, configError(lineNo, "%s", err)
+			}
+			a = append(a, newSettings(parent, key, values, lineNo)...)
+			continue
+		}
+
+		key, values, err := parseYAMLPair(text)
+		if err != nil {
+			return nil, configError(lineNo, "%s", err)
+		}
+		parent = ""
+		if values == nil {
+			parent = key
+			continue
+		}
+		a = append(a, newSettings("", key, values, lineNo)...)
+	}
+	return a, nil
+}
+
+// parseYAMLPair parses a "key: value" line. Returns nil values if the value
+// is blank, which starts a block.
+func parseYAMLPair(text string) (string, []string, error) {
+	j := indexUnquoted(text, ':')
+	if j == -1 {
+		return "", nil, fmt.Errorf("expected key: value: %s", text)
+	}
+	key, err := unquoteKey(strings.TrimSpace(text[:j]))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid key: %s", text[:j])
+	}
+
+	rest := strings.TrimSpace(text[j+1:])
+	if rest == "" {
+		return key, nil, nil
+	}
+	values, err := parseConfigValues(rest, true)
+	return key, values, err
+}
+
+// newSettings returns the settings of a key's values. Keys within a table
+// set the table's flag as "key=value".
+func newSettings(table, key string, values []string, lineNo int) []setting {
+	a := make([]setting, 0, len(values))
+	for _, value := range values {
+		if table != "" {
+			a = append(a, setting{name: table, value: key + "=" + value, lineNo: lineNo})
+		} else {
+			a = append(a, setting{name: key, value: value, lineNo: lineNo})
+		}
+	}
+	return a
+}
+
+// parseConfigValues parses a scalar or an array of scalars on a single line.
+// Unquoted strings are only allowed in YAML.
+func parseConfigValues(s string, plain bool) ([]string, error) {
+	if !strings.HasPrefix(s, "[") {
+		v, err := parseConfigScalar(s, plain)
+		if err != nil {
+			return nil, err
+		}
+		return []string{v}, nil
+	} else if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated array: %s", s)
+	}
+
+	values := []string{}
+	for s = strings.TrimSpace(s[1 : len(s)-1]); s != ""; {
+		j := indexUnquoted(s, ',')
+		if j == -1 {
+			j = len(s)
+		}
+		v, err := parseConfigScalar(strings.TrimSpace(s[:j]), plain)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		if j == len(s) {
+			break
+		}
+		s = strings.TrimSpace(s[j+1:])
+	}
+	return values, nil
+}
+
+// parseConfigScalar parses a quoted string, boolean or number.
+func parseConfigScalar(s string, plain bool) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid string: %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid string: %s", s)
+		} else if plain {
+			return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+		}
+		return s[1 : len(s)-1], nil
+	case s == "true" || s == "false":
+		return s, nil
+	case plain && s != "":
+		return s, nil
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(s, "_", "", -1), 64); err != nil {
+		return "", fmt.Errorf("invalid value: %s", s)
+	}
+	return strings.Replace(s, "_", "", -1), nil
+}
+
+// unquoteKey returns a bare or quoted key.
+func unquoteKey(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'") && len(s) >= 2 && strings.HasSuffix(s, "'"):
+		return s[1 : len(s)-1], nil
+	case s == "" || strings.ContainsAny(s, " \t\"'"):
+		return "", fmt.Errorf("invalid key: %s", s)
+	}
+	return s, nil
+}
+
+// indexComment returns the index of the comment of a line, which starts with
+// a '#' that is not within a quoted string and is at the start of the line or
+// after whitespace, so that values such as "a#b" are kept. Returns -1 if there
+// is none.
+func indexComment(line string) int {
+	for i := 0; i < len(line); {
+		j := indexUnquoted(line[i:], '#')
+		if j == -1 {
+			return -1
+		} else if j += i; j == 0 || line[j-1] == ' ' || line[j-1] == '\t' {
+			return j
+		}
+		i = j + 1
+	}
+	return -1
+}
+
+// indexUnquoted returns the index of the first ch in s that is not within a
+// quoted string. Returns -1 if there is none.
+func indexUnquoted(s string, ch byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == ch:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Ensure that the supported subset of TOML is parsed into settings.
+func TestParseTOML(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		src      string
+		settings []setting
+		err      string
+	}{
+		{
+			name:     "Scalars",
+			src:      "tabwidth = 4\nstrict = true\nratio = 1_000.5\npackage = \"main\"\n",
+			settings: []setting{{"tabwidth", "4", 1}, {"strict", "true", 2}, {"ratio", "1000.5", 3}, {"package", "main", 4}},
+		},
+		{
+			name:     "Comments",
+			src:      "# header\nstrict = true # trailing\n\tpackage = \"a#b\"\n",
+			settings: []setting{{"strict", "true", 2}, {"package", "a#b", 3}},
+		},
+		{
+			name:     "Quoting",
+			src:      "package = \"a \\\"b\\\"\"\nfilter = 'c\\d'\n\"fast-writer\" = true\n",
+			settings: []setting{{"package", `a "b"`, 1}, {"filter", `c\d`, 2}, {"fast-writer", "true", 3}},
+		},
+		{
+			name:     "Arrays",
+			src:      "filter = [\"upper=strings.ToUpper\", \"a,b\"]\nfilter = []\n",
+			settings: []setting{{"filter", "upper=strings.ToUpper", 1}, {"filter", "a,b", 1}},
+		},
+		{
+			name:     "Tables",
+			src:      "[deprecated]\n\"ego:Button\" = \"use ego:Btn\"\n",
+			settings: []setting{{"deprecated", "ego:Button=use ego:Btn", 2}},
+		},
+		{
+			name: "CommentWithoutSpace",
+			src:  "tabwidth = 4#four\n",
+			err:  "1: invalid value: 4#four",
+		},
+		{
+			name: "UnterminatedArray",
+			src:  "\nfilter = [\"a\"\n",
+			err:  "2: unterminated array: [\"a\"",
+		},
+		{
+			name: "ArrayOfTables",
+			src:  "[[deprecated]]\n",
+			err:  "1: invalid table: [[deprecated]]",
+		},
+		{
+			name: "UnquotedString",
+			src:  "package = main\n",
+			err:  "1: invalid value: main",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := parseTOML([]byte(tt.src))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(settings, tt.settings) {
+				t.Fatalf("unexpected settings: %+v", settings)
+			}
+		})
+	}
+}
+
+// Ensure that the supported subset of YAML is parsed into settings.
+func TestParseYAML(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		src      string
+		settings []setting
+		err      string
+	}{
+		{
+			name:     "Scalars",
+			src:      "---\ntabwidth: 4\nstrict: true\npackage: main\n",
+			settings: []setting{{"tabwidth", "4", 2}, {"strict", "true", 3}, {"package", "main", 4}},
+		},
+		{
+			name:     "Comments",
+			src:      "# header\nstrict: true # trailing\npackage: a#b\nsanitizer: \"c #d\"\n",
+			settings: []setting{{"strict", "true", 2}, {"package", "a#b", 3}, {"sanitizer", "c #d", 4}},
+		},
+		{
+			name:     "Quoting",
+			src:      "package: 'it''s'\nfilter: \"a: b\"\n'fast-writer': true\n",
+			settings: []setting{{"package", "it's", 1}, {"filter", "a: b", 2}, {"fast-writer", "true", 3}},
+		},
+		{
+			name:     "FlowSequence",
+			src:      "filter: [upper=strings.ToUpper, \"a,b\"]\n",
+			settings: []setting{{"filter", "upper=strings.ToUpper", 1}, {"filter", "a,b", 1}},
+		},
+		{
+			name:     "BlockSequence",
+			src:      "filter:\n  - upper=strings.ToUpper # comment\n  - lower=strings.ToLower\nstrict: true\n",
+			settings: []setting{{"filter", "upper=strings.ToUpper", 2}, {"filter", "lower=strings.ToLower", 3}, {"strict", "true", 4}},
+		},
+		{
+			name:     "BlockMapping",
+			src:      "deprecated:\n  \"ego:Button\": use ego:Btn\n",
+			settings: []setting{{"deprecated", "ego:Button=use ego:Btn", 2}},
+		},
+		{
+			name: "UnexpectedIndentation",
+			src:  "  strict: true\n",
+			err:  "1: unexpected indentation",
+		},
+		{
+			name: "MissingValue",
+			src:  "strict\n",
+			err:  "1: expected key: value: strict",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := parseYAML([]byte(tt.src))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(settings, tt.settings) {
+				t.Fatalf("unexpected settings: %+v", settings)
+			}
+		})
+	}
+}
+
+// Ensure that unknown keys of a config file are reported at their line.
+func TestConfig_UnknownKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ego.toml")
+	if err := ioutil.WriteFile(path, []byte("strict = true\nunknown = 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newConfig(nil).options(dir); err == nil || err.Error() != path+":2: unknown option: unknown" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/scanner"
+	"io"
+	"os"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Diagnostic represents an error or warning as written by -diagnostics=json.
+// Line & column start at 1 and are omitted if the position is unknown.
+type Diagnostic struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Severities of diagnostics.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// diagnostics writes the errors & warnings found while processing templates.
+// They are written as text, or as one JSON object per line if json is set.
+type diagnostics struct {
+	w    io.Writer
+	json bool
+}
+
+// newDiagnostics returns diagnostics written to stderr as text, or to stdout
+// as JSON so that they are not mixed with other output.
+func newDiagnostics(json bool) *diagnostics {
+	if json {
+		return &diagnostics{w: os.Stdout, json: true}
+	}
+	return &diagnostics{w: os.Stderr}
+}
+
+// error writes an error found in the template at path. Errors with positions,
+// such as syntax errors, are written at their position and lists of errors
+// are written as one diagnostic per error.
+func (d *diagnostics) error(path string, err error) {
+	if !d.json {
+		fmt.Fprintln(d.w, err)
+		return
+	}
+
+	switch err := err.(type) {
+	case ego.ErrorList:
+		for _, e := range err {
+			d.error(path, e)
+		}
+	case *ego.SyntaxError:
+		d.write(Diagnostic{Path: err.Pos.Path, Line: err.Pos.LineNo, Column: err.Pos.Column, Severity: SeverityError, Message: err.Message})
+	case scanner.ErrorList:
+		for _, e := range err {
+			d.error(path, e)
+		}
+	case *scanner.Error:
+		// Go syntax errors in generated code are positioned at the template
+		// by //line directives, except before the first directive.
+		if err.Pos.Filename == "" {
+			d.write(Diagnostic{Path: path, Severity: SeverityError, Message: err.Msg})
+			return
+		}
+		d.write(Diagnostic{Path: err.Pos.Filename, Line: err.Pos.Line, Column: err.Pos.Column, Severity: SeverityError, Message: err.Msg})
+	default:
+		d.write(Diagnostic{Path: path, Severity: SeverityError, Message: err.Error()})
+	}
+}
+
+// failed writes the error returned for a template. Errors that only
+// summarize diagnostics that were already written are skipped if writing JSON.
+func (d *diagnostics) failed(path string, err error) {
+	if _, ok := err.(reportedError); ok && d.json {
+		return
+	}
+	d.error(path, err)
+}
+
+// warning writes a warning, such as from the linter or vet.
+func (d *diagnostics) warning(w *ego.Warning) {
+	if !d.json {
+		fmt.Fprintln(d.w, w)
+		return
+	}
+	d.write(Diagnostic{Path: w.Pos.Path, Line: w.Pos.LineNo, Column: w.Pos.Column, Severity: SeverityWarning, Message: w.Message})
+}
+
+// write writes a diagnostic as JSON.
+func (d *diagnostics) write(diag Diagnostic) {
+	buf, _ := json.Marshal(diag)
+	d.w.Write(append(buf, '\n'))
+}
+
+// reportedError is returned for a template whose errors were already written
+// as diagnostics. Its message only summarizes them, such as the number of
+// syntax errors, so it is not written as a diagnostic of its own.
+type reportedError struct {
+	error
+}
+
+// diagnosticsFlag implements flag.Value for the format of diagnostics.
+type diagnosticsFlag bool
+
+func (f *diagnosticsFlag) String() string {
+	if *f {
+		return "json"
+	}
+	return "text"
+}
+
+func (f *diagnosticsFlag) Set(s string) error {
+	switch s {
+	case "text":
+		*f = false
+	case "json":
+		*f = true
+	default:
+		return fmt.Errorf("invalid diagnostics format: %q", s)
+	}
+	return nil
+}
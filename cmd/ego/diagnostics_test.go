@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/scanner"
+	"go/token"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that errors & warnings are written as one JSON object per line with
+// their positions, and that summaries of reported errors are skipped.
+func TestDiagnostics_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	d := &diagnostics{w: &buf, json: true}
+
+	d.error("page.ego", ego.ErrorList{
+		ego.NewSyntaxError(ego.Pos{Path: "page.ego", LineNo: 2, Column: 3}, "Unexpected %s", "end"),
+		ego.NewSyntaxError(ego.Pos{Path: "header.ego", LineNo: 1}, "Missing package"),
+	})
+	d.error("page.ego", scanner.ErrorList{
+		{Pos: token.Position{Filename: "page.ego", Line: 5, Column: 1}, Msg: "expected ';'"},
+		{Msg: "expected 'package'"},
+	})
+	d.warning(&ego.Warning{Pos: ego.Pos{Path: "page.ego", LineNo: 7, Column: 2}, Message: "unused attribute"})
+	d.failed("page.ego", errors.New("open page.ego: permission denied"))
+	d.failed("page.ego", reportedError{errors.New("2 errors")})
+
+	exp := `{"path":"page.ego","line":2,"column":3,"severity":"error","message":"Unexpected end"}
+{"path":"header.ego","line":1,"severity":"error","message":"Missing package"}
+{"path":"page.ego","line":5,"column":1,"severity":"error","message":"expected ';'"}
+{"path":"page.ego","severity":"error","message":"expected 'package'"}
+{"path":"page.ego","line":7,"column":2,"severity":"warning","message":"unused attribute"}
+{"path":"page.ego","severity":"error","message":"open page.ego: permission denied"}
+`
+	if buf.String() != exp {
+		t.Fatalf("unexpected output:\n%s\n\nexpected:\n%s", buf.String(), exp)
+	}
+}
+
+// Ensure that diagnostics are written as text by default, including the
+// summaries of reported errors.
+func TestDiagnostics_Text(t *testing.T) {
+	var buf bytes.Buffer
+	d := &diagnostics{w: &buf}
+	d.error("page.ego", ego.NewSyntaxError(ego.Pos{Path: "page.ego", LineNo: 2}, "Unexpected end"))
+	d.warning(&ego.Warning{Pos: ego.Pos{Path: "page.ego", LineNo: 7}, Message: "unused attribute"})
+	d.failed("page.ego", reportedError{errors.New("2 errors")})
+
+	if exp := "Unexpected end at page.ego:2\nunused attribute at page.ego:7\n2 errors\n"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
+
+// Ensure that the diagnostics flag only accepts known formats.
+func TestDiagnosticsFlag(t *testing.T) {
+	var f diagnosticsFlag
+	if err := f.Set("json"); err != nil || !bool(f) || f.String() != "json" {
+		t.Fatalf("unexpected flag: %v %v", f, err)
+	} else if err := f.Set("text"); err != nil || bool(f) || f.String() != "text" {
+		t.Fatalf("unexpected flag: %v %v", f, err)
+	} else if err := f.Set("xml"); err == nil || err.Error() != `invalid diagnostics format: "xml"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/benbjohnson/ego"
+)
+
+// runDoc writes a Markdown reference of the components of a package: their
+// fields & closures with their doc comments, and example usages from the
+// templates of the package and of the directories passed with -usages.
+func runDoc(args []string) error {
+	fs := flag.NewFlagSet("ego doc", flag.ContinueOnError)
+	var p ego.Parser
+	fs.BoolVar(&p.Interpolate, "interpolate", false, "parse ${expr} within text as print blocks")
+	fs.Var((*delimsFlag)(&p.Delims), "delims", "open & close delimiters of ego blocks (e.g. \"{{% %}}\")")
+	fs.BoolVar(&p.PreserveWhitespace, "preserve-whitespace", false, "write all template text byte-for-byte")
+	fs.StringVar(&p.IncludeRoot, "include-root", ".", "directory that files of include & extends directives must be within (blank to disable)")
+	var usages []string
+	fs.Var((*listFlag)(&usages), "usages", "directory of templates that use the components of the package")
+	examples := fs.Int("examples", 3, "maximum number of example usages of each component")
+	output := fs.String("o", "", "write the reference to a file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 1 {
+		return fmt.Errorf("ego doc: expected one package directory, found %d", fs.NArg())
+	}
+
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	templates, err := packageTemplates(dir, &p)
+	if err != nil {
+		return err
+	}
+	docs, err := ego.Document(dir, templates)
+	if err != nil {
+		return err
+	}
+	for _, other := range usages {
+		templates, err := packageTemplates(other, &p)
+		if err != nil {
+			return err
+		} else if err := ego.DocumentUsages(docs, dir, templates); err != nil {
+			return err
+		}
+	}
+
+	if *output == "" {
+		return writeDoc(os.Stdout, packageName(dir), docs, *examples)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := writeDoc(f, packageName(dir), docs, *examples); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// packageTemplates parses the templates of the package in dir. Templates
+// included by other templates are skipped.
+func packageTemplates(dir string, p *ego.Parser) ([]*ego.Template, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	included := includedFiles(dir, fis, p)
+
+	var templates []*ego.Template
+	for _, fi := range fis {
+		path := filepath.Join(dir, fi.Name())
+		if fi.IsDir() || filepath.Ext(path) != ".ego" || included[path] {
+			continue
+		}
+		tmpl, err := p.ParseFile(path)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.Package = packageName(dir)
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+// writeDoc writes the Markdown reference of the components of a package,
+// starting with an index that links to each component.
+func writeDoc(w io.Writer, pkg string, docs []*ego.ComponentDoc, examples int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Components of package %s\n\n", pkg)
+	if len(docs) == 0 {
+		fmt.Fprintln(bw, "No components.")
+		return bw.Flush()
+	}
+	for _, doc := range docs {
+		fmt.Fprintf(bw, "- [%s](#%s)\n", doc.Name, strings.ToLower(doc.Name))
+	}
+
+	for _, doc := range docs {
+		fmt.Fprintf(bw, "\n## %s\n\n", doc.Name)
+		if doc.Doc != "" {
+			fmt.Fprintf(bw, "%s\n\n", doc.Doc)
+		}
+		fmt.Fprintf(bw, "Declared at `%s:%d`.\n", doc.Pos.Path, doc.Pos.LineNo)
+
+		if len(doc.Fields) > 0 {
+			fmt.Fprint(bw, "\n| Field | Type | Description |\n| --- | --- | --- |\n")
+			for _, field := range doc.Fields {
+				fmt.Fprintf(bw, "| `%s` | `%s` | %s |\n", field.Name, tableCell(field.Type), tableCell(field.Doc))
+			}
+		}
+
+		if len(doc.Closures) > 0 {
+			fmt.Fprint(bw, "\n| Closure | Type | Description |\n| --- | --- | --- |\n")
+			for _, field := range doc.Closures {
+				name := fmt.Sprintf("`<ego::%s>`", field.Name)
+				if field.Name == "Yield" {
+					name = "body (`Yield`)"
+				}
+				fmt.Fprintf(bw, "| %s | `%s` | %s |\n", name, tableCell(field.Type), tableCell(field.Doc))
+			}
+		}
+
+		if len(doc.Usages) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "\nUsed %d time(s).", len(doc.Usages))
+		if examples > 0 {
+			fmt.Fprint(bw, " Examples:")
+		}
+		fmt.Fprintln(bw)
+		for i, blk := range doc.Usages {
+			if i == examples {
+				break
+			}
+			fmt.Fprintf(bw, "\n`%s:%d`\n\n```\n%s\n```\n", blk.Pos.Path, blk.Pos.LineNo, exampleTag(blk))
+		}
+	}
+	return bw.Flush()
+}
+
+// exampleTag returns the start tag of a component usage with its attributes,
+// followed by its named closures & end tag if it has a body.
+func exampleTag(blk *ego.ComponentStartBlock) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "<%s:%s", blk.Namespace(), blk.Name)
+	if blk.For != "" {
+		fmt.Fprintf(&buf, " for=(%s)", blk.For)
+	}
+	if blk.Flag != "" {
+		fmt.Fprintf(&buf, " flag=%s", blk.Flag)
+	}
+	if blk.Load != "" {
+		fmt.Fprintf(&buf, " load=%s", blk.Load)
+	}
+	for _, field := range blk.Fields {
+		fmt.Fprintf(&buf, " %s=%s", field.Name, field.Value)
+	}
+	for _, attr := range blk.Attrs {
+		if attr.Value == "" {
+			fmt.Fprintf(&buf, " %s", attr.Name)
+		} else {
+			fmt.Fprintf(&buf, " %s=%s", attr.Name, attr.Value)
+		}
+	}
+	for _, spread := range blk.Spreads {
+		fmt.Fprintf(&buf, " ...%s", spread.Value)
+	}
+
+	if blk.Closed {
+		buf.WriteString(" />")
+		return buf.String()
+	}
+	buf.WriteString(">\n")
+	for _, attrBlock := range blk.AttrBlocks {
+		fmt.Fprintf(&buf, "\t<%s::%s>…</%s::%s>\n", attrBlock.Namespace(), attrBlock.Name, attrBlock.Namespace(), attrBlock.Name)
+	}
+	if len(blk.Yield) > 0 {
+		buf.WriteString("\t…\n")
+	}
+	fmt.Fprintf(&buf, "</%s:%s>", blk.Namespace(), blk.Name)
+	return buf.String()
+}
+
+// tableCell escapes text for a cell of a Markdown table.
+func tableCell(s string) string {
+	s = strings.Replace(s, "\n", " ", -1)
+	return strings.Replace(s, "|", `\|`, -1)
+}
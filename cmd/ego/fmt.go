@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/benbjohnson/ego"
+)
+
+// runFmt formats templates. By default the formatted templates are written
+// to stdout. Directories are not searched recursively.
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("ego fmt", flag.ContinueOnError)
+	list := fs.Bool("l", false, "list templates whose formatting differs")
+	write := fs.Bool("w", false, "write the formatted templates to their files")
+	var p ego.Parser
+	fs.BoolVar(&p.Interpolate, "interpolate", false, "parse ${expr} within text as print blocks")
+	fs.Var((*delimsFlag)(&p.Delims), "delims", "open & close delimiters of ego blocks (e.g. \"{{% %}}\")")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		} else if !fi.IsDir() {
+			if err := formatFile(&p, path, *list, *write); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fis, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if fi.IsDir() || filepath.Ext(fi.Name()) != ".ego" {
+				continue
+			}
+			if err := formatFile(&p, filepath.Join(path, fi.Name()), *list, *write); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatFile formats a single template with the syntax of p. Its name is
+// printed if list is set and the file is rewritten if write is set. Otherwise
+// the formatted template is written to stdout.
+func formatFile(p *ego.Parser, path string, list, write bool) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out, err := p.Format(src, path)
+	if err != nil {
+		return err
+	}
+
+	changed := !bytes.Equal(src, out)
+	if list && changed {
+		fmt.Println(path)
+	}
+	if write && changed {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, out, fi.Mode())
+	}
+	if !list && !write {
+		_, err = os.Stdout.Write(out)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/benbjohnson/ego"
+)
+
+// runI18n runs an i18n subcommand. The only subcommand is "extract".
+func runI18n(args []string) error {
+	if len(args) == 0 || args[0] != "extract" {
+		return fmt.Errorf("usage: ego i18n extract [-format json|po] [-o FILE] [PATH...]")
+	}
+	return runI18nExtract(args[1:])
+}
+
+// catalogEntry represents a message key & the positions of its translate
+// directives.
+type catalogEntry struct {
+	Key       string   `json:"key"`
+	Positions []string `json:"positions"`
+}
+
+// runI18nExtract writes the message keys of the translate directives in
+// templates to a catalog, sorted by key. Directories are not searched
+// recursively.
+func runI18nExtract(args []string) error {
+	fs := flag.NewFlagSet("ego i18n extract", flag.ContinueOnError)
+	format := fs.String("format", "json", "catalog format: json or po")
+	output := fs.String("o", "", "write the catalog to file instead of stdout")
+	var p ego.Parser
+	fs.BoolVar(&p.Interpolate, "interpolate", false, "parse ${expr} within text as print blocks")
+	fs.Var((*delimsFlag)(&p.Delims), "delims", "open & close delimiters of ego blocks (e.g. \"{{% %}}\")")
+	fs.StringVar(&p.IncludeRoot, "include-root", ".", "directory that files of include & extends directives must be within (blank to disable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *format != "json" && *format != "po" {
+		return fmt.Errorf("invalid catalog format: %s", *format)
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	// Collect the positions of each key.
+	m := make(map[string]*catalogEntry)
+	for _, path := range templatePaths(paths) {
+		tmpl, err := p.ParseFile(path)
+		if err != nil {
+			return err
+		}
+		for _, msg := range ego.ExtractMessages(tmpl) {
+			entry := m[msg.Key]
+			if entry == nil {
+				entry = &catalogEntry{Key: msg.Key}
+				m[msg.Key] = entry
+			}
+			entry.Positions = append(entry.Positions, fmt.Sprintf("%s:%d", msg.Pos.Path, msg.Pos.LineNo))
+		}
+	}
+	entries := make([]*catalogEntry, 0, len(m))
+	for _, entry := range m {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	var buf bytes.Buffer
+	if *format == "po" {
+		writePO(&buf, entries)
+	} else {
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(entries); err != nil {
+			return err
+		}
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(*output, buf.Bytes(), 0666)
+}
+
+// templatePaths returns the paths of the templates in each path, which is
+// either a template or a directory of templates. Unreadable paths are
+// returned as-is so that their errors are reported when they are parsed.
+func templatePaths(paths []string) []string {
+	var a []string
+	for _, path := range paths {
+		fis, err := ioutil.ReadDir(path)
+		if err != nil {
+			a = append(a, path)
+			continue
+		}
+		for _, fi := range fis {
+			if !fi.IsDir() && filepath.Ext(fi.Name()) == ".ego" {
+				a = append(a, filepath.Join(path, fi.Name()))
+			}
+		}
+	}
+	return a
+}
+
+// writePO writes catalog entries in the gettext PO format with empty
+// translations. Each entry has a reference comment for its positions.
+func writePO(buf *bytes.Buffer, entries []*catalogEntry) {
+	for i, entry := range entries {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "#: %s\n", strings.Join(entry.Positions, " "))
+		fmt.Fprintf(buf, "msgid %s\n", poQuote(entry.Key))
+		buf.WriteString("msgstr \"\"\n")
+	}
+}
+
+// poQuote returns s as a quoted PO string.
+func poQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
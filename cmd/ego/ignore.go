@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ignoreFileName is the name of files listing ignore patterns.
+const ignoreFileName = ".egoignore"
+
+// ignorer matches the files found while traversing directories against
+// ignore patterns. Patterns are globs as defined by path.Match. A
+// pattern without a slash matches the name of a file or any directory
+// above it, such as "testdata" or "*_mock.ego". Other patterns match the
+// path relative to the directory of their .egoignore file, or the working
+// directory for patterns from the command line, and everything below it.
+//
+// As in .gitignore files, a pattern ending with a slash only matches
+// directories and a pattern starting with "!" includes the files it matches
+// again. The last matching pattern decides whether a file is ignored.
+type ignorer struct {
+	patterns []string // from the command line
+
+	mu    sync.Mutex
+	files map[string][]string // patterns of the .egoignore file by directory
+}
+
+func newIgnorer(patterns []string) *ignorer {
+	return &ignorer{patterns: patterns, files: make(map[string][]string)}
+}
+
+// ignored returns true if a file is ignored by the patterns of the .egoignore
+// files in its directory & its parents, farthest first, and then by the
+// patterns from the command line.
+func (ig *ignorer) ignored(filename string) bool {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return false
+	}
+
+	var dirs []string
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	var ignored bool
+	for i := len(dirs) - 1; i >= 0; i-- {
+		ignored = matchIgnore(ig.filePatterns(dirs[i]), dirs[i], abs, ignored)
+	}
+	if wd, err := os.Getwd(); err == nil {
+		ignored = matchIgnore(ig.patterns, wd, abs, ignored)
+	}
+	return ignored
+}
+
+// filePatterns returns the patterns of the .egoignore file in dir. Blank
+// lines & lines starting with "#" are skipped. Returns nil if the file does
+// not exist or cannot be read.
+func (ig *ignorer) filePatterns(dir string) []string {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	if patterns, ok := ig.files[dir]; ok {
+		return patterns
+	}
+
+	var patterns []string
+	if f, err := os.Open(filepath.Join(dir, ignoreFileName)); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+		f.Close()
+	}
+	ig.files[dir] = patterns
+	return patterns
+}
+
+// matchIgnore returns whether the absolute filename is ignored after applying
+// the patterns relative to dir, in order, to the result of previous patterns.
+// Files outside of dir are not matched.
+func matchIgnore(patterns []string, dir, filename string, ignored bool) bool {
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ignored
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		negate := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		// Directory patterns only match the directories above the file.
+		names := elems
+		if strings.HasSuffix(pattern, "/") {
+			names = elems[:len(elems)-1]
+		}
+		if matchPattern(strings.Trim(pattern, "/"), names) {
+			ignored = !negate
+		}
+	}
+	return ignored
+}
+
+// matchPattern returns true if a pattern without a slash matches any name of
+// a path, or if any other pattern matches the leading names of the path so
+// that a pattern matching a directory also matches everything below it.
+func matchPattern(pattern string, names []string) bool {
+	if !strings.Contains(pattern, "/") {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	n := strings.Count(pattern, "/") + 1
+	if n > len(names) {
+		return false
+	}
+	ok, _ := path.Match(pattern, strings.Join(names[:n], "/"))
+	return ok
+}
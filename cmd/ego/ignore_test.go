@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Ensure that ignore patterns match names, relative paths & directories, and
+// that negated patterns include files again.
+func TestMatchIgnore(t *testing.T) {
+	dir := filepath.FromSlash("/app")
+	for _, tt := range []struct {
+		patterns []string
+		filename string
+		exp      bool
+	}{
+		{[]string{"*_mock.ego"}, "views/page_mock.ego", true},
+		{[]string{"*_mock.ego"}, "views/page.ego", false},
+		{[]string{"testdata"}, "views/testdata/page.ego", true},
+		{[]string{"views/drafts"}, "views/drafts/new/page.ego", true},
+		{[]string{"views/drafts"}, "other/views/drafts/page.ego", false},
+		{[]string{"/views/"}, "views/page.ego", true},
+		{[]string{"drafts/"}, "views/drafts/page.ego", true},
+		{[]string{"page.ego/"}, "views/page.ego", false},
+		{[]string{"*.ego", "!keep.ego"}, "views/keep.ego", false},
+		{[]string{"*.ego", "!keep.ego"}, "views/page.ego", true},
+		{[]string{"!keep.ego", "*.ego"}, "views/keep.ego", true},
+		{[]string{"views", "!views/public"}, "views/public/page.ego", false},
+		{[]string{"*.ego"}, "../other/page.ego", false},
+	} {
+		if ok := matchIgnore(tt.patterns, dir, filepath.Join(dir, filepath.FromSlash(tt.filename)), false); ok != tt.exp {
+			t.Errorf("%v %s: unexpected result: %v", tt.patterns, tt.filename, ok)
+		}
+	}
+}
+
+// Ensure that nested .egoignore files are applied after their parents.
+func TestIgnorer_Nested(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "views", "public"), 0777); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, ignoreFileName), []byte("# comment\n*_mock.ego\n"), 0666); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, "views", "public", ignoreFileName), []byte("!demo_mock.ego\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ig := newIgnorer(nil)
+	for filename, exp := range map[string]bool{
+		"page_mock.ego":                true,
+		"views/page.ego":               false,
+		"views/demo_mock.ego":          true,
+		"views/public/demo_mock.ego":   false,
+		"views/public/other_mock.ego":  true,
+		"views/public/nested/page.ego": false,
+	} {
+		if ok := ig.ignored(filepath.Join(dir, filepath.FromSlash(filename))); ok != exp {
+			t.Errorf("%s: unexpected result: %v", filename, ok)
+		}
+	}
+
+	// Patterns from the command line are relative to the working directory
+	// and are applied last.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if ig := newIgnorer([]string{"views/"}); !ig.ignored(filepath.Join(dir, "views", "public", "demo_mock.ego")) {
+		t.Error("expected command line pattern to ignore file")
+	}
+}
@@ -2,12 +2,22 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"go/parser"
+	"go/printer"
+	"go/token"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/benbjohnson/ego"
 )
@@ -23,31 +33,70 @@ func main() {
 }
 
 func run(args []string) error {
-	fs := flag.NewFlagSet("ego", flag.ContinueOnError)
-	versionFlag := fs.Bool("version", false, "print version")
-	verbose := fs.Bool("v", false, "verbose")
-	if err := fs.Parse(args); err != nil {
+	if len(args) > 0 && args[0] == "fmt" {
+		return runFmt(args[1:])
+	} else if len(args) > 0 && args[0] == "parse" {
+		return runParse(args[1:])
+	} else if len(args) > 0 && args[0] == "vet" {
+		return runVet(args[1:])
+	} else if len(args) > 0 && args[0] == "i18n" {
+		return runI18n(args[1:])
+	} else if len(args) > 0 && args[0] == "clean" {
+		return runClean(args[1:])
+	} else if len(args) > 0 && args[0] == "doc" {
+		return runDoc(args[1:])
+	}
+
+	cfg := newConfig(args)
+	cmd, opt, fs, err := cfg.parse(".")
+	if err != nil {
 		return err
 	}
+	cfg.base = opt
 
 	log.SetFlags(0)
-	if !*verbose {
+	if !cmd.verbose {
 		log.SetOutput(ioutil.Discard)
 	}
 
 	// If the version flag is set then print the version.
-	if *versionFlag {
+	if cmd.version {
 		fmt.Printf("ego %s\n", Version)
 		return nil
 	}
 
+	diag := newDiagnostics(bool(cmd.diagnostics))
+
 	// If no paths are provided then use the present working directory.
 	paths := fs.Args()
 	if len(paths) == 0 {
 		paths = []string{"."}
 	}
 
+	// Regenerate templates as they change, if enabled.
+	if cmd.watch && opt.Check {
+		return fmt.Errorf("cannot use -check with -watch")
+	} else if cmd.watch {
+		for _, path := range paths {
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+		}
+		return watch(paths, cfg, newIgnorer(cmd.ignore), diag, cmd.manifestPath, cmd.watchInterval)
+	}
+
+	// Skip templates that are unchanged since the last run, unless checking.
+	if cmd.cachePath != "" && !opt.Check {
+		cache, err := loadCache(cmd.cachePath, cmd.force)
+		if err != nil {
+			return err
+		}
+		opt.Cache = cache
+	}
+
 	// Find all templates in each directory.
+	var templates, dirs []string
+	ig := newIgnorer(cmd.ignore)
 	for _, path := range paths {
 		fi, err := os.Stat(path)
 		if err != nil {
@@ -56,73 +105,860 @@ func run(args []string) error {
 
 		// Process all ego files in directory.
 		if fi.IsDir() {
-			if err := processDir(path); err != nil {
+			dirOpt, err := cfg.options(path)
+			if err != nil {
 				return err
 			}
+			a, err := dirTemplates(path, &dirOpt.Parser, ig)
+			if err != nil {
+				return err
+			}
+			templates = append(templates, a...)
+			dirs = append(dirs, path)
 			continue
 		}
 
 		// Ignore files without an .ego extension.
-		if filepath.Ext(path) != ".ego" {
-			continue
+		if filepath.Ext(path) == ".ego" {
+			templates = append(templates, path)
+		}
+	}
+
+	// Generate templates concurrently.
+	files, err := processFiles(templates, cfg, diag)
+	if err != nil {
+		return err
+	}
+
+	// Record the hashes of generated templates.
+	if opt.Cache != nil {
+		if err := opt.Cache.save(); err != nil {
+			return err
+		}
+	}
+
+	// Remove generated files of templates that no longer exist, if requested.
+	// They are reported as out of date instead, if checking.
+	var orphaned int
+	if cmd.prune {
+		for _, dir := range dirs {
+			a, err := orphanedFiles(dir, ig)
+			if err != nil {
+				return err
+			}
+			for _, filename := range a {
+				if opt.Check {
+					diag.error(filename, fmt.Errorf("%s: template no longer exists", filename))
+					orphaned++
+					continue
+				}
+				log.Printf("[prune] %s", filename)
+				if err := os.Remove(filename); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Fail if any generated file is out of date, if checking.
+	if opt.Check {
+		stale := orphaned
+		for _, file := range files {
+			if file.stale {
+				stale++
+			}
+		}
+		if stale > 0 {
+			return fmt.Errorf("%d generated file(s) out of date, run ego to regenerate", stale)
+		}
+		return nil
+	}
+
+	// Write manifest of generated files, if requested.
+	if cmd.manifestPath != "" {
+		if err := writeManifest(cmd.manifestPath, files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// command represents the settings of the ego command other than the options
+// applied to each template.
+type command struct {
+	version        bool
+	verbose        bool
+	watch          bool
+	watchInterval  time.Duration
+	force          bool
+	prune          bool
+	diagnostics    diagnosticsFlag
+	ignore         []string
+	cachePath      string
+	manifestPath   string
+	schemasPath    string
+	defaultFilters bool
+}
+
+// defaultLinter is the linter used with -lint unless -purity is set.
+var defaultLinter = ego.Linter{Purity: ego.PurityMutations}
+
+// newFlagSet returns the flags of the ego command, which set cmd & opt.
+func newFlagSet(cmd *command, opt *Options) *flag.FlagSet {
+	fs := flag.NewFlagSet("ego", flag.ContinueOnError)
+	fs.BoolVar(&cmd.version, "version", false, "print version")
+	fs.BoolVar(&cmd.verbose, "v", false, "verbose")
+	fs.BoolVar(&cmd.watch, "watch", false, "regenerate templates as they change and report errors without exiting")
+	fs.DurationVar(&cmd.watchInterval, "watch-interval", 500*time.Millisecond, "how often -watch checks templates for changes")
+	fs.BoolVar(&opt.Check, "check", false, "report generated files that are out of date instead of writing them")
+	fs.BoolVar(&cmd.force, "force", false, "regenerate templates even if they are unchanged since the last run")
+	fs.Var(&cmd.diagnostics, "diagnostics", "format of errors & warnings: text or json (written to stdout)")
+	fs.BoolVar(&cmd.prune, "prune", false, "remove generated files in directories whose templates no longer exist")
+	fs.Var((*listFlag)(&cmd.ignore), "ignore", "skip files found in directories that match a glob pattern (e.g. testdata or *_mock.ego)")
+	fs.StringVar(&cmd.cachePath, "cache", defaultCachePath(), "file recording template hashes used to skip unchanged templates (blank to disable)")
+	fs.BoolVar(&opt.Spaces, "spaces", false, "indent generated code with spaces")
+	fs.IntVar(&opt.TabWidth, "tabwidth", 8, "tab width of generated code")
+	fs.BoolVar(&opt.HTMLMethod, "html-method", false, "generate HTML methods for html/template interop")
+	fs.BoolVar(&opt.CountedMethod, "counted-method", false, "generate RenderCounted methods that return bytes written")
+	fs.BoolVar(&opt.StdMethods, "std-methods", false, "generate String and WriteTo methods for fmt.Stringer and io.WriterTo")
+	fs.BoolVar(&opt.ResponseMethod, "response-method", false, "generate RenderResponse methods that render to an http.ResponseWriter")
+	fs.StringVar(&opt.ContentType, "content-type", "", "content type of RenderResponse methods (default detected from path)")
+	fs.BoolVar(&opt.ReturnErrors, "return-errors", false, "generate code for components whose Render methods return errors")
+	fs.BoolVar(&opt.WriteErrors, "write-errors", false, "return the first write error from generated code (implies -return-errors)")
+	fs.BoolVar(&opt.ValidateRender, "validate-render", false, "call Validate at the start of Render methods that return errors")
+	fs.BoolVar(&opt.CopyAttrs, "copy-attrs", false, "copy the Attrs map of components at the start of Render methods")
+	fs.BoolVar(&opt.DefaultYield, "default-yield", false, "set an empty Yield closure on components used without a body")
+	fs.BoolVar(&opt.Pool, "pool", false, "render components into pooled buffers and write each buffer when its render returns")
+	fs.BoolVar(&opt.RecoverPanics, "recover-panics", false, "recover panics in Render methods and pass them to the panic handler")
+	fs.StringVar(&opt.PanicHandler, "panic-handler", ego.DefaultPanicHandler, "function called with panics recovered by Render methods & errors of dynamic components")
+	fs.BoolVar(&opt.FunctionalOptions, "functional-options", false, "generate functional options constructors for components")
+	fs.BoolVar(&opt.QueryMethod, "query-method", false, "generate FromQuery methods that set component fields from URL query parameters")
+	fs.BoolVar(&opt.SetFieldMethod, "set-field-method", false, "generate SetField methods that set component fields by name")
+	fs.BoolVar(&opt.IntoMethod, "into-method", false, "generate RenderInto methods that render into a caller-provided buffer")
+	fs.BoolVar(&opt.StringMethods, "string-methods", false, "generate RenderString and RenderBytes methods that render into a pooled buffer")
+	fs.BoolVar(&opt.BoundedMethod, "bounded-method", false, "generate RenderBounded methods that stream to a bounded writer")
+	fs.BoolVar(&opt.TruncateMethod, "truncate-method", false, "generate RenderTruncated methods that cut output to a byte budget")
+	fs.BoolVar(&opt.TestHelpers, "test-helpers", false, "write AssertRender test helpers to a _test.go file for each template")
+	fs.StringVar(&opt.Sanitizer, "sanitizer", ego.DefaultSanitizer, "function called by sanitize print blocks")
+	fs.StringVar(&opt.FeatureFunc, "feature-func", ego.DefaultFeatureFunc, "function called to check component feature flags")
+	fs.BoolVar(&opt.TrimPath, "trimpath", false, "write //line paths relative to the module root")
+	fs.Var((*lineDirectivesFlag)(&opt.LineDirectives), "line-directives", "how template positions are written: on, off, or comment")
+	fs.Var((*lineEndingFlag)(&opt.LineEnding), "line-ending", "line endings of template text: preserve, lf, or crlf")
+	fs.IntVar(&opt.MaxLiteralLen, "max-literal", 0, "split text into string literals of at most n bytes")
+	fs.IntVar(&opt.HoistTextLen, "hoist-text", 0, "write text blocks of at least n bytes from package-level []byte variables")
+	fs.IntVar(&opt.MaxDepth, "max-depth", ego.DefaultMaxDepth, "maximum nesting depth of components")
+	fs.BoolVar(&opt.Parser.Interpolate, "interpolate", false, "parse ${expr} within text as print blocks")
+	fs.Var((*delimsFlag)(&opt.Parser.Delims), "delims", "open & close delimiters of ego blocks (e.g. \"{{% %}}\")")
+	fs.BoolVar(&opt.Parser.PreserveWhitespace, "preserve-whitespace", false, "write all template text byte-for-byte")
+	fs.BoolVar(&opt.Parser.PlainText, "text", false, "write print blocks without HTML escaping unless a template sets its mode")
+	fs.BoolVar(&opt.Parser.StrictUTF8, "strict-utf8", false, "report text with invalid UTF-8 as an error instead of a warning")
+	fs.BoolVar(&opt.Parser.Minify, "minify", false, "collapse whitespace and remove comments in template text")
+	fs.BoolVar(&opt.Parser.LogicLess, "logic-less", false, "only allow conditionals and loops in code blocks")
+	fs.StringVar(&opt.Parser.IncludeRoot, "include-root", ".", "directory that files of include & extends directives must be within (blank to disable)")
+	fs.BoolVar(&opt.Comments, "comments", false, "write template comments to generated code as Go comments")
+	fs.BoolVar(&opt.Trace, "trace", false, "generate egoTrace calls before each block for debugging")
+	fs.BoolVar(&opt.Tracing, "tracing", false, "start an OpenTelemetry span in the Render method of each component")
+	fs.StringVar(&opt.Tracer, "tracer", ego.DefaultTracer, "expression returning the tracer used with -tracing")
+	fs.BoolVar(&opt.Vet, "vet", false, "check generated code for likely mistakes before writing")
+	fs.BoolVar(&opt.Strict, "strict", false, "report components whose types are not declared in their package")
+	fs.IntVar(&opt.FlushInterval, "flush-interval", 0, "check for cancellation and flush every n iterations of component loops")
+	fs.BoolVar(&opt.UnsafeBytes, "unsafe-bytes", false, "write text without copying using package unsafe (requires Go 1.20)")
+	fs.BoolVar(&opt.FastWriter, "fast-writer", false, "write print blocks through ego.Writer methods when available")
+	fs.BoolVar(&opt.SafeTypes, "safe-types", false, "write ego.HTML values in print blocks without escaping")
+	fs.BoolVar(&opt.ContextEscape, "context-escape", false, "escape print blocks for their HTML context, such as scripts and URLs")
+	fs.BoolVar(&opt.Lint, "lint", false, "report warnings for suspicious template constructs")
+	fs.Var((*purityFlag)(&opt.Linter.Purity), "purity", "side effect checks on print blocks with -lint: none, mutations, or calls")
+	fs.BoolVar(&opt.TypedAttrs, "typed-attrs", false, "generate component Attrs maps as map[string]interface{} with the original values")
+	fs.Var((*attrMergeFlag)(&opt.AttrMerge), "attr-merge", "merge policy of a spread attribute: replace or append (e.g. class=append)")
+	fs.Var((*mapFlag)(&opt.Deprecated), "deprecated", "mark a component as deprecated (e.g. ego:Button=\"use ego:Btn\")")
+	fs.StringVar(&cmd.manifestPath, "manifest", "", "write a JSON manifest of generated files to path")
+	fs.StringVar(&cmd.schemasPath, "schemas", "", "validate component attributes against a JSON schema file")
+	fs.Var((*mapFlag)(&opt.Parser.Filters), "filter", "register a print block filter (e.g. upper=strings.ToUpper)")
+	fs.BoolVar(&cmd.defaultFilters, "default-filters", false, "register the upper, lower, trim & truncate filters")
+	return fs
+}
+
+// finish sets the options that depend on other settings, such as default
+// filters & schemas.
+func (cmd *command) finish(opt *Options) error {
+	// Register default filters that are not overridden by -filter.
+	if cmd.defaultFilters {
+		if opt.Parser.Filters == nil {
+			opt.Parser.Filters = make(map[string]string)
+		}
+		for name, fn := range ego.DefaultFilters {
+			if _, ok := opt.Parser.Filters[name]; !ok {
+				opt.Parser.Filters[name] = fn
+			}
 		}
+	}
 
-		// Process individual file.
-		if err := processFile(path); err != nil {
+	// Load component schemas, if specified.
+	if cmd.schemasPath != "" {
+		buf, err := ioutil.ReadFile(cmd.schemasPath)
+		if err != nil {
 			return err
+		} else if err := json.Unmarshal(buf, &opt.Schemas); err != nil {
+			return fmt.Errorf("%s: %s", cmd.schemasPath, err)
+		}
+	}
+	return nil
+}
+
+// Options represents code generation options applied to each template.
+type Options struct {
+	Parser ego.Parser
+
+	Spaces     bool
+	TabWidth   int
+	Deprecated map[string]string
+	HTMLMethod bool
+	Schemas    map[string]*ego.Schema
+	Sanitizer  string
+	AttrMerge  map[string]ego.AttrMerge
+
+	FeatureFunc string
+
+	MaxLiteralLen     int
+	HoistTextLen      int
+	MaxDepth          int
+	LineEnding        ego.LineEnding
+	TrimPath          bool
+	LineDirectives    ego.LineDirectives
+	CountedMethod     bool
+	StdMethods        bool
+	IntoMethod        bool
+	StringMethods     bool
+	BoundedMethod     bool
+	TruncateMethod    bool
+	TestHelpers       bool
+	ResponseMethod    bool
+	ContentType       string
+	FunctionalOptions bool
+	QueryMethod       bool
+	SetFieldMethod    bool
+	ReturnErrors      bool
+	WriteErrors       bool
+	ValidateRender    bool
+	CopyAttrs         bool
+	DefaultYield      bool
+	TypedAttrs        bool
+	Pool              bool
+	RecoverPanics     bool
+	PanicHandler      string
+	Comments          bool
+	Trace             bool
+	Tracing           bool
+	Tracer            string
+	FlushInterval     int
+	FastWriter        bool
+	SafeTypes         bool
+	ContextEscape     bool
+	UnsafeBytes       bool
+
+	Lint   bool
+	Linter ego.Linter
+
+	Vet    bool
+	Strict bool
+
+	// Check compares generated code with existing files instead of writing
+	// them.
+	Check bool
+
+	// Cache is used to skip templates that are unchanged since they were
+	// last generated, if set.
+	Cache *Cache `json:"-"`
+
+	// types caches the types of each package checked by Strict, if set.
+	types *packageTypes
+}
+
+// packageTypes caches the types declared by the package in each directory so
+// that the templates of a package are parsed once when checking components.
+type packageTypes struct {
+	mu    sync.Mutex
+	types map[string]map[string]bool // by directory
+}
+
+// get returns the types declared by the package in dir, parsing its templates
+// with p.
+func (c *packageTypes) get(dir string, p *ego.Parser) map[string]bool {
+	c.mu.Lock()
+	types, ok := c.types[dir]
+	c.mu.Unlock()
+	if ok {
+		return types
+	}
+
+	types = p.PackageTypes(dir)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.types == nil {
+		c.types = make(map[string]map[string]bool)
+	}
+	c.types[dir] = types
+	return types
+}
+
+// reset forgets the types of all packages, such as after templates change.
+func (c *packageTypes) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.types = nil
+}
+
+// packageTypes returns the types declared by the package in dir.
+func (opt *Options) packageTypes(dir string) map[string]bool {
+	if opt.types == nil {
+		return opt.Parser.PackageTypes(dir)
+	}
+	return opt.types.get(dir, &opt.Parser)
+}
+
+// apply sets the options on a parsed template.
+func (opt *Options) apply(tmpl *ego.Template) {
+	if opt.Spaces {
+		tmpl.PrinterConfig = &printer.Config{Mode: printer.UseSpaces, Tabwidth: opt.TabWidth}
+	} else if opt.TabWidth != 8 {
+		tmpl.PrinterConfig = &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: opt.TabWidth}
+	}
+	tmpl.Deprecated = opt.Deprecated
+	tmpl.HTMLMethod = opt.HTMLMethod
+	tmpl.Schemas = opt.Schemas
+	tmpl.Sanitizer = opt.Sanitizer
+	tmpl.AttrMerge = opt.AttrMerge
+	tmpl.FeatureFunc = opt.FeatureFunc
+	tmpl.MaxLiteralLen = opt.MaxLiteralLen
+	tmpl.HoistTextLen = opt.HoistTextLen
+	tmpl.MaxDepth = opt.MaxDepth
+	tmpl.LineEnding = opt.LineEnding
+	tmpl.LineDirectives = opt.LineDirectives
+	if opt.TrimPath {
+		tmpl.TrimPath = moduleRoot(filepath.Dir(tmpl.Path))
+	}
+	tmpl.CountedMethod = opt.CountedMethod
+	tmpl.StdMethods = opt.StdMethods
+	tmpl.IntoMethod = opt.IntoMethod
+	tmpl.StringMethods = opt.StringMethods
+	tmpl.BoundedMethod = opt.BoundedMethod
+	tmpl.TruncateMethod = opt.TruncateMethod
+	tmpl.ResponseMethod = opt.ResponseMethod
+	tmpl.ContentType = opt.ContentType
+	tmpl.FunctionalOptions = opt.FunctionalOptions
+	tmpl.QueryMethod = opt.QueryMethod
+	tmpl.SetFieldMethod = opt.SetFieldMethod
+	tmpl.ReturnErrors = opt.ReturnErrors
+	tmpl.WriteErrors = opt.WriteErrors
+	tmpl.ValidateRender = opt.ValidateRender
+	tmpl.CopyAttrs = opt.CopyAttrs
+	tmpl.DefaultYield = opt.DefaultYield
+	tmpl.TypedAttrs = opt.TypedAttrs
+	tmpl.Pool = opt.Pool
+	tmpl.RecoverPanics = opt.RecoverPanics
+	tmpl.PanicHandler = opt.PanicHandler
+	tmpl.Comments = opt.Comments
+	tmpl.Trace = opt.Trace
+	tmpl.Tracing = opt.Tracing
+	tmpl.Tracer = opt.Tracer
+	tmpl.FlushInterval = opt.FlushInterval
+	tmpl.FastWriter = opt.FastWriter
+	tmpl.SafeTypes = opt.SafeTypes
+	tmpl.ContextEscape = opt.ContextEscape
+	tmpl.UnsafeBytes = opt.UnsafeBytes
+}
+
+// moduleRoot returns the nearest directory containing a go.mod file, starting
+// at dir. Returns dir if no parent has one.
+func moduleRoot(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	for d := abs; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return abs
+		}
+		d = parent
+	}
+}
+
+// purityFlag is a flag for setting the linter's purity level by name.
+type purityFlag ego.Purity
+
+func (p *purityFlag) String() string {
+	switch ego.Purity(*p) {
+	case ego.PurityMutations:
+		return "mutations"
+	case ego.PurityCalls:
+		return "calls"
+	default:
+		return "none"
+	}
+}
+
+func (p *purityFlag) Set(s string) error {
+	switch s {
+	case "none":
+		*p = purityFlag(ego.PurityIgnore)
+	case "mutations":
+		*p = purityFlag(ego.PurityMutations)
+	case "calls":
+		*p = purityFlag(ego.PurityCalls)
+	default:
+		return fmt.Errorf("invalid purity level: %q", s)
+	}
+	return nil
+}
+
+// lineEndingFlag is a flag for setting the line endings of template text.
+type lineEndingFlag ego.LineEnding
+
+func (f *lineEndingFlag) String() string {
+	switch ego.LineEnding(*f) {
+	case ego.LineEndingLF:
+		return "lf"
+	case ego.LineEndingCRLF:
+		return "crlf"
+	default:
+		return "preserve"
+	}
+}
+
+func (f *lineEndingFlag) Set(s string) error {
+	switch s {
+	case "preserve":
+		*f = lineEndingFlag(ego.LineEndingPreserve)
+	case "lf":
+		*f = lineEndingFlag(ego.LineEndingLF)
+	case "crlf":
+		*f = lineEndingFlag(ego.LineEndingCRLF)
+	default:
+		return fmt.Errorf("invalid line ending: %q", s)
+	}
+	return nil
+}
+
+// delimsFlag is a flag of space-separated open & close delimiters.
+type delimsFlag [2]string
+
+func (f *delimsFlag) String() string {
+	if *f == (delimsFlag{}) {
+		return ""
+	}
+	return f[0] + " " + f[1]
+}
+
+func (f *delimsFlag) Set(s string) error {
+	a := strings.Fields(s)
+	if len(a) != 2 {
+		return fmt.Errorf("invalid delimiters: %q", s)
+	}
+	*f = delimsFlag{a[0], a[1]}
+	return nil
+}
+
+// lineDirectivesFlag is a flag for setting how template positions are written.
+type lineDirectivesFlag ego.LineDirectives
+
+func (f *lineDirectivesFlag) String() string {
+	switch ego.LineDirectives(*f) {
+	case ego.LineDirectivesOff:
+		return "off"
+	case ego.LineDirectivesComment:
+		return "comment"
+	default:
+		return "on"
+	}
+}
+
+func (f *lineDirectivesFlag) Set(s string) error {
+	switch s {
+	case "on":
+		*f = lineDirectivesFlag(ego.LineDirectivesOn)
+	case "off":
+		*f = lineDirectivesFlag(ego.LineDirectivesOff)
+	case "comment":
+		*f = lineDirectivesFlag(ego.LineDirectivesComment)
+	default:
+		return fmt.Errorf("invalid line directives: %q", s)
+	}
+	return nil
+}
+
+// listFlag is a repeatable flag of values.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// mapFlag is a repeatable flag of "key=value" pairs.
+type mapFlag map[string]string
+
+func (m *mapFlag) String() string {
+	a := make([]string, 0, len(*m))
+	for k, v := range *m {
+		a = append(a, k+"="+v)
+	}
+	sort.Strings(a)
+	return strings.Join(a, ",")
+}
+
+func (m *mapFlag) Set(s string) error {
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) == 1 {
+		kv = append(kv, "")
+	}
+	(*m)[kv[0]] = kv[1]
+	return nil
+}
+
+// attrMergeFlag is a repeatable flag of "name=policy" attribute merge policies.
+type attrMergeFlag map[string]ego.AttrMerge
+
+func (m *attrMergeFlag) String() string {
+	a := make([]string, 0, len(*m))
+	for k, v := range *m {
+		if v == ego.AttrMergeAppend {
+			a = append(a, k+"=append")
+		} else {
+			a = append(a, k+"=replace")
 		}
 	}
+	sort.Strings(a)
+	return strings.Join(a, ",")
+}
 
+func (m *attrMergeFlag) Set(s string) error {
+	if *m == nil {
+		*m = make(map[string]ego.AttrMerge)
+	}
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("invalid attribute merge policy: %q", s)
+	}
+	switch kv[1] {
+	case "replace":
+		(*m)[kv[0]] = ego.AttrMergeReplace
+	case "append":
+		(*m)[kv[0]] = ego.AttrMergeAppend
+	default:
+		return fmt.Errorf("invalid attribute merge policy: %q", s)
+	}
 	return nil
 }
 
-func processDir(path string) error {
+// dirTemplates returns the paths of the templates in a directory that are not
+// ignored, sorted by name.
+func dirTemplates(path string, p *ego.Parser, ig *ignorer) ([]string, error) {
 	fis, err := ioutil.ReadDir(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Files included or extended by other templates are only partials or
+	// layouts so they are not generated on their own.
+	included := includedFiles(path, fis, p)
+
+	var paths []string
+	for _, fi := range fis {
+		filename := filepath.Join(path, fi.Name())
+		if filepath.Ext(fi.Name()) != ".ego" || included[filename] || ig.ignored(filename) {
+			continue
+		}
+		paths = append(paths, filename)
+	}
+	return paths, nil
+}
+
+// processFiles generates templates concurrently with a worker per CPU. The
+// diagnostics of each template are buffered and printed in the order of
+// paths. If any template fails then every error is printed in order and a
+// summary error is returned, or the error itself if only one failed. Errors
+// are written with the other diagnostics of their template if writing JSON.
+func processFiles(paths []string, cfg *config, diag *diagnostics) ([]*ManifestFile, error) {
+	type result struct {
+		opt  *Options
+		file *ManifestFile
+		err  error
+		buf  bytes.Buffer
+	}
+	results := make([]result, len(paths))
+	for i, path := range paths {
+		results[i].opt, results[i].err = cfg.options(filepath.Dir(path))
+	}
+
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range ch {
+				if r := &results[j]; r.err == nil {
+					r.file, r.err = processFile(paths[j], r.opt, &diagnostics{w: &r.buf, json: diag.json})
+				}
+			}
+		}()
+	}
+	for j := range paths {
+		ch <- j
+	}
+	close(ch)
+	wg.Wait()
+
+	var files []*ManifestFile
+	var errs []error
+	for i := range results {
+		r := &results[i]
+		if r.err != nil && diag.json {
+			(&diagnostics{w: &r.buf, json: true}).failed(paths[i], r.err)
+		}
+		diag.w.Write(r.buf.Bytes())
+		if r.err != nil {
+			errs = append(errs, r.err)
+		} else if r.file != nil {
+			files = append(files, r.file)
+		}
+	}
+
+	switch {
+	case len(errs) == 0:
+		return files, nil
+	case diag.json:
+		return nil, fmt.Errorf("%d of %d templates failed", len(errs), len(paths))
+	case len(errs) == 1:
+		return nil, errs[0]
+	default:
+		for _, err := range errs {
+			fmt.Fprintln(diag.w, err)
+		}
+		return nil, fmt.Errorf("%d of %d templates failed", len(errs), len(paths))
 	}
+}
+
+// includedFiles returns the set of files included by the templates of a
+// directory. Templates that cannot be parsed are skipped as their errors are
+// reported when they are processed.
+func includedFiles(dir string, fis []os.FileInfo, p *ego.Parser) map[string]bool {
+	m := make(map[string]bool)
 	for _, fi := range fis {
-		if err := processFile(filepath.Join(path, fi.Name())); err != nil {
-			return err
+		if filepath.Ext(fi.Name()) != ".ego" {
+			continue
+		}
+		tmpl, err := p.ParseFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			continue
+		}
+		for _, path := range tmpl.Includes {
+			m[filepath.Clean(path)] = true
 		}
 	}
-	return nil
+	return m
 }
 
-func processFile(path string) error {
+// processFile generates the Go file for a template. Diagnostics, such as
+// warnings, are written to diag. Returns a nil manifest entry if the path is
+// not a template.
+func processFile(path string, opt *Options, diag *diagnostics) (*ManifestFile, error) {
 	if filepath.Ext(path) != ".ego" {
-		return nil
+		return nil, nil
 	}
 
 	log.Printf("[process] %s", path)
 
 	fi, err := os.Stat(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Read current file, if it exists.
 	dest := path + ".go"
 	existing, err := ioutil.ReadFile(dest)
 	if err != nil && !os.IsNotExist(err) {
-		return err
+		return nil, err
+	}
+	missing := os.IsNotExist(err)
+
+	// Skip the template if it is unchanged since it was last generated. The
+	// template is hashed before it is parsed so that changes made while it
+	// is generated are not recorded.
+	var input string
+	if opt.Cache != nil {
+		if input, err = opt.Cache.hashInput(path, opt); err != nil {
+			return nil, err
+		} else if !missing && opt.Cache.fresh(path, input, existing) {
+			log.Printf("[cached] %s", path)
+			file := newManifestFile(path, dest, existing)
+			file.includes = opt.Cache.includes(path)
+			return file, nil
+		}
 	}
 
 	// Parse file & write to buffer. Ignore if equal to contents.
 	var buf bytes.Buffer
-	if tmpl, err := ego.ParseFile(path); err != nil {
-		return err
-	} else if _, err := tmpl.WriteTo(&buf); err != nil {
-		ioutil.WriteFile(dest, buf.Bytes(), fi.Mode())
-		return err
-	} else if bytes.Equal(existing, buf.Bytes()) {
-		return nil
+	tmpl, err := opt.Parser.ParseFile(path)
+	if errs, ok := err.(ego.ErrorList); ok && len(errs) > 1 {
+		for _, e := range errs {
+			diag.error(path, e)
+		}
+		return nil, reportedError{fmt.Errorf("%s: found %d syntax errors", path, len(errs))}
+	} else if err != nil {
+		return nil, err
+	}
+	opt.apply(tmpl)
+	tmpl.Package = packageName(filepath.Dir(path))
+
+	// Report parse warnings & lint warnings, if enabled.
+	for _, w := range tmpl.Warnings {
+		diag.warning(w)
+	}
+	if opt.Lint {
+		for _, w := range opt.Linter.Lint(tmpl) {
+			diag.warning(w)
+		}
+	}
+
+	// Check that component types exist before writing, if enabled.
+	if opt.Strict {
+		err := tmpl.CheckComponentTypes(filepath.Dir(path), opt.packageTypes(filepath.Dir(path)))
+		if errs, ok := err.(ego.ErrorList); ok {
+			for _, e := range errs {
+				diag.error(path, e)
+			}
+			return nil, reportedError{fmt.Errorf("%s: found %d unknown component(s)", path, len(errs))}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		if !opt.Check {
+			ioutil.WriteFile(dest, buf.Bytes(), fi.Mode())
+		}
+		return nil, err
+	}
+
+	// Check generated code before writing, if enabled.
+	if opt.Vet {
+		// Vet code with line directives so warnings point at the template.
+		src := buf.Bytes()
+		if tmpl.LineDirectives != ego.LineDirectivesOn {
+			other := *tmpl
+			other.LineDirectives = ego.LineDirectivesOn
+			var buf bytes.Buffer
+			if _, err := other.WriteTo(&buf); err != nil {
+				return nil, err
+			}
+			src = buf.Bytes()
+		}
+
+		warnings, err := ego.Vet(src)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range warnings {
+			diag.warning(w)
+		}
+		if len(warnings) > 0 {
+			return nil, reportedError{fmt.Errorf("%s: vet found %d issue(s)", path, len(warnings))}
+		}
+	}
+
+	// Report the differences with the existing file instead of writing it,
+	// if checking.
+	if opt.Check {
+		if missing || !bytes.Equal(existing, buf.Bytes()) {
+			reportStale(diag, dest, existing, missing, buf.Bytes())
+			file := newManifestFile(path, dest, buf.Bytes())
+			file.stale = true
+			return file, nil
+		}
+		return newManifestFile(path, dest, buf.Bytes()), nil
+	}
+
+	// Write test helpers, if enabled.
+	if opt.TestHelpers {
+		if err := writeTestHelpers(path, tmpl, fi.Mode()); err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.Cache != nil {
+		opt.Cache.store(path, input, tmpl.Includes, buf.Bytes())
+	}
+
+	file := newManifestFile(path, dest, buf.Bytes())
+	file.includes = tmpl.Includes
+	if bytes.Equal(existing, buf.Bytes()) {
+		return file, nil
 	}
 
 	// Write to file.
 	if err := ioutil.WriteFile(dest, buf.Bytes(), fi.Mode()); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// writeTestHelpers writes the test helpers for a template next to its
+// generated file, if the template has any components.
+func writeTestHelpers(path string, tmpl *ego.Template, mode os.FileMode) error {
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTestHelpersTo(&buf); err != nil {
 		return err
+	} else if buf.Len() == 0 {
+		return nil
 	}
 
-	return nil
+	dest := strings.TrimSuffix(path, ".ego") + ".ego_test.go"
+	if existing, err := ioutil.ReadFile(dest); err == nil && bytes.Equal(existing, buf.Bytes()) {
+		return nil
+	}
+	return ioutil.WriteFile(dest, buf.Bytes(), mode)
+}
+
+// packageName returns the package name of the Go files in dir. Falls back to
+// the directory name if there are no Go files.
+func packageName(dir string) string {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly)
+		if err == nil {
+			return f.Name.Name
+		}
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "main"
+	}
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, filepath.Base(abs))
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return name
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestVersion is the version of the manifest schema.
+const ManifestVersion = 1
+
+// Manifest represents a machine-readable list of generated files. It is
+// written with the -manifest flag so build systems can track dependencies
+// between templates and generated code.
+type Manifest struct {
+	Version   int             `json:"version"`
+	Generator string          `json:"generator"`
+	Files     []*ManifestFile `json:"files"`
+}
+
+// ManifestFile represents a single template and its generated output.
+// Paths use forward slashes. The hash is the hex-encoded SHA-256 digest of
+// the generated content so it only changes when the output changes.
+type ManifestFile struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	SHA256 string `json:"sha256"`
+
+	stale    bool     // differs from the existing file, when checking
+	includes []string // files included by the template
+}
+
+func newManifestFile(input, output string, data []byte) *ManifestFile {
+	sum := sha256.Sum256(data)
+	return &ManifestFile{
+		Input:  filepath.ToSlash(input),
+		Output: filepath.ToSlash(output),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// writeManifest writes files as a manifest to path, sorted by input path.
+func writeManifest(path string, files []*ManifestFile) error {
+	m := &Manifest{
+		Version:   ManifestVersion,
+		Generator: strings.TrimSpace("ego " + Version),
+		Files:     make([]*ManifestFile, len(files)),
+	}
+	copy(m.Files, files)
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Input < m.Files[j].Input })
+
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(buf, '\n'), 0666)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Ensure that -manifest writes each template & its generated file, sorted by
+// template path, with the hash of the generated file.
+func TestRun_Manifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"b.ego", "a.ego"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>Hello<% } %>\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, "manifest.json")
+	if err := run([]string{"-cache=", "-manifest", path, dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		t.Fatal(err)
+	} else if m.Version != ManifestVersion {
+		t.Fatalf("unexpected version: %d", m.Version)
+	} else if !strings.HasPrefix(m.Generator, "ego") {
+		t.Fatalf("unexpected generator: %s", m.Generator)
+	} else if len(m.Files) != 2 {
+		t.Fatalf("unexpected files: %d", len(m.Files))
+	}
+
+	for i, name := range []string{"a.ego", "b.ego"} {
+		input := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(input + ".go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+
+		if f := m.Files[i]; f.Input != filepath.ToSlash(input) {
+			t.Fatalf("%d. unexpected input: %s", i, f.Input)
+		} else if f.Output != filepath.ToSlash(input+".go") {
+			t.Fatalf("%d. unexpected output: %s", i, f.Output)
+		} else if f.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Fatalf("%d. unexpected hash: %s", i, f.SHA256)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/benbjohnson/ego"
+)
+
+// runParse parses templates and prints their block trees. With -json, each
+// template is written as a JSON object, one after another like "go list -json".
+// Otherwise an indented outline of the blocks is printed.
+func runParse(args []string) error {
+	fs := flag.NewFlagSet("ego parse", flag.ContinueOnError)
+	jsonFlag := fs.Bool("json", false, "print the block tree of each template as JSON")
+	var p ego.Parser
+	fs.BoolVar(&p.Interpolate, "interpolate", false, "parse ${expr} within text as print blocks")
+	fs.Var((*delimsFlag)(&p.Delims), "delims", "open & close delimiters of ego blocks (e.g. \"{{% %}}\")")
+	fs.BoolVar(&p.PreserveWhitespace, "preserve-whitespace", false, "write all template text byte-for-byte")
+	fs.StringVar(&p.IncludeRoot, "include-root", ".", "directory that files of include & extends directives must be within (blank to disable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		return fmt.Errorf("usage: ego parse [-json] FILE...")
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	for _, path := range fs.Args() {
+		tmpl, err := p.ParseFile(path)
+		if err != nil {
+			return err
+		}
+
+		if !*jsonFlag {
+			printOutline(tmpl)
+			continue
+		}
+		if err := enc.Encode(templateJSON(tmpl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateJSON returns the value written for a template with -json.
+func templateJSON(tmpl *ego.Template) map[string]interface{} {
+	return map[string]interface{}{
+		"path":     tmpl.Path,
+		"blocks":   jsonValue(reflect.ValueOf(tmpl.Blocks)),
+		"warnings": jsonValue(reflect.ValueOf(tmpl.Warnings)),
+	}
+}
+
+// printOutline prints each block of the template on its own line, indented
+// by its depth, with its position and content or name.
+func printOutline(tmpl *ego.Template) {
+	depth := 0
+	ego.Inspect(tmpl.Blocks, func(blk ego.Block) bool {
+		if blk == nil {
+			depth--
+			return false
+		}
+
+		pos := ego.Position(blk)
+		v := reflect.ValueOf(blk).Elem()
+		var detail string
+		if f := v.FieldByName("Content"); f.IsValid() {
+			detail = strconv.Quote(f.String())
+		} else if f := v.FieldByName("Name"); f.IsValid() {
+			detail = f.String()
+		}
+		fmt.Printf("%s:%d:%d: %s%s %s\n", pos.Path, pos.LineNo, pos.Column, strings.Repeat("  ", depth), blockType(blk), detail)
+		depth++
+		return true
+	})
+}
+
+// blockType returns the name of a block's type without the "Block" suffix,
+// such as "text" or "componentStart".
+func blockType(blk ego.Block) string {
+	return lowerFirst(strings.TrimSuffix(reflect.TypeOf(blk).Elem().Name(), "Block"))
+}
+
+// jsonValue converts a value of the block tree into a value that encodes as
+// JSON with lower camel case keys. Blocks are given a "type" key and zero
+// fields are omitted, except within positions.
+func jsonValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		m := jsonValue(v.Elem())
+		if blk, ok := v.Interface().(ego.Block); ok {
+			m.(map[string]interface{})["type"] = blockType(blk)
+		}
+		return m
+
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		_, isPos := v.Interface().(ego.Pos)
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.PkgPath == "" && (isPos || !v.Field(i).IsZero()) {
+				m[lowerFirst(f.Name)] = jsonValue(v.Field(i))
+			}
+		}
+		return m
+
+	case reflect.Slice:
+		a := make([]interface{}, v.Len())
+		for i := range a {
+			a[i] = jsonValue(v.Index(i))
+		}
+		return a
+
+	default:
+		return v.Interface()
+	}
+}
+
+// lowerFirst returns s with its first letter in lower case. A leading
+// initialism is lowered as a whole, such as "jsonPrint" for "JSONPrint".
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	n := 1
+	for n < len(s) && unicode.IsUpper(rune(s[n])) && (n+1 == len(s) || unicode.IsUpper(rune(s[n+1]))) {
+		n++
+	}
+	return strings.ToLower(s[:n]) + s[n:]
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that the block tree of a template is written as JSON with typed
+// blocks, positions, and without zero fields.
+func TestTemplateJSON(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<ego:Card Title=\"x\"><%= name %></ego:Card>"), "page.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := json.Marshal(templateJSON(tmpl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"blocks":[{"end":{"column":21,"lineNo":1,"offset":20,"path":"page.ego"},"fields":[{"name":"Title","namePos":{"column":11,"lineNo":1,"offset":10,"path":"page.ego"},"value":"\"x\"","valuePos":{"column":17,"lineNo":1,"offset":16,"path":"page.ego"}}],"name":"Card","pos":{"column":1,"lineNo":1,"offset":0,"path":"page.ego"},"type":"componentStart","yield":[{"content":" name ","end":{"column":32,"lineNo":1,"offset":31,"path":"page.ego"},"pos":{"column":21,"lineNo":1,"offset":20,"path":"page.ego"},"type":"print"}]}],"path":"page.ego","warnings":[]}`
+	if string(buf) != exp {
+		t.Fatalf("unexpected JSON:\n%s\n\nexpected:\n%s", buf, exp)
+	}
+}
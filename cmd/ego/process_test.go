@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Ensure that the errors of templates generated concurrently are written in
+// the order of their paths.
+func TestProcessFiles_ErrorOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for i := 0; i < 16; i++ {
+		src := "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>Hello<% } %>\n"
+		if i%3 == 0 {
+			src = "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%><ego:Card>\n<% } %>\n"
+		}
+		path := filepath.Join(dir, fmt.Sprintf("page%02d.ego", i))
+		if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	for _, json := range []bool{false, true} {
+		var buf bytes.Buffer
+		if _, err := processFiles(paths, newConfig(nil), &diagnostics{w: &buf, json: json}); err == nil || err.Error() != "6 of 16 templates failed" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var exp bytes.Buffer
+		for i := 0; i < 16; i += 3 {
+			if json {
+				fmt.Fprintf(&exp, `{"path":%q,"line":5,"column":3,"severity":"error","message":"Expected component close tag, found EOF: \u003cego:Card\u003e"}`+"\n", paths[i])
+			} else {
+				fmt.Fprintf(&exp, "Expected component close tag, found EOF: <ego:Card> at %s:5\n", paths[i])
+			}
+		}
+		if buf.String() != exp.String() {
+			t.Fatalf("unexpected output:\n%s\n\nexpected:\n%s", buf.String(), exp.String())
+		}
+	}
+}
+
+// Ensure that the error of a single failed template is returned as is.
+func TestProcessFiles_SingleError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "page.ego")
+	if err := ioutil.WriteFile(path, []byte("<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%><ego:Card>\n<% } %>\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := processFiles([]string{path}, newConfig(nil), &diagnostics{w: &buf}); err == nil || err.Error() != "Expected component close tag, found EOF: <ego:Card> at "+path+":5" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if buf.Len() != 0 {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
+
+// Ensure that -strict looks up the types of a package once with the
+// configured parser, and again after they are reset.
+func TestProcessFile_Strict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"ego.toml": "delims = \"{{% %}}\"\nstrict = true\n",
+		"card.ego": "{{%\npackage main\n\ntype Card struct{}\n\nfunc (r *Card) Render(ctx context.Context, w io.Writer) {\n%}}card{{% } %}}\n",
+		"page.ego": "{{%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%}}<ego:Card /><ego:Icon />{{% } %}}\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := newConfig(nil)
+	opt, err := cfg.options(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := processFile(filepath.Join(dir, "page.ego"), opt, &diagnostics{w: &buf}); err == nil {
+		t.Fatal("expected error")
+	} else if exp := "Unknown component ego:Icon at " + filepath.Join(dir, "page.ego") + ":5\n"; buf.String() != exp {
+		t.Fatalf("unexpected diagnostics: %s", buf.String())
+	}
+
+	// Types are cached until reset.
+	if err := ioutil.WriteFile(filepath.Join(dir, "icon.ego"), []byte("{{%\npackage main\n\ntype Icon struct{}\n%}}"), 0666); err != nil {
+		t.Fatal(err)
+	} else if _, err := processFile(filepath.Join(dir, "page.ego"), opt, &diagnostics{w: ioutil.Discard}); err == nil {
+		t.Fatal("expected cached types")
+	}
+	cfg.types.reset()
+	if _, err := processFile(filepath.Join(dir, "page.ego"), opt, &diagnostics{w: ioutil.Discard}); err != nil {
+		t.Fatal(err)
+	}
+}
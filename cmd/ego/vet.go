@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/benbjohnson/ego"
+)
+
+// runVet type-checks the templates of each package directory with the
+// package's Go files and reports component fields that do not exist or
+// whose values cannot be assigned to them.
+func runVet(args []string) error {
+	fs := flag.NewFlagSet("ego vet", flag.ContinueOnError)
+	var p ego.Parser
+	fs.BoolVar(&p.Interpolate, "interpolate", false, "parse ${expr} within text as print blocks")
+	fs.Var((*delimsFlag)(&p.Delims), "delims", "open & close delimiters of ego blocks (e.g. \"{{% %}}\")")
+	fs.BoolVar(&p.PreserveWhitespace, "preserve-whitespace", false, "write all template text byte-for-byte")
+	fs.StringVar(&p.IncludeRoot, "include-root", ".", "directory that files of include & extends directives must be within (blank to disable)")
+	var format diagnosticsFlag
+	fs.Var(&format, "diagnostics", "format of errors & warnings: text or json (written to stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	diag := newDiagnostics(bool(format))
+
+	dirs := fs.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	var n int
+	for _, dir := range dirs {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		included := includedFiles(dir, fis, &p)
+
+		var templates []*ego.Template
+		for _, fi := range fis {
+			path := filepath.Join(dir, fi.Name())
+			if fi.IsDir() || filepath.Ext(path) != ".ego" || included[path] {
+				continue
+			}
+			tmpl, err := p.ParseFile(path)
+			if err != nil && diag.json {
+				diag.error(path, err)
+				return fmt.Errorf("%s: cannot parse template", path)
+			} else if err != nil {
+				return err
+			}
+			tmpl.Package = packageName(dir)
+			templates = append(templates, tmpl)
+		}
+
+		warnings, err := ego.VetFields(dir, templates)
+		if err != nil {
+			return err
+		}
+		for _, w := range warnings {
+			diag.warning(w)
+		}
+		n += len(warnings)
+	}
+
+	if n > 0 {
+		return fmt.Errorf("vet found %d issue(s)", n)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// watcher regenerates templates as they change. Files are polled for changes
+// to their modification time or size so the command has no dependencies
+// outside of the standard library. Templates are also regenerated when the
+// files they include change, and all templates are regenerated with the
+// reloaded config when a config file is added, changed, or removed.
+type watcher struct {
+	paths    []string
+	cfg      *config
+	ignorer  *ignorer
+	diag     *diagnostics
+	manifest string
+
+	stamps   map[string]fileStamp            // last seen stamps of templates
+	includes map[string]map[string]fileStamp // stamps of included files by template path
+	configs  map[string]fileStamp            // last seen stamps of config files
+	files    map[string]*ManifestFile        // generated files by template path
+	failed   map[string]bool                 // templates whose last generation failed
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+// watch generates the templates within paths and then regenerates them as
+// they are added or changed. Errors are reported to stderr and the templates
+// continue to be watched until the process is stopped.
+func watch(paths []string, cfg *config, ig *ignorer, diag *diagnostics, manifestPath string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("watch interval must be positive: %s", interval)
+	}
+
+	w := newWatcher(paths, cfg, ig, diag, manifestPath)
+	for {
+		w.poll()
+		time.Sleep(interval)
+	}
+}
+
+func newWatcher(paths []string, cfg *config, ig *ignorer, diag *diagnostics, manifestPath string) *watcher {
+	return &watcher{
+		paths:    paths,
+		cfg:      cfg,
+		ignorer:  ig,
+		diag:     diag,
+		manifest: manifestPath,
+		stamps:   make(map[string]fileStamp),
+		includes: make(map[string]map[string]fileStamp),
+		files:    make(map[string]*ManifestFile),
+		failed:   make(map[string]bool),
+	}
+}
+
+// poll generates each template that was added or changed since the last poll.
+func (w *watcher) poll() {
+	stamps := w.scan()
+
+	paths := make([]string, 0, len(stamps))
+	for path := range stamps {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// Reload the config if any config file changed.
+	configs := configStamps(paths)
+	reload := w.configs != nil && !equalStamps(w.configs, configs)
+	if reload {
+		w.cfg.reset()
+	}
+	w.configs = configs
+
+	// Find the templates that were added, changed, or removed. The types
+	// declared by templates are looked up again for -strict if any changed.
+	var changed, removed []string
+	for _, path := range paths {
+		if prev, ok := w.stamps[path]; ok && prev == stamps[path] && !reload && !w.includesChanged(path) {
+			continue
+		}
+		changed = append(changed, path)
+	}
+	for path := range w.stamps {
+		if _, ok := stamps[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	if len(changed) > 0 || len(removed) > 0 {
+		w.cfg.types.reset()
+	}
+
+	for _, path := range changed {
+		var file *ManifestFile
+		opt, err := w.cfg.options(filepath.Dir(path))
+		if err == nil {
+			file, err = processFile(path, opt, w.diag)
+		}
+		if err != nil {
+			w.diag.failed(path, err)
+			w.failed[path] = true
+			delete(w.files, path)
+			delete(w.includes, path)
+			continue
+		} else if w.failed[path] && !w.diag.json {
+			fmt.Fprintf(os.Stderr, "%s: ok\n", path)
+			delete(w.failed, path)
+		}
+		w.files[path] = file
+
+		includes := make(map[string]fileStamp)
+		for _, include := range file.includes {
+			includes[include] = statFileStamp(include)
+		}
+		w.includes[path] = includes
+	}
+
+	// Forget templates that were removed. Their generated files are kept.
+	for _, path := range removed {
+		delete(w.files, path)
+		delete(w.includes, path)
+		delete(w.failed, path)
+	}
+	w.stamps = stamps
+
+	// Rewrite manifest of generated files, if requested.
+	if (len(changed) > 0 || len(removed) > 0) && w.manifest != "" {
+		files := make([]*ManifestFile, 0, len(w.files))
+		for _, file := range w.files {
+			files = append(files, file)
+		}
+		if err := writeManifest(w.manifest, files); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// scan returns the stamps of all templates within the watched paths.
+// Directories are not searched recursively, the same as when generating
+// without watching. Paths that cannot be read are reported and skipped and
+// paths that do not exist are skipped silently since they may be recreated.
+func (w *watcher) scan() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, path := range w.paths {
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		} else if !fi.IsDir() {
+			if filepath.Ext(path) == ".ego" {
+				stamps[path] = newFileStamp(fi)
+			}
+			continue
+		}
+
+		fis, err := ioutil.ReadDir(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		for _, fi := range fis {
+			filename := filepath.Join(path, fi.Name())
+			if !fi.IsDir() && filepath.Ext(fi.Name()) == ".ego" && !w.ignorer.ignored(filename) {
+				stamps[filename] = newFileStamp(fi)
+			}
+		}
+	}
+	return stamps
+}
+
+// includesChanged returns true if a file included by the template at path
+// changed since the template was generated.
+func (w *watcher) includesChanged(path string) bool {
+	for include, stamp := range w.includes[path] {
+		if statFileStamp(include) != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// configStamps returns the stamps of the config files that apply to the
+// templates at paths. Config files that do not exist have a zero stamp so
+// that adding one is detected.
+func configStamps(paths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, path := range paths {
+		dir, err := filepath.Abs(filepath.Dir(path))
+		if err != nil {
+			continue
+		}
+		for {
+			for _, name := range configNames {
+				filename := filepath.Join(dir, name)
+				if _, ok := stamps[filename]; !ok {
+					stamps[filename] = statFileStamp(filename)
+				}
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return stamps
+}
+
+// equalStamps returns true if a & b have the same files & stamps.
+func equalStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for filename, stamp := range a {
+		if other, ok := b[filename]; !ok || other != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+func newFileStamp(fi os.FileInfo) fileStamp {
+	return fileStamp{modTime: fi.ModTime().UnixNano(), size: fi.Size()}
+}
+
+// statFileStamp returns the stamp of a file, or a zero stamp if it cannot be
+// read, such as if it does not exist.
+func statFileStamp(filename string) fileStamp {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return fileStamp{}
+	}
+	return newFileStamp(fi)
+}
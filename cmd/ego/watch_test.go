@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Ensure that templates are regenerated when their included files or config
+// files change.
+func TestWatcher_Poll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, include := filepath.Join(dir, "page.ego"), filepath.Join(dir, "partials", "header.ego")
+	if err := os.Mkdir(filepath.Dir(include), 0777); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(path, []byte("<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer, name string) {\n%><%@ include \"partials/header.ego\" %><%= name %><% } %>\n"), 0666); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(include, []byte("Hello"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := newWatcher([]string{dir}, newConfig([]string{"-include-root", dir}), newIgnorer(nil), &diagnostics{w: &buf}, "")
+	w.poll()
+	if s := readGenerated(t, path); !strings.Contains(s, `"Hello"`) {
+		t.Fatalf("unexpected generated file:\n%s", s)
+	}
+
+	// Changing an included file regenerates the template.
+	if err := ioutil.WriteFile(include, []byte("Goodbye, world"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	w.poll()
+	if s := readGenerated(t, path); !strings.Contains(s, `"Goodbye, world"`) {
+		t.Fatalf("unexpected generated file:\n%s", s)
+	}
+
+	// Adding a config file regenerates the template with the new options.
+	if err := ioutil.WriteFile(filepath.Join(dir, "ego.toml"), []byte("fast-writer = true\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	w.poll()
+	if s := readGenerated(t, path); !strings.Contains(s, "ego.WriteEscaped(w, name)") {
+		t.Fatalf("unexpected generated file:\n%s", s)
+	}
+
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected diagnostics: %s", buf.String())
+	}
+}
+
+// readGenerated returns the contents of the file generated for the template at path.
+func readGenerated(t *testing.T, path string) string {
+	t.Helper()
+	buf, err := ioutil.ReadFile(path + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
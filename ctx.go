@@ -0,0 +1,105 @@
+package ego
+
+import (
+	"bytes"
+	"go/ast"
+	"go/token"
+	"sort"
+)
+
+// Markers that delimit the generated code of a ctx directive.
+const (
+	ctxStartMarker = "/*ego:ctx*/"
+	ctxEndMarker   = "/*ego:endctx*/"
+)
+
+// hoistCtxBlocks moves the code of each ctx directive, including its line
+// directive, to the start of the body of its enclosing function declaration.
+// Directives keep their relative order. Returns nil if there are no directives.
+func hoistCtxBlocks(fset *token.FileSet, f *ast.File, src []byte) ([]byte, error) {
+	var comments []*ast.Comment
+	for _, cg := range f.Comments {
+		comments = append(comments, cg.List...)
+	}
+
+	var edits []edit
+	for i := 0; i < len(comments); i++ {
+		start := comments[i]
+		if start.Text != ctxStartMarker {
+			continue
+		}
+
+		// Find matching end marker.
+		var end *ast.Comment
+		for ; end == nil && i < len(comments); i++ {
+			if comments[i].Text == ctxEndMarker {
+				end = comments[i]
+			}
+		}
+		assert(end != nil)
+		i--
+
+		// Find the function declaration containing the directive.
+		decl := enclosingFuncDecl(f, start.Pos())
+		if decl == nil {
+			pos := fset.Position(start.Pos())
+			return nil, NewSyntaxError(Pos{Path: pos.Filename, LineNo: pos.Line}, "Ctx directive used outside of a function")
+		}
+
+		// Remove the code along with its preceding line directive, if any.
+		tf := fset.File(start.Pos())
+		off, endOff := tf.Offset(start.Pos()), tf.Offset(end.End())+1
+		lineStart := off
+		if i := bytes.LastIndexByte(src[:off], '\n'); i != -1 {
+			if j := bytes.LastIndexByte(src[:i], '\n'); bytes.HasPrefix(src[j+1:], []byte("//line ")) {
+				lineStart = j + 1
+			}
+		}
+		edits = append(edits, edit{Start: lineStart, End: endOff})
+
+		// Insert the code, without markers, at the start of the body.
+		var text bytes.Buffer
+		text.WriteString("\n")
+		text.Write(src[lineStart:off])
+		text.Write(bytes.TrimPrefix(src[off+len(ctxStartMarker):tf.Offset(end.Pos())], []byte(" ")))
+		lbrace := tf.Offset(decl.Body.Lbrace) + 1
+		edits = append(edits, edit{Start: lbrace, End: lbrace, Text: text.Bytes()})
+	}
+
+	if len(edits) == 0 {
+		return nil, nil
+	}
+	return applyEdits(src, edits), nil
+}
+
+// enclosingFuncDecl returns the function declaration whose body contains pos.
+func enclosingFuncDecl(f *ast.File, pos token.Pos) *ast.FuncDecl {
+	for _, decl := range f.Decls {
+		if decl, ok := decl.(*ast.FuncDecl); ok && decl.Body != nil && decl.Body.Lbrace < pos && pos < decl.Body.Rbrace {
+			return decl
+		}
+	}
+	return nil
+}
+
+// edit represents a replacement of the source between two offsets.
+type edit struct {
+	Start, End int
+	Text       []byte
+}
+
+// applyEdits returns a copy of src with non-overlapping edits applied. Edits at
+// the same offset are applied in the order given.
+func applyEdits(src []byte, edits []edit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Start < edits[j].Start })
+
+	var buf bytes.Buffer
+	var pos int
+	for _, e := range edits {
+		buf.Write(src[pos:e.Start])
+		buf.Write(e.Text)
+		pos = e.End
+	}
+	buf.Write(src[pos:])
+	return buf.Bytes()
+}
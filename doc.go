@@ -0,0 +1,198 @@
+package ego
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ComponentDoc documents a component type of a package, which is any type
+// with a Render method.
+type ComponentDoc struct {
+	Name string
+	Doc  string
+	Pos  Pos // position of the type declaration
+
+	// Fields are set by the attributes of the component. Closures are the
+	// fields of function type, which are set by the body of the component,
+	// as Yield, or by named closures such as <ego::Header>.
+	Fields   []*FieldDoc
+	Closures []*FieldDoc
+
+	// Usages are the components in templates that render the type.
+	Usages []*ComponentStartBlock
+}
+
+// FieldDoc documents a field of a component type.
+type FieldDoc struct {
+	Name string
+	Type string
+	Doc  string
+}
+
+// Document returns the documentation of the components declared by the Go
+// files & templates of the package in dir, sorted by name. Templates are read
+// from their generated code so previously generated files of the templates
+// are ignored. Components used by the templates without a package are
+// recorded as usages.
+func Document(dir string, templates []*Template) ([]*ComponentDoc, error) {
+	fset := token.NewFileSet()
+
+	files, err := packageFiles(fset, dir, templates, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the types with a Render method.
+	renderers := make(map[string]bool)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == "Render" && fn.Recv != nil && len(fn.Recv.List) == 1 {
+				renderers[receiverName(fn.Recv.List[0].Type)] = true
+			}
+		}
+	}
+
+	var docs []*ComponentDoc
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				if !renderers[spec.Name.Name] {
+					continue
+				}
+
+				position := fset.Position(spec.Pos())
+				doc := &ComponentDoc{
+					Name: spec.Name.Name,
+					Doc:  strings.TrimSpace(spec.Doc.Text()),
+					Pos:  Pos{Path: position.Filename, LineNo: position.Line, Column: position.Column},
+				}
+				if doc.Doc == "" && len(decl.Specs) == 1 {
+					doc.Doc = strings.TrimSpace(decl.Doc.Text())
+				}
+				if typ, ok := spec.Type.(*ast.StructType); ok {
+					doc.addFields(typ)
+				}
+				docs = append(docs, doc)
+			}
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+
+	// Record the usages within the templates of the package.
+	m := componentDocsByName(docs)
+	for _, tmpl := range templates {
+		Inspect(tmpl.Blocks, func(blk Block) bool {
+			if blk, ok := blk.(*ComponentStartBlock); ok && blk.Package == "" && m[blk.Name] != nil {
+				m[blk.Name].Usages = append(m[blk.Name].Usages, blk)
+			}
+			return true
+		})
+	}
+	return docs, nil
+}
+
+// DocumentUsages records the components used by the templates of other
+// packages as usages of docs, the components of the package in dir. A
+// component is a usage if its package name refers to the package in dir
+// within the imports of its template.
+func DocumentUsages(docs []*ComponentDoc, dir string, templates []*Template) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	m := componentDocsByName(docs)
+	for _, tmpl := range templates {
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			return err
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), "", buf.Bytes(), parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+
+		// Resolve each package name of the template once.
+		imported := make(map[string]bool)
+		resolve := func(name string) bool {
+			if ok, found := imported[name]; found {
+				return ok
+			}
+			pkg := importedPackage(filepath.Dir(tmpl.Path), f, name)
+			imported[name] = pkg != nil && filepath.Clean(pkg.Dir) == dir
+			return imported[name]
+		}
+
+		Inspect(tmpl.Blocks, func(blk Block) bool {
+			if blk, ok := blk.(*ComponentStartBlock); ok && blk.Package != "" && m[blk.Name] != nil && resolve(blk.Package) {
+				m[blk.Name].Usages = append(m[blk.Name].Usages, blk)
+			}
+			return true
+		})
+	}
+	return nil
+}
+
+// addFields adds the fields of a component's struct type. Embedded fields
+// are named by their type.
+func (doc *ComponentDoc) addFields(typ *ast.StructType) {
+	for _, field := range typ.Fields.List {
+		text := strings.TrimSpace(field.Doc.Text())
+		if text == "" {
+			text = strings.TrimSpace(field.Comment.Text())
+		}
+
+		names := make([]string, 0, len(field.Names))
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+		if len(names) == 0 {
+			names = append(names, receiverName(field.Type))
+		}
+
+		for _, name := range names {
+			fieldDoc := &FieldDoc{Name: name, Type: types.ExprString(field.Type), Doc: text}
+			if _, ok := field.Type.(*ast.FuncType); ok {
+				doc.Closures = append(doc.Closures, fieldDoc)
+			} else {
+				doc.Fields = append(doc.Fields, fieldDoc)
+			}
+		}
+	}
+}
+
+// receiverName returns the name of the type of a method receiver or embedded
+// field, such as "Button" for "*Button" or "ego.Button".
+func receiverName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.IndexExpr:
+		return receiverName(expr.X)
+	case *ast.SelectorExpr:
+		return expr.Sel.Name
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
+
+// componentDocsByName returns docs by component name.
+func componentDocsByName(docs []*ComponentDoc) map[string]*ComponentDoc {
+	m := make(map[string]*ComponentDoc, len(docs))
+	for _, doc := range docs {
+		m[doc.Name] = doc
+	}
+	return m
+}
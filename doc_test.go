@@ -0,0 +1,57 @@
+package ego_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that components are documented from Go files & templates.
+func TestDocument(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "button.go"), []byte("package main\n\nimport (\n\t\"context\"\n\t\"io\"\n)\n\n// Button renders a button.\ntype Button struct {\n\t// Style is the style of the button.\n\tStyle string\n\tCount int // number of clicks\n\n\tHeader func()\n\tYield  func()\n}\n\nfunc (r *Button) Render(ctx context.Context, w io.Writer) {}\n\n// Options is not a component.\ntype Options struct{}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := ego.Parse(strings.NewReader("<%\npackage main\n\n// Page renders a page.\ntype Page struct {\n\tTitle string\n}\n\nfunc (r *Page) Render(ctx context.Context, w io.Writer) {\n%>\n<ego:Button Style=\"primary\" />\n<ego:Button>\n\t<ego::Header>Hi</ego::Header>\n</ego:Button>\n<% } %>\n"), "page.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Package = "main"
+
+	docs, err := ego.Document(dir, []*ego.Template{tmpl})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(docs) != 2 {
+		t.Fatalf("unexpected docs: %d", len(docs))
+	}
+
+	if doc := docs[0]; doc.Name != "Button" || doc.Doc != "Button renders a button." {
+		t.Fatalf("unexpected doc: %s %q", doc.Name, doc.Doc)
+	} else if doc.Pos.Path != filepath.Join(dir, "button.go") || doc.Pos.LineNo != 9 {
+		t.Fatalf("unexpected pos: %s:%d", doc.Pos.Path, doc.Pos.LineNo)
+	} else if len(doc.Fields) != 2 || *doc.Fields[0] != (ego.FieldDoc{Name: "Style", Type: "string", Doc: "Style is the style of the button."}) || *doc.Fields[1] != (ego.FieldDoc{Name: "Count", Type: "int", Doc: "number of clicks"}) {
+		t.Fatalf("unexpected fields: %+v", doc.Fields)
+	} else if len(doc.Closures) != 2 || doc.Closures[0].Name != "Header" || doc.Closures[0].Type != "func()" || doc.Closures[1].Name != "Yield" {
+		t.Fatalf("unexpected closures: %+v", doc.Closures)
+	} else if len(doc.Usages) != 2 || doc.Usages[0].Pos.LineNo != 11 || doc.Usages[1].Pos.LineNo != 12 {
+		t.Fatalf("unexpected usages: %+v", doc.Usages)
+	}
+
+	if doc := docs[1]; doc.Name != "Page" || doc.Doc != "Page renders a page." {
+		t.Fatalf("unexpected doc: %s %q", doc.Name, doc.Doc)
+	} else if doc.Pos.Path != "page.ego" {
+		t.Fatalf("unexpected path: %s", doc.Pos.Path)
+	} else if len(doc.Fields) != 1 || doc.Fields[0].Name != "Title" || len(doc.Usages) != 0 {
+		t.Fatalf("unexpected fields: %+v", doc.Fields)
+	}
+}
@@ -4,12 +4,20 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
+	"go/build/constraint"
 	"go/format"
 	"go/parser"
+	"go/printer"
 	"go/token"
+	"hash/fnv"
 	"io"
+	"mime"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Template represents an entire Ego template.
@@ -18,18 +26,425 @@ import (
 type Template struct {
 	Path   string
 	Blocks []Block
+
+	// Warnings are non-fatal problems found while parsing, such as text
+	// containing invalid UTF-8.
+	Warnings []*Warning
+
+	// Includes are the paths of the files read for include & extends
+	// directives, in the order they are first read.
+	Includes []string
+
+	// PrinterConfig is used to print the generated code, if set.
+	// Otherwise the code is formatted using gofmt style.
+	PrinterConfig *printer.Config
+
+	// Deprecated maps component names (e.g. "ego:Button" or "ui:Card") to a
+	// deprecation message. Each usage of a deprecated component calls a
+	// Deprecated_<Name>() function in the component's package so that
+	// deprecation linters such as staticcheck flag the call site.
+	Deprecated map[string]string
+
+	// HTMLMethod generates an HTML(ctx) method on each type with a Render
+	// method so that it can be embedded in an html/template as trusted HTML.
+	HTMLMethod bool
+
+	// CountedMethod generates a RenderCounted(ctx, w) method on each type with
+	// a Render method which returns the number of bytes written and the first
+	// write error.
+	CountedMethod bool
+
+	// StdMethods generates String() and WriteTo(w) methods on each type with
+	// a Render method so that it implements fmt.Stringer and io.WriterTo.
+	// Both methods render using a background context.
+	StdMethods bool
+
+	// IntoMethod generates a RenderInto(ctx, buf) method on each type with a
+	// Render method which renders into a caller-provided *bytes.Buffer. This
+	// lets hot paths manage their own buffer pooling.
+	IntoMethod bool
+
+	// StringMethods generates RenderString(ctx) and RenderBytes(ctx) methods
+	// on each type with a Render method which render into a pooled buffer
+	// and return its contents, such as for emails & caches.
+	StringMethods bool
+
+	// BoundedMethod generates a RenderBounded(ctx, w) method on each type with
+	// a Render method which renders to an ego.BoundedWriter, such as an
+	// ego.RingBuffer, and then closes it. Writes block while the writer is
+	// full so the method must run in a separate goroutine from its consumer.
+	BoundedMethod bool
+
+	// TruncateMethod generates a RenderTruncated(ctx, w, budget, ellipsis)
+	// method on each type with a Render method which writes at most budget
+	// bytes. Output that does not fit is cut at a block boundary and replaced
+	// by the ellipsis. See TruncateWriter.
+	TruncateMethod bool
+
+	// Schemas maps component names (e.g. "ego:Button") to schemas. If a
+	// component has a schema then passing an unknown attribute is an error.
+	Schemas map[string]*Schema
+
+	// Sanitizer is the name of the function called by sanitize print blocks.
+	// It must have the signature func(context.Context, interface{}) string.
+	// Defaults to DefaultSanitizer.
+	Sanitizer string
+
+	// MaxLiteralLen is the maximum number of bytes of text written in a single
+	// string literal. Longer text blocks are split into concatenated literals,
+	// preferably after newlines. Zero means no limit.
+	MaxLiteralLen int
+
+	// HoistTextLen is the minimum number of bytes of a text block that is
+	// hoisted into a package-level []byte variable and written with the
+	// writer's Write method. This avoids converting large literals at each
+	// render. Zero means text is not hoisted.
+	HoistTextLen int
+
+	// Trace generates a call to a trace function before the output of each
+	// block, in execution order. The function must be provided by the
+	// package and have the signature:
+	//
+	//	func egoTrace(ctx context.Context, block, pos string)
+	//
+	// The block is the kind of block (e.g. "text", "print", "component") and
+	// pos is its template position as "path:line". Nothing is generated if
+	// tracing is disabled.
+	Trace bool
+
+	// FlushInterval is the number of iterations between checks of a component
+	// loop. Every interval, the loop stops if the context is canceled and the
+	// writer is flushed if it implements http.Flusher. Zero disables checks.
+	FlushInterval int
+
+	// FeatureFunc is the name of the function called to check the feature
+	// flag of components with a "flag" attribute. It must have the signature
+	// func(ctx context.Context, flag string) bool. Defaults to DefaultFeatureFunc.
+	FeatureFunc string
+
+	// TrimPath is a directory, such as the module root, that is removed from
+	// the start of the template paths written in //line directives so that
+	// generated code does not depend on where the source is checked out.
+	// Paths are written with forward slashes. Paths outside of the directory
+	// are unchanged.
+	TrimPath string
+
+	// LineDirectives sets how template positions are written in generated
+	// code. Debuggers, coverage tools & some linters are confused by //line
+	// directives, which are written by default.
+	LineDirectives LineDirectives
+
+	// LineEnding sets the line endings of text written by the template.
+	// By default, text is written with the line endings of the source.
+	LineEnding LineEnding
+
+	// Package is the package name of the generated file for templates
+	// without any content, which have no package clause of their own.
+	Package string
+
+	// FastWriter generates print blocks that write through the specialized
+	// methods of an ego.Writer, if the writer implements it. Other writers
+	// receive escaped output directly instead of through an intermediate
+	// escaped string. See WriteEscaped() and EscapeTo().
+	FastWriter bool
+
+	// SafeTypes writes the values of print blocks with the type ego.HTML
+	// without escaping in element content. They are still escaped in
+	// attribute values & raw text elements, such as textarea. Other values
+	// are HTML-escaped as usual. ContextEscape templates always honor ego.JS
+	// & ego.URL values.
+	SafeTypes bool
+
+	// ContextEscape escapes print blocks for the HTML context they are written
+	// in, similar to html/template. Values within script & style elements,
+	// event handler & style attributes, URL attributes such as href, and
+	// unquoted attribute values use JavaScript, CSS, URL & attribute escaping.
+	// The context is determined from the template text in source order so
+	// code blocks should not change it, such as by opening a tag in one
+	// branch of a conditional only.
+	ContextEscape bool
+
+	// ReturnErrors generates code for components whose Render method returns
+	// an error. Yield & attribute block closures return an error and the
+	// generated code returns the first error from a nested component's Render.
+	//
+	// A component can return ErrSkip to stop rendering itself. Output that
+	// has already been written is kept and the parent continues rendering
+	// as if the component returned nil.
+	ReturnErrors bool
+
+	// WriteErrors returns the first write error from the generated code so
+	// that rendering stops as soon as the writer fails, such as when a client
+	// closes its connection. It implies ReturnErrors so every function in the
+	// template that writes output must return an error.
+	WriteErrors bool
+
+	// Tracing starts an OpenTelemetry span at the start of the Render method
+	// of each component declared in the template and ends it when the method
+	// returns. The span is named after the component (e.g. "ego:Button") and
+	// its context replaces the method's context so components rendered by the
+	// method start child spans. Render methods must name their context
+	// parameter.
+	Tracing bool
+
+	// Tracer is the expression that returns the trace.Tracer used to start
+	// component spans. Defaults to DefaultTracer.
+	Tracer string
+
+	// ResponseMethod generates a RenderResponse method for each component
+	// that renders to an http.ResponseWriter using the request's context.
+	// The Content-Type header is set to ContentType.
+	ResponseMethod bool
+
+	// ContentType is the content type of responses written by generated
+	// RenderResponse methods. Defaults to the type of the extension before
+	// ".ego" in the template path (e.g. "feed.xml.ego") or to HTML otherwise.
+	ContentType string
+
+	// PreserveWhitespace writes text byte-for-byte for templates where all
+	// whitespace is significant, such as preformatted text. It takes
+	// precedence over options that change text, such as LineEnding. It is
+	// set by Parser.PreserveWhitespace, which also keeps whitespace that is
+	// otherwise removed while parsing.
+	PreserveWhitespace bool
+
+	// PlainText writes print blocks without HTML escaping for templates that
+	// are not HTML, such as emails, configuration files & SQL. Options that
+	// depend on HTML, such as ContextEscape, are ignored. It is set by the
+	// "<%@ mode text %>" directive or Parser.PlainText.
+	PlainText bool
+
+	// UnsafeBytes writes text by passing the bytes of its string literal
+	// directly to the writer's Write method using package unsafe. This avoids
+	// the copy made by io.WriteString for writers that do not implement
+	// io.StringWriter.
+	//
+	// WARNING: the bytes are read-only memory. A writer that modifies the
+	// slice passed to Write, which violates the io.Writer contract, crashes
+	// the program. The generated code requires Go 1.20 or later.
+	UnsafeBytes bool
+
+	// ValidateRender calls the Validate method at the start of each Render
+	// method that returns an error. Validate methods are generated for
+	// components with fields declared as required with a
+	// `validate:"required"` tag, which return an error if a required field has
+	// its zero value. Declared Validate methods are only called if they have
+	// the signature Validate() error.
+	ValidateRender bool
+
+	// CopyAttrs copies the Attrs map of a component at the start of its Render
+	// method so that a Render method which modifies its attributes does not
+	// race with concurrent renders of the same component or of components
+	// sharing the map. It applies to components whose struct is declared in
+	// the template with an Attrs map field. Render methods with a pointer
+	// receiver use the copy in place of the field so the field is not
+	// assigned on the shared component.
+	CopyAttrs bool
+
+	// DefaultYield sets an empty Yield closure on components that are used
+	// without a body, including self-closing components and components with
+	// only attribute blocks, so that Yield is never nil. Every component
+	// must then declare a Yield field. Otherwise Yield is only set when a
+	// component has a body.
+	DefaultYield bool
+
+	// Pool renders the body of each component's Render method into a buffer
+	// from a shared pool and writes the buffer to the writer when the body
+	// returns. A component rendered into a *bytes.Buffer, such as a nested
+	// component, writes to it directly. Output is not written if the body
+	// panics. Render methods must name their writer parameter, which must be
+	// an io.Writer.
+	Pool bool
+
+	// RecoverPanics recovers panics in the Render method of each component,
+	// including panics in the template's code blocks, and passes them to the
+	// PanicHandler. Output written before the panic is kept. A Render method
+	// that returns an error returns the error from the handler. Otherwise a
+	// non-nil error from the handler is panicked again so the panic is not
+	// silently discarded. Render methods must name their context & writer
+	// parameters.
+	RecoverPanics bool
+
+	// PanicHandler is the name of the function called with panics recovered
+	// by Render methods. Unless ReturnErrors is set, it is also called with
+	// the errors of dynamic components, such as an unregistered name, which
+	// are skipped. Its error is then ignored. It must have the signature:
+	//
+	//	func(ctx context.Context, w io.Writer, v interface{}) error
+	//
+	// It can write fallback output to w. Defaults to DefaultPanicHandler.
+	PanicHandler string
+
+	// FunctionalOptions generates a functional options constructor for each
+	// component whose struct is declared in the template, for use from Go
+	// code. For a component "Card" this is a CardOption type, a
+	// NewCard(opts...) function, and a WithCardX(v) option for each exported
+	// field X.
+	FunctionalOptions bool
+
+	// AttrMerge sets how the value of a passthrough attribute is combined when
+	// it is set both by a component's Attrs field, such as attributes spread
+	// from a parent with Attrs=r.Attrs, and by an explicit attribute. Explicit
+	// attributes are merged into a copy of the Attrs field. Attributes without
+	// a policy use AttrMergeReplace.
+	AttrMerge map[string]AttrMerge
+
+	// TypedAttrs generates the Attrs maps of components as
+	// map[string]interface{} with the original values of attributes, such as
+	// booleans, numbers & functions, instead of strings formatted with
+	// fmt.Sprint. Attributes without a value are set to true. It is disabled
+	// by default for compatibility with components using map[string]string.
+	//
+	// The setting applies to every component rendered by the template, so all
+	// of them must declare their Attrs field as map[string]interface{}. A
+	// template cannot mix components with map[string]string and typed Attrs
+	// fields; render them from separate templates instead.
+	TypedAttrs bool
+
+	// QueryMethod generates a FromQuery(q url.Values) method for each
+	// component whose struct is declared in the template. It sets exported
+	// string, int, int64, float64 & bool fields from the query parameter with
+	// the field's name or the name in its "query" tag.
+	QueryMethod bool
+
+	// SetFieldMethod generates a SetField(name, value) method for each
+	// component whose struct is declared in the template. It sets the exported
+	// field with the given name using a type assertion instead of reflection
+	// so components can be populated from dynamic data, such as a map.
+	SetFieldMethod bool
+
+	// Comments writes template comments to the generated code as Go
+	// comments, such as for debugging. They are removed by default.
+	Comments bool
+
+	// Funcs are the package functions available to code evaluated by
+	// Execute by qualified name, such as "strings.ToUpper". They take
+	// precedence over DefaultFuncs. Generated code does not use them.
+	Funcs map[string]interface{}
+
+	// MaxDepth is the maximum static nesting depth of components within the
+	// body & attribute blocks of other components. Deeply nested components
+	// generate functions that are slow or impossible to compile. Zero uses
+	// DefaultMaxDepth.
+	MaxDepth int
+
+	// json is set while writing the blocks of a JSON format region.
+	json bool
+
+	// escapers are the contextual escaping expressions of print & translate
+	// blocks.
+	escapers map[Block]string
+
+	// inferredParams are the attribute block parameters whose types are
+	// inferred, indexed by their placeholder type.
+	inferredParams []*inferredParam
+
+	// funcBlock is the function directive whose body is being written.
+	funcBlock *FuncBlock
+
+	// hoistedText are the quoted contents of text blocks hoisted into
+	// package-level variables, indexed by their variable suffix.
+	hoistedText []string
 }
 
+// LineEnding represents the line endings used for template text.
+type LineEnding int
+
+const (
+	// LineEndingPreserve writes text with the line endings of the source.
+	LineEndingPreserve LineEnding = iota
+
+	// LineEndingLF rewrites CRLF line endings to LF.
+	LineEndingLF
+
+	// LineEndingCRLF rewrites LF line endings to CRLF.
+	LineEndingCRLF
+)
+
+// AttrMerge represents a policy for merging attribute values.
+type AttrMerge int
+
+const (
+	// AttrMergeReplace replaces the value in the Attrs field with the
+	// explicit value.
+	AttrMergeReplace AttrMerge = iota
+
+	// AttrMergeAppend appends the explicit value to the value in the Attrs
+	// field, separated by a space. This is useful for "class", "style" and
+	// "rel" attributes.
+	AttrMergeAppend
+)
+
+// DefaultFeatureFunc is the name of the function called to check component
+// feature flags when no function is specified on the template.
+const DefaultFeatureFunc = "featureEnabled"
+
+// DefaultTracer is the tracer used for component spans when no tracer is
+// specified on the template. It uses the global OpenTelemetry tracer provider
+// so spans are dropped unless the program calls otel.SetTracerProvider().
+const DefaultTracer = `otel.Tracer("github.com/benbjohnson/ego")`
+
+// otelPath is the import path of the OpenTelemetry API used by DefaultTracer.
+const otelPath = "go.opentelemetry.io/otel"
+
+// TraceFunc is the name of the function called before each block when
+// tracing is enabled.
+const TraceFunc = "egoTrace"
+
+// DefaultPanicHandler is the name of the function called with recovered panics
+// when no handler is specified on the template. It converts the panic into a
+// *PanicError.
+const DefaultPanicHandler = "ego.RecoverPanic"
+
+// DefaultSanitizer is the name of the function called by sanitize print blocks
+// when no sanitizer is specified on the template.
+const DefaultSanitizer = "sanitize"
+
+// DynamicComponent is the name of the component, in the "ego" namespace, that
+// renders a registered component chosen by the value of its name attribute,
+// such as "<ego:Dynamic name=block.Kind>". The name is reserved so a type
+// named Dynamic in a template's package cannot be used as a component without
+// a package. CheckComponents reports such types.
+const DynamicComponent = "Dynamic"
+
 // WriteTo writes the template to a writer.
+//
+// Declarations generated outside of the template's own code are written in a
+// deterministic order so that unrelated template edits produce minimal diffs.
+// Imports are sorted by path and hoisted declarations, such as helper methods,
+// are ordered by the template position they are generated from and then by kind.
 func (t *Template) WriteTo(w io.Writer) (n int64, err error) {
 	var buf bytes.Buffer
 
+	// Validate component usage before generating code.
+	if err := t.Validate(); err != nil {
+		return 0, err
+	}
+
+	// Write build constraint, if specified by a directive.
+	if err := t.writeBuildConstraint(&buf); err != nil {
+		return 0, err
+	}
+
 	// Write "generated" header comment.
 	buf.WriteString("// Generated by ego.\n")
 	buf.WriteString("// DO NOT EDIT\n\n")
 
+	// Templates without content only require a package clause.
+	if t.isEmpty() {
+		if t.Package == "" {
+			return 0, fmt.Errorf("Package name required for empty template: %s", t.Path)
+		}
+		fmt.Fprintf(&buf, "package %s\n", t.Package)
+		return buf.WriteTo(w)
+	}
+
 	// Write blocks.
-	writeBlocksTo(&buf, t.Blocks)
+	t.escapers = t.printEscapers()
+	defer func() { t.escapers, t.inferredParams, t.funcBlock, t.hoistedText = nil, nil, nil, nil }()
+	t.writeBlocksTo(&buf, t.Blocks)
+	writeFuncEnd(&buf, t.funcBlock)
+	t.writeHoistedText(&buf)
 
 	// Parse buffer as a Go file.
 	fset := token.NewFileSet()
@@ -39,75 +454,998 @@ func (t *Template) WriteTo(w io.Writer) (n int64, err error) {
 		return n, err
 	}
 
+	// Replace inferred attribute block parameter types and reparse.
+	if src, err := inferParamTypes(fset, f, buf.Bytes(), t.inferredParams); err != nil {
+		n, _ = buf.WriteTo(w)
+		return n, err
+	} else if src != nil {
+		if f, err = reparse(fset, &buf, src); err != nil {
+			n, _ = buf.WriteTo(w)
+			return n, err
+		}
+	}
+
+	// Move ctx directive code to the start of its function and reparse.
+	if src, err := hoistCtxBlocks(fset, f, buf.Bytes()); err != nil {
+		n, _ = buf.WriteTo(w)
+		return n, err
+	} else if src != nil {
+		if f, err = reparse(fset, &buf, src); err != nil {
+			n, _ = buf.WriteTo(w)
+			return n, err
+		}
+	}
+
+	// Declare region buffers used by append & flush directives and reparse.
+	if src, err := declareRegions(fset, f, buf.Bytes()); err != nil {
+		n, _ = buf.WriteTo(w)
+		return n, err
+	} else if src != nil {
+		if f, err = reparse(fset, &buf, src); err != nil {
+			n, _ = buf.WriteTo(w)
+			return n, err
+		}
+	}
+
+	// Validate required fields at the start of Render methods and reparse.
+	if t.ValidateRender {
+		if src := insertValidateCalls(fset, findRenderers(f), buf.Bytes()); src != nil {
+			if f, err = reparse(fset, &buf, src); err != nil {
+				n, _ = buf.WriteTo(w)
+				return n, err
+			}
+		}
+	}
+
+	// Copy Attrs maps at the start of Render methods and reparse.
+	var copiedAttrs bool
+	if t.CopyAttrs {
+		if src := insertAttrsCopies(fset, findRenderers(f), buf.Bytes()); src != nil {
+			copiedAttrs = true
+			if f, err = reparse(fset, &buf, src); err != nil {
+				n, _ = buf.WriteTo(w)
+				return n, err
+			}
+		}
+	}
+
+	// Render the body of Render methods into pooled buffers and reparse.
+	var pooled bool
+	if t.Pool {
+		if src := insertPoolBuffers(fset, findRenderers(f), buf.Bytes()); src != nil {
+			pooled = true
+			if f, err = reparse(fset, &buf, src); err != nil {
+				n, _ = buf.WriteTo(w)
+				return n, err
+			}
+		}
+	}
+
+	// Start tracing spans in Render methods and reparse.
+	var traced bool
+	if t.Tracing {
+		if src := insertSpans(fset, findRenderers(f), buf.Bytes(), t.tracer()); src != nil {
+			traced = true
+			if f, err = reparse(fset, &buf, src); err != nil {
+				n, _ = buf.WriteTo(w)
+				return n, err
+			}
+		}
+	}
+
+	// Recover panics in Render methods and reparse. This wraps the body of
+	// each method so it must happen after other code is inserted at its start.
+	var recoversPanics bool
+	if t.RecoverPanics {
+		if src := insertPanicRecovery(fset, findRenderers(f), buf.Bytes(), t.panicHandler()); src != nil {
+			recoversPanics = true
+			if f, err = reparse(fset, &buf, src); err != nil {
+				n, _ = buf.WriteTo(w)
+				return n, err
+			}
+		}
+	}
+
+	// Generate helper methods for renderer types and reparse.
+	renderers := findRenderers(f)
+	if err := checkAttrCalls(fset, renderers); err != nil {
+		n, _ = buf.WriteTo(w)
+		return n, err
+	}
+	sz := buf.Len()
+	imports := t.writeMethodsTo(&buf, fset, renderers)
+	if buf.Len() > sz {
+		if f, err = parser.ParseFile(fset, "", buf.Bytes(), parser.ParseComments); err != nil {
+			n, _ = buf.WriteTo(w)
+			return n, err
+		}
+	}
+
+	// Replace scope placeholders with the scope of the enclosing type. This
+	// modifies the AST so it must happen after the source is last reparsed.
+	if err := replaceScopes(fset, f); err != nil {
+		n, _ = buf.WriteTo(w)
+		return n, err
+	}
+
 	// Inject required packages.
-	injectImports(f)
+	if copiedAttrs || pooled || (recoversPanics && t.panicHandler() == DefaultPanicHandler) {
+		imports = appendImport(imports, RuntimePath)
+	}
+	if traced && t.tracer() == DefaultTracer {
+		imports = appendImport(imports, otelPath)
+	}
+	injectImports(f, append(t.blockImports(), imports...)...)
 
 	// Attempt to gofmt.
 	var result bytes.Buffer
-	if err := format.Node(&result, fset, f); err != nil {
+	if err := t.format(&result, fset, f); err != nil {
 		n, _ = buf.WriteTo(w)
 		return n, err
 	}
 
+	// Remove or convert line directives and reformat so trailing comments
+	// are aligned. Directives are written until now since other steps report
+	// errors at template positions.
+	if t.LineDirectives != LineDirectivesOn {
+		src, err := rewriteLineDirectives(result.Bytes(), t.LineDirectives == LineDirectivesComment)
+		if err != nil {
+			n, _ = result.WriteTo(w)
+			return n, err
+		}
+		fset := token.NewFileSet()
+		f, err := reparse(fset, &buf, src)
+		if err != nil {
+			n, _ = buf.WriteTo(w)
+			return n, err
+		}
+		result.Reset()
+		if err := t.format(&result, fset, f); err != nil {
+			n, _ = buf.WriteTo(w)
+			return n, err
+		}
+	}
+
 	// Write to output writer.
 	return result.WriteTo(w)
 }
 
-func writeBlocksTo(buf *bytes.Buffer, blks []Block) {
+// reparse replaces the contents of buf with src, such as the source of a
+// rewritten file, and parses it again.
+func reparse(fset *token.FileSet, buf *bytes.Buffer, src []byte) (*ast.File, error) {
+	buf.Reset()
+	buf.Write(src)
+	return parser.ParseFile(fset, "", buf.Bytes(), parser.ParseComments)
+}
+
+// Slice returns a copy of the template containing only the top-level blocks
+// in the range [start, end). Block positions are preserved so the slice can be
+// written independently. Returns an error if the range is out of bounds or if
+// it separates a component or attribute start block from its end block.
+func (t *Template) Slice(start, end int) (*Template, error) {
+	if start < 0 || end > len(t.Blocks) || start > end {
+		return nil, fmt.Errorf("slice bounds out of range [%d:%d] with length %d", start, end, len(t.Blocks))
+	}
+
+	// Ensure no start/end pair straddles a boundary of the range.
+	for i, j := range matchBlockPairs(t.Blocks) {
+		if (i >= start && i < end) != (j >= start && j < end) {
+			return nil, NewSyntaxError(Position(t.Blocks[i]), "Slice [%d:%d] splits %s from its end block", start, end, shortComponentBlockString(t.Blocks[i]))
+		}
+	}
+
+	other := *t
+	other.Blocks = make([]Block, end-start)
+	copy(other.Blocks, t.Blocks[start:end])
+	return &other, nil
+}
+
+// matchBlockPairs returns a mapping of the index of each unclosed component or
+// attribute start block to the index of its matching end block. Parsed
+// templates nest their end blocks so this only applies to flat block lists.
+func matchBlockPairs(a []Block) map[int]int {
+	m := make(map[int]int)
+	var stack []int
+	for i, blk := range a {
+		switch blk := blk.(type) {
+		case *ComponentStartBlock:
+			if !blk.Closed {
+				stack = append(stack, i)
+			}
+		case *AttrStartBlock:
+			stack = append(stack, i)
+
+		case *ComponentEndBlock, *AttrEndBlock:
+			for j := len(stack) - 1; j >= 0; j-- {
+				if !isMatchingBlockPair(a[stack[j]], blk) {
+					continue
+				}
+				m[stack[j]] = i
+				stack = stack[:j]
+				break
+			}
+		}
+	}
+	return m
+}
+
+func isMatchingBlockPair(start, end Block) bool {
+	switch start := start.(type) {
+	case *ComponentStartBlock:
+		end, ok := end.(*ComponentEndBlock)
+		return ok && start.Package == end.Package && start.Name == end.Name
+	case *AttrStartBlock:
+		end, ok := end.(*AttrEndBlock)
+		return ok && start.Package == end.Package && start.Name == end.Name
+	default:
+		return false
+	}
+}
+
+// format prints the generated file using the template's printer config.
+func (t *Template) format(w io.Writer, fset *token.FileSet, f *ast.File) error {
+	if t.PrinterConfig == nil {
+		return format.Node(w, fset, f)
+	}
+	ast.SortImports(fset, f)
+	return t.PrinterConfig.Fprint(w, fset, f)
+}
+
+func (t *Template) writeBlocksTo(buf *bytes.Buffer, blks []Block) {
 	for _, blk := range blks {
+		// Build directives are written in the file header & mode directives
+		// only change how other blocks are written.
+		switch blk.(type) {
+		case *BuildBlock, *ModeBlock:
+			continue
+		}
+
+		// Comments are dropped unless enabled.
+		if blk, ok := blk.(*CommentBlock); ok {
+			if t.Comments {
+				writeComment(buf, blk.Content)
+			}
+			continue
+		}
+
 		// Write line comment.
 		if pos := Position(blk); pos.Path != "" && pos.LineNo > 0 {
-			fmt.Fprintf(buf, "//line %s:%d\n", pos.Path, pos.LineNo)
+			fmt.Fprintf(buf, "//line %s:%d\n", t.linePath(pos.Path), pos.LineNo)
+		}
+
+		// Write trace call, if enabled.
+		if t.Trace {
+			t.writeTrace(buf, blk)
 		}
 
 		// Write block.
 		switch blk := blk.(type) {
 		case *TextBlock:
-			fmt.Fprintf(buf, `_, _ = io.WriteString(w, %q)`+"\n", blk.Content)
+			if t.isHoistedText(blk) {
+				t.writeOutput(buf, fmt.Sprintf("w.Write(%s)", t.hoistText(t.convertLineEndings(blk.Content))))
+			} else if t.UnsafeBytes {
+				fmt.Fprintf(buf, "{\nconst EGO_TEXT = %s\n", t.quoteText(t.convertLineEndings(blk.Content)))
+				t.writeOutput(buf, "w.Write(unsafe.Slice(unsafe.StringData(EGO_TEXT), len(EGO_TEXT)))")
+				buf.WriteString("}\n")
+			} else {
+				t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, %s)", t.quoteText(t.convertLineEndings(blk.Content))))
+			}
 
 		case *CodeBlock:
 			fmt.Fprintln(buf, blk.Content)
 
-		case *PrintBlock:
-			fmt.Fprintf(buf, `_, _ = io.WriteString(w, html.EscapeString(fmt.Sprint(%s)))`+"\n", blk.Content)
+		case *FuncBlock:
+			writeFuncEnd(buf, t.funcBlock)
+			fmt.Fprintf(buf, "%s {\n", blk.Content)
+			t.funcBlock = blk
 
-		case *RawPrintBlock:
-			fmt.Fprintf(buf, `_, _ = fmt.Fprint(w, %s)`+"\n", blk.Content)
+		case *PrintBlock, *RawPrintBlock, *SanitizeBlock, *JSONPrintBlock, *URLPrintBlock, *TranslateBlock:
+			t.writePrintBlock(buf, blk)
 
-		case *ComponentStartBlock:
-			if blk.Package != "" {
-				fmt.Fprintf(buf, "{\nvar EGO %s.%s\n", blk.Package, blk.Name)
+		case *ScopeBlock:
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, %s)", scopeIdent))
+
+		case *CtxBlock:
+			fmt.Fprintf(buf, "%s %s\n%s\n", ctxStartMarker, blk.Content, ctxEndMarker)
+
+		case *AppendStartBlock:
+			if len(blk.Yield) == 0 {
+				fmt.Fprintf(buf, "%s.Writer(%s)\n", regionsIdent, blk.Name)
+				continue
+			}
+			fmt.Fprintf(buf, "{\nw := %s.Writer(%s)\n", regionsIdent, blk.Name)
+			t.writeBlocksTo(buf, blk.Yield)
+			buf.WriteString("}\n")
+
+		case *FlushBlock:
+			t.writeOutput(buf, fmt.Sprintf("%s.Flush(w, %s)", regionsIdent, blk.Name))
+
+		case *FormatStartBlock:
+			fmt.Fprintf(buf, "if ego.ContextFormat(ctx) == %q {\n", blk.Format)
+			inJSON := t.json
+			t.json = blk.Format == FormatJSON
+			t.writeBlocksTo(buf, blk.Yield)
+			t.json = inJSON
+			buf.WriteString("}\n")
+
+		case *YieldStartBlock:
+			if t.returnErrors() {
+				fmt.Fprintf(buf, "if err := ego.YieldError(%s, func() error {\n", blk.Content)
+				t.writeBlocksTo(buf, blk.Yield)
+				buf.WriteString("return nil\n}); err != nil {\nreturn err\n}\n")
 			} else {
-				fmt.Fprintf(buf, "{\nvar EGO %s\n", blk.Name)
+				fmt.Fprintf(buf, "ego.Yield(%s, func() {\n", blk.Content)
+				t.writeBlocksTo(buf, blk.Yield)
+				buf.WriteString("})\n")
 			}
 
-			for _, field := range blk.Fields {
-				fmt.Fprintf(buf, "EGO.%s = %s\n", field.Name, field.Value)
+		case *ComponentStartBlock:
+			if blk.Flag != "" {
+				fmt.Fprintf(buf, "if %s(ctx, %s) {\n", t.featureFunc(), blk.Flag)
+			}
+			if blk.For != "" {
+				t.writeLoopStart(buf, blk)
 			}
 
-			if len(blk.Attrs) > 0 {
-				fmt.Fprintf(buf, "EGO.Attrs = map[string]string{\n")
-				for _, attr := range blk.Attrs {
-					fmt.Fprintf(buf, "	%q: fmt.Sprint(%s),\n", attr.Name, attr.Value)
-				}
-				fmt.Fprintf(buf, "}\n")
+			buf.WriteString("{\n")
+			t.writeDeprecation(buf, blk)
+
+			if blk.isDynamic() {
+				t.writeDynamicComponent(buf, blk)
+			} else {
+				t.writeStaticComponent(buf, blk, "var EGO "+blk.typeName(), t.writeBlocksTo)
+			}
+
+			switch {
+			case t.returnErrors():
+				fmt.Fprint(buf, "if err := EGO.Render(ctx, w); err != nil && err != ego.ErrSkip {\nreturn err\n}\n}\n")
+			case blk.isDynamic():
+				fmt.Fprintf(buf, "if err := EGO.Render(ctx, w); err != nil && err != ego.ErrSkip {\n_ = %s(ctx, w, err)\n}\n}\n", t.panicHandler())
+			default:
+				fmt.Fprint(buf, "EGO.Render(ctx, w) }\n")
 			}
 
-			for _, attrBlock := range blk.AttrBlocks {
-				fmt.Fprintf(buf, "EGO.%s = func() {\n", attrBlock.Name)
-				writeBlocksTo(buf, attrBlock.Yield)
+			if blk.For != "" {
+				t.writeLoopEnd(buf)
+			}
+			if blk.Flag != "" {
 				fmt.Fprint(buf, "}\n")
 			}
+		}
+	}
+}
 
-			if len(blk.Yield) > 0 {
-				buf.WriteString("EGO.Yield = func() {\n")
-				writeBlocksTo(buf, blk.Yield)
-				buf.WriteString("}\n")
+// writeStaticComponent writes the declaration of a component whose type is
+// known at compile time and sets its fields, attributes, attribute blocks &
+// yield. The component is declared by decl and the bodies of its closures are
+// written by writeBody so that Execute can share the code of components.
+func (t *Template) writeStaticComponent(buf *bytes.Buffer, blk *ComponentStartBlock, decl string, writeBody func(*bytes.Buffer, []Block)) {
+	fmt.Fprintln(buf, decl)
+
+	for _, field := range blk.Fields {
+		fmt.Fprintf(buf, "EGO.%s = %s\n", field.Name, field.Value)
+	}
+
+	var base string
+	if blk.hasAttrsField() {
+		base = "EGO.Attrs"
+	}
+	t.writeAttrsValue(buf, "EGO.Attrs", base, blk.Spreads, blk.Attrs)
+
+	for _, attrBlock := range blk.AttrBlocks {
+		if attrBlock.Cond != "" {
+			fmt.Fprintf(buf, "if %s {\n", attrBlock.Cond)
+		}
+		fmt.Fprintf(buf, "EGO.%s = func(%s) %s{\n", attrBlock.Name, t.paramList(blk, attrBlock), t.closureResult())
+		writeBody(buf, attrBlock.Yield)
+		t.writeClosureEnd(buf)
+		if attrBlock.Cond != "" {
+			fmt.Fprint(buf, "}\n")
+		}
+	}
+
+	if blk.Load != "" {
+		fmt.Fprintf(buf, "EGO.Yield = func() %s{\n", t.closureResult())
+		t.writeLoadStates(buf, blk)
+		t.writeClosureEnd(buf)
+	} else if len(blk.Yield) > 0 || t.DefaultYield {
+		fmt.Fprintf(buf, "EGO.Yield = func() %s{\n", t.closureResult())
+		writeBody(buf, blk.Yield)
+		t.writeClosureEnd(buf)
+	}
+}
+
+// writeDynamicComponent writes the declaration of a dynamic component. Its
+// fields, attributes, attribute blocks & yield are collected by name and set
+// on the registered component at render time.
+func (t *Template) writeDynamicComponent(buf *bytes.Buffer, blk *ComponentStartBlock) {
+	name, attrs := blk.dynamicName()
+	fmt.Fprintf(buf, "EGO := ego.Dynamic{Name: %s, Fields: map[string]interface{}{}}\n", name)
+
+	// An Attrs field is merged with the attributes instead of being set.
+	var attrsValue string
+	for _, field := range blk.Fields {
+		if field.Name == "Attrs" && (len(attrs) > 0 || len(blk.Spreads) > 0) {
+			attrsValue = field.Value
+			continue
+		}
+		fmt.Fprintf(buf, "EGO.Fields[%q] = %s\n", field.Name, field.Value)
+	}
+	t.writeAttrsValue(buf, `EGO.Fields["Attrs"]`, attrsValue, blk.Spreads, attrs)
+
+	for _, attrBlock := range blk.AttrBlocks {
+		if attrBlock.Cond != "" {
+			fmt.Fprintf(buf, "if %s {\n", attrBlock.Cond)
+		}
+		fmt.Fprintf(buf, "EGO.Fields[%q] = func(%s) %s{\n", attrBlock.Name, t.paramList(blk, attrBlock), t.closureResult())
+		t.writeBlocksTo(buf, attrBlock.Yield)
+		t.writeClosureEnd(buf)
+		if attrBlock.Cond != "" {
+			fmt.Fprint(buf, "}\n")
+		}
+	}
+
+	if len(blk.Yield) > 0 {
+		fmt.Fprintf(buf, "EGO.Fields[\"Yield\"] = func() %s{\n", t.closureResult())
+		t.writeBlocksTo(buf, blk.Yield)
+		t.writeClosureEnd(buf)
+	}
+}
+
+// writeAttrsValue writes the assignment of a component's attributes to target.
+// The base value, such as the component's Attrs field, and spread attributes
+// are merged in order, followed by the explicit attributes. Nothing is written
+// if there are no spread or explicit attributes.
+func (t *Template) writeAttrsValue(buf *bytes.Buffer, target, base string, spreads []*Spread, attrs []*Attr) {
+	mapType, merge, spread := "map[string]string", "MergeAttrs", "SpreadAttrs"
+	if t.TypedAttrs {
+		mapType, merge, spread = "map[string]interface{}", "MergeTypedAttrs", "SpreadTypedAttrs"
+	}
+
+	for _, s := range spreads {
+		if base == "" {
+			fmt.Fprintf(buf, "%s = ego.%s(%s)\n", target, spread, s.Value)
+		} else {
+			fmt.Fprintf(buf, "%s = ego.%s(%s, ego.%s(%s))\n", target, merge, base, spread, s.Value)
+		}
+		base = target
+	}
+
+	if len(attrs) == 0 {
+		return
+	} else if base == "" {
+		fmt.Fprintf(buf, "%s = %s{\n", target, mapType)
+		t.writeAttrValues(buf, attrs)
+		fmt.Fprintf(buf, "}\n")
+		return
+	}
+	fmt.Fprintf(buf, "%s = ego.%s(%s, %s{\n", target, merge, base, mapType)
+	t.writeAttrValues(buf, attrs)
+	fmt.Fprintf(buf, "}")
+	for _, name := range t.appendAttrs(attrs) {
+		fmt.Fprintf(buf, ", %q", name)
+	}
+	fmt.Fprintf(buf, ")\n")
+}
+
+// writeAttrValues writes the entries of a map literal of attribute values.
+// Typed attributes keep the values of their expressions.
+func (t *Template) writeAttrValues(buf *bytes.Buffer, attrs []*Attr) {
+	for _, attr := range attrs {
+		if t.TypedAttrs && attr.Value == "" {
+			fmt.Fprintf(buf, "	%q: true,\n", attr.Name)
+		} else if t.TypedAttrs || isStringLit(attr.Value) {
+			fmt.Fprintf(buf, "	%q: %s,\n", attr.Name, attr.Value)
+		} else {
+			fmt.Fprintf(buf, "	%q: fmt.Sprint(%s),\n", attr.Name, attr.Value)
+		}
+	}
+}
+
+// appendAttrs returns the sorted names of attrs with an append merge policy.
+func (t *Template) appendAttrs(attrs []*Attr) []string {
+	var a []string
+	for _, attr := range attrs {
+		if t.AttrMerge[attr.Name] == AttrMergeAppend && !stringSliceContains(a, attr.Name) {
+			a = append(a, attr.Name)
+		}
+	}
+	sort.Strings(a)
+	return a
+}
+
+// writeFuncEnd writes the end of the function of a function directive. A
+// function with a single error result returns nil and a function with named
+// results returns them. Other results must be returned by the template.
+func writeFuncEnd(buf *bytes.Buffer, blk *FuncBlock) {
+	if blk == nil {
+		return
+	}
+	if results := parseFuncSignature(blk.Content).Type.Results; results != nil && len(results.List) > 0 {
+		if len(results.List[0].Names) > 0 {
+			buf.WriteString("return\n")
+		} else if len(results.List) == 1 && isIdent(results.List[0].Type, "error") {
+			buf.WriteString("return nil\n")
+		}
+	}
+	buf.WriteString("}\n")
+}
+
+// printEscapers returns the escaping expressions of the print, translate &
+// JSON print blocks that depend on their context, or nil if they are escaped
+// the same in all contexts.
+func (t *Template) printEscapers() map[Block]string {
+	switch {
+	case t.PlainText:
+		return nil
+	case t.ContextEscape:
+		return contextEscapers(t.Blocks, t.SafeTypes, true)
+	case t.SafeTypes:
+		// ego.HTML values are only written as-is in element content.
+		return contextEscapers(t.Blocks, true, false)
+	}
+	// JSON print blocks are escaped within attribute values.
+	return contextEscapers(t.Blocks, false, false)
+}
+
+// writePrintBlock writes the statement that writes the value of a print,
+// sanitize, URL, or translate block, escaped for the template's options & the
+// block's context. It is shared with Execute so both escape values the same.
+func (t *Template) writePrintBlock(buf *bytes.Buffer, blk Block) {
+	switch blk := blk.(type) {
+	case *PrintBlock:
+		if t.json {
+			t.writeOutput(buf, fmt.Sprintf("ego.WriteJSON(w, %s)", blk.Content))
+		} else if t.PlainText {
+			t.writeOutput(buf, fmt.Sprintf("fmt.Fprint(w, %s)", blk.Content))
+		} else if esc := t.escapers[blk]; esc != "" {
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, %s)", esc))
+		} else if t.FastWriter && t.SafeTypes {
+			t.writeOutput(buf, fmt.Sprintf("ego.WriteEscapedHTML(w, %s)", blk.Content))
+		} else if t.FastWriter {
+			t.writeOutput(buf, fmt.Sprintf("ego.WriteEscaped(w, %s)", blk.Content))
+		} else if t.SafeTypes {
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, ego.EscapeHTML(%s))", blk.Content))
+		} else {
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, html.EscapeString(fmt.Sprint(%s)))", blk.Content))
+		}
+
+	case *RawPrintBlock:
+		t.writeOutput(buf, fmt.Sprintf("fmt.Fprint(w, %s)", blk.Content))
+
+	case *SanitizeBlock:
+		t.writeOutput(buf, fmt.Sprintf("fmt.Fprint(w, %s(ctx, %s))", t.sanitizer(), blk.Content))
+
+	case *JSONPrintBlock:
+		// Within attribute values, the escaper escapes the encoding in EGO_JSON.
+		esc := t.escapers[blk]
+		if !t.returnErrors() && esc == "" {
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, ego.MustScriptJSON(%s))", blk.Content))
+			return
+		}
+
+		if t.returnErrors() {
+			fmt.Fprintf(buf, "{\nEGO_JSON, err := ego.ScriptJSON(%s)\nif err != nil {\nreturn err\n}\n", blk.Content)
+		} else {
+			fmt.Fprintf(buf, "{\nEGO_JSON := ego.MustScriptJSON(%s)\n", blk.Content)
+		}
+		if esc == "" {
+			esc = "EGO_JSON"
+		}
+		t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, %s)", esc))
+		buf.WriteString("}\n")
+
+	case *URLPrintBlock:
+		if t.json {
+			t.writeOutput(buf, fmt.Sprintf("ego.WriteJSON(w, %s)", blk.expr(!t.PlainText)))
+		} else {
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, %s)", blk.expr(!t.PlainText)))
+		}
+
+	case *TranslateBlock:
+		if t.json {
+			t.writeOutput(buf, fmt.Sprintf("ego.WriteJSON(w, %s)", blk.expr()))
+		} else if t.PlainText {
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, %s)", blk.expr()))
+		} else if esc := t.escapers[blk]; esc != "" {
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, %s)", esc))
+		} else if t.FastWriter {
+			t.writeOutput(buf, fmt.Sprintf("ego.WriteEscaped(w, %s)", blk.expr()))
+		} else {
+			t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, html.EscapeString(%s))", blk.expr()))
+		}
+	}
+}
+
+// returnErrors returns true if generated code returns errors.
+func (t *Template) returnErrors() bool {
+	return t.ReturnErrors || t.WriteErrors
+}
+
+// writeOutput writes a statement that calls a function writing output, such
+// as io.WriteString. Its error is returned if write errors are returned.
+// Otherwise its results are discarded.
+func (t *Template) writeOutput(buf *bytes.Buffer, call string) {
+	if t.WriteErrors {
+		fmt.Fprintf(buf, "if _, err := %s; err != nil {\nreturn err\n}\n", call)
+		return
+	}
+	fmt.Fprintf(buf, "_, _ = %s\n", call)
+}
+
+// closureResult returns the result type of generated yield & attribute
+// block closures, including a trailing space.
+func (t *Template) closureResult() string {
+	if t.returnErrors() {
+		return "error "
+	}
+	return ""
+}
+
+// writeClosureEnd writes the end of a yield or attribute block closure.
+func (t *Template) writeClosureEnd(buf *bytes.Buffer) {
+	if t.returnErrors() {
+		buf.WriteString("return nil\n")
+	}
+	buf.WriteString("}\n")
+}
+
+// isStringLit returns true if expr is a string literal. These can be used as
+// attribute values directly without formatting at runtime.
+func isStringLit(expr string) bool {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return false
+	}
+	lit, ok := e.(*ast.BasicLit)
+	return ok && lit.Kind == token.STRING
+}
+
+// isEmpty returns true if the template only contains whitespace & directives
+// that do not generate code.
+func (t *Template) isEmpty() bool {
+	for _, blk := range t.Blocks {
+		switch blk := blk.(type) {
+		case *BuildBlock, *ModeBlock:
+		case *TextBlock:
+			if strings.TrimSpace(blk.Content) != "" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// blockImports returns the import paths required by generated block code.
+func (t *Template) blockImports() []string {
+	var imports []string
+	inspectBlocks(t.Blocks, func(blk Block) bool {
+		switch blk := blk.(type) {
+		case *TextBlock:
+			if t.UnsafeBytes && !t.isHoistedText(blk) {
+				imports = appendImport(imports, "unsafe")
+			}
+		case *PrintBlock:
+			if ((t.FastWriter || t.SafeTypes) && !t.PlainText) || t.escapers[blk] != "" || refsRuntime(blk.Content) {
+				imports = appendImport(imports, RuntimePath)
+			}
+		case *RawPrintBlock:
+			if refsRuntime(blk.Content) {
+				imports = appendImport(imports, RuntimePath)
+			}
+		case *ComponentStartBlock:
+			if t.returnErrors() || blk.Load != "" || (len(blk.Attrs) > 0 && blk.hasAttrsField()) || len(blk.Spreads) > 0 || blk.isDynamic() {
+				imports = appendImport(imports, RuntimePath)
+			}
+		case *AppendStartBlock, *FlushBlock, *FormatStartBlock, *YieldStartBlock, *TranslateBlock, *JSONPrintBlock, *URLPrintBlock:
+			imports = appendImport(imports, RuntimePath)
+		}
+		return true
+	})
+	return imports
+}
+
+// refsRuntime returns true if expr refers to the runtime package, such as the
+// functions of DefaultFilters.
+func refsRuntime(expr string) bool {
+	x, err := parser.ParseExpr(expr)
+	if err != nil {
+		return false
+	}
+	var found bool
+	ast.Inspect(x, func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Name == "ego" {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// writeBuildConstraint writes the "//go:build" and "// +build" lines for the
+// template's build directive, followed by a blank line.
+func (t *Template) writeBuildConstraint(buf *bytes.Buffer) error {
+	for _, blk := range t.Blocks {
+		blk, ok := blk.(*BuildBlock)
+		if !ok {
+			continue
+		}
+
+		expr, err := parseBuildConstraint(blk.Constraint)
+		if err != nil {
+			return NewSyntaxError(blk.Pos, "Invalid build constraint: %s", blk.Constraint)
+		}
+		fmt.Fprintf(buf, "//go:build %s\n", expr)
+		if lines, err := constraint.PlusBuildLines(expr); err == nil {
+			for _, line := range lines {
+				fmt.Fprintln(buf, line)
+			}
+		}
+		buf.WriteString("\n")
+		return nil
+	}
+	return nil
+}
+
+// parseBuildConstraint parses a constraint in either "//go:build" expression
+// syntax (e.g. "linux && !windows") or "// +build" syntax (e.g. "linux,!windows").
+func parseBuildConstraint(s string) (constraint.Expr, error) {
+	if strings.ContainsAny(s, "&|()") {
+		return constraint.Parse("//go:build " + s)
+	}
+	return constraint.Parse("// +build " + s)
+}
+
+// writeLoopStart writes the start of a component loop. If a flush interval is
+// set then the loop periodically checks for cancellation and flushes.
+func (t *Template) writeLoopStart(buf *bytes.Buffer, blk *ComponentStartBlock) {
+	if t.FlushInterval <= 0 {
+		fmt.Fprintf(buf, "for %s {\n", blk.For)
+		return
+	}
+
+	fmt.Fprintf(buf, "{\nEGO_N := 0\n")
+	fmt.Fprintf(buf, "for %s {\n", blk.For)
+	fmt.Fprintf(buf, "if EGO_N > 0 && EGO_N%%%d == 0 {\n", t.FlushInterval)
+	fmt.Fprintf(buf, "if ctx.Err() != nil {\nbreak\n}\n")
+	fmt.Fprintf(buf, "if f, ok := w.(interface{ Flush() }); ok {\nf.Flush()\n}\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "EGO_N++\n")
+}
+
+// writeLoopEnd writes the end of a component loop.
+func (t *Template) writeLoopEnd(buf *bytes.Buffer) {
+	if t.FlushInterval <= 0 {
+		fmt.Fprint(buf, "}\n")
+		return
+	}
+	fmt.Fprint(buf, "}\n}\n")
+}
+
+// writeComment writes the content of a template comment as Go line comments.
+func writeComment(buf *bytes.Buffer, content string) {
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			buf.WriteString("//\n")
+		} else {
+			fmt.Fprintf(buf, "// %s\n", line)
+		}
+	}
+}
+
+// writeTrace writes a call to the trace function for blocks that write output.
+// Positions have the same path as line directives.
+func (t *Template) writeTrace(buf *bytes.Buffer, blk Block) {
+	var name string
+	switch blk.(type) {
+	case *TextBlock:
+		name = "text"
+	case *PrintBlock:
+		name = "print"
+	case *RawPrintBlock:
+		name = "raw"
+	case *ScopeBlock:
+		name = "scope"
+	case *SanitizeBlock:
+		name = "sanitize"
+	case *JSONPrintBlock:
+		name = "json"
+	case *URLPrintBlock:
+		name = "url"
+	case *TranslateBlock:
+		name = "translate"
+	case *FlushBlock:
+		name = "flush"
+	case *ComponentStartBlock:
+		name = "component"
+	default:
+		return
+	}
+
+	pos := Position(blk)
+	fmt.Fprintf(buf, "%s(ctx, %q, %q)\n", TraceFunc, name, fmt.Sprintf("%s:%d", t.linePath(pos.Path), pos.LineNo))
+}
+
+// linePath returns the path written in a //line directive for a template
+// path, which is relative to TrimPath if it is within it.
+func (t *Template) linePath(path string) string {
+	if t.TrimPath == "" {
+		return path
+	}
+	root, err := filepath.Abs(t.TrimPath)
+	if err != nil {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// convertLineEndings returns s with the template's line endings.
+func (t *Template) convertLineEndings(s string) string {
+	if t.PreserveWhitespace {
+		return s
+	}
+	switch t.LineEnding {
+	case LineEndingLF:
+		return strings.Replace(s, "\r\n", "\n", -1)
+	case LineEndingCRLF:
+		return strings.Replace(strings.Replace(s, "\r\n", "\n", -1), "\n", "\r\n", -1)
+	default:
+		return s
+	}
+}
+
+// isHoistedText returns true if the text block is written from a
+// package-level variable.
+func (t *Template) isHoistedText(blk *TextBlock) bool {
+	return t.HoistTextLen > 0 && len(t.convertLineEndings(blk.Content)) >= t.HoistTextLen
+}
+
+// hoistText adds text to the variables written by writeHoistedText and
+// returns the name of its variable.
+func (t *Template) hoistText(s string) string {
+	t.hoistedText = append(t.hoistedText, t.quoteText(s))
+	return t.hoistedTextName(len(t.hoistedText) - 1)
+}
+
+// hoistedTextName returns the name of the i-th hoisted text variable. Names
+// include the base name of the template's path and a hash of it so they are
+// unique within a package, even for names that only differ by characters that
+// cannot be used in identifiers, such as "my-page.ego" & "my_page.ego".
+func (t *Template) hoistedTextName(i int) string {
+	base := filepath.Base(t.Path)
+	h := fnv.New32a()
+	h.Write([]byte(base))
+
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(base, filepath.Ext(base)))
+	return fmt.Sprintf("egoText_%s_%08x_%d", name, h.Sum32(), i)
+}
+
+// writeHoistedText writes the package-level variables of hoisted text.
+func (t *Template) writeHoistedText(buf *bytes.Buffer) {
+	for i, s := range t.hoistedText {
+		fmt.Fprintf(buf, "\nvar %s = []byte(%s)\n", t.hoistedTextName(i), s)
+	}
+}
+
+// quoteText returns s as a Go string expression. If s exceeds the maximum
+// literal length then it is split into multiple concatenated literals.
+func (t *Template) quoteText(s string) string {
+	if t.MaxLiteralLen <= 0 || len(s) <= t.MaxLiteralLen {
+		return strconv.Quote(s)
+	}
+
+	a := splitText(s, t.MaxLiteralLen)
+	for i := range a {
+		a[i] = strconv.Quote(a[i])
+	}
+	return strings.Join(a, "+\n")
+}
+
+// splitText splits s into chunks of at most max bytes. Chunks end after the
+// last newline within the limit, if any, and never split a UTF-8 sequence.
+func splitText(s string, max int) []string {
+	var a []string
+	for len(s) > max {
+		n := strings.LastIndexByte(s[:max], '\n') + 1
+		if n == 0 {
+			for n = max; n > 0 && !utf8.RuneStart(s[n]); n-- {
 			}
+			if n == 0 {
+				_, n = utf8.DecodeRuneInString(s)
+			}
+		}
+		a, s = append(a, s[:n]), s[n:]
+	}
+	return append(a, s)
+}
 
-			fmt.Fprint(buf, "EGO.Render(ctx, w) }\n")
+// featureFunc returns the name of the feature flag function.
+func (t *Template) featureFunc() string {
+	if t.FeatureFunc == "" {
+		return DefaultFeatureFunc
+	}
+	return t.FeatureFunc
+}
+
+// contentType returns the content type of generated responses.
+func (t *Template) contentType() string {
+	if t.ContentType != "" {
+		return t.ContentType
+	}
+	if ext := filepath.Ext(strings.TrimSuffix(t.Path, ".ego")); ext != "" {
+		if typ := mime.TypeByExtension(ext); typ != "" {
+			return typ
 		}
 	}
+	return "text/html; charset=utf-8"
+}
+
+// tracer returns the expression for the tracer used by component spans.
+func (t *Template) tracer() string {
+	if t.Tracer == "" {
+		return DefaultTracer
+	}
+	return t.Tracer
+}
+
+// panicHandler returns the name of the function called with recovered panics.
+func (t *Template) panicHandler() string {
+	if t.PanicHandler == "" {
+		return DefaultPanicHandler
+	}
+	return t.PanicHandler
+}
+
+// sanitizer returns the name of the sanitizer function.
+func (t *Template) sanitizer() string {
+	if t.Sanitizer == "" {
+		return DefaultSanitizer
+	}
+	return t.Sanitizer
+}
+
+// writeDeprecation writes a call to the deprecation marker function of a
+// component, if the component is listed as deprecated.
+func (t *Template) writeDeprecation(buf *bytes.Buffer, blk *ComponentStartBlock) {
+	msg, ok := t.Deprecated[blk.Namespace()+":"+blk.Name]
+	if !ok {
+		return
+	}
+
+	if msg != "" {
+		fmt.Fprintf(buf, "// %s is deprecated: %s\n", strings.Trim(shortComponentBlockString(blk), "<>"), strings.Replace(msg, "\n", " ", -1))
+	}
+	if blk.Package != "" {
+		fmt.Fprintf(buf, "%s.Deprecated_%s()\n", blk.Package, blk.Name)
+	} else {
+		fmt.Fprintf(buf, "Deprecated_%s()\n", blk.Name)
+	}
+}
+
+// inspectBlocks calls fn for each block in depth-first order, including blocks
+// nested within components and attribute blocks. If fn returns false then the
+// nested blocks of the block are skipped.
+func inspectBlocks(a []Block, fn func(Block) bool) {
+	Inspect(a, func(blk Block) bool {
+		return blk != nil && fn(blk)
+	})
 }
 
 // Normalize joins together adjacent text blocks.
@@ -129,14 +1467,17 @@ func joinAdjacentTextBlocks(a []Block) []Block {
 		}
 
 		// Simply append if this block or prev block are not text blocks.
+		// Text from different files, such as included files, is kept in
+		// separate blocks so each is attributed to its own file.
 		prev, isPrevTextBlock := other[len(other)-1].(*TextBlock)
-		if !isTextBlock || !isPrevTextBlock {
+		if !isTextBlock || !isPrevTextBlock || prev.Pos.Path != curr.Pos.Path {
 			other = append(other, blk)
 			continue
 		}
 
 		// Append this text block's content to the previous block.
 		prev.Content += curr.Content
+		prev.End = curr.End
 	}
 
 	return other
@@ -154,8 +1495,32 @@ func trimTrailingEmptyTextBlocks(a []Block) []Block {
 	return a
 }
 
-func injectImports(f *ast.File) {
-	names := []string{`"fmt"`, `"html"`, `"io"`, `"context"`}
+// injectImports adds the standard imports referenced by the file as well as
+// any extra import paths required by generated helper methods. Standard
+// imports are only added for packages referenced by the file so unused
+// packages are not imported.
+func injectImports(f *ast.File, extra ...string) {
+	// Extra imports are sorted so their order does not depend on the order
+	// that they were required while generating code.
+	extra = append([]string(nil), extra...)
+	sort.Strings(extra)
+
+	// Existing imports of the standard packages are always removed so that
+	// unused imports do not fail to compile.
+	std := []string{`"fmt"`, `"html"`, `"io"`, `"context"`}
+	used := packageRefs(f)
+
+	var names []string
+	for _, name := range std {
+		if path, _ := strconv.Unquote(name); used[path] {
+			names = append(names, name)
+		}
+	}
+	for _, path := range extra {
+		if name := strconv.Quote(path); !stringSliceContains(names, name) {
+			names = append(names, name)
+		}
+	}
 
 	// Strip packages from existing imports.
 	for i := 0; i < len(f.Decls); i++ {
@@ -165,7 +1530,7 @@ func injectImports(f *ast.File) {
 		}
 
 		// Remove listed imports.
-		removeImportSpecs(decl, names)
+		removeImportSpecs(decl, append(std, names...))
 
 		// Remove declaration if it has no imports.
 		if len(decl.Specs) == 0 {
@@ -176,41 +1541,39 @@ func injectImports(f *ast.File) {
 		}
 	}
 
-	// Generate new import.
+	// Generate new import. Imports are positioned after the package clause
+	// so that the printer does not misplace comments around them.
+	pos := f.Name.End()
 	for i := len(names) - 1; i >= 0; i-- {
 		f.Decls = append([]ast.Decl{&ast.GenDecl{
-			Tok: token.IMPORT,
+			TokPos: pos,
+			Tok:    token.IMPORT,
 			Specs: []ast.Spec{
-				&ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: names[i]}},
+				&ast.ImportSpec{Path: &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: names[i]}},
 			},
 		}}, f.Decls...)
 	}
+}
 
-	// Add unnamed vars at the end of the file to ensure imports are used.
-	f.Decls = append(f.Decls, &ast.GenDecl{
-		Tok: token.VAR,
-		Specs: []ast.Spec{
-			&ast.ValueSpec{Names: []*ast.Ident{{Name: "_"}}, Type: &ast.Ident{Name: "fmt.Stringer"}},
-		},
-	})
-	f.Decls = append(f.Decls, &ast.GenDecl{
-		Tok: token.VAR,
-		Specs: []ast.Spec{
-			&ast.ValueSpec{Names: []*ast.Ident{{Name: "_"}}, Type: &ast.Ident{Name: "io.Reader"}},
-		},
-	})
-	f.Decls = append(f.Decls, &ast.GenDecl{
-		Tok: token.VAR,
-		Specs: []ast.Spec{
-			&ast.ValueSpec{Names: []*ast.Ident{{Name: "_"}}, Type: &ast.Ident{Name: "context.Context"}},
-		},
-	})
-	f.Decls = append(f.Decls, &ast.GenDecl{
-		Tok: token.VAR,
-		Specs: []ast.Spec{
-			&ast.ValueSpec{Names: []*ast.Ident{{Name: "_"}}, Values: []ast.Expr{&ast.Ident{Name: "html.EscapeString"}}},
-		},
+// packageRefs returns the names of packages referenced by selectors in f.
+// These are identifiers that are not declared in the file, such as the "fmt"
+// in fmt.Sprint.
+func packageRefs(f *ast.File) map[string]bool {
+	unresolved := make(map[*ast.Ident]bool, len(f.Unresolved))
+	for _, ident := range f.Unresolved {
+		unresolved[ident] = true
+	}
+
+	m := make(map[string]bool)
+	ast.Inspect(f, func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok && unresolved[ident] {
+				m[ident.Name] = true
+			}
+		}
+		return true
 	})
+	return m
 }
 
 func removeImportSpecs(decl *ast.GenDecl, names []string) {
@@ -236,47 +1599,345 @@ type Block interface {
 
 func (*TextBlock) block()           {}
 func (*CodeBlock) block()           {}
+func (*CommentBlock) block()        {}
+func (*FuncBlock) block()           {}
 func (*PrintBlock) block()          {}
 func (*RawPrintBlock) block()       {}
 func (*ComponentStartBlock) block() {}
 func (*ComponentEndBlock) block()   {}
 func (*AttrStartBlock) block()      {}
 func (*AttrEndBlock) block()        {}
+func (*ScopeBlock) block()          {}
+func (*SanitizeBlock) block()       {}
+func (*TranslateBlock) block()      {}
+func (*JSONPrintBlock) block()      {}
+func (*URLPrintBlock) block()       {}
+func (*BuildBlock) block()          {}
+func (*ModeBlock) block()           {}
+func (*CtxBlock) block()            {}
+func (*AppendStartBlock) block()    {}
+func (*AppendEndBlock) block()      {}
+func (*FlushBlock) block()          {}
+func (*FormatStartBlock) block()    {}
+func (*FormatEndBlock) block()      {}
+func (*YieldStartBlock) block()     {}
+func (*YieldEndBlock) block()       {}
+func (*LoadStateBlock) block()      {}
+func (*IncludeBlock) block()        {}
+func (*ExtendsBlock) block()        {}
+func (*ContentStartBlock) block()   {}
+func (*ContentEndBlock) block()     {}
 
 // TextBlock represents a UTF-8 encoded block of text that is written to the writer as-is.
 type TextBlock struct {
 	Pos     Pos
+	End     Pos
 	Content string
 }
 
+// Trim represents how whitespace in text adjacent to a block is removed.
+type Trim int
+
+const (
+	// TrimNone keeps adjacent whitespace.
+	TrimNone Trim = iota
+
+	// TrimAll removes all adjacent whitespace, including newlines. It is
+	// marked by a "-" after the open tag or before the close tag.
+	TrimAll
+
+	// TrimLine removes adjacent whitespace up to a single newline. Before a
+	// block, spaces and tabs back to the start of the line are removed. After
+	// a block, spaces and tabs and the newline that ends the line are removed.
+	// Whitespace is kept if other content shares the line on that side. It is
+	// marked by a "~" after the open tag or before the close tag.
+	TrimLine
+)
+
 // CodeBlock represents a Go code block that is printed as-is to the template.
 type CodeBlock struct {
 	Pos     Pos
+	End     Pos
+	Content string
+
+	// Whitespace removed from adjacent text by trim markers.
+	TrimLeft, TrimRight Trim
+}
+
+// CommentBlock represents a template comment, such as "<%# TODO %>". It is
+// removed from the output & the generated code unless the template's Comments
+// option is set.
+type CommentBlock struct {
+	Pos     Pos
+	End     Pos
 	Content string
+
+	// Whitespace removed from adjacent text by trim markers.
+	TrimLeft, TrimRight Trim
+}
+
+// FuncBlock represents a function directive, such as
+// "<%: func Render(ctx context.Context, w io.Writer) error %>". The top-level
+// blocks that follow it, up to the next function directive, are the body of
+// the function. Its return statement & closing brace are generated.
+type FuncBlock struct {
+	Pos     Pos
+	End     Pos
+	Content string // function signature
 }
 
 // PrintBlock represents a block that will HTML escape the contents before outputting
 type PrintBlock struct {
 	Pos     Pos
+	End     Pos
 	Content string
+
+	// Whitespace removed from adjacent text by trim markers.
+	TrimLeft, TrimRight Trim
 }
 
 // RawPrintBlock represents a block of the template that is printed out to the writer.
 type RawPrintBlock struct {
 	Pos     Pos
+	End     Pos
+	Content string
+
+	// Whitespace removed from adjacent text by trim markers.
+	TrimLeft, TrimRight Trim
+}
+
+// ScopeBlock represents a directive that prints the CSS scope prefix of the
+// component whose Render method contains the block. See ScopeClass().
+type ScopeBlock struct {
+	Pos Pos
+	End Pos
+}
+
+// SanitizeBlock represents a print block whose value is passed through the
+// template's sanitizer and then written to the writer without escaping.
+type SanitizeBlock struct {
+	Pos     Pos
+	End     Pos
+	Content string
+}
+
+// JSONPrintBlock represents a print block whose value is written as JSON that
+// is safe to embed in script elements, such as "<%=json state %>".
+type JSONPrintBlock struct {
+	Pos     Pos
+	End     Pos
+	Content string
+}
+
+// URLPrintBlock represents a print block whose value is escaped for use in a
+// URL query, such as "<%=url q %>", or in a path segment if Path is set, such
+// as "<%=url:path name %>".
+type URLPrintBlock struct {
+	Pos     Pos
+	End     Pos
 	Content string
+	Path    bool
+}
+
+// expr returns the expression of the block's escaped value. If escapeHTML is
+// set, escaped path segments are also HTML-escaped as they may contain
+// ampersands.
+func (blk *URLPrintBlock) expr(escapeHTML bool) string {
+	if blk.Path && escapeHTML {
+		return fmt.Sprintf("html.EscapeString(ego.EscapePath(%s))", blk.Content)
+	} else if blk.Path {
+		return fmt.Sprintf("ego.EscapePath(%s)", blk.Content)
+	}
+	return fmt.Sprintf("ego.EscapeQuery(%s)", blk.Content)
+}
+
+// TranslateBlock represents a directive that writes the translation of a
+// message key, such as `<%t "welcome", user.Name %>`. The translation is
+// returned by the translator of the render's context and is escaped.
+type TranslateBlock struct {
+	Pos  Pos
+	End  Pos
+	Key  string
+	Args []string
+}
+
+// expr returns the expression that translates the block's message.
+func (blk *TranslateBlock) expr() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "ego.Translate(ctx, %s", strconv.Quote(blk.Key))
+	for _, arg := range blk.Args {
+		fmt.Fprintf(&buf, ", %s", arg)
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+// BuildBlock represents a directive at the top of a template that sets the
+// build constraint of the generated file (e.g. "linux && !windows").
+type BuildBlock struct {
+	Pos        Pos
+	End        Pos
+	Constraint string
+}
+
+// CtxBlock represents a directive whose statement is moved to the start of
+// the enclosing function, such as extracting values from the context.
+type CtxBlock struct {
+	Pos     Pos
+	End     Pos
+	Content string
+}
+
+// AppendStartBlock represents the opening of an append directive. Its output
+// is collected into a named region, instead of being written in place, until
+// the region is written by a flush directive.
+type AppendStartBlock struct {
+	Pos   Pos
+	End   Pos
+	Name  string // region name as a Go string literal
+	Yield []Block
+}
+
+// AppendEndBlock represents the close tag of an append directive.
+type AppendEndBlock struct {
+	Pos Pos
+	End Pos
+}
+
+// FlushBlock represents a directive that writes the output collected in a
+// named region and clears the region.
+type FlushBlock struct {
+	Pos  Pos
+	End  Pos
+	Name string
+}
+
+// FormatStartBlock represents the opening of a format region, such as
+// "<%json %>". Its blocks are only rendered when the format of the render's
+// context matches. See ContextFormat().
+type FormatStartBlock struct {
+	Pos    Pos
+	End    Pos
+	Format string // FormatHTML or FormatJSON
+	Yield  []Block
+}
+
+// FormatEndBlock represents the close tag of a format region.
+type FormatEndBlock struct {
+	Pos    Pos
+	End    Pos
+	Format string
+}
+
+// YieldStartBlock represents the opening of a yield region, such as
+// "<%yield r.Yield %>", within the Render method of a component. The closure
+// of the expression is called if it is not nil. Otherwise the region's blocks
+// are rendered as the default content.
+type YieldStartBlock struct {
+	Pos     Pos
+	End     Pos
+	Content string // closure expression
+	Yield   []Block
+}
+
+// YieldEndBlock represents the close tag of a yield region.
+type YieldEndBlock struct {
+	Pos Pos
+	End Pos
+}
+
+// LoadStateBlock represents the start of a load state region within the body
+// of a component with a loader, such as "<%success user %>". The region
+// continues until the next load state or the end of the body. Name is the
+// variable bound to the loader's error or value, if any.
+type LoadStateBlock struct {
+	Pos   Pos
+	End   Pos
+	State string // LoadStateLoading, LoadStateError, or LoadStateSuccess
+	Name  string
+}
+
+// Modes of mode directives.
+const (
+	ModeHTML = "html"
+	ModeText = "text"
+)
+
+// ModeBlock represents a directive at the top of a template that sets the
+// kind of output it writes, such as "<%@ mode text %>". See PlainText.
+type ModeBlock struct {
+	Pos  Pos
+	End  Pos
+	Mode string
+}
+
+// IncludeBlock represents a directive that includes the blocks of another
+// template file, such as "<%@ include "header.ego" %>". Include blocks are
+// replaced by the included blocks when parsing so they do not appear in a
+// template's block list.
+type IncludeBlock struct {
+	Pos  Pos
+	End  Pos
+	Path string // path relative to the including file
+}
+
+// ExtendsBlock represents a directive that renders a layout file in its
+// place, such as "<%@ extends "layout.ego" %>". The content blocks defined
+// next to the directive replace the layout's content blocks of the same name.
+// Extends blocks are replaced by the layout's blocks when parsing.
+type ExtendsBlock struct {
+	Pos  Pos
+	End  Pos
+	Path string // path relative to the extending file
+}
+
+// ContentStartBlock represents a named content block, such as
+// "<%@ block "title" %>", which is closed by "<%@ end %>". In a layout, its
+// yielded blocks are the default content. Next to an extends directive, they
+// replace the layout's content. Content blocks are replaced by their yielded
+// blocks when parsing.
+type ContentStartBlock struct {
+	Pos   Pos
+	End   Pos
+	Name  string
+	Yield []Block
+}
+
+// ContentEndBlock represents the end of a named content block.
+type ContentEndBlock struct {
+	Pos Pos
+	End Pos
 }
 
 // ComponentStartBlock represents the opening block of an ego component.
 type ComponentStartBlock struct {
 	Pos        Pos
+	End        Pos
 	Package    string
 	Name       string
 	Closed     bool
 	Fields     []*Field
 	Attrs      []*Attr
+	Spreads    []*Spread
 	AttrBlocks []*AttrStartBlock
 	Yield      []Block
+
+	// Optional loop clause from a "for" attribute, such as
+	// "_, item := range items". The component is rendered once per iteration.
+	For    string
+	ForPos Pos
+
+	// Optional feature flag expression from a "flag" attribute. If set, the
+	// component is only rendered when the template's feature function
+	// reports that the flag is enabled.
+	Flag    string
+	FlagPos Pos
+
+	// Optional loader expression from a "load" attribute. The loader is
+	// called with the render's context and returns a value and an error
+	// which select the load state region of the body that is rendered.
+	Load    string
+	LoadPos Pos
 }
 
 // Namespace returns the block package, if defined. Otherwise returns "ego".
@@ -287,9 +1948,48 @@ func (blk *ComponentStartBlock) Namespace() string {
 	return blk.Package
 }
 
+// typeName returns the Go type name of the component, such as "Card" or
+// "ui.Card".
+func (blk *ComponentStartBlock) typeName() string {
+	if blk.Package == "" {
+		return blk.Name
+	}
+	return blk.Package + "." + blk.Name
+}
+
+// hasAttrsField returns true if the component sets its Attrs field directly.
+func (blk *ComponentStartBlock) hasAttrsField() bool {
+	for _, field := range blk.Fields {
+		if field.Name == "Attrs" {
+			return true
+		}
+	}
+	return false
+}
+
+// isDynamic returns true if the block is a dynamic component, which renders
+// a registered component chosen by name at runtime.
+func (blk *ComponentStartBlock) isDynamic() bool {
+	return blk.Package == "" && blk.Name == DynamicComponent
+}
+
+// dynamicName returns the expression of the name attribute of a dynamic
+// component and its other attributes.
+func (blk *ComponentStartBlock) dynamicName() (name string, attrs []*Attr) {
+	for _, attr := range blk.Attrs {
+		if attr.Name == "name" && name == "" {
+			name = attr.Value
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	return name, attrs
+}
+
 // ComponentEndBlock represents the closing block of an ego component.
 type ComponentEndBlock struct {
 	Pos     Pos
+	End     Pos
 	Package string
 	Name    string
 }
@@ -305,9 +2005,28 @@ func (blk *ComponentEndBlock) Namespace() string {
 // AttrStartBlock represents the opening block of an ego component attribute.
 type AttrStartBlock struct {
 	Pos     Pos
+	End     Pos
 	Package string
 	Name    string
 	Yield   []Block
+
+	// Optional condition from an "if" attribute. If set, the attribute is
+	// only assigned when the condition is true.
+	Cond    string
+	CondPos Pos
+
+	// Parameters of the attribute's function, such as "row" in
+	// "<ego::Row row>", so the component can pass data to each call.
+	Params []*Param
+}
+
+// Param represents a parameter of an attribute block's function, such as
+// "row" or "row=RowData". If Type is blank, it is the type of the parameter
+// of the component's field, which must be declared in the template.
+type Param struct {
+	Name string
+	Type string
+	Pos  Pos
 }
 
 // Namespace returns the block package, if defined. Otherwise returns "ego".
@@ -321,6 +2040,7 @@ func (blk *AttrStartBlock) Namespace() string {
 // AttrEndBlock represents the closing block of an ego component attribute.
 type AttrEndBlock struct {
 	Pos     Pos
+	End     Pos
 	Package string
 	Name    string
 }
@@ -366,34 +2086,103 @@ type Attr struct {
 	ValuePos Pos
 }
 
+// Spread represents attributes spread onto a component from an expression,
+// such as "...r.Attrs".
+type Spread struct {
+	Value    string
+	ValuePos Pos
+}
+
 // Position returns the position of the block.
 func Position(blk Block) Pos {
+	pos, _ := blockPos(blk)
+	return *pos
+}
+
+// EndPosition returns the position immediately after the block's source.
+// For component, attribute & region start blocks, this is the end of the
+// start tag. Blocks that were not scanned from source have a zero end.
+func EndPosition(blk Block) Pos {
+	_, end := blockPos(blk)
+	return *end
+}
+
+// blockPos returns pointers to the start & end positions of the block.
+func blockPos(blk Block) (pos, end *Pos) {
 	switch blk := blk.(type) {
 	case *TextBlock:
-		return blk.Pos
+		return &blk.Pos, &blk.End
 	case *CodeBlock:
-		return blk.Pos
+		return &blk.Pos, &blk.End
+	case *CommentBlock:
+		return &blk.Pos, &blk.End
+	case *FuncBlock:
+		return &blk.Pos, &blk.End
 	case *PrintBlock:
-		return blk.Pos
+		return &blk.Pos, &blk.End
 	case *RawPrintBlock:
-		return blk.Pos
+		return &blk.Pos, &blk.End
 	case *ComponentStartBlock:
-		return blk.Pos
+		return &blk.Pos, &blk.End
 	case *ComponentEndBlock:
-		return blk.Pos
+		return &blk.Pos, &blk.End
 	case *AttrStartBlock:
-		return blk.Pos
+		return &blk.Pos, &blk.End
 	case *AttrEndBlock:
-		return blk.Pos
+		return &blk.Pos, &blk.End
+	case *ScopeBlock:
+		return &blk.Pos, &blk.End
+	case *SanitizeBlock:
+		return &blk.Pos, &blk.End
+	case *TranslateBlock:
+		return &blk.Pos, &blk.End
+	case *JSONPrintBlock:
+		return &blk.Pos, &blk.End
+	case *URLPrintBlock:
+		return &blk.Pos, &blk.End
+	case *BuildBlock:
+		return &blk.Pos, &blk.End
+	case *ModeBlock:
+		return &blk.Pos, &blk.End
+	case *CtxBlock:
+		return &blk.Pos, &blk.End
+	case *AppendStartBlock:
+		return &blk.Pos, &blk.End
+	case *AppendEndBlock:
+		return &blk.Pos, &blk.End
+	case *FlushBlock:
+		return &blk.Pos, &blk.End
+	case *FormatStartBlock:
+		return &blk.Pos, &blk.End
+	case *FormatEndBlock:
+		return &blk.Pos, &blk.End
+	case *YieldStartBlock:
+		return &blk.Pos, &blk.End
+	case *YieldEndBlock:
+		return &blk.Pos, &blk.End
+	case *LoadStateBlock:
+		return &blk.Pos, &blk.End
+	case *IncludeBlock:
+		return &blk.Pos, &blk.End
+	case *ExtendsBlock:
+		return &blk.Pos, &blk.End
+	case *ContentStartBlock:
+		return &blk.Pos, &blk.End
+	case *ContentEndBlock:
+		return &blk.Pos, &blk.End
 	default:
 		panic("unreachable")
 	}
 }
 
-// Pos represents a position in a given file.
+// Pos represents a position in a given file. Column is the byte column of
+// the position on its line and Offset is its byte offset in the file, both
+// starting at 1 and 0 respectively.
 type Pos struct {
 	Path   string
 	LineNo int
+	Column int
+	Offset int
 }
 
 func stringSliceContains(a []string, v string) bool {
@@ -2,6 +2,14 @@ package ego_test
 
 import (
 	"bytes"
+	"fmt"
+	"go/build"
+	"go/printer"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/benbjohnson/ego"
@@ -29,3 +37,2984 @@ func TestTemplate_Write(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// Ensure that only the standard packages referenced by generated code are
+// imported.
+func TestTemplate_Write_Imports(t *testing.T) {
+	for _, tt := range []struct {
+		src               string
+		imports, excluded []string
+	}{
+		{
+			src:      "<%\npackage foo\n\nfunc Render(_ context.Context, w io.Writer) {\n%><p>hi</p><% } %>",
+			imports:  []string{`"io"`, `"context"`},
+			excluded: []string{`"fmt"`, `"html"`},
+		},
+		{
+			src:     "<%\npackage foo\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n)\n\nfunc Render(ctx context.Context, w io.Writer, s string) {\n%><%= strings.ToUpper(s) %><% } %>",
+			imports: []string{`"fmt"`, `"html"`, `"io"`, `"context"`, `"strings"`},
+		},
+		{
+			src:      "<%\npackage foo\n\nimport \"fmt\"\n\nfunc Render(ctx context.Context, w io.Writer, html fmt.Stringer) {\n%><%== html.String() %><% } %>",
+			imports:  []string{`"fmt"`, `"io"`, `"context"`},
+			excluded: []string{`"html"`},
+		},
+	} {
+		tmpl, err := ego.Parse(strings.NewReader(tt.src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		s := buf.String()
+		if strings.Contains(s, "var _") {
+			t.Fatalf("unexpected anchor: %s", s)
+		}
+		for _, path := range tt.imports {
+			if strings.Count(s, path) != 1 {
+				t.Fatalf("expected single import of %s: %s", path, s)
+			}
+		}
+		for _, path := range tt.excluded {
+			if strings.Contains(s, path) {
+				t.Fatalf("unexpected import of %s: %s", path, s)
+			}
+		}
+	}
+}
+
+// Ensure that function directives generate the functions around their bodies.
+func TestTemplate_Write_FuncDirective(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Item struct {
+	Name string
+}
+%>
+
+<%: func ItemList(ctx context.Context, w io.Writer, items []Item) error %>
+<%ctx prefix := ctx.Value("prefix").(string) %><ul><% for _, item := range items { %><li><%= prefix + item.Name %></li><% } %></ul>
+
+<%: func (r Item) Render(ctx context.Context, w io.Writer) %>
+<p><%= r.Name %></p>
+<%: func count(items []Item) (n int) %>
+<% n = len(items) %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	items := []Item{{Name: "a"}, {Name: "<b>"}}
+	ctx := context.WithValue(context.Background(), "prefix", "#")
+	if err := ItemList(ctx, os.Stdout, items); err != nil {
+		panic(err)
+	}
+	items[0].Render(ctx, os.Stdout)
+	fmt.Print(count(items))
+}
+`, nil)
+	if exp := "<ul><li>#a</li><li>#&lt;b&gt;</li></ul><p>a</p>2"; out != exp {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	t.Run("ErrNested", func(t *testing.T) {
+		_, err := ego.Parse(strings.NewReader("<%\npackage main\n%><%: func A(w io.Writer) %><ego:Card><%: func B(w io.Writer) %></ego:Card>"), "tmpl.ego")
+		if err == nil || err.Error() != "Function directive must appear at the top level of the template at tmpl.ego:3" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that comments are removed from the output & optionally written to the
+// generated code.
+func TestTemplate_Write_Comments(t *testing.T) {
+	src := "<%# Generated by hand. -%>\n<%build linux %><%\npackage foo\n\nfunc Render(ctx context.Context, w io.Writer) {\n%><p>\n\t<%#~ first\n\tsecond ~%>\n</p><%#- return %>!<% } %>"
+	tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); strings.Contains(s, "first") || !strings.Contains(s, `io.WriteString(w, "<p>\n")`) || !strings.Contains(s, `io.WriteString(w, "</p>")`) {
+		t.Fatalf("unexpected output: %s", s)
+	}
+
+	buf.Reset()
+	tmpl.Comments = true
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); !strings.Contains(s, "\t// first\n\t// second\n") || !strings.Contains(s, "// return\n") {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure that a template can be printed with a custom printer config.
+func TestTemplate_Write_PrinterConfig(t *testing.T) {
+	tmpl := &ego.Template{
+		Blocks: []ego.Block{
+			&ego.CodeBlock{Content: "package foo"},
+			&ego.CodeBlock{Content: "func doSomething() {"},
+			&ego.TextBlock{Content: "<html>"},
+			&ego.CodeBlock{Content: "}"},
+		},
+		PrinterConfig: &printer.Config{Mode: printer.UseSpaces, Tabwidth: 4},
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); strings.Contains(s, "\t") {
+		t.Fatalf("unexpected tab in output: %s", s)
+	} else if !strings.Contains(s, "\n    _, _ = io.WriteString(w, \"<html>\")\n") {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure that usages of deprecated components reference a marker function.
+func TestTemplate_Write_Deprecated(t *testing.T) {
+	tmpl := &ego.Template{
+		Blocks: []ego.Block{
+			&ego.CodeBlock{Content: "package foo"},
+			&ego.CodeBlock{Content: "func doSomething() {"},
+			&ego.ComponentStartBlock{Name: "Button"},
+			&ego.ComponentStartBlock{Package: "ui", Name: "Card"},
+			&ego.ComponentStartBlock{Name: "Link"},
+			&ego.CodeBlock{Content: "}"},
+		},
+		Deprecated: map[string]string{
+			"ego:Button": "use ego:Btn instead",
+			"ui:Card":    "",
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); !strings.Contains(s, "// ego:Button is deprecated: use ego:Btn instead\n\t\tDeprecated_Button()\n") {
+		t.Fatalf("expected local marker: %s", s)
+	} else if !strings.Contains(s, "\t\tui.Deprecated_Card()\n") {
+		t.Fatalf("expected package marker: %s", s)
+	} else if strings.Contains(s, "Deprecated_Link") {
+		t.Fatalf("unexpected marker: %s", s)
+	}
+}
+
+// Ensure that a range of blocks can be sliced from a template.
+func TestTemplate_Slice(t *testing.T) {
+	tmpl := &ego.Template{
+		Path: "foo.ego",
+		Blocks: []ego.Block{
+			&ego.TextBlock{Content: "<p>", Pos: ego.Pos{Path: "foo.ego", LineNo: 1}},
+			&ego.ComponentStartBlock{Name: "Button", Pos: ego.Pos{Path: "foo.ego", LineNo: 2}},
+			&ego.TextBlock{Content: "click", Pos: ego.Pos{Path: "foo.ego", LineNo: 2}},
+			&ego.ComponentEndBlock{Name: "Button", Pos: ego.Pos{Path: "foo.ego", LineNo: 3}},
+			&ego.TextBlock{Content: "</p>", Pos: ego.Pos{Path: "foo.ego", LineNo: 4}},
+		},
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		other, err := tmpl.Slice(1, 4)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(other.Blocks) != 3 || other.Blocks[0] != tmpl.Blocks[1] || other.Blocks[2] != tmpl.Blocks[3] {
+			t.Fatalf("unexpected blocks: %#v", other.Blocks)
+		} else if other.Path != "foo.ego" {
+			t.Fatalf("unexpected path: %s", other.Path)
+		} else if len(tmpl.Blocks) != 5 {
+			t.Fatal("original template modified")
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if other, err := tmpl.Slice(2, 2); err != nil {
+			t.Fatal(err)
+		} else if len(other.Blocks) != 0 {
+			t.Fatalf("unexpected blocks: %#v", other.Blocks)
+		}
+	})
+
+	t.Run("ErrOutOfRange", func(t *testing.T) {
+		if _, err := tmpl.Slice(3, 6); err == nil || err.Error() != `slice bounds out of range [3:6] with length 5` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("ErrSplitComponent", func(t *testing.T) {
+		if _, err := tmpl.Slice(0, 3); err == nil || err.Error() != `Slice [0:3] splits <ego:Button> from its end block at foo.ego:2` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := tmpl.Slice(2, 5); err == nil || err.Error() != `Slice [2:5] splits <ego:Button> from its end block at foo.ego:2` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
+
+// Ensure that an HTML method is generated for types with a Render method.
+func TestTemplate_Write_HTMLMethod(t *testing.T) {
+	const src = `<%
+package main
+
+type Bold struct {
+	Text string
+}
+
+func (b *Bold) Render(ctx context.Context, w io.Writer) {
+%><b><%= b.Text %></b><% } %>`
+
+	t.Run("Generate", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(src), "bold.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.HTMLMethod = true
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, "func (b *Bold) HTML(ctx context.Context) template.HTML {") {
+			t.Fatalf("expected HTML method: %s", s)
+		} else if !strings.Contains(s, `import "html/template"`) {
+			t.Fatalf("expected html/template import: %s", s)
+		}
+	})
+
+	t.Run("Embed", func(t *testing.T) {
+		out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"html/template"
+	"os"
+)
+
+func main() {
+	tmpl := template.Must(template.New("").Parse("<p>{{.}}</p>"))
+	tmpl.Execute(os.Stdout, (&Bold{Text: "a&b"}).HTML(context.Background()))
+}
+`, func(tmpl *ego.Template) { tmpl.HTMLMethod = true })
+
+		if out != "<p><b>a&amp;b</b></p>" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+}
+
+// Ensure that scope blocks are replaced with the scope of the enclosing type.
+func TestTemplate_Write_Scope(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(`<%
+package views
+
+func (b *Button) Render(ctx context.Context, w io.Writer) {
+%><button class="<%=scope %>-button"></button><% } %>`), "button.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s, want := buf.String(), `_, _ = io.WriteString(w, "`+ego.ScopeClass("views.Button")+`")`; !strings.Contains(s, want) {
+			t.Fatalf("expected %s in output: %s", want, s)
+		}
+	})
+
+	// Scopes are replaced after the helper methods are generated, which
+	// reparses the source and would otherwise restore the placeholders.
+	t.Run("HTMLMethod", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(`<%
+package views
+
+func (b *Button) Render(ctx context.Context, w io.Writer) {
+%><button class="<%=scope %>-button"></button><% } %>`), "button.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.HTMLMethod = true
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s, want := buf.String(), `_, _ = io.WriteString(w, "`+ego.ScopeClass("views.Button")+`")`; !strings.Contains(s, want) {
+			t.Fatalf("expected %s in output: %s", want, s)
+		}
+	})
+
+	t.Run("ErrOutsideMethod", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<% package views\n\nfunc Render(ctx context.Context, w io.Writer) { %><%=scope %><% } %>"), "button.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || err.Error() != `Scope directive used outside of a component method at button.ego:3` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
+
+// Ensure that scope classes are stable.
+func TestScopeClass(t *testing.T) {
+	if s := ego.ScopeClass("views.Button"); s != ego.ScopeClass("views.Button") {
+		t.Fatal("expected stable scope")
+	} else if len(s) != 12 || !strings.HasPrefix(s, "ego-") {
+		t.Fatalf("unexpected scope: %s", s)
+	} else if s == ego.ScopeClass("views.Card") {
+		t.Fatal("expected distinct scopes")
+	}
+}
+
+// Ensure that sanitize blocks call the template's sanitizer.
+func TestTemplate_Write_Sanitize(t *testing.T) {
+	tmpl := &ego.Template{
+		Blocks: []ego.Block{
+			&ego.CodeBlock{Content: "package foo"},
+			&ego.CodeBlock{Content: "func doSomething() {"},
+			&ego.SanitizeBlock{Content: "post.Body"},
+			&ego.CodeBlock{Content: "}"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); !strings.Contains(s, `_, _ = fmt.Fprint(w, sanitize(ctx, post.Body))`) {
+		t.Fatalf("unexpected output: %s", s)
+	}
+
+	tmpl.Sanitizer = "policy.Sanitize"
+	buf.Reset()
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); !strings.Contains(s, `_, _ = fmt.Fprint(w, policy.Sanitize(ctx, post.Body))`) {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure that long text blocks are split into multiple string literals.
+func TestTemplate_Write_MaxLiteralLen(t *testing.T) {
+	text := "<p>line one</p>\n<p>line two</p>\n" + strings.Repeat("é", 20) + "\n"
+	src := "<% package main\n\nfunc render(w io.Writer) { %>" + text + "<% } %>"
+
+	t.Run("Generate", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.MaxLiteralLen = 20
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, `_, _ = io.WriteString(w, "<p>line one</p>\n"+`+"\n\t\t"+`"<p>line two</p>\n"+`) {
+			t.Fatalf("unexpected output: %s", s)
+		}
+	})
+
+	t.Run("Output", func(t *testing.T) {
+		out := runTemplate(t, src, `package main
+
+import "os"
+
+func main() { render(os.Stdout) }
+`, func(tmpl *ego.Template) { tmpl.MaxLiteralLen = 7 })
+
+		if out != text {
+			t.Fatalf("unexpected output: %q", out)
+		}
+	})
+}
+
+// Ensure that large text blocks are written from package-level variables.
+func TestTemplate_Write_HoistTextLen(t *testing.T) {
+	src := "<% package main\n\nfunc render(w io.Writer, name string) { %><html><body><%= name %>!<%= name %></body></html><% } %>"
+
+	t.Run("Generate", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(src), "views/my-page.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.HoistTextLen = 10
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, "\t_, _ = w.Write(egoText_my_page_82fb18d6_0)\n") ||
+			!strings.Contains(s, "_, _ = io.WriteString(w, \"!\")\n") ||
+			!strings.Contains(s, "var egoText_my_page_82fb18d6_1 = []byte(\"</body></html>\")\n") {
+			t.Fatalf("unexpected output: %s", s)
+		}
+
+		// Names differ for templates whose names only differ by punctuation.
+		buf.Reset()
+		tmpl.Path = "views/my_page.ego"
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, "var egoText_my_page_0e06ab40_0 = ") {
+			t.Fatalf("unexpected output: %s", s)
+		}
+	})
+
+	t.Run("Output", func(t *testing.T) {
+		skipBefore(t, "go1.20")
+		out := runTemplate(t, src, `package main
+
+import "os"
+
+func main() {
+	render(os.Stdout, "<bob>")
+	render(os.Stdout, "eve")
+}
+`, func(tmpl *ego.Template) { tmpl.HoistTextLen = 10; tmpl.UnsafeBytes = true })
+
+		if out != "<html><body>&lt;bob&gt;!&lt;bob&gt;</body></html><html><body>eve!eve</body></html>" {
+			t.Fatalf("unexpected output: %q", out)
+		}
+	})
+}
+
+// Ensure that constant string attributes are not formatted at runtime.
+func TestTemplate_Write_ConstantAttrs(t *testing.T) {
+	tmpl := &ego.Template{
+		Blocks: []ego.Block{
+			&ego.CodeBlock{Content: "package foo"},
+			&ego.CodeBlock{Content: "func doSomething() {"},
+			&ego.ComponentStartBlock{
+				Package: "ego",
+				Name:    "Button",
+				Attrs: []*ego.Attr{
+					{Name: "class", Value: `"btn"`},
+					{Name: "title", Value: "`raw`"},
+					{Name: "id", Value: "id"},
+					{Name: "data-n", Value: "42"},
+					{Name: "label", Value: `"a" + name`},
+				},
+			},
+			&ego.CodeBlock{Content: "}"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s := buf.String()
+	for _, line := range []string{
+		`"class":  "btn",`,
+		"\"title\":  `raw`,",
+		`"id":     fmt.Sprint(id),`,
+		`"data-n": fmt.Sprint(42),`,
+		`"label":  fmt.Sprint("a" + name),`,
+	} {
+		if !strings.Contains(s, line) {
+			t.Fatalf("expected %s in output: %s", line, s)
+		}
+	}
+}
+
+// Ensure that a RenderCounted method reports the number of bytes written.
+func TestTemplate_Write_CountedMethod(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Page struct {
+	Name string
+}
+
+func (p *Page) Render(ctx context.Context, w io.Writer) {
+%><h1>Hello, <%= p.Name %>!</h1><% } %>`, `package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+func main() {
+	var buf bytes.Buffer
+	n, err := (&Page{Name: "<bob>"}).RenderCounted(context.Background(), &buf)
+	fmt.Print(n, " ", err, " ", buf.Len())
+}
+`, func(tmpl *ego.Template) { tmpl.CountedMethod = true })
+
+	if out != "28 <nil> 28" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that panics in code blocks are recovered and converted into errors
+// or fallback output, and that Render methods without an error result panic
+// again with the handler's error.
+func TestTemplate_Write_RecoverPanics(t *testing.T) {
+	const src = `<%
+package main
+
+type Page struct {
+	Items []string
+}
+
+func (r *Page) Render(ctx context.Context, w io.Writer) error {
+%><ul><% for i := 0; i < 3; i++ { %><li><%= r.Items[i] %></li><% } %></ul><%
+	return nil
+}
+
+type Card struct{}
+
+func (r Card) Render(ctx context.Context, w io.Writer) {
+	var m map[string]int
+%>[<% m["x"] = 1 %>]<% } %>`
+
+	t.Run("Error", func(t *testing.T) {
+		out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/benbjohnson/ego"
+)
+
+func main() {
+	err := (&Page{Items: []string{"a"}}).Render(context.Background(), os.Stdout)
+	fmt.Println()
+	fmt.Println(err)
+	fmt.Println(strings.Contains(string(err.(*ego.PanicError).Stack), "tmpl.ego:9"))
+
+	fmt.Println((&Page{Items: []string{"a", "b", "c"}}).Render(context.Background(), os.Stdout))
+	defer func() { fmt.Print("] ", recover().(*ego.PanicError).Value) }()
+	Card{}.Render(context.Background(), os.Stdout)
+}
+`, func(tmpl *ego.Template) { tmpl.RecoverPanics = true })
+
+		if out != "<ul><li>a</li><li>\n"+
+			"ego: panic: runtime error: index out of range [1] with length 1\n"+
+			"true\n"+
+			"<ul><li>a</li><li>b</li><li>c</li></ul><nil>\n"+
+			"[] assignment to entry in nil map" {
+			t.Fatalf("unexpected output: %q", out)
+		}
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		out := runTemplate(t, src+`<%
+func fallback(ctx context.Context, w io.Writer, v interface{}) error {
+	io.WriteString(w, "<!-- error -->")
+	return fmt.Errorf("recovered: %v", v)
+} %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println((&Page{}).Render(context.Background(), os.Stdout))
+	defer func() { fmt.Print(" ", recover()) }()
+	Card{}.Render(context.Background(), os.Stdout)
+}
+`, func(tmpl *ego.Template) {
+			tmpl.RecoverPanics = true
+			tmpl.PanicHandler = "fallback"
+		})
+
+		if out != "<ul><li><!-- error -->recovered: runtime error: index out of range [0] with length 0\n"+
+			"[<!-- error --> recovered: assignment to entry in nil map" {
+			t.Fatalf("unexpected output: %q", out)
+		}
+	})
+}
+
+// Ensure that attribute blocks can declare parameters that are passed by the
+// component, with types inferred from the component's fields.
+func TestTemplate_Write_AttrBlockParams(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type RowData struct {
+	Name  string
+	Price int
+}
+
+type Table struct {
+	Rows   []RowData
+	Header func()
+	Row    func(i int, row RowData)
+}
+
+func (r *Table) Render(ctx context.Context, w io.Writer) {
+%><table><% r.Header() %><% for i, row := range r.Rows { r.Row(i, row) } %></table><% }
+
+func Render(ctx context.Context, w io.Writer, rows []RowData) {
+%><ego:Table Rows=rows>
+	<ego::Header><tr><th>Name</th></tr></ego::Header>
+	<ego::Row i row=RowData><tr><td><%= i %>. <%= row.Name %></td><td><%= row.Price %></td></tr></ego::Row>
+</ego:Table><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() {
+	Render(context.Background(), os.Stdout, []RowData{{"apple", 2}, {"pear", 3}})
+}
+`, nil)
+
+	if exp := `<table><tr><th>Name</th></tr><tr><td>0. apple</td><td>2</td></tr><tr><td>1. pear</td><td>3</td></tr></table>`; out != exp {
+		t.Fatalf("unexpected output: %s", out)
+	}
+
+	t.Run("ErrNotInferred", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%><ui:Table><ui::Row row></ui::Row></ui:Table><% } %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err == nil || err.Error() != "Cannot infer type of attribute block parameter: row at tmpl.ego:5" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that Render methods copy the Attrs map so that concurrent renders of
+// the same component which modify their attributes do not race, and that other
+// fields are not copied. The race detector reports a data race without the copy.
+func TestTemplate_Write_CopyAttrs(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+import "strings"
+
+type Button struct {
+	Attrs map[string]string
+}
+
+func (r *Button) Render(ctx context.Context, w io.Writer) {
+	r.Attrs["class"] = strings.TrimSpace("btn " + r.Attrs["class"])
+%><button class="<%= r.Attrs["class"] %>"></button><% }
+
+type Link struct {
+	Attrs map[string]string
+}
+
+func (r Link) Render(ctx context.Context, w io.Writer) {
+	r.Attrs["rel"] = "noopener"
+%><a rel="<%= r.Attrs["rel"] %>"></a><% }
+
+type Badge struct {
+	Attrs    map[string]string
+	Rendered bool
+}
+
+func (r *Badge) Render(ctx context.Context, w io.Writer) {
+	r.Attrs["title"], r.Rendered = "new", true
+%><span title="<%= r.Attrs["title"] %>"></span><% } %>`, `package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+)
+
+func main() {
+	button := &Button{Attrs: map[string]string{"class": "primary"}}
+	link := Link{Attrs: map[string]string{}}
+
+	var wg sync.WaitGroup
+	outs := make([]string, 8)
+	for i := range outs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			button.Render(context.Background(), &buf)
+			link.Render(context.Background(), &buf)
+			outs[i] = buf.String()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, out := range outs[1:] {
+		if out != outs[0] {
+			panic("mismatch: " + out)
+		}
+	}
+
+	badge := &Badge{Attrs: map[string]string{}}
+	var buf bytes.Buffer
+	badge.Render(context.Background(), &buf)
+	fmt.Print(outs[0], buf.String(), " ", button.Attrs["class"], " ", len(link.Attrs), " ", len(badge.Attrs), " ", badge.Rendered)
+}
+`, func(tmpl *ego.Template) { tmpl.CopyAttrs = true }, "-race")
+
+	if out != `<button class="btn primary"></button><a rel="noopener"></a><span title="new"></span> primary 0 0 true` {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that a RenderTruncated method is generated that cuts output at a block boundary.
+func TestTemplate_Write_TruncateMethod(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Alert struct {
+	Items []string
+}
+
+func (r *Alert) Render(ctx context.Context, w io.Writer) {
+%>ALERT:<% for _, item := range r.Items { %> <%= item %>;<% } %><% } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	a := &Alert{Items: []string{"disk full", "cpu hot", "fan <off>"}}
+	for _, budget := range []int{100, 43, 42, 20, 9} {
+		truncated, err := a.RenderTruncated(context.Background(), os.Stdout, budget, "...")
+		fmt.Println("", truncated, err)
+	}
+}
+`, func(tmpl *ego.Template) { tmpl.TruncateMethod = true })
+
+	if out != "ALERT: disk full; cpu hot; fan &lt;off&gt;; false <nil>\n"+
+		"ALERT: disk full; cpu hot; fan &lt;off&gt;; false <nil>\n"+
+		"ALERT: disk full; cpu hot; ... true <nil>\n"+
+		"ALERT: disk full;... true <nil>\n"+
+		"ALERT:... true <nil>\n" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that String and WriteTo methods are generated for standard interfaces.
+func TestTemplate_Write_StdMethods(t *testing.T) {
+	const src = `<%
+package main
+
+type Page struct {
+	Name string
+}
+
+func (p Page) Render(ctx context.Context, w io.Writer) {
+%><h1><%= p.Name %></h1><% } %>`
+	const main = `package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+var _ fmt.Stringer = Page{}
+var _ io.WriterTo = Page{}
+
+func main() {
+	n, err := Page{Name: "a&b"}.WriteTo(os.Stdout)
+	fmt.Printf(" %d %v %s", n, err, Page{Name: "c"})
+}
+`
+
+	t.Run("OK", func(t *testing.T) {
+		out := runTemplate(t, src, main, func(tmpl *ego.Template) { tmpl.StdMethods = true })
+		if out != "<h1>a&amp;b</h1> 16 <nil> <h1>c</h1>" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("WithCountedMethod", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(src), "page.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.StdMethods, tmpl.CountedMethod = true, true
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, "return p.RenderCounted(context.Background(), w)") {
+			t.Fatalf("expected WriteTo to delegate to RenderCounted: %s", s)
+		} else if strings.Count(s, "ego.CountWriter") != 1 {
+			t.Fatalf("expected single counting implementation: %s", s)
+		}
+	})
+}
+
+// Ensure that trace calls are generated in block execution order.
+func TestTemplate_Write_Trace(t *testing.T) {
+	const src = `<%
+package main
+
+import "fmt"
+
+func egoTrace(ctx context.Context, block, pos string) { fmt.Printf("[%s %s]", block, pos) }
+
+type Item struct {
+	Yield func()
+}
+
+func (r *Item) Render(ctx context.Context, w io.Writer) {
+%><li><% r.Yield() %></li><% }
+
+func render(ctx context.Context, w io.Writer) {
+%><ul>
+<ego:Item><%= 1 %></ego:Item></ul><% } %>`
+
+	t.Run("Enabled", func(t *testing.T) {
+		out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, func(tmpl *ego.Template) { tmpl.Trace = true })
+
+		if exp := "[text tmpl.ego:16]<ul>\n[component tmpl.ego:17][text tmpl.ego:13]<li>[print tmpl.ego:17]1[text tmpl.ego:13]</li>[text tmpl.ego:17]</ul>"; out != exp {
+			t.Fatalf("unexpected output:\ngot: %s\nexp: %s", out, exp)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); strings.Contains(s, "egoTrace(ctx,") {
+			t.Fatalf("unexpected trace call: %s", s)
+		}
+	})
+}
+
+// Ensure that conditional attribute blocks are only assigned when true.
+func TestTemplate_Write_AttrBlockCond(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Card struct {
+	Footer func()
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) {
+%>[card<% if r.Footer != nil { %> <% r.Footer() %><% } %>]<% }
+
+func render(ctx context.Context, w io.Writer, show bool) {
+%><ego:Card><ego::Footer if=show>footer</ego::Footer></ego:Card><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() {
+	render(context.Background(), os.Stdout, true)
+	render(context.Background(), os.Stdout, false)
+}
+`, nil)
+
+	if out != "[card footer][card]" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that yield regions render default content when a component has no
+// body and that components always receive a Yield closure with DefaultYield.
+func TestTemplate_Write_Filters(t *testing.T) {
+	const src = `<%
+package main
+
+func render(ctx context.Context, w io.Writer, name string) {
+%><p><%= name | trim | upper | truncate(8) %></p><% } %>`
+
+	out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout, "  <b>alice</b> ") }
+`, func(tmpl *ego.Template) {
+		other, err := (&ego.Parser{Filters: ego.DefaultFilters}).Parse(strings.NewReader(src), tmpl.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.Blocks = other.Blocks
+	})
+	if out != "<p>&lt;B&gt;ALIC…</p>" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestTemplate_Write_Verbatim(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+func render(ctx context.Context, w io.Writer, name string) {
+%><p>Hello <%= name %>, print with <code><%%<%= x %>%%></code></p><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout, "<b>") }
+`, nil)
+	if out != "<p>Hello &lt;b&gt;, print with <code><%= x %></code></p>" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestTemplate_Write_Delims(t *testing.T) {
+	const src = `{{%
+package main
+
+func render(ctx context.Context, w io.Writer, name string) {
+%}}<?php echo "<%= $name %>"; ?> {{%= name %}}{{% } %}}`
+
+	out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout, "<b>") }
+`, func(tmpl *ego.Template) {
+		other, err := (&ego.Parser{Delims: [2]string{"{{%", "%}}"}}).Parse(strings.NewReader(src), tmpl.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.Blocks = other.Blocks
+	})
+	if out != `<?php echo "<%= $name %>"; ?> &lt;b&gt;` {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestTemplate_Write_JSONPrint(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		out := runTemplate(t, `<%
+package main
+
+func render(ctx context.Context, w io.Writer, v interface{}) {
+%><script>var state = <%=json v %>;</script><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() {
+	render(context.Background(), os.Stdout, map[string]string{"html": "</script><!--\u2028&"})
+}
+`, nil)
+		if out != `<script>var state = {"html":"\u003c/script\u003e\u003c!--\u2028\u0026"};</script>` {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("ReturnErrors", func(t *testing.T) {
+		out := runTemplate(t, `<%
+package main
+
+func render(ctx context.Context, w io.Writer, v interface{}) error {
+%><script>var state = <%=json v %>;</script><% return nil } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	err := render(context.Background(), os.Stdout, make(chan int))
+	fmt.Print("|", err)
+}
+`, func(tmpl *ego.Template) { tmpl.ReturnErrors = true })
+		if out != "<script>var state = |json: unsupported type: chan int" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("Attr", func(t *testing.T) {
+		src := `<%
+package main
+
+func render(ctx context.Context, w io.Writer, v interface{}) error {
+%><div data-state="<%=json v %>" data-raw=<%=json v %>></div><% return nil } %>`
+		main := `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() {
+	render(context.Background(), os.Stdout, map[string]string{"a": "b c'"})
+}
+`
+		const exp = `<div data-state="{&#34;a&#34;:&#34;b c&#39;&#34;}" data-raw={&#34;a&#34;:&#34;b&#32;c&#39;&#34;}></div>`
+		if out := runTemplate(t, src, main, nil); out != exp {
+			t.Fatalf("unexpected output: %s", out)
+		} else if out := runTemplate(t, src, main, func(tmpl *ego.Template) { tmpl.ReturnErrors = true }); out != exp {
+			t.Fatalf("unexpected output with errors: %s", out)
+		}
+	})
+}
+
+func TestTemplate_Write_URLPrint(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+func render(ctx context.Context, w io.Writer, name, q string) {
+%><a href="/users/<%=url:path name %>?q=<%=url q %>">x</a><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout, "a&b/c d", "x&y=1 <z>") }
+`, nil)
+	if out != `<a href="/users/a&amp;b%2Fc%20d?q=x%26y%3D1+%3Cz%3E">x</a>` {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestTemplate_Write_PlainText(t *testing.T) {
+	out := runTemplate(t, `<%@ mode text %>
+<%
+package main
+
+func render(ctx context.Context, w io.Writer, name, path string) {
+%>Hello, <%= name %>!
+See <%=url:path path %>
+<% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout, "Tom & \"Jerry\" <tj>", "a&b") }
+`, func(tmpl *ego.Template) { tmpl.ContextEscape = true })
+	if out != "Hello, Tom & \"Jerry\" <tj>!\nSee a&b\n" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestTemplate_Write_Translate(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+func render(ctx context.Context, w io.Writer, name string) {
+%><h1><%t "welcome", name %></h1><p><%t "missing" %></p><% } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/benbjohnson/ego"
+)
+
+func main() {
+	ctx := ego.WithTranslator(context.Background(), ego.TranslatorFunc(func(key string, args ...interface{}) string {
+		if key == "welcome" {
+			return fmt.Sprintf("Welcome, %s!", args...)
+		}
+		return key
+	}))
+	render(ctx, os.Stdout, "<Bob>")
+}
+`, nil)
+	if out != "<h1>Welcome, &lt;Bob&gt;!</h1><p>missing</p>" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that translations are escaped for their context like print blocks.
+func TestTemplate_Write_Translate_ContextEscape(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+func render(ctx context.Context, w io.Writer) {
+%><a href="<%t "link" %>" title=<%t "title" %>><%t "title" %></a><script>var s = "<%t "title" %>";</script><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/benbjohnson/ego"
+)
+
+func main() {
+	ctx := ego.WithTranslator(context.Background(), ego.TranslatorFunc(func(key string, args ...interface{}) string {
+		if key == "link" {
+			return "javascript:alert(1)"
+		}
+		return "a \"b\" </script>"
+	}))
+	render(ctx, os.Stdout)
+}
+`, func(tmpl *ego.Template) { tmpl.ContextEscape = true })
+	if exp := `<a href="about:invalid#ego" title=a&#32;&#34;b&#34;&#32;&lt;/script&gt;>a &#34;b&#34; &lt;/script&gt;</a><script>var s = "a \u0022b\u0022 \u003c\u002fscript\u003e";</script>`; out != exp {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestTemplate_Write_YieldRegion(t *testing.T) {
+	const src = `<%
+package main
+
+type Card struct {
+	Yield func()
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) {
+%>[<%yield r.Yield %>empty<%/yield %>]<% }
+
+type Icon struct {
+	Name string
+}
+
+func (r *Icon) Render(ctx context.Context, w io.Writer) {
+%>{<%= r.Name %>}<% }
+
+func render(ctx context.Context, w io.Writer) {
+%><ego:Card>body</ego:Card><ego:Card/><ego:Card></ego:Card><ego:Icon Name="a"></ego:Icon><% } %>`
+
+	t.Run("OK", func(t *testing.T) {
+		out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, nil)
+		if out != "[body][empty][empty]{a}" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("ReturnErrors", func(t *testing.T) {
+		src := strings.NewReplacer("Yield func()", "Yield func() error", "w io.Writer) {", "w io.Writer) error {", "<% }", "<% return nil }").Replace(src)
+		out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, func(tmpl *ego.Template) { tmpl.ReturnErrors = true })
+		if out != "[body][empty][empty]{a}" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("DefaultYield", func(t *testing.T) {
+		out := runTemplate(t, `<%
+package main
+
+type Panel struct {
+	Yield func()
+}
+
+func (r *Panel) Render(ctx context.Context, w io.Writer) {
+%>(<% r.Yield() %>)<% }
+
+func render(ctx context.Context, w io.Writer) {
+%><ego:Panel>body</ego:Panel><ego:Panel/><ego:Panel></ego:Panel><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, func(tmpl *ego.Template) { tmpl.DefaultYield = true })
+		if out != "(body)()()" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("ErrUnclosed", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader("<%yield r.Yield %>x"), "tmpl.ego"); err == nil || err.Error() != "Expected close of yield region, found EOF at tmpl.ego:1" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("CodeBlock", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<%yield := 1 %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if _, ok := tmpl.Blocks[0].(*ego.CodeBlock); !ok {
+			t.Fatalf("unexpected block: %T", tmpl.Blocks[0])
+		}
+	})
+}
+
+// Ensure that spread attributes are merged into a component's attributes
+// before explicit attributes.
+func TestTemplate_Write_SpreadAttrs(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+import "github.com/benbjohnson/ego"
+
+type Button struct {
+	Attrs map[string]string
+}
+
+func (r *Button) Render(ctx context.Context, w io.Writer) {
+%>[button<% ego.WriteStringAttrs(w, r.Attrs) %>]<% }
+
+type Wrapper struct {
+	Attrs map[string]string
+}
+
+func (r *Wrapper) Render(ctx context.Context, w io.Writer) {
+%><ego:Button ...r.Attrs class="btn" /><% }
+
+func render(ctx context.Context, w io.Writer) {
+	extra := []ego.Attr{{Name: "id", Value: "x"}, {Name: "title", Value: "a"}}
+%><ego:Wrapper class="primary" title="b" /><ego:Button title="c" ...extra ...map[string]string{"id": "y"} /><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, nil)
+
+	if exp := `[button class="btn" title="b"][button id="y" title="c"]`; out != exp {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that a spread operator without an expression is an error.
+func TestTemplate_Write_SpreadAttrs_ErrNoExpr(t *testing.T) {
+	if _, err := ego.Parse(strings.NewReader(`<ego:Button ... />`), "tmpl.ego"); err == nil || err.Error() != "Expected expression after spread operator at tmpl.ego:1" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that component loops check for cancellation and flush periodically.
+func TestTemplate_Write_ComponentLoop(t *testing.T) {
+	const src = `<%
+package main
+
+type Item struct {
+	Name string
+}
+
+func (r *Item) Render(ctx context.Context, w io.Writer) {
+%><li><%= r.Name %></li><% }
+
+func render(ctx context.Context, w io.Writer, items []string) {
+%><ego:Item for=(_, item := range items) Name=item /><% } %>`
+	const main = `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+type flushWriter struct {
+	cancel func()
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+
+func (w *flushWriter) Flush() {
+	fmt.Print("|")
+	if w.cancel != nil {
+		w.cancel()
+	}
+}
+
+func main() {
+	items := []string{"a", "b", "c", "d", "e"}
+	render(context.Background(), &flushWriter{}, items)
+	fmt.Println()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	render(ctx, &flushWriter{cancel: cancel}, items)
+}
+`
+
+	t.Run("OK", func(t *testing.T) {
+		out := runTemplate(t, src, main, nil)
+		if exp := "<li>a</li><li>b</li><li>c</li><li>d</li><li>e</li>\n<li>a</li><li>b</li><li>c</li><li>d</li><li>e</li>"; out != exp {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("FlushInterval", func(t *testing.T) {
+		out := runTemplate(t, src, main, func(tmpl *ego.Template) { tmpl.FlushInterval = 2 })
+		if exp := "<li>a</li><li>b</li>|<li>c</li><li>d</li>|<li>e</li>\n<li>a</li><li>b</li>|<li>c</li><li>d</li>"; out != exp {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+}
+
+// Ensure that a build directive sets the build constraint of the generated file.
+func TestTemplate_Write_BuildBlock(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<%build linux,!windows %>\n<% package foo %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); !strings.HasPrefix(s, "//go:build linux && !windows\n// +build linux,!windows\n\n// Generated by ego.\n") {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure that print blocks write through a fast writer, if enabled.
+func TestTemplate_Write_FastWriter(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+func render(ctx context.Context, w io.Writer) {
+%><p><%= "<b>" %> <%= 42 %> <%= 1.5 %> <%= float32(0.1) %></p><% } %>`, `package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/benbjohnson/ego"
+)
+
+func main() {
+	var buf ego.Buffer
+	render(context.Background(), &buf)
+	fmt.Println(buf.String())
+
+	var std bytes.Buffer
+	render(context.Background(), &std)
+	fmt.Print(std.String())
+}
+`, func(tmpl *ego.Template) { tmpl.FastWriter = true })
+
+	if out != "<p>&lt;b&gt; 42 1.5 0.1</p>\n<p>&lt;b&gt; 42 1.5 0.1</p>" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that generated declarations are ordered independently of unrelated blocks.
+func TestTemplate_Write_HoistedOrder(t *testing.T) {
+	generate := func(src string) string {
+		tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.HTMLMethod, tmpl.StdMethods, tmpl.CountedMethod, tmpl.FastWriter = true, true, true, true
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	// Type declarations are reordered but Render methods are not.
+	a := generate("<% package foo\n\ntype A struct{}\ntype B struct{}\n\nfunc (a *A) Render(ctx context.Context, w io.Writer) { %><%= 1 %><% }\n\nfunc (b *B) Render(ctx context.Context, w io.Writer) { %>b<% } %>")
+	b := generate("<% package foo\n\ntype B struct{}\n\ntype A struct{}\n\nfunc (a *A) Render(ctx context.Context, w io.Writer) { %><%= 1 %><% }\n\nfunc (b *B) Render(ctx context.Context, w io.Writer) { %>b<% } %>")
+
+	for _, s := range []string{a, b} {
+		var prev int
+		for _, sig := range []string{
+			`import "bytes"`,
+			`import "github.com/benbjohnson/ego"`,
+			`import "html/template"`,
+			"func (a *A) HTML(",
+			"func (a *A) RenderCounted(",
+			"func (a *A) WriteTo(",
+			"func (a *A) String(",
+			"func (b *B) HTML(",
+			"func (b *B) RenderCounted(",
+			"func (b *B) WriteTo(",
+			"func (b *B) String(",
+		} {
+			i := strings.Index(s, sig)
+			if i == -1 || i < prev {
+				t.Fatalf("unexpected position of %s: %s", sig, s)
+			}
+			prev = i
+		}
+	}
+
+	// Hoisted declarations are identical.
+	hoisted := func(s string) string { return s[strings.Index(s, "// HTML renders A"):] }
+	if hoisted(a) != hoisted(b) {
+		t.Fatalf("hoisted declarations differ:\n%s\n\n%s", hoisted(a), hoisted(b))
+	}
+}
+
+// Ensure that ctx directives are moved to the start of their function.
+func TestTemplate_Write_Ctx(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		out := runTemplate(t, `<%
+package main
+
+type key struct{}
+
+type Item struct {
+	Yield func()
+}
+
+func (r *Item) Render(ctx context.Context, w io.Writer) {
+%><li><% r.Yield() %></li><% }
+
+func render(ctx context.Context, w io.Writer) {
+%><ul><ego:Item><%ctx user := ctx.Value(key{}).(string) %><%= user %></ego:Item></ul>
+<%ctx n := len(user) %><%= n %><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.WithValue(context.Background(), key{}, "bob"), os.Stdout) }
+`, nil)
+
+		if out != "<ul><li>bob</li></ul>\n3" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("ErrOutsideFunc", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<% package foo %><%ctx var x = 1 %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || err.Error() != "Ctx directive used outside of a function at tmpl.ego:1" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that RenderInto does not allocate when a buffer is reused.
+func TestTemplate_Write_IntoMethod(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Page struct {
+	Title string
+}
+
+func (p *Page) Render(ctx context.Context, w io.Writer) {
+%><html><head><title><% io.WriteString(w, p.Title) %></title></head><body>Hello, world!</body></html><% } %>`, `package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func main() {
+	ctx, page := context.Background(), &Page{Title: "Home"}
+
+	var buf bytes.Buffer
+	page.RenderInto(ctx, &buf)
+	fmt.Println(buf.String())
+
+	fmt.Print(testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		page.RenderInto(ctx, &buf)
+	}))
+}
+`, func(tmpl *ego.Template) { tmpl.IntoMethod = true })
+
+	if out != "<html><head><title>Home</title></head><body>Hello, world!</body></html>\n0" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that RenderString & RenderBytes return the output of a render and
+// the error of a component that returns errors.
+func TestTemplate_Write_StringMethods(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+import "errors"
+
+type Page struct {
+	Title string
+}
+
+func (p *Page) Render(ctx context.Context, w io.Writer) error {
+	if p.Title == "" {
+		return errors.New("missing title")
+	}
+%><h1><%= p.Title %></h1><% return nil } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func main() {
+	ctx := context.Background()
+	s, err := (&Page{Title: "Home"}).RenderString(ctx)
+	fmt.Println(s, err)
+	b, err := (&Page{Title: "<About>"}).RenderBytes(ctx)
+	fmt.Println(string(b), err)
+	s, err = (&Page{}).RenderString(ctx)
+	fmt.Printf("%q %v", s, err)
+}
+`, func(tmpl *ego.Template) { tmpl.StringMethods = true })
+
+	if out != "<h1>Home</h1> <nil>\n<h1>&lt;About&gt;</h1> <nil>\n\"\" missing title" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that pooled renders write their output once and that nested
+// components render into the buffer of their parent.
+func TestTemplate_Write_Pool(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Item struct {
+	Name string
+}
+
+func (r *Item) Render(ctx context.Context, w io.Writer) {
+%><li><%= r.Name %></li><% } %><%
+type List struct {
+	Names []string
+}
+
+func (r *List) Render(ctx context.Context, w io.Writer) error {
+%><ul><% for _, name := range r.Names { %><ego:Item Name=name /><% } %></ul><% return nil } %>`, `package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type writer struct {
+	calls int
+	err   error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.calls++
+	fmt.Printf("%s\n", p)
+	return len(p), w.err
+}
+
+func main() {
+	w := &writer{}
+	fmt.Println((&List{Names: []string{"a", "<b>"}}).Render(context.Background(), w), w.calls)
+	(&Item{Name: "c"}).Render(context.Background(), w)
+	fmt.Print((&List{}).Render(context.Background(), &writer{err: errors.New("marker")}))
+}
+`, func(tmpl *ego.Template) { tmpl.Pool = true })
+
+	if out != "<ul><li>a</li><li>&lt;b&gt;</li></ul>\n<nil> 1\n<li>c</li>\n<ul></ul>\nmarker" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that components can be streamed through a ring buffer that is much
+// smaller than their output.
+func TestTemplate_Write_BoundedMethod(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+import "errors"
+
+type Report struct {
+	N int
+}
+
+func (r *Report) Render(ctx context.Context, w io.Writer) {
+	for i := 0; i < r.N; i++ {
+%><row><%= i %></row><% } } %><%
+
+type Broken struct{}
+
+func (r *Broken) Render(ctx context.Context, w io.Writer) error {
+%>partial<% return errors.New("marker") } %>`, `package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/benbjohnson/ego"
+)
+
+func main() {
+	ctx := context.Background()
+
+	rb := ego.NewRingBuffer(16)
+	go (&Report{N: 10000}).RenderBounded(ctx, rb)
+	h := sha256.New()
+	n, err := io.Copy(h, rb)
+	fmt.Println(n, err)
+
+	var want ego.Buffer
+	(&Report{N: 10000}).Render(ctx, &want)
+	fmt.Println(fmt.Sprintf("%x", h.Sum(nil)) == fmt.Sprintf("%x", sha256.Sum256(want.Bytes())))
+
+	rb = ego.NewRingBuffer(16)
+	errc := make(chan error)
+	go func() { errc <- (&Broken{}).RenderBounded(ctx, rb) }()
+	buf, err := ioutil.ReadAll(rb)
+	fmt.Print(string(buf), " ", err, " ", <-errc)
+}
+`, func(tmpl *ego.Template) {
+		tmpl.BoundedMethod = true
+		tmpl.ReturnErrors = true
+	})
+
+	if out != "148890 <nil>\ntrue\npartial marker marker" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that test helpers compare rendered output and report a diff.
+func TestTemplate_WriteTestHelpersTo(t *testing.T) {
+	// The failing test is expected so the error of the command is ignored.
+	out, _ := goTemplate(t, `<%
+package card
+
+type Card struct {
+	Title string
+}
+
+func (t *Card) Render(ctx context.Context, w io.Writer) {
+%><div><%= t.Title %></div>
+<% }
+
+type Badge struct{}
+
+func (buf *Badge) Render(ctx context.Context, w io.Writer) {
+%>badge<% }
+
+type Tag struct{}
+
+func (ctx *Tag) Render(c context.Context, w io.Writer) {
+%>tag<% }
+
+type Label struct{}
+
+func (want *Label) Render(ctx context.Context, w io.Writer) error {
+%>label<% return nil } %>`, nil, map[string]string{
+		"card_test.go": `package card
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPass(t *testing.T) {
+	(&Card{Title: "<b>"}).AssertRender(t, context.Background(), "<div>&lt;b&gt;</div>\n")
+	(&Badge{}).AssertRender(t, context.Background(), "badge")
+	(&Tag{}).AssertRender(t, context.Background(), "tag")
+	(&Label{}).AssertRender(t, context.Background(), "label")
+}
+
+func TestFail(t *testing.T) {
+	(&Card{Title: "Hello"}).AssertRender(t, context.Background(), "<div>Hello!</div>\n")
+}
+`,
+	}, "test", "-v", ".")
+
+	if !strings.Contains(out, "--- PASS: TestPass") {
+		t.Fatalf("expected pass:\n%s", out)
+	} else if !strings.Contains(out, "card_test.go:16: Card: unexpected output:\n") ||
+		!strings.Contains(out, `-"<div>Hello!</div>\n"`) ||
+		!strings.Contains(out, `+"<div>Hello</div>\n"`) {
+		t.Fatalf("expected diff:\n%s", out)
+	}
+}
+
+// Ensure that components with a feature flag are only rendered when enabled.
+func TestTemplate_Write_ComponentFlag(t *testing.T) {
+	const src = `<%
+package main
+
+type Nav struct {
+	Name string
+}
+
+func (r *Nav) Render(ctx context.Context, w io.Writer) {
+%>[<%= r.Name %>]<% }
+
+func render(ctx context.Context, w io.Writer) {
+%><ego:Nav flag="new-nav" Name="new" /><ego:Nav flag="old-nav" Name="old" /><% } %>`
+
+	t.Run("Default", func(t *testing.T) {
+		out := runTemplate(t, src+`<%
+func featureEnabled(ctx context.Context, flag string) bool { return flag == "new-nav" }
+%>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, nil)
+		if out != "[new]" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("FeatureFunc", func(t *testing.T) {
+		out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func isEnabled(ctx context.Context, flag string) bool { return flag == "old-nav" }
+
+func main() { render(context.Background(), os.Stdout) }
+`, func(tmpl *ego.Template) { tmpl.FeatureFunc = "isEnabled" })
+		if out != "[old]" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+}
+
+// Ensure that templates without content generate a minimal file.
+func TestTemplate_Write_Empty(t *testing.T) {
+	for _, src := range []string{"", " \n\t\n", "<%build linux %>\n\n"} {
+		tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || err.Error() != "Package name required for empty template: tmpl.ego" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		tmpl.Package = "foo"
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.HasSuffix(s, "// Generated by ego.\n// DO NOT EDIT\n\npackage foo\n") {
+			t.Fatalf("unexpected output: %q", s)
+		} else if strings.Contains(s, "import") || strings.Contains(s, "var _") {
+			t.Fatalf("unexpected imports or sentinels: %q", s)
+		}
+	}
+}
+
+// Ensure that a container can render a slice of child components.
+func TestTemplate_Write_Renderers(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+import "github.com/benbjohnson/ego"
+
+type List struct {
+	Children ego.Renderers
+}
+
+func (r *List) Render(ctx context.Context, w io.Writer) {
+%><ul><% for _, c := range r.Children { %><li><% c.Render(ctx, w) %></li><% } %></ul><% }
+
+type Item struct {
+	Name string
+}
+
+func (r *Item) Render(ctx context.Context, w io.Writer) {
+%><%= r.Name %><% }
+
+func render(ctx context.Context, w io.Writer) {
+%><ego:List Children=ego.Renderers{&Item{Name: "a"}, &List{}, &Item{Name: "b"}} /><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, nil)
+
+	if out != "<ul><li>a</li><li><ul></ul></li><li>b</li></ul>" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that //line paths are written relative to the trimmed directory.
+func TestTemplate_Write_TrimPath(t *testing.T) {
+	root, err := filepath.Abs(filepath.Join("testdata", "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "views", "tmpl.ego")
+	tmpl, err := ego.Parse(strings.NewReader("<%\npackage foo\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>hi<% } %>"), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tmpl.TrimPath, tmpl.Trace = root, true
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); !strings.Contains(s, "//line views/tmpl.ego:5\n") || !strings.Contains(s, `egoTrace(ctx, "text", "views/tmpl.ego:5")`) || strings.Contains(s, root) {
+		t.Fatalf("unexpected output: %s", s)
+	}
+
+	// Paths outside of the directory are unchanged.
+	buf.Reset()
+	tmpl.TrimPath = filepath.Join(root, "other")
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if s := buf.String(); !strings.Contains(s, "//line "+path+":5\n") {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure that line directives can be removed or written as plain comments.
+func TestTemplate_Write_LineDirectives(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<%\npackage foo\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>hi<% x := `a\nb` %><%= x %><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Off", func(t *testing.T) {
+		var buf bytes.Buffer
+		tmpl.LineDirectives = ego.LineDirectivesOff
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); strings.Contains(s, "tmpl.ego") {
+			t.Fatalf("unexpected output: %s", s)
+		}
+	})
+
+	t.Run("Comment", func(t *testing.T) {
+		var buf bytes.Buffer
+		tmpl.LineDirectives = ego.LineDirectivesComment
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		s := buf.String()
+		if strings.Contains(s, "//line") {
+			t.Fatalf("unexpected line directive: %s", s)
+		} else if !strings.Contains(s, `_, _ = io.WriteString(w, "hi") // tmpl.ego:5`+"\n") {
+			t.Fatalf("expected trailing comment: %s", s)
+		} else if !strings.Contains(s, "\t// tmpl.ego:5\n\tx := `a\nb`\n") {
+			t.Fatalf("expected comment before raw string: %s", s)
+		}
+	})
+
+	t.Run("RawString", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<%\npackage foo\n\nfunc Render(ctx context.Context, w io.Writer) {\n%><% x := `a\n//line b.go:1\nc` %><%= x %><% } %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		tmpl.LineDirectives = ego.LineDirectivesOff
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, "x := `a\n//line b.go:1\nc`\n") || strings.Contains(s, "tmpl.ego") {
+			t.Fatalf("unexpected output: %s", s)
+		}
+	})
+}
+
+// Ensure that text line endings can be converted.
+func TestTemplate_Write_LineEnding(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		lineEnding ego.LineEnding
+		exp        string
+	}{
+		{name: "Preserve", lineEnding: ego.LineEndingPreserve, exp: `"a\r\nb\nc"`},
+		{name: "LF", lineEnding: ego.LineEndingLF, exp: `"a\nb\nc"`},
+		{name: "CRLF", lineEnding: ego.LineEndingCRLF, exp: `"a\r\nb\r\nc"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := &ego.Template{
+				LineEnding: tt.lineEnding,
+				Blocks: []ego.Block{
+					&ego.CodeBlock{Content: "package foo"},
+					&ego.CodeBlock{Content: "func doSomething() {"},
+					&ego.TextBlock{Content: "a\r\nb\nc"},
+					&ego.CodeBlock{Content: "}"},
+				},
+			}
+
+			var buf bytes.Buffer
+			if _, err := tmpl.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			} else if s := buf.String(); !strings.Contains(s, "_, _ = io.WriteString(w, "+tt.exp+")") {
+				t.Fatalf("unexpected output: %s", s)
+			}
+		})
+	}
+}
+
+// Ensure that components can return errors and skip rendering with ErrSkip.
+func TestTemplate_Write_ReturnErrors(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+import (
+	"errors"
+
+	"github.com/benbjohnson/ego"
+)
+
+type Box struct {
+	Yield func() error
+}
+
+func (r *Box) Render(ctx context.Context, w io.Writer) error {
+%>[<% if err := r.Yield(); err != nil { return err } %>]<% return nil }
+
+type Item struct {
+	Name string
+	Skip bool
+	Fail bool
+}
+
+func (r *Item) Render(ctx context.Context, w io.Writer) error {
+%><i><% if r.Skip { return ego.ErrSkip } else if r.Fail { return errors.New("marker") } %><%= r.Name %></i><% return nil }
+
+func render(ctx context.Context, w io.Writer, fail bool) error {
+%><ego:Box><ego:Item Name="a" /><ego:Item Name="b" Skip=true /><ego:Item Name="c" /></ego:Box><ego:Box><ego:Item Name="d" /><% if true { return ego.ErrSkip } %><ego:Item Name="e" /></ego:Box><ego:Box><ego:Item Name="f" Fail=fail /></ego:Box>!<% return nil } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(render(context.Background(), os.Stdout, false))
+	fmt.Println(render(context.Background(), os.Stdout, true))
+	var item Item
+	fmt.Println(item.RenderCounted(context.Background(), os.Stdout))
+	item.Skip = true
+	fmt.Println(item.RenderCounted(context.Background(), os.Stdout))
+}
+`, func(tmpl *ego.Template) {
+		tmpl.ReturnErrors = true
+		tmpl.CountedMethod = true
+	})
+
+	if exp := "[<i>a</i><i><i>c</i>][<i>d</i>[<i>f</i>]!<nil>\n" +
+		"[<i>a</i><i><i>c</i>][<i>d</i>[<i>marker\n" +
+		"<i></i>7 <nil>\n" +
+		"<i>3 <nil>\n"; out != exp {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that accessor methods are generated for known component attributes.
+func TestTemplate_Write_KnownAttrs(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		out := runTemplate(t, `<%
+package main
+
+type Button struct {
+	Attrs map[string]string `+"`"+`ego:"title, id,class"`+"`"+`
+}
+
+func (r *Button) Render(ctx context.Context, w io.Writer) {
+%><button<% r.RenderAttrs(w) %>><%= r.Attr("title") %></button><% }
+
+func render(ctx context.Context, w io.Writer) {
+%><ego:Button title="<Go>" class="btn" data-x="1" /><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, nil)
+
+		if out != `<button class="btn" title="&lt;Go&gt;">&lt;Go&gt;</button>` {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("ErrUnknownAttr", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(`<%
+package foo
+
+type Button struct {
+	Attrs map[string]string `+"`"+`ego:"id"`+"`"+`
+}
+
+func (r *Button) Render(ctx context.Context, w io.Writer) {
+%><%= r.Attr("titel") %><% } %>`), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || err.Error() != "Unknown attribute for Button: titel at tmpl.ego:9" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// An empty tag declares that there are no known attributes.
+	t.Run("ErrEmptyTag", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(`<%
+package foo
+
+type Button struct {
+	Attrs map[string]string `+"`"+`ego:""`+"`"+`
+}
+
+func (r *Button) Render(ctx context.Context, w io.Writer) {
+%><%= r.Attr("id") %><% } %>`), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || err.Error() != "Unknown attribute for Button: id at tmpl.ego:9" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that explicit attributes are merged into spread attributes using the
+// attribute's merge policy.
+func TestTemplate_Write_AttrMerge(t *testing.T) {
+	const src = `<%
+package main
+
+type Button struct {
+	Attrs map[string]string ` + "`" + `ego:"class,id,rel"` + "`" + `
+}
+
+func (r *Button) Render(ctx context.Context, w io.Writer) {
+%><button<% r.RenderAttrs(w) %>></button><% }
+
+type Card struct {
+	Attrs map[string]string
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) {
+%><ego:Button Attrs=r.Attrs class="btn" rel=r.Attrs["id"] /><% }
+
+func render(ctx context.Context, w io.Writer) {
+%><ego:Card id="x" class="primary" rel="nofollow" /><ego:Card /><% } %>`
+	const main = `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`
+
+	t.Run("Replace", func(t *testing.T) {
+		out := runTemplate(t, src, main, nil)
+		if out != `<button class="btn" id="x" rel="x"></button><button class="btn" rel=""></button>` {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("Append", func(t *testing.T) {
+		out := runTemplate(t, src, main, func(tmpl *ego.Template) {
+			tmpl.AttrMerge = map[string]ego.AttrMerge{"class": ego.AttrMergeAppend, "rel": ego.AttrMergeAppend, "id": ego.AttrMergeReplace}
+		})
+		if out != `<button class="primary btn" id="x" rel="nofollow x"></button><button class="btn" rel=""></button>` {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+}
+
+// Ensure that typed attributes keep the values of their expressions.
+func TestTemplate_Write_TypedAttrs(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Button struct {
+	Attrs map[string]interface{} `+"`"+`ego:"class,disabled,hidden,tabindex"`+"`"+`
+}
+
+func (r *Button) Render(ctx context.Context, w io.Writer) {
+	onClick, _ := r.Attrs["onclick"].(func() string)
+%><button<% r.RenderAttrs(w) %>><%= onClick() %> <%= r.Attr("tabindex").(int) + 1 %></button><% }
+
+type Card struct {
+	Attrs map[string]interface{}
+}
+
+func (r Card) Render(ctx context.Context, w io.Writer) {
+	r.Attrs["hidden"] = false
+%><ego:Button Attrs=r.Attrs class="btn" tabindex=2 /><% }
+
+func render(ctx context.Context, w io.Writer) {
+	card := Card{Attrs: map[string]interface{}{"class": "primary"}}
+	card.Attrs["onclick"] = func() string { return "click" }
+%><ego:Button disabled hidden=false tabindex=1 onclick=card.Attrs["onclick"] /><% card.Render(ctx, w) %><%= card.Attrs["hidden"] == nil %><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout) }
+`, func(tmpl *ego.Template) {
+		tmpl.TypedAttrs = true
+		tmpl.CopyAttrs = true
+		tmpl.AttrMerge = map[string]ego.AttrMerge{"class": ego.AttrMergeAppend}
+	})
+	if out != `<button disabled tabindex="1">click 2</button><button class="primary btn" tabindex="2">click 3</button>true` {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that Render methods of components start & end tracing spans.
+func TestTemplate_Write_Tracing(t *testing.T) {
+	t.Run("Nested", func(t *testing.T) {
+		out := runTemplate(t, `<%
+package main
+
+type Box struct {
+	Yield func()
+}
+
+func (r *Box) Render(ctx context.Context, w io.Writer) {
+%>[<% r.Yield() %>]<% }
+
+type Item struct{}
+
+func (r *Item) Render(ctx context.Context, w io.Writer) {
+%>item<% }
+
+type Page struct{}
+
+func (r *Page) Render(ctx context.Context, w io.Writer) {
+%><ego:Box><ego:Item /></ego:Box><% } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+type spanKey struct{}
+
+type testTracer struct{}
+
+func (testTracer) Start(ctx context.Context, name string) (context.Context, testSpan) {
+	parent, _ := ctx.Value(spanKey{}).(string)
+	fmt.Printf("(%s<%s", name, parent)
+	return context.WithValue(ctx, spanKey{}, name), testSpan{}
+}
+
+type testSpan struct{}
+
+func (testSpan) End() { fmt.Print(")") }
+
+func main() {
+	(&Page{}).Render(context.Background(), os.Stdout)
+	(&Item{}).Render(context.Background(), os.Stdout)
+}
+`, func(tmpl *ego.Template) {
+			tmpl.Tracing = true
+			tmpl.Tracer = "testTracer{}"
+		})
+
+		if out != "(ego:Page<(ego:Box<ego:Page[(ego:Item<ego:Pageitem)]))(ego:Item<item)" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("DefaultTracer", func(t *testing.T) {
+		tmpl := &ego.Template{
+			Tracing: true,
+			Blocks: []ego.Block{
+				&ego.CodeBlock{Content: "package foo"},
+				&ego.CodeBlock{Content: "type Button struct{}"},
+				&ego.CodeBlock{Content: "func (r *Button) Render(ctx context.Context, w io.Writer) {"},
+				&ego.TextBlock{Content: "<button></button>"},
+				&ego.CodeBlock{Content: "}"},
+			},
+		}
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, `"go.opentelemetry.io/otel"`) {
+			t.Fatalf("expected otel import: %s", s)
+		} else if !strings.Contains(s, `ctx, EGO_SPAN := otel.Tracer("github.com/benbjohnson/ego").Start(ctx, "ego:Button")`) {
+			t.Fatalf("expected span: %s", s)
+		}
+	})
+}
+
+// Ensure that output can be collected into regions and written out of order.
+func TestTemplate_Write_Regions(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Note struct {
+	N     int
+	Yield func()
+}
+
+func (r *Note) Render(ctx context.Context, w io.Writer) {
+%><li><%= r.N %>. <% r.Yield() %></li><% }
+
+func render(ctx context.Context, w io.Writer, notes []string) {
+%><p><% for i, note := range notes { %>[<%= i+1 %>]<%=append "notes" <ego:Note N=i+1><%= note %></ego:Note> %><% } %></p><ol><%=flush "notes" %></ol><%=flush "notes" %><% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() { render(context.Background(), os.Stdout, []string{"a", "<b>"}) }
+`, nil)
+
+	if out != "<p>[1][2]</p><ol><li>1. a</li><li>2. &lt;b&gt;</li></ol>" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that rendering stops at the first write error.
+func TestTemplate_Write_WriteErrors(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Item struct {
+	N int
+}
+
+func (r *Item) Render(ctx context.Context, w io.Writer) error {
+	rendered++
+%><li><%= r.N %></li><%
+	return nil
+}
+
+var rendered int
+
+func Render(ctx context.Context, w io.Writer) error {
+%><ul><% for i := 0; i < 100; i++ { %><ego:Item N=i /><% } %></ul><%
+	return nil
+} %>`, `package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type limitWriter struct {
+	n int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.n < len(p) {
+		return 0, errors.New("connection closed")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func main() {
+	err := Render(context.Background(), &limitWriter{n: 30})
+	fmt.Print(err, " ", rendered)
+}
+`, func(tmpl *ego.Template) { tmpl.WriteErrors = true })
+
+	if out != "connection closed 3" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that print blocks are escaped for their HTML context.
+func TestTemplate_Write_ContextEscape(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Card struct {
+	Yield func()
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) {
+%><div><% r.Yield() %></div><% }
+
+func Render(ctx context.Context, w io.Writer, v string) {
+%><p title="<%= v %>"><%= v %></p>
+<a href="<%= v %>">a</a><a href="/search?q=<%= v %>">b</a><a href=<%= v %>>c</a><a href="/users/<%= v %>">d</a>
+<input value=<%= v %>><div style="color: <%= v %>" onclick="f(<%= v %>, '<%= v %>')"></div>
+<script>var a = <%= v %>, b = "<%= v %>\"", c = '<%= v %>';</script>
+<style>p { color: <%= v %>; }</style>
+<ego:Card><a href="<%= v %>"></a></ego:Card>
+<!-- <script> --><%= v %>
+<% } %>`, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() {
+	Render(context.Background(), os.Stdout, "javascript:x('</script> a=b')")
+}
+`, func(tmpl *ego.Template) { tmpl.ContextEscape = true })
+
+	if exp := `<p title="javascript:x(&#39;&lt;/script&gt; a=b&#39;)">javascript:x(&#39;&lt;/script&gt; a=b&#39;)</p>
+<a href="about:invalid#ego">a</a><a href="/search?q=javascript%3Ax%28%27%3C%2Fscript%3E+a%3Db%27%29">b</a><a href=about:invalid#ego>c</a><a href="/users/javascript:x(&#39;%3C/script%3E%20a=b&#39;)">d</a>
+<input value=javascript:x(&#39;&lt;/script&gt;&#32;a&#61;b&#39;)><div style="color: javascript\3ax\28\27\3c\2fscript\3e  a=b\27\29" onclick="f(&#34;javascript:x(&#39;\u003c/script\u003e a=b&#39;)&#34;, 'javascript:x(\u0027\u003c\u002fscript\u003e a\u003db\u0027)')"></div>
+<script>var a = "javascript:x('\u003c/script\u003e a=b')", b = "javascript:x(\u0027\u003c\u002fscript\u003e a\u003db\u0027)\"", c = 'javascript:x(\u0027\u003c\u002fscript\u003e a\u003db\u0027)';</script>
+<style>p { color: javascript\3ax\28\27\3c\2fscript\3e  a=b\27\29; }</style>
+<div><a href="about:invalid#ego"></a></div>
+<!-- <script> -->javascript:x(&#39;&lt;/script&gt; a=b&#39;)
+`; out != exp {
+		t.Fatalf("unexpected output:\n%s\n\nexpected:\n%s", out, exp)
+	}
+}
+
+// Ensure that safe-content types skip escaping only where they are trusted.
+func TestTemplate_Write_SafeTypes(t *testing.T) {
+	src := `<%
+package main
+
+import "github.com/benbjohnson/ego"
+
+func Render(ctx context.Context, w io.Writer, h ego.HTML, j ego.JS, u ego.URL) {
+%><p title="<%= h %>"><%= h %> <%= "<i>" %></p><a href="<%= u %>"></a>
+<script>var a = <%= j %>;</script><textarea><%= h %></textarea>
+<% } %>`
+	main := `package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/benbjohnson/ego"
+)
+
+func main() {
+	Render(context.Background(), os.Stdout, ego.HTML("<b>x</b>"), ego.JS("f(1)"), ego.URL("data:,x"))
+}
+`
+	exp := `<p title="&lt;b&gt;x&lt;/b&gt;"><b>x</b> &lt;i&gt;</p><a href="data:,x"></a>
+<script>var a = f(1);</script><textarea>&lt;b&gt;x&lt;/b&gt;</textarea>
+`
+	for _, fn := range []func(*ego.Template){
+		func(tmpl *ego.Template) { tmpl.SafeTypes, tmpl.ContextEscape = true, true },
+		func(tmpl *ego.Template) { tmpl.SafeTypes, tmpl.FastWriter, tmpl.ContextEscape = true, true, true },
+		func(tmpl *ego.Template) { tmpl.SafeTypes = true },
+		func(tmpl *ego.Template) { tmpl.SafeTypes, tmpl.FastWriter = true, true },
+	} {
+		if out := runTemplate(t, src, main, fn); out != exp {
+			t.Fatalf("unexpected output:\n%s\n\nexpected:\n%s", out, exp)
+		}
+	}
+
+	// Without SafeTypes, HTML values are escaped everywhere.
+	exp = `<p title="&lt;b&gt;x&lt;/b&gt;">&lt;b&gt;x&lt;/b&gt; &lt;i&gt;</p><a href="data:,x"></a>
+<script>var a = f(1);</script><textarea>&lt;b&gt;x&lt;/b&gt;</textarea>
+`
+	if out := runTemplate(t, src, main, func(tmpl *ego.Template) { tmpl.FastWriter = true }); out != exp {
+		t.Fatalf("unexpected output:\n%s\n\nexpected:\n%s", out, exp)
+	}
+}
+
+// Ensure that component fields can be set from URL query parameters.
+func TestTemplate_Write_QueryMethod(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Search struct {
+	Query  string `+"`"+`query:"q"`+"`"+`
+	Page   int
+	Limit  int64
+	Min    float64
+	Exact  bool
+	Debug  bool
+	Offset int `+"`"+`query:"o%d\""`+"`"+`
+	Filter []string
+	hidden string
+}
+
+func (q Search) Render(ctx context.Context, w io.Writer) {
+%><%= q.Query %> <%= q.Page %> <%= q.Limit %> <%= q.Min %> <%= q.Exact %> <%= q.Debug %><% } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+func main() {
+	s := Search{Page: 1, Limit: 10}
+	q, _ := url.ParseQuery("q=<go>&q=other&Exact=on&Min=1.5&Debug=false&hidden=x")
+	fmt.Println(s.FromQuery(q))
+	s.Render(context.Background(), os.Stdout)
+
+	q, _ = url.ParseQuery("Page=two")
+	fmt.Println()
+	fmt.Println(s.FromQuery(q))
+
+	fmt.Print(s.FromQuery(url.Values{"o%d\"": {"x"}}))
+}
+`, func(tmpl *ego.Template) { tmpl.QueryMethod = true })
+
+	if out != "<nil>\n&lt;go&gt; 1 10 1.5 true false\nSearch: invalid query parameter Page: \"two\"\nSearch: invalid query parameter o%d\": \"x\"" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that component fields can be set by name from dynamic values.
+func TestTemplate_Write_SetFieldMethod(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Card struct {
+	Title, Body string
+	Count       int
+	Tags        []string
+	Yield       func()
+	Meta        struct{ Format string "%d" }
+	hidden      string
+}
+
+func (value *Card) Render(ctx context.Context, w io.Writer) {
+%><%= value.Title %>/<%= value.Body %>/<%= value.Count %>/<%= len(value.Tags) %><% } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	c := &Card{Body: "old"}
+	for name, value := range map[string]interface{}{"Title": "<Hi>", "Count": 2, "Tags": []string{"a"}, "Body": nil} {
+		if err := c.SetField(name, value); err != nil {
+			panic(err)
+		}
+	}
+	c.Render(context.Background(), os.Stdout)
+	fmt.Println()
+	fmt.Println(c.SetField("Count", 2.0))
+	fmt.Println(c.SetField("Meta", 1))
+	fmt.Println(c.SetField("hidden", "x"))
+	fmt.Print(c.SetField("Missing", "x"))
+}
+`, func(tmpl *ego.Template) { tmpl.SetFieldMethod = true })
+
+	if out != "&lt;Hi&gt;//2/1\n"+
+		"Card: invalid type for field Count: got float64, want int\n"+
+		"Card: invalid type for field Meta: got int, want struct {\n\tFormat string \"%d\"\n}\n"+
+		"Card: unknown field: hidden\n"+
+		"Card: unknown field: Missing" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that dynamic components render a registered component by name.
+func TestTemplate_Write_DynamicComponent(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Card struct {
+	Title  string
+	Attrs  map[string]string
+	Footer func()
+	Yield  func()
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) {
+%><div class="<%= r.Attrs["class"] %>"><%= r.Title %>:<% r.Yield() %>/<% r.Footer() %></div><% } %><%
+type Page struct {
+	Kind string
+}
+
+func (r *Page) Render(ctx context.Context, w io.Writer) {
+%><ego:Dynamic name=r.Kind Title="<Hi>" class="big"><ego::Footer>foot</ego::Footer>body</ego:Dynamic><% } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/benbjohnson/ego"
+)
+
+func main() {
+	ego.Register("card", func() ego.Component { return &Card{} })
+	(&Page{Kind: "card"}).Render(context.Background(), os.Stdout)
+	fmt.Println()
+	fmt.Println(ego.Dynamic{Name: "missing"}.Render(context.Background(), os.Stdout))
+	fmt.Println(ego.Dynamic{Name: "card", Fields: map[string]interface{}{"Title": 1}}.Render(context.Background(), os.Stdout))
+	(&Page{Kind: "missing"}).Render(context.Background(), os.Stdout)
+}
+
+func handle(ctx context.Context, w io.Writer, v interface{}) error {
+	fmt.Fprintf(w, "[%v]", v)
+	return nil
+}
+`, func(tmpl *ego.Template) {
+		tmpl.SetFieldMethod = true
+		tmpl.PanicHandler = "handle"
+	})
+
+	if out != "<div class=\"big\">&lt;Hi&gt;:body/foot</div>\n"+
+		"ego: component not registered: \"missing\"\n"+
+		"ego: component card: Card: invalid type for field Title: got int, want string\n"+
+		"[ego: component not registered: \"missing\"]" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that dynamic components require a name.
+func TestTemplate_Write_DynamicComponent_ErrNoName(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% func Render(ctx context.Context, w io.Writer) { %><ego:Dynamic Title=\"x\"/><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := tmpl.WriteTo(&bytes.Buffer{}); err == nil || err.Error() != "Expected name attribute on dynamic component: <ego:Dynamic> at tmpl.ego:1" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that format regions are only rendered for the format of the context
+// and that print blocks within JSON regions are encoded as JSON.
+func TestTemplate_Write_FormatRegions(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type User struct {
+	Name string
+}
+
+func (r *User) Render(ctx context.Context, w io.Writer) {
+%><%html %><h1><%= r.Name %></h1><%/html %><%json %>{"name":<%= r.Name %>}<%/json %>
+<% } %>`, `package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/benbjohnson/ego"
+)
+
+func main() {
+	u := &User{Name: "<Bob>"}
+	u.Render(context.Background(), os.Stdout)
+	u.Render(ego.WithFormat(context.Background(), ego.NegotiateFormat("text/html;q=0.9, application/json")), os.Stdout)
+}
+`, nil)
+
+	if out != "<h1>&lt;Bob&gt;</h1>\n{\"name\":\"\\u003cBob\\u003e\"}\n" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that components with a loader render the region for the load state.
+func TestTemplate_Write_Load(t *testing.T) {
+	src := `<%
+package main
+
+import "errors"
+
+type Card struct {
+	Yield func()
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) {
+%>[<% r.Yield() %>]<%
+}
+
+type Page struct {
+	Name string
+}
+
+func (r *Page) load(ctx context.Context) (string, error) {
+	switch r.Name {
+	case "":
+		return "", ego.ErrLoading
+	case "bad":
+		return "", errors.New("not found")
+	}
+	return r.Name, nil
+}
+
+func (r *Page) Render(ctx context.Context, w io.Writer) {
+%><ego:Card load=r.load><%loading %>...<%error err %>ERR:<%= err.Error() %><%success name %>Hi <%= name %></ego:Card><%
+} %>`
+
+	out := runTemplate(t, src, `package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() {
+	for _, name := range []string{"", "bad", "Bob"} {
+		(&Page{Name: name}).Render(context.Background(), os.Stdout)
+	}
+}
+`, nil)
+	if out != "[...][ERR:not found][Hi Bob]" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	t.Run("ReturnErrors", func(t *testing.T) {
+		out := runTemplate(t, `<%
+package main
+
+import "errors"
+
+type Card struct {
+	Yield func() error
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) error {
+%>[<% if err := r.Yield(); err != nil { return err } %>]<%
+	return nil
+}
+
+func load(ctx context.Context) (int, error) {
+	return 0, errors.New("not found")
+}
+
+func Render(ctx context.Context, w io.Writer) error {
+%><ego:Card load=load><%success n %><%= n %></ego:Card><%
+	return nil
+} %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(Render(context.Background(), os.Stdout))
+}
+`, func(tmpl *ego.Template) {
+			tmpl.ReturnErrors = true
+		})
+		if out != "[not found\n" {
+			t.Fatalf("unexpected output: %q", out)
+		}
+	})
+}
+
+// Ensure that components can render responses to HTTP requests.
+func TestTemplate_Write_ResponseMethod(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		out := runTemplate(t, `<%
+package main
+
+import "errors"
+
+type Page struct {
+	Fail  bool
+	Title string
+}
+
+func (r *Page) Render(ctx context.Context, w io.Writer) error {
+	if r.Fail && r.Title == "" {
+		return errors.New("no title")
+	}
+%><h1><%= r.Title %></h1><%
+	if r.Fail {
+		return errors.New("marker")
+	}
+	return nil
+} %>`, `package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+)
+
+func main() {
+	for _, page := range []*Page{{Title: "a&b"}, {Fail: true}, {Fail: true, Title: "c"}} {
+		w := httptest.NewRecorder()
+		page.RenderResponse(w, httptest.NewRequest("GET", "/", nil))
+		fmt.Printf("%d %s %q\n", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+`, func(tmpl *ego.Template) {
+			tmpl.ReturnErrors = true
+			tmpl.ResponseMethod = true
+		})
+
+		if exp := `200 text/html; charset=utf-8 "<h1>a&amp;b</h1>"` + "\n" +
+			`500 text/plain; charset=utf-8 "Internal Server Error\n"` + "\n" +
+			`200 text/html; charset=utf-8 "<h1>c</h1>"` + "\n"; out != exp {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("ContentType", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(`<%
+package foo
+
+type Feed struct{}
+
+func (r *Feed) Render(ctx context.Context, w io.Writer) {
+} %>`), "feed.xml.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.ResponseMethod = true
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, `rw := &ego.ResponseWriter{W: w, ContentType: "text/xml; charset=utf-8"}`) {
+			t.Fatalf("unexpected output: %s", s)
+		}
+	})
+}
+
+// Ensure that functional options are generated for components.
+func TestTemplate_Write_Options(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Card struct {
+	Title, Subtitle string
+	Tags            []string
+	Yield           func()
+	count           int
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) {
+%><h1><%= r.Title %></h1><h2><%= r.Subtitle %></h2><%= len(r.Tags) %><% if r.Yield != nil { r.Yield() } %><% }
+
+type Badge struct {
+	Label string
+}
+
+func (r Badge) Render(ctx context.Context, w io.Writer) {
+%>(<%= r.Label %>)<% } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	ctx := context.Background()
+	NewCard(WithCardTitle("a"), WithCardTags([]string{"x", "y"}), WithCardYield(func() { fmt.Print("!") })).Render(ctx, os.Stdout)
+	NewBadge(WithBadgeLabel("b")).Render(ctx, os.Stdout)
+}
+`, func(tmpl *ego.Template) {
+		tmpl.FunctionalOptions = true
+	})
+
+	if out != "<h1>a</h1><h2></h2>2!(b)" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that text can be written without copying using package unsafe.
+func TestTemplate_Write_UnsafeBytes(t *testing.T) {
+	skipBefore(t, "go1.20")
+	out := runTemplate(t, `<%
+package main
+
+func render(ctx context.Context, w io.Writer) {
+%><html><body>Hello, world!</body></html><% } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// byteWriter only implements io.Writer so io.WriteString must copy strings.
+type byteWriter struct{ n int }
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+func main() {
+	ctx := context.Background()
+	render(ctx, os.Stdout)
+
+	var w byteWriter
+	fmt.Printf("\n%v", testing.AllocsPerRun(100, func() { render(ctx, &w) }))
+}
+`, func(tmpl *ego.Template) { tmpl.UnsafeBytes = true })
+
+	if out != "<html><body>Hello, world!</body></html>\n0" {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// Ensure that required fields are validated before rendering, and that
+// declared Validate methods with another signature are not called.
+func TestTemplate_Write_ValidateRender(t *testing.T) {
+	out := runTemplate(t, `<%
+package main
+
+type Card struct {
+	Title string   `+"`"+`validate:"required"`+"`"+`
+	Tags  []string `+"`"+`validate:"required"`+"`"+`
+	Body  interface{} `+"`"+`validate:"required"`+"`"+`
+	Note  string
+}
+
+func (r *Card) Render(ctx context.Context, w io.Writer) error {
+%><h1><%= r.Title %></h1><% return nil }
+
+type Form struct {
+	Name string `+"`"+`validate:"required"`+"`"+`
+}
+
+func (r *Form) Validate(strict bool) []string { return nil }
+
+func (r *Form) Render(ctx context.Context, w io.Writer) error {
+%><form><%= r.Name %></form><% return nil }
+
+func render(ctx context.Context, w io.Writer, title string) error {
+%><ego:Card Title=title Tags=[]string{"a"} Body=ctx /><% return nil } %>`, `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func main() {
+	ctx := context.Background()
+	fmt.Println(render(ctx, os.Stdout, "ok"))
+	fmt.Println(render(ctx, os.Stdout, ""))
+	fmt.Println((&Card{Title: "x", Body: ctx}).Validate())
+	fmt.Println((&Form{}).Render(ctx, os.Stdout))
+}
+`, func(tmpl *ego.Template) {
+		tmpl.ReturnErrors = true
+		tmpl.ValidateRender = true
+	})
+
+	if exp := "<h1>ok</h1><nil>\n" +
+		"Card: required field Title is not set\n" +
+		"Card: required field Tags is not set\n" +
+		"<form></form><nil>\n"; out != exp {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+// runTemplate generates Go code from an ego template with options applied by
+// fn, builds it with a main.go file, and returns the program's output.
+func runTemplate(tb testing.TB, src, main string, fn func(*ego.Template), flags ...string) string {
+	tb.Helper()
+	out, err := goTemplate(tb, src, fn, map[string]string{"main.go": main}, append(append([]string{"run"}, flags...), ".")...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return out
+}
+
+// goTemplate generates Go code from an ego template with options applied by
+// fn, writes it with files to a temporary module, and returns the output of
+// the go command with args run in the module. Test helpers are also written
+// for the test command. The error includes the output & the generated code.
+func goTemplate(tb testing.TB, src string, fn func(*ego.Template), files map[string]string, args ...string) (string, error) {
+	tb.Helper()
+	if testing.Short() {
+		tb.Skip("skipping compilation in short mode")
+	}
+
+	root, err := filepath.Abs(".")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if fn != nil {
+		fn(tmpl)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		tb.Fatalf("%s\n%s", err, buf.String())
+	}
+	files["go.mod"] = "module egotest\n\ngo 1.20\n\nrequire github.com/benbjohnson/ego v0.0.0\n\nreplace github.com/benbjohnson/ego => " + root + "\n"
+	files["tmpl.ego.go"] = buf.String()
+	if args[0] == "test" {
+		var helpers bytes.Buffer
+		if _, err := tmpl.WriteTestHelpersTo(&helpers); err != nil {
+			tb.Fatal(err)
+		}
+		files["tmpl.ego_test.go"] = helpers.String()
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("go", append([]string{args[0], "-mod=mod"}, args[1:]...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPROXY=off", "GOFLAGS=")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s\n%s\n%s", err, out, buf.String())
+	}
+	return string(out), nil
+}
+
+// skipBefore skips a test whose generated code needs a newer Go release, such
+// as "go1.20", than the toolchain running the tests.
+func skipBefore(tb testing.TB, release string) {
+	tb.Helper()
+	for _, tag := range build.Default.ReleaseTags {
+		if tag == release {
+			return
+		}
+	}
+	tb.Skipf("skipping test that requires %s", release)
+}
@@ -0,0 +1,359 @@
+package ego
+
+import (
+	"strings"
+)
+
+// escState is the state of the HTML context tracker.
+type escState int
+
+const (
+	escText        escState = iota // element content
+	escComment                     // within an HTML comment
+	escTag                         // within a tag, outside of an attribute value
+	escAttrName                    // after an attribute name
+	escBeforeValue                 // after the "=" of an attribute
+	escAttrValue                   // within an attribute value
+	escRawText                     // within a script, style, textarea or title element
+)
+
+// escAttr is the kind of content of an attribute value.
+type escAttr int
+
+const (
+	escAttrNone escAttr = iota
+	escAttrURL
+	escAttrJS
+	escAttrCSS
+)
+
+// escContext tracks the HTML context of template text so that print blocks
+// can be escaped for where they are written. It is a simplified version of the
+// contextual escaper of html/template. Code blocks are ignored so text is
+// assumed to be written in source order.
+type escContext struct {
+	state   escState
+	element string  // name of the current tag or raw text element
+	closing bool    // true if the current tag is a closing tag
+	attr    escAttr // kind of the current attribute
+	quote   byte    // quote of the current attribute value, if quoted
+	urlPart int     // 0 before any URL content, 1 in the path, 2 after "?" or "#"
+	jsQuote byte    // quote of the current JavaScript string, if any
+	jsEsc   bool    // true after a backslash in a JavaScript string
+
+	// safeTypes is true if the default escaping writes ego.HTML values as-is
+	// so they must be escaped explicitly where HTML is not allowed.
+	safeTypes bool
+
+	// contextual is true if values are escaped for their context. Otherwise
+	// only ego.HTML values are escaped where HTML is not allowed.
+	contextual bool
+}
+
+// reset returns a context in element content with the settings of c.
+func (c *escContext) reset() escContext {
+	return escContext{safeTypes: c.safeTypes, contextual: c.contextual}
+}
+
+// urlAttrs are attributes whose values are URLs.
+var urlAttrs = map[string]bool{
+	"action": true, "background": true, "cite": true, "codebase": true,
+	"data": true, "formaction": true, "href": true, "icon": true,
+	"longdesc": true, "manifest": true, "poster": true, "src": true,
+	"usemap": true, "xlink:href": true,
+}
+
+// next updates the context for the text s.
+func (c *escContext) next(s string) {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch c.state {
+		case escText:
+			if strings.HasPrefix(s[i:], "<!--") {
+				c.state, i = escComment, i+3
+			} else if ch == '<' && i+1 < len(s) && (isASCIILetter(s[i+1]) || s[i+1] == '/') {
+				i = c.startTag(s, i)
+			}
+
+		case escComment:
+			if strings.HasPrefix(s[i:], "-->") {
+				c.state, i = escText, i+2
+			}
+
+		case escTag, escAttrName:
+			switch {
+			case ch == '>':
+				c.endTag()
+			case ch == '=' && c.state == escAttrName:
+				c.state = escBeforeValue
+			case isWhitespace(rune(ch)) || ch == '/':
+			default:
+				// Read an attribute name.
+				j := i
+				for j < len(s) && !isWhitespace(rune(s[j])) && !strings.ContainsRune("=>/", rune(s[j])) {
+					j++
+				}
+				c.startAttr(strings.ToLower(s[i:j]))
+				i = j - 1
+			}
+
+		case escBeforeValue:
+			switch {
+			case ch == '>':
+				c.endTag()
+			case ch == '"' || ch == '\'':
+				c.state, c.quote = escAttrValue, ch
+			case isWhitespace(rune(ch)):
+			default:
+				c.state, c.quote = escAttrValue, 0
+				i--
+			}
+
+		case escAttrValue:
+			if c.quote != 0 && ch == c.quote {
+				c.state, c.quote = escTag, 0
+			} else if c.quote == 0 && isWhitespace(rune(ch)) {
+				c.state = escTag
+			} else if c.quote == 0 && ch == '>' {
+				c.endTag()
+			} else {
+				c.nextValue(ch)
+			}
+
+		case escRawText:
+			if strings.HasPrefix(strings.ToLower(s[i:]), "</"+c.element) {
+				i = c.startTag(s, i)
+			} else if c.element == "script" {
+				c.nextJS(ch)
+			}
+		}
+	}
+}
+
+// startTag reads the name of the tag starting at s[i] and returns the index
+// of the last byte of the name.
+func (c *escContext) startTag(s string, i int) int {
+	i++
+	c.closing = s[i] == '/'
+	if c.closing {
+		i++
+	}
+	j := i
+	for j < len(s) && (isASCIILetter(s[j]) || (s[j] >= '0' && s[j] <= '9') || s[j] == '-') {
+		j++
+	}
+	c.state, c.element, c.jsQuote, c.jsEsc = escTag, strings.ToLower(s[i:j]), 0, false
+	return j - 1
+}
+
+// endTag updates the context at the end of a tag.
+func (c *escContext) endTag() {
+	c.state, c.attr, c.quote = escText, escAttrNone, 0
+	switch c.element {
+	case "script", "style", "textarea", "title":
+		if !c.closing {
+			c.state = escRawText
+		}
+	}
+	c.jsQuote, c.jsEsc = 0, false
+}
+
+// startAttr updates the context at the start of an attribute name.
+func (c *escContext) startAttr(name string) {
+	c.state, c.attr, c.urlPart, c.jsQuote, c.jsEsc = escAttrName, escAttrNone, 0, 0, false
+	switch {
+	case strings.HasPrefix(name, "on"):
+		c.attr = escAttrJS
+	case name == "style":
+		c.attr = escAttrCSS
+	case urlAttrs[name]:
+		c.attr = escAttrURL
+	}
+}
+
+// nextValue updates the context for a byte of an attribute value.
+func (c *escContext) nextValue(ch byte) {
+	switch c.attr {
+	case escAttrURL:
+		if ch == '?' || ch == '#' {
+			c.urlPart = 2
+		} else if c.urlPart == 0 && !isWhitespace(rune(ch)) {
+			c.urlPart = 1
+		}
+	case escAttrJS:
+		c.nextJS(ch)
+	}
+}
+
+// nextJS updates whether the context is within a JavaScript string.
+func (c *escContext) nextJS(ch byte) {
+	switch {
+	case c.jsEsc:
+		c.jsEsc = false
+	case c.jsQuote != 0 && ch == '\\':
+		c.jsEsc = true
+	case c.jsQuote == 0 && (ch == '"' || ch == '\'' || ch == '`'):
+		c.jsQuote = ch
+	case c.jsQuote != 0 && ch == c.jsQuote:
+		c.jsQuote = 0
+	}
+}
+
+// escaper returns the expression that escapes the value of a print block with
+// the content expr for the context. Returns a blank string for element content
+// & quoted attribute values which use the default HTML escaping. If the default
+// escaping writes ego.HTML values as-is, they are escaped in quoted attribute
+// values & raw text elements, such as textarea, instead.
+func (c *escContext) escaper(expr string) string {
+	if !c.contextual {
+		if c.safeTypes && (c.state == escRawText || c.state == escBeforeValue || c.state == escAttrValue) {
+			return "ego.EscapeAttr(" + expr + ")"
+		}
+		return ""
+	}
+
+	var s string
+	switch c.state {
+	case escRawText:
+		switch c.element {
+		case "script":
+			return c.jsEscaper(expr)
+		case "style":
+			return "ego.EscapeCSS(" + expr + ")"
+		}
+		if c.safeTypes {
+			return "ego.EscapeAttr(" + expr + ")"
+		}
+		return ""
+
+	case escBeforeValue:
+		// A value starting with a print block is unquoted.
+		c2 := *c
+		c2.state = escAttrValue
+		return c2.escaper(expr)
+
+	case escAttrValue:
+		switch c.attr {
+		case escAttrURL:
+			switch c.urlPart {
+			case 0:
+				s = "ego.EscapeURL(" + expr + ")"
+			case 1:
+				s = "ego.NormalizeURL(" + expr + ")"
+			default:
+				s = "ego.EscapeQuery(" + expr + ")"
+			}
+		case escAttrJS:
+			s = c.jsEscaper(expr)
+		case escAttrCSS:
+			s = "ego.EscapeCSS(" + expr + ")"
+		default:
+			if c.quote != 0 && c.safeTypes {
+				return "ego.EscapeAttr(" + expr + ")"
+			} else if c.quote != 0 {
+				return ""
+			}
+			return "ego.EscapeUnquotedAttr(fmt.Sprint(" + expr + "))"
+		}
+		if c.quote == 0 {
+			return "ego.EscapeUnquotedAttr(" + s + ")"
+		}
+		return "html.EscapeString(" + s + ")"
+
+	default:
+		return ""
+	}
+}
+
+// attrEscaper returns the expression that escapes expr, a string that is
+// already escaped for its content such as a JSON encoding, within an attribute
+// value. Returns a blank string outside of attribute values, even if the
+// template does not escape print blocks contextually.
+func (c *escContext) attrEscaper(expr string) string {
+	switch c.state {
+	case escBeforeValue:
+		return "ego.EscapeUnquotedAttr(" + expr + ")"
+	case escAttrValue:
+		if c.quote == 0 {
+			return "ego.EscapeUnquotedAttr(" + expr + ")"
+		}
+		return "html.EscapeString(" + expr + ")"
+	default:
+		return ""
+	}
+}
+
+// nextPrint advances the context past the value of a print block.
+func (c *escContext) nextPrint() {
+	if c.state == escBeforeValue {
+		c.state, c.quote = escAttrValue, 0
+	}
+	if c.state == escAttrValue {
+		c.nextValue('x')
+	}
+}
+
+// jsEscaper returns the expression that escapes expr as a JavaScript value or
+// within a JavaScript string.
+func (c *escContext) jsEscaper(expr string) string {
+	if c.jsQuote != 0 {
+		return "ego.EscapeJSString(" + expr + ")"
+	}
+	return "ego.EscapeJS(" + expr + ")"
+}
+
+// contextEscapers returns the escaping expression of each print, translate &
+// JSON print block whose context requires escaping other than the default
+// HTML escaping. Nested blocks, such as the body of a component, start in the
+// context of their parent block. Regions & functions of function directives
+// start in element content and JSON format regions are skipped. If safeTypes
+// is true, the default escaping writes ego.HTML values as-is. If contextual
+// is false, only ego.HTML values in attribute values & raw text elements are
+// escaped.
+func contextEscapers(a []Block, safeTypes, contextual bool) map[Block]string {
+	m := make(map[Block]string)
+	walkContextEscapers(a, &escContext{safeTypes: safeTypes, contextual: contextual}, m)
+	return m
+}
+
+func walkContextEscapers(a []Block, c *escContext, m map[Block]string) {
+	for _, blk := range a {
+		switch blk := blk.(type) {
+		case *TextBlock:
+			c.next(blk.Content)
+		case *PrintBlock:
+			if s := c.escaper(blk.Content); s != "" {
+				m[blk] = s
+			}
+			c.nextPrint()
+		case *TranslateBlock:
+			if s := c.escaper(blk.expr()); s != "" {
+				m[blk] = s
+			}
+			c.nextPrint()
+		case *JSONPrintBlock:
+			if s := c.attrEscaper("EGO_JSON"); s != "" {
+				m[blk] = s
+			}
+			c.nextPrint()
+		case *ComponentStartBlock:
+			for _, attrBlock := range blk.AttrBlocks {
+				c2 := *c
+				walkContextEscapers(attrBlock.Yield, &c2, m)
+			}
+			c2 := *c
+			walkContextEscapers(blk.Yield, &c2, m)
+		case *FuncBlock:
+			*c = c.reset()
+		case *AppendStartBlock:
+			c2 := c.reset()
+			walkContextEscapers(blk.Yield, &c2, m)
+		case *FormatStartBlock:
+			if blk.Format != FormatJSON {
+				walkContextEscapers(blk.Yield, c, m)
+			}
+		case *YieldStartBlock:
+			walkContextEscapers(blk.Yield, c, m)
+		}
+	}
+}
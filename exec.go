@@ -0,0 +1,1881 @@
+package ego
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"html"
+	"io"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultFuncs are the package functions available to Execute in addition to
+// the template's Funcs.
+var DefaultFuncs = map[string]interface{}{
+	"fmt.Sprint":        fmt.Sprint,
+	"fmt.Sprintf":       fmt.Sprintf,
+	"fmt.Fprint":        fmt.Fprint,
+	"fmt.Fprintf":       fmt.Fprintf,
+	"html.EscapeString": html.EscapeString,
+	"io.WriteString":    io.WriteString,
+	"strconv.Itoa":      strconv.Itoa,
+	"strconv.Quote":     strconv.Quote,
+	"strings.Contains":  strings.Contains,
+	"strings.HasPrefix": strings.HasPrefix,
+	"strings.HasSuffix": strings.HasSuffix,
+	"strings.Join":      strings.Join,
+	"strings.Repeat":    strings.Repeat,
+	"strings.Split":     strings.Split,
+	"strings.Title":     strings.Title,
+	"strings.ToLower":   strings.ToLower,
+	"strings.ToUpper":   strings.ToUpper,
+	"strings.TrimSpace": strings.TrimSpace,
+	"ego.Upper":         Upper,
+	"ego.Lower":         Lower,
+	"ego.TrimSpace":     TrimSpace,
+	"ego.Truncate":      Truncate,
+	"ego.EscapeQuery":   EscapeQuery,
+	"ego.EscapePath":    EscapePath,
+
+	// Functions called by the output of print blocks & components.
+	"ego.EscapeHTML":         EscapeHTML,
+	"ego.EscapeAttr":         EscapeAttr,
+	"ego.EscapeUnquotedAttr": EscapeUnquotedAttr,
+	"ego.EscapeCSS":          EscapeCSS,
+	"ego.EscapeJS":           EscapeJS,
+	"ego.EscapeJSString":     EscapeJSString,
+	"ego.EscapeURL":          EscapeURL,
+	"ego.NormalizeURL":       NormalizeURL,
+	"ego.WriteEscaped":       WriteEscaped,
+	"ego.WriteEscapedHTML":   WriteEscapedHTML,
+	"ego.WriteJSON":          WriteJSON,
+	"ego.ScriptJSON":         ScriptJSON,
+	"ego.MustScriptJSON":     MustScriptJSON,
+	"ego.Translate":          Translate,
+	"ego.Yield":              Yield,
+	"ego.YieldError":         YieldError,
+	"ego.SpreadAttrs":        SpreadAttrs,
+	"ego.MergeAttrs":         MergeAttrs,
+	"ego.SpreadTypedAttrs":   SpreadTypedAttrs,
+	"ego.MergeTypedAttrs":    MergeTypedAttrs,
+}
+
+// ExecError represents an error evaluating a template with Execute.
+type ExecError struct {
+	Message string
+	Pos     Pos
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("%s at %s:%d", e.Message, e.Pos.Path, e.Pos.LineNo)
+}
+
+// Execute renders the template by interpreting it instead of generating code
+// so that development servers can render changed templates without being
+// recompiled. Production code should continue to use generated code.
+//
+// The first function or method named Render is called. Its receiver and its
+// parameters, other than the context & writer, are bound to data. A single
+// value is bound to data itself. Multiple values are bound by name to the
+// values of data, which must be a map[string]interface{}.
+//
+// Output is written by the same code as the generated code of the template so
+// values are escaped the same, including by the ContextEscape, SafeTypes,
+// FastWriter & PlainText options, and write errors are returned if
+// WriteErrors is set.
+//
+// Code blocks are evaluated by an interpreter which supports a subset of Go:
+//
+//   - variables & constants, assignments, conditionals, loops, switches,
+//     and returns.
+//   - calls of functions declared by the template, function literals, and
+//     package functions in DefaultFuncs or the template's Funcs.
+//   - the fields & methods of values, indexing, and slicing.
+//   - the predeclared types, such as string & error, and slice, array, map,
+//     pointer & function types of them, which may be used in declarations,
+//     conversions & composite literals.
+//
+// Types declared by the template or by packages are not known to the
+// interpreter, so they cannot be declared or used in composite literals.
+// Type assertions, type switches, labels, goto, fallthrough, defer &
+// goroutines are not supported. Panics of called functions, such as a method
+// called on a nil pointer, are returned as errors.
+//
+// Components are created by the constructor passed to Register with their
+// type name, such as "Card" or "ui.Card", and their fields & closures are set
+// as by generated code. Dynamic components, component loaders & append
+// regions are not supported.
+func (t *Template) Execute(ctx context.Context, w io.Writer, data interface{}) (err error) {
+	// Output is written with a copy of the template so that it can be
+	// executed concurrently.
+	tmpl := *t
+	tmpl.escapers = tmpl.printEscapers()
+	in := &interp{t: &tmpl, ctx: ctx, w: w, fset: token.NewFileSet(), funcs: make(map[string]*closure)}
+
+	// Errors within closures called by Go functions, such as the closures of
+	// components, are returned by panicking. Other panics are returned as a
+	// *PanicError.
+	defer func() {
+		if r := recover(); r != nil {
+			if p, ok := r.(execPanic); ok {
+				err = p.err
+			} else {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}
+	}()
+
+	// Translate the template into Go source with calls to write its output.
+	var buf bytes.Buffer
+	if err := in.writeSource(&buf, t.Blocks); err != nil {
+		return err
+	}
+	writeFuncEnd(&buf, in.funcBlock)
+	f, err := parser.ParseFile(in.fset, t.Path, buf.Bytes(), 0)
+	if err != nil {
+		return in.syntaxError(err)
+	}
+
+	// Find declared functions & the render function.
+	var render *ast.FuncDecl
+	for _, decl := range f.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Body != nil {
+			if fd.Recv == nil {
+				in.funcs[fd.Name.Name] = &closure{name: fd.Name.Name, typ: fd.Type, body: fd.Body}
+			}
+			if render == nil && fd.Name.Name == "Render" {
+				render = fd
+			}
+		}
+	}
+	if render == nil {
+		return &ExecError{Message: "Render function not found", Pos: Pos{Path: t.Path}}
+	}
+
+	s, err := in.bindRender(render, data)
+	if err != nil {
+		return err
+	}
+	if _, err := in.execBlock(s, render.Body.List); err != nil {
+		return err
+	}
+	return in.returnedError()
+}
+
+// interp evaluates the Go source of a template.
+type interp struct {
+	t    *Template
+	ctx  context.Context
+	w    io.Writer
+	fset *token.FileSet
+
+	text  []string            // text blocks by index
+	funcs map[string]*closure // functions declared by the template
+	ret   []reflect.Value     // values of the last return statement
+
+	funcBlock *FuncBlock // function directive whose body is being written
+}
+
+// writeSource writes the blocks as Go source. Print blocks are written as by
+// the generated code of the template. Text is written with EGO_TEXT and
+// components are created with EGO_COMPONENT & rendered with EGO_RENDER, which
+// are evaluated by the interpreter.
+func (in *interp) writeSource(buf *bytes.Buffer, a []Block) error {
+	for _, blk := range a {
+		switch blk.(type) {
+		case *BuildBlock, *ModeBlock, *CommentBlock:
+			continue
+		}
+		pos := Position(blk)
+		fmt.Fprintf(buf, "\n//line %s:%d\n", pos.Path, pos.LineNo)
+
+		switch blk := blk.(type) {
+		case *TextBlock:
+			in.t.writeOutput(buf, fmt.Sprintf("io.WriteString(w, EGO_TEXT(%d))", len(in.text)))
+			in.text = append(in.text, in.t.convertLineEndings(blk.Content))
+		case *CodeBlock:
+			fmt.Fprintln(buf, blk.Content)
+		case *FuncBlock:
+			writeFuncEnd(buf, in.funcBlock)
+			fmt.Fprintf(buf, "%s {\n", blk.Content)
+			in.funcBlock = blk
+		case *CtxBlock:
+			fmt.Fprintln(buf, blk.Content)
+		case *PrintBlock, *RawPrintBlock, *SanitizeBlock, *JSONPrintBlock, *URLPrintBlock, *TranslateBlock:
+			in.t.writePrintBlock(buf, blk)
+		case *FormatStartBlock:
+			fmt.Fprintf(buf, "if EGO_FORMAT(%q) {\n", blk.Format)
+			inJSON := in.t.json
+			in.t.json = blk.Format == FormatJSON
+			err := in.writeSource(buf, blk.Yield)
+			in.t.json = inJSON
+			if err != nil {
+				return err
+			}
+			buf.WriteString("}\n")
+		case *YieldStartBlock:
+			if in.t.returnErrors() {
+				fmt.Fprintf(buf, "if err := ego.YieldError(%s, func() error {\n", blk.Content)
+			} else {
+				fmt.Fprintf(buf, "ego.Yield(%s, func() {\n", blk.Content)
+			}
+			if err := in.writeSource(buf, blk.Yield); err != nil {
+				return err
+			}
+			if in.t.returnErrors() {
+				buf.WriteString("return nil\n}); err != nil {\nreturn err\n}\n")
+			} else {
+				buf.WriteString("})\n")
+			}
+		case *ComponentStartBlock:
+			if err := in.writeComponent(buf, blk); err != nil {
+				return err
+			}
+		case *AppendStartBlock, *FlushBlock:
+			return NewSyntaxError(pos, "Append regions are not supported by Execute")
+		default:
+			return NewSyntaxError(pos, "Block is not supported by Execute: %T", blk)
+		}
+	}
+	return nil
+}
+
+// writeComponent writes the source of a component, which is created by the
+// constructor registered with its type name and rendered once per iteration
+// of its loop, if any.
+func (in *interp) writeComponent(buf *bytes.Buffer, blk *ComponentStartBlock) error {
+	if blk.isDynamic() {
+		return NewSyntaxError(blk.Pos, "Dynamic components are not supported by Execute: %s", shortComponentBlockString(blk))
+	} else if blk.Load != "" {
+		return NewSyntaxError(blk.LoadPos, "Component loaders are not supported by Execute: %s", shortComponentBlockString(blk))
+	}
+
+	// The component is created on the line of its block so that errors are
+	// reported at the block.
+	if blk.Flag != "" {
+		fmt.Fprintf(buf, "if %s(ctx, %s) { ", in.t.featureFunc(), blk.Flag)
+	}
+	if blk.For != "" {
+		fmt.Fprintf(buf, "for %s { ", blk.For)
+	}
+
+	// Closure bodies are written by the interpreter, keeping the first error.
+	var err error
+	writeBody := func(buf *bytes.Buffer, a []Block) {
+		if e := in.writeSource(buf, a); e != nil && err == nil {
+			err = e
+		}
+	}
+	buf.WriteString("{ ")
+	in.t.writeStaticComponent(buf, blk, fmt.Sprintf("EGO := EGO_COMPONENT(%q)", blk.typeName()), writeBody)
+	if in.t.returnErrors() {
+		buf.WriteString("if err := EGO_RENDER(EGO, ctx, w); err != nil {\nreturn err\n}\n}\n")
+	} else {
+		buf.WriteString("EGO_RENDER(EGO, ctx, w)\n}\n")
+	}
+
+	if blk.For != "" {
+		buf.WriteString("}\n")
+	}
+	if blk.Flag != "" {
+		buf.WriteString("}\n")
+	}
+	return err
+}
+
+// syntaxError converts an error from the Go parser to a syntax error at the
+// template position of the first error.
+func (in *interp) syntaxError(err error) error {
+	if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+		return NewSyntaxError(Pos{Path: list[0].Pos.Filename, LineNo: list[0].Pos.Line}, "%s", list[0].Msg)
+	}
+	return err
+}
+
+// errorf returns an error at the template position of node.
+func (in *interp) errorf(node ast.Node, format string, args ...interface{}) error {
+	p := in.fset.Position(node.Pos())
+	return &ExecError{Message: fmt.Sprintf(format, args...), Pos: Pos{Path: p.Filename, LineNo: p.Line}}
+}
+
+// bindRender returns the scope of the render function with its parameters
+// bound to the context, writer, and data.
+func (in *interp) bindRender(fd *ast.FuncDecl, data interface{}) (*scope, error) {
+	var names []string
+	s := newScope(nil)
+	var fields []*ast.Field
+	if fd.Recv != nil {
+		fields = append(fields, fd.Recv.List...)
+	}
+	fields = append(fields, fd.Type.Params.List...)
+	for _, field := range fields {
+		var typ bytes.Buffer
+		if err := printer.Fprint(&typ, in.fset, field.Type); err != nil {
+			return nil, err
+		}
+		for _, name := range field.Names {
+			switch typ.String() {
+			case "context.Context":
+				s.define(name.Name, reflect.ValueOf(&in.ctx).Elem())
+			case "io.Writer":
+				s.define(name.Name, reflect.ValueOf(&in.w).Elem())
+			default:
+				names = append(names, name.Name)
+			}
+		}
+	}
+
+	if len(names) == 1 {
+		s.define(names[0], reflect.ValueOf(data))
+	} else if len(names) > 1 {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, in.errorf(fd, "Render has %d parameters, expected data of type map[string]interface{}, got %T", len(names), data)
+		}
+		for _, name := range names {
+			v, ok := m[name]
+			if !ok {
+				return nil, in.errorf(fd, "Render parameter not found in data: %s", name)
+			}
+			s.define(name, reflect.ValueOf(v))
+		}
+	}
+	return s, nil
+}
+
+// returnedError returns the error returned by the render function, if any.
+func (in *interp) returnedError() error {
+	if len(in.ret) == 0 || !in.ret[0].IsValid() {
+		return nil
+	} else if err, ok := in.ret[0].Interface().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// scope holds the variables of a block.
+type scope struct {
+	parent *scope
+	vars   map[string]reflect.Value
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, vars: make(map[string]reflect.Value)}
+}
+
+// define declares a variable with a copy of v so it can be assigned to.
+func (s *scope) define(name string, v reflect.Value) {
+	if name == "_" {
+		return
+	} else if !v.IsValid() {
+		v = reflect.ValueOf((*interface{})(nil)).Elem()
+	}
+	nv := reflect.New(v.Type()).Elem()
+	nv.Set(v)
+	s.vars[name] = nv
+}
+
+// lookup returns the variable with the given name.
+func (s *scope) lookup(name string) (reflect.Value, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// flow is the result of executing a statement.
+type flow int
+
+const (
+	flowNext flow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+)
+
+// execBlock executes a list of statements in a new scope.
+func (in *interp) execBlock(parent *scope, list []ast.Stmt) (flow, error) {
+	s := newScope(parent)
+	for _, stmt := range list {
+		if f, err := in.exec(s, stmt); err != nil || f != flowNext {
+			return f, err
+		}
+	}
+	return flowNext, nil
+}
+
+// exec executes a single statement.
+func (in *interp) exec(s *scope, stmt ast.Stmt) (flow, error) {
+	switch stmt := stmt.(type) {
+	case *ast.EmptyStmt:
+		return flowNext, nil
+
+	case *ast.ExprStmt:
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok {
+			return flowNext, in.errorf(stmt, "Expression is not used")
+		}
+		_, err := in.call(s, call)
+		return flowNext, err
+
+	case *ast.AssignStmt:
+		return flowNext, in.assign(s, stmt)
+
+	case *ast.IncDecStmt:
+		op := token.ADD
+		if stmt.Tok == token.DEC {
+			op = token.SUB
+		}
+		x, err := in.eval(s, stmt.X)
+		if err != nil {
+			return flowNext, err
+		}
+		v, err := in.binary(stmt, op, x, reflect.ValueOf(1), false, true)
+		if err != nil {
+			return flowNext, err
+		}
+		return flowNext, in.store(s, stmt.X, v)
+
+	case *ast.DeclStmt:
+		return flowNext, in.declare(s, stmt)
+
+	case *ast.BlockStmt:
+		return in.execBlock(s, stmt.List)
+
+	case *ast.IfStmt:
+		s = newScope(s)
+		if stmt.Init != nil {
+			if _, err := in.exec(s, stmt.Init); err != nil {
+				return flowNext, err
+			}
+		}
+		cond, err := in.cond(s, stmt.Cond)
+		if err != nil {
+			return flowNext, err
+		} else if cond {
+			return in.execBlock(s, stmt.Body.List)
+		} else if stmt.Else != nil {
+			return in.exec(s, stmt.Else)
+		}
+		return flowNext, nil
+
+	case *ast.ForStmt:
+		return in.execFor(s, stmt)
+
+	case *ast.RangeStmt:
+		return in.execRange(s, stmt)
+
+	case *ast.SwitchStmt:
+		return in.execSwitch(s, stmt)
+
+	case *ast.BranchStmt:
+		if stmt.Label != nil {
+			return flowNext, in.errorf(stmt, "Labels are not supported by Execute")
+		}
+		switch stmt.Tok {
+		case token.BREAK:
+			return flowBreak, nil
+		case token.CONTINUE:
+			return flowContinue, nil
+		}
+		return flowNext, in.errorf(stmt, "Statement is not supported by Execute: %s", stmt.Tok)
+
+	case *ast.ReturnStmt:
+		ret := make([]reflect.Value, len(stmt.Results))
+		for i, expr := range stmt.Results {
+			v, err := in.eval(s, expr)
+			if err != nil {
+				return flowNext, err
+			}
+			ret[i] = v
+		}
+		in.ret = ret
+		return flowReturn, nil
+
+	case *ast.TypeSwitchStmt:
+		return flowNext, in.errorf(stmt, "Type switches are not supported by Execute")
+
+	default:
+		return flowNext, in.errorf(stmt, "Statement is not supported by Execute: %T", stmt)
+	}
+}
+
+// execFor executes a for loop.
+func (in *interp) execFor(s *scope, stmt *ast.ForStmt) (flow, error) {
+	s = newScope(s)
+	if stmt.Init != nil {
+		if _, err := in.exec(s, stmt.Init); err != nil {
+			return flowNext, err
+		}
+	}
+	for {
+		if stmt.Cond != nil {
+			if cond, err := in.cond(s, stmt.Cond); err != nil {
+				return flowNext, err
+			} else if !cond {
+				return flowNext, nil
+			}
+		}
+		f, err := in.execBlock(s, stmt.Body.List)
+		if err != nil || f == flowBreak || f == flowReturn {
+			if f == flowBreak {
+				f = flowNext
+			}
+			return f, err
+		}
+		if stmt.Post != nil {
+			if _, err := in.exec(s, stmt.Post); err != nil {
+				return flowNext, err
+			}
+		}
+	}
+}
+
+// execRange executes a range loop over a slice, array, string, map, or integer.
+// Maps are iterated in the order of their sorted keys.
+func (in *interp) execRange(s *scope, stmt *ast.RangeStmt) (flow, error) {
+	x, err := in.eval(s, stmt.X)
+	if err != nil {
+		return flowNext, err
+	}
+	x = indirect(x)
+
+	var keys, values []reflect.Value
+	switch x.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < x.Len(); i++ {
+			keys, values = append(keys, reflect.ValueOf(i)), append(values, x.Index(i))
+		}
+	case reflect.String:
+		for i, ch := range x.String() {
+			keys, values = append(keys, reflect.ValueOf(i)), append(values, reflect.ValueOf(ch))
+		}
+	case reflect.Map:
+		keys = x.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return lessValue(keys[i], keys[j]) })
+		for _, k := range keys {
+			values = append(values, x.MapIndex(k))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		for i := int64(0); i < x.Int(); i++ {
+			keys = append(keys, reflect.ValueOf(i).Convert(x.Type()))
+		}
+	case reflect.Invalid:
+		return flowNext, nil
+	default:
+		return flowNext, in.errorf(stmt.X, "Cannot range over %s", x.Type())
+	}
+
+	for i := range keys {
+		body := newScope(s)
+		for j, expr := range []ast.Expr{stmt.Key, stmt.Value} {
+			if expr == nil {
+				continue
+			}
+			v := keys[i]
+			if j == 1 {
+				v = values[i]
+			}
+			if stmt.Tok == token.DEFINE {
+				body.define(expr.(*ast.Ident).Name, v)
+			} else if err := in.store(s, expr, v); err != nil {
+				return flowNext, err
+			}
+		}
+
+		f, err := in.execBlock(body, stmt.Body.List)
+		if err != nil || f == flowBreak || f == flowReturn {
+			if f == flowBreak {
+				f = flowNext
+			}
+			return f, err
+		}
+	}
+	return flowNext, nil
+}
+
+// execSwitch executes an expression switch statement.
+func (in *interp) execSwitch(s *scope, stmt *ast.SwitchStmt) (flow, error) {
+	s = newScope(s)
+	if stmt.Init != nil {
+		if _, err := in.exec(s, stmt.Init); err != nil {
+			return flowNext, err
+		}
+	}
+
+	tag, untypedTag := reflect.ValueOf(true), false
+	if stmt.Tag != nil {
+		v, err := in.eval(s, stmt.Tag)
+		if err != nil {
+			return flowNext, err
+		}
+		tag, untypedTag = v, isUntyped(stmt.Tag)
+	}
+
+	var body *ast.CaseClause
+	for _, clause := range stmt.Body.List {
+		clause := clause.(*ast.CaseClause)
+		if clause.List == nil {
+			body = clause
+			continue
+		}
+		var match bool
+		for _, expr := range clause.List {
+			v, err := in.eval(s, expr)
+			if err != nil {
+				return flowNext, err
+			}
+			eq, err := in.binary(expr, token.EQL, tag, v, untypedTag, isUntyped(expr))
+			if err != nil {
+				return flowNext, err
+			} else if match = eq.Bool(); match {
+				break
+			}
+		}
+		if match {
+			body = clause
+			break
+		}
+	}
+	if body == nil {
+		return flowNext, nil
+	}
+
+	for _, stmt := range body.Body {
+		if branch, ok := stmt.(*ast.BranchStmt); ok && branch.Tok == token.FALLTHROUGH {
+			return flowNext, in.errorf(stmt, "Statement is not supported by Execute: fallthrough")
+		}
+	}
+	f, err := in.execBlock(s, body.Body)
+	if f == flowBreak {
+		f = flowNext
+	}
+	return f, err
+}
+
+// assign executes an assignment or short variable declaration.
+func (in *interp) assign(s *scope, stmt *ast.AssignStmt) error {
+	// Evaluate values, including multiple values from a single call.
+	var values []reflect.Value
+	if len(stmt.Lhs) > 1 && len(stmt.Rhs) == 1 {
+		switch rhs := stmt.Rhs[0].(type) {
+		case *ast.CallExpr:
+			results, err := in.call(s, rhs)
+			if err != nil {
+				return err
+			}
+			values = results
+		case *ast.IndexExpr:
+			x, err := in.eval(s, rhs.X)
+			if err != nil {
+				return err
+			} else if x = indirect(x); x.Kind() != reflect.Map {
+				return in.errorf(rhs, "Expected map for two-value index expression, got %s", x.Type())
+			}
+			key, err := in.eval(s, rhs.Index)
+			if err != nil {
+				return err
+			} else if key, err = in.convert(rhs.Index, key, x.Type().Key()); err != nil {
+				return err
+			}
+			v := x.MapIndex(key)
+			values = []reflect.Value{v, reflect.ValueOf(v.IsValid())}
+			if !v.IsValid() {
+				values[0] = reflect.Zero(x.Type().Elem())
+			}
+		default:
+			return in.errorf(rhs, "Expression is not supported by Execute: %T", rhs)
+		}
+	} else {
+		for _, expr := range stmt.Rhs {
+			v, err := in.eval(s, expr)
+			if err != nil {
+				return err
+			}
+			values = append(values, v)
+		}
+	}
+	if len(values) != len(stmt.Lhs) {
+		return in.errorf(stmt, "Assignment mismatch: %d variables but %d values", len(stmt.Lhs), len(values))
+	}
+
+	switch stmt.Tok {
+	case token.DEFINE:
+		for i, lhs := range stmt.Lhs {
+			name := lhs.(*ast.Ident).Name
+			if v, ok := s.vars[name]; ok {
+				if err := in.setValue(lhs, v, values[i]); err != nil {
+					return err
+				}
+				continue
+			}
+			s.define(name, values[i])
+		}
+		return nil
+
+	case token.ASSIGN:
+		for i, lhs := range stmt.Lhs {
+			if err := in.store(s, lhs, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		op, ok := assignOps[stmt.Tok]
+		if !ok {
+			return in.errorf(stmt, "Operator is not supported by Execute: %s", stmt.Tok)
+		}
+		x, err := in.eval(s, stmt.Lhs[0])
+		if err != nil {
+			return err
+		}
+		v, err := in.binary(stmt, op, x, values[0], false, isUntyped(stmt.Rhs[0]))
+		if err != nil {
+			return err
+		}
+		return in.store(s, stmt.Lhs[0], v)
+	}
+}
+
+// assignOps are the binary operators of assignment operators.
+var assignOps = map[token.Token]token.Token{
+	token.ADD_ASSIGN: token.ADD, token.SUB_ASSIGN: token.SUB,
+	token.MUL_ASSIGN: token.MUL, token.QUO_ASSIGN: token.QUO,
+	token.REM_ASSIGN: token.REM, token.AND_ASSIGN: token.AND,
+	token.OR_ASSIGN: token.OR, token.XOR_ASSIGN: token.XOR,
+}
+
+// declare executes a variable declaration.
+func (in *interp) declare(s *scope, stmt *ast.DeclStmt) error {
+	decl, ok := stmt.Decl.(*ast.GenDecl)
+	if !ok || (decl.Tok != token.VAR && decl.Tok != token.CONST) {
+		return in.errorf(stmt, "Declaration is not supported by Execute: %s", decl.Tok)
+	}
+	for _, spec := range decl.Specs {
+		spec := spec.(*ast.ValueSpec)
+		if decl.Tok == token.CONST && len(spec.Values) == 0 {
+			return in.errorf(spec, "Constants without values are not supported by Execute")
+		}
+		var typ reflect.Type
+		if spec.Type != nil {
+			var err error
+			if typ, err = in.typeOf(spec.Type); err != nil {
+				return err
+			}
+		}
+		for i, name := range spec.Names {
+			v := reflect.Zero(predeclaredTypes["any"])
+			if typ != nil {
+				v = reflect.Zero(typ)
+			}
+			if i < len(spec.Values) {
+				var err error
+				if v, err = in.eval(s, spec.Values[i]); err != nil {
+					return err
+				}
+			}
+			if typ != nil {
+				var err error
+				if v, err = in.convert(spec, v, typ); err != nil {
+					return err
+				}
+			}
+			s.define(name.Name, v)
+		}
+	}
+	return nil
+}
+
+// store assigns v to the variable, field, or element expr.
+func (in *interp) store(s *scope, expr ast.Expr, v reflect.Value) error {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if expr.Name == "_" {
+			return nil
+		}
+		dst, ok := s.lookup(expr.Name)
+		if !ok {
+			return in.errorf(expr, "Undefined: %s", expr.Name)
+		}
+		return in.setValue(expr, dst, v)
+
+	case *ast.IndexExpr:
+		x, err := in.eval(s, expr.X)
+		if err != nil {
+			return err
+		}
+		key, err := in.eval(s, expr.Index)
+		if err != nil {
+			return err
+		}
+		if x = indirect(x); x.Kind() == reflect.Map {
+			if x.IsNil() {
+				return in.errorf(expr, "Assignment to entry in nil map")
+			} else if key, err = in.convert(expr.Index, key, x.Type().Key()); err != nil {
+				return err
+			} else if v, err = in.convert(expr, v, x.Type().Elem()); err != nil {
+				return err
+			}
+			x.SetMapIndex(key, v)
+			return nil
+		}
+		dst, err := in.index(expr, x, key)
+		if err != nil {
+			return err
+		}
+		return in.setValue(expr, dst, v)
+
+	case *ast.SelectorExpr, *ast.StarExpr, *ast.ParenExpr:
+		dst, err := in.eval(s, expr)
+		if err != nil {
+			return err
+		}
+		return in.setValue(expr, dst, v)
+
+	default:
+		return in.errorf(expr, "Cannot assign to expression")
+	}
+}
+
+// setValue sets dst to v, converting v to the type of dst.
+func (in *interp) setValue(node ast.Node, dst, v reflect.Value) error {
+	if !dst.CanSet() {
+		return in.errorf(node, "Cannot assign to expression")
+	}
+	v, err := in.convert(node, v, dst.Type())
+	if err != nil {
+		return err
+	}
+	dst.Set(v)
+	return nil
+}
+
+// cond evaluates a boolean condition.
+func (in *interp) cond(s *scope, expr ast.Expr) (bool, error) {
+	v, err := in.eval(s, expr)
+	if err != nil {
+		return false, err
+	} else if v.Kind() != reflect.Bool {
+		return false, in.errorf(expr, "Expected boolean condition, got %s", typeString(v))
+	}
+	return v.Bool(), nil
+}
+
+// eval evaluates an expression with a single value.
+func (in *interp) eval(s *scope, expr ast.Expr) (reflect.Value, error) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		return in.literal(expr)
+
+	case *ast.Ident:
+		switch expr.Name {
+		case "true", "false":
+			return reflect.ValueOf(expr.Name == "true"), nil
+		case "nil":
+			return reflect.Value{}, nil
+		}
+		if v, ok := s.lookup(expr.Name); ok {
+			return v, nil
+		} else if fn, ok := in.fn(expr.Name); ok {
+			return fn, nil
+		}
+		return reflect.Value{}, in.errorf(expr, "Undefined: %s", expr.Name)
+
+	case *ast.ParenExpr:
+		return in.eval(s, expr.X)
+
+	case *ast.SelectorExpr:
+		// Package functions are identified by their qualified name.
+		if ident, ok := expr.X.(*ast.Ident); ok {
+			if _, ok := s.lookup(ident.Name); !ok {
+				if fn, ok := in.fn(ident.Name + "." + expr.Sel.Name); ok {
+					return fn, nil
+				}
+				return reflect.Value{}, in.errorf(expr, "Undefined: %s.%s", ident.Name, expr.Sel.Name)
+			}
+		}
+		x, err := in.eval(s, expr.X)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return in.selector(expr, x, expr.Sel.Name)
+
+	case *ast.IndexExpr:
+		x, err := in.eval(s, expr.X)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		key, err := in.eval(s, expr.Index)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if x = indirect(x); x.Kind() == reflect.Map {
+			if key, err = in.convert(expr.Index, key, x.Type().Key()); err != nil {
+				return reflect.Value{}, err
+			} else if v := x.MapIndex(key); v.IsValid() {
+				return v, nil
+			}
+			return reflect.Zero(x.Type().Elem()), nil
+		}
+		return in.index(expr, x, key)
+
+	case *ast.SliceExpr:
+		return in.slice(s, expr)
+
+	case *ast.StarExpr:
+		x, err := in.eval(s, expr.X)
+		if err != nil {
+			return reflect.Value{}, err
+		} else if x.Kind() != reflect.Ptr {
+			return reflect.Value{}, in.errorf(expr, "Cannot dereference %s", typeString(x))
+		} else if x.IsNil() {
+			return reflect.Value{}, in.errorf(expr, "Nil pointer dereference")
+		}
+		return x.Elem(), nil
+
+	case *ast.UnaryExpr:
+		return in.unary(s, expr)
+
+	case *ast.BinaryExpr:
+		// Logical operators are evaluated lazily.
+		if expr.Op == token.LAND || expr.Op == token.LOR {
+			x, err := in.cond(s, expr.X)
+			if err != nil || x == (expr.Op == token.LOR) {
+				return reflect.ValueOf(x), err
+			}
+			y, err := in.cond(s, expr.Y)
+			return reflect.ValueOf(y), err
+		}
+
+		x, err := in.eval(s, expr.X)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		y, err := in.eval(s, expr.Y)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return in.binary(expr, expr.Op, x, y, isUntyped(expr.X), isUntyped(expr.Y))
+
+	case *ast.CallExpr:
+		results, err := in.call(s, expr)
+		if err != nil {
+			return reflect.Value{}, err
+		} else if len(results) != 1 {
+			return reflect.Value{}, in.errorf(expr, "Expected single value from call, got %d", len(results))
+		}
+		return results[0], nil
+
+	case *ast.CompositeLit:
+		return in.compositeLit(s, expr, nil)
+
+	case *ast.FuncLit:
+		return reflect.ValueOf(&closure{name: "function literal", typ: expr.Type, body: expr.Body, s: s}), nil
+
+	case *ast.TypeAssertExpr:
+		return reflect.Value{}, in.errorf(expr, "Type assertions are not supported by Execute")
+
+	default:
+		return reflect.Value{}, in.errorf(expr, "Expression is not supported by Execute: %T", expr)
+	}
+}
+
+// compositeLit evaluates a slice, array, or map literal. The type of literals
+// within another literal may be elided, in which case it is typ.
+func (in *interp) compositeLit(s *scope, lit *ast.CompositeLit, typ reflect.Type) (reflect.Value, error) {
+	if lit.Type != nil {
+		// The length of an array literal may be the number of its elements.
+		if arr, ok := lit.Type.(*ast.ArrayType); ok {
+			if _, ok := arr.Len.(*ast.Ellipsis); ok {
+				elem, err := in.typeOf(arr.Elt)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				typ = reflect.ArrayOf(len(lit.Elts), elem)
+			}
+		}
+		if typ == nil {
+			var err error
+			if typ, err = in.typeOf(lit.Type); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+	} else if typ == nil {
+		return reflect.Value{}, in.errorf(lit, "Missing type in composite literal")
+	}
+
+	switch typ.Kind() {
+	case reflect.Ptr:
+		v, err := in.compositeLit(s, lit, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(typ.Elem())
+		p.Elem().Set(v)
+		return p, nil
+
+	case reflect.Slice, reflect.Array:
+		v := reflect.New(typ).Elem()
+		if typ.Kind() == reflect.Slice {
+			v = reflect.MakeSlice(typ, len(lit.Elts), len(lit.Elts))
+		} else if len(lit.Elts) > typ.Len() {
+			return reflect.Value{}, in.errorf(lit, "Array index %d out of bounds [0:%d]", typ.Len(), typ.Len())
+		}
+		for i, elt := range lit.Elts {
+			if _, ok := elt.(*ast.KeyValueExpr); ok {
+				return reflect.Value{}, in.errorf(elt, "Indexed elements are not supported by Execute")
+			}
+			x, err := in.element(s, elt, typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.Index(i).Set(x)
+		}
+		return v, nil
+
+	case reflect.Map:
+		v := reflect.MakeMapWithSize(typ, len(lit.Elts))
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				return reflect.Value{}, in.errorf(elt, "Missing key in map literal")
+			}
+			key, err := in.element(s, kv.Key, typ.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			x, err := in.element(s, kv.Value, typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.SetMapIndex(key, x)
+		}
+		return v, nil
+
+	default:
+		return reflect.Value{}, in.errorf(lit, "Invalid composite literal type %s", typ)
+	}
+}
+
+// element evaluates an element of a composite literal as typ.
+func (in *interp) element(s *scope, expr ast.Expr, typ reflect.Type) (reflect.Value, error) {
+	if lit, ok := expr.(*ast.CompositeLit); ok && lit.Type == nil {
+		return in.compositeLit(s, lit, typ)
+	}
+	v, err := in.eval(s, expr)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return in.convert(expr, v, typ)
+}
+
+// literal evaluates a basic literal as an untyped constant of its default type.
+func (in *interp) literal(lit *ast.BasicLit) (reflect.Value, error) {
+	switch lit.Kind {
+	case token.INT:
+		i, err := strconv.ParseInt(lit.Value, 0, 64)
+		if err != nil {
+			return reflect.Value{}, in.errorf(lit, "Invalid integer: %s", lit.Value)
+		}
+		return reflect.ValueOf(int(i)), nil
+	case token.FLOAT:
+		f, err := strconv.ParseFloat(lit.Value, 64)
+		if err != nil {
+			return reflect.Value{}, in.errorf(lit, "Invalid float: %s", lit.Value)
+		}
+		return reflect.ValueOf(f), nil
+	case token.CHAR:
+		ch, _, _, err := strconv.UnquoteChar(lit.Value[1:len(lit.Value)-1], '\'')
+		if err != nil {
+			return reflect.Value{}, in.errorf(lit, "Invalid character: %s", lit.Value)
+		}
+		return reflect.ValueOf(ch), nil
+	case token.STRING:
+		str, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return reflect.Value{}, in.errorf(lit, "Invalid string: %s", lit.Value)
+		}
+		return reflect.ValueOf(str), nil
+	default:
+		return reflect.Value{}, in.errorf(lit, "Literal is not supported by Execute: %s", lit.Value)
+	}
+}
+
+// fn returns the function declared by the template or registered with name.
+func (in *interp) fn(name string) (reflect.Value, bool) {
+	if c, ok := in.funcs[name]; ok {
+		return reflect.ValueOf(c), true
+	} else if fn, ok := in.t.Funcs[name]; ok {
+		return reflect.ValueOf(fn), true
+	} else if fn, ok := DefaultFuncs[name]; ok {
+		return reflect.ValueOf(fn), true
+	}
+	return reflect.Value{}, false
+}
+
+// selector returns the field or method name of x.
+func (in *interp) selector(node ast.Node, x reflect.Value, name string) (reflect.Value, error) {
+	if !x.IsValid() {
+		return reflect.Value{}, in.errorf(node, "Nil pointer dereference")
+	}
+	if m := x.MethodByName(name); m.IsValid() {
+		return m, nil
+	} else if x.Kind() != reflect.Ptr && x.CanAddr() {
+		if m := x.Addr().MethodByName(name); m.IsValid() {
+			return m, nil
+		}
+	}
+
+	for x.Kind() == reflect.Ptr || x.Kind() == reflect.Interface {
+		if x.IsNil() {
+			return reflect.Value{}, in.errorf(node, "Nil pointer dereference")
+		}
+		x = x.Elem()
+	}
+	if x.Kind() == reflect.Struct {
+		if field, ok := x.Type().FieldByName(name); ok && field.PkgPath == "" {
+			return x.FieldByIndex(field.Index), nil
+		}
+	}
+	return reflect.Value{}, in.errorf(node, "Undefined field or method on %s: %s", x.Type(), name)
+}
+
+// index returns the element of the slice, array, or string x at index key.
+func (in *interp) index(node ast.Node, x, key reflect.Value) (reflect.Value, error) {
+	switch x.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String:
+	default:
+		return reflect.Value{}, in.errorf(node, "Cannot index %s", typeString(x))
+	}
+	i, ok := intValue(key)
+	if !ok {
+		return reflect.Value{}, in.errorf(node, "Expected integer index, got %s", typeString(key))
+	} else if i < 0 || i >= int64(x.Len()) {
+		return reflect.Value{}, in.errorf(node, "Index out of range [%d] with length %d", i, x.Len())
+	}
+	return x.Index(int(i)), nil
+}
+
+// slice evaluates a slice expression.
+func (in *interp) slice(s *scope, expr *ast.SliceExpr) (reflect.Value, error) {
+	if expr.Slice3 {
+		return reflect.Value{}, in.errorf(expr, "Expression is not supported by Execute: full slice expression")
+	}
+	x, err := in.eval(s, expr.X)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	x = indirect(x)
+	switch x.Kind() {
+	case reflect.Slice, reflect.String:
+	case reflect.Array:
+		if !x.CanAddr() {
+			return reflect.Value{}, in.errorf(expr, "Cannot slice unaddressable array")
+		}
+	default:
+		return reflect.Value{}, in.errorf(expr, "Cannot slice %s", typeString(x))
+	}
+
+	bounds := []int{0, x.Len()}
+	for i, e := range []ast.Expr{expr.Low, expr.High} {
+		if e == nil {
+			continue
+		}
+		v, err := in.eval(s, e)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		n, ok := intValue(v)
+		if !ok {
+			return reflect.Value{}, in.errorf(e, "Expected integer index, got %s", typeString(v))
+		}
+		bounds[i] = int(n)
+	}
+	if bounds[0] < 0 || bounds[0] > bounds[1] || bounds[1] > x.Len() {
+		return reflect.Value{}, in.errorf(expr, "Slice bounds out of range [%d:%d] with length %d", bounds[0], bounds[1], x.Len())
+	}
+	return x.Slice(bounds[0], bounds[1]), nil
+}
+
+// unary evaluates a unary expression.
+func (in *interp) unary(s *scope, expr *ast.UnaryExpr) (reflect.Value, error) {
+	x, err := in.eval(s, expr.X)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	switch expr.Op {
+	case token.NOT:
+		if x.Kind() == reflect.Bool {
+			return reflect.ValueOf(!x.Bool()), nil
+		}
+	case token.ADD:
+		if isNumber(x) {
+			return x, nil
+		}
+	case token.SUB:
+		if isNumber(x) {
+			return in.binary(expr, token.SUB, reflect.Zero(x.Type()), x, false, false)
+		}
+	case token.AND:
+		if x.CanAddr() {
+			return x.Addr(), nil
+		}
+		return reflect.Value{}, in.errorf(expr, "Cannot take address of expression")
+	}
+	return reflect.Value{}, in.errorf(expr, "Invalid operation: %s%s", expr.Op, typeString(x))
+}
+
+// binary evaluates a binary operation. An untyped operand, such as a literal,
+// is converted to the type of the other operand.
+func (in *interp) binary(node ast.Node, op token.Token, x, y reflect.Value, untypedX, untypedY bool) (reflect.Value, error) {
+	// Comparisons with nil.
+	if !x.IsValid() || !y.IsValid() {
+		if op != token.EQL && op != token.NEQ {
+			return reflect.Value{}, in.errorf(node, "Invalid operation on nil: %s", op)
+		}
+		eq := isNil(x) && isNil(y)
+		return reflect.ValueOf(eq == (op == token.EQL)), nil
+	}
+
+	var err error
+	if x.Type() != y.Type() {
+		if untypedY && !untypedX {
+			y, err = in.convert(node, y, x.Type())
+		} else if untypedX && !untypedY {
+			x, err = in.convert(node, x, y.Type())
+		} else if untypedX && untypedY && isNumber(x) && isNumber(y) {
+			x, y = x.Convert(reflect.TypeOf(float64(0))), y.Convert(reflect.TypeOf(float64(0)))
+		} else if op == token.EQL || op == token.NEQ {
+			if x.Kind() == reflect.Interface || y.Kind() == reflect.Interface || x.Type().AssignableTo(y.Type()) || y.Type().AssignableTo(x.Type()) {
+				return in.compare(node, op, x, y)
+			}
+			err = in.errorf(node, "Mismatched types %s and %s", x.Type(), y.Type())
+		} else {
+			err = in.errorf(node, "Mismatched types %s and %s", x.Type(), y.Type())
+		}
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	switch op {
+	case token.EQL, token.NEQ:
+		return in.compare(node, op, x, y)
+	}
+
+	typ := x.Type()
+	switch x.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		a, b := x.Int(), y.Int()
+		switch op {
+		case token.ADD:
+			return reflect.ValueOf(a + b).Convert(typ), nil
+		case token.SUB:
+			return reflect.ValueOf(a - b).Convert(typ), nil
+		case token.MUL:
+			return reflect.ValueOf(a * b).Convert(typ), nil
+		case token.QUO, token.REM:
+			if b == 0 {
+				return reflect.Value{}, in.errorf(node, "Integer divide by zero")
+			} else if op == token.QUO {
+				return reflect.ValueOf(a / b).Convert(typ), nil
+			}
+			return reflect.ValueOf(a % b).Convert(typ), nil
+		case token.AND:
+			return reflect.ValueOf(a & b).Convert(typ), nil
+		case token.OR:
+			return reflect.ValueOf(a | b).Convert(typ), nil
+		case token.XOR:
+			return reflect.ValueOf(a ^ b).Convert(typ), nil
+		case token.LSS, token.GTR, token.LEQ, token.GEQ:
+			return reflect.ValueOf(compareOrdered(op, a < b, a > b)), nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		a, b := x.Uint(), y.Uint()
+		switch op {
+		case token.ADD:
+			return reflect.ValueOf(a + b).Convert(typ), nil
+		case token.SUB:
+			return reflect.ValueOf(a - b).Convert(typ), nil
+		case token.MUL:
+			return reflect.ValueOf(a * b).Convert(typ), nil
+		case token.QUO, token.REM:
+			if b == 0 {
+				return reflect.Value{}, in.errorf(node, "Integer divide by zero")
+			} else if op == token.QUO {
+				return reflect.ValueOf(a / b).Convert(typ), nil
+			}
+			return reflect.ValueOf(a % b).Convert(typ), nil
+		case token.AND:
+			return reflect.ValueOf(a & b).Convert(typ), nil
+		case token.OR:
+			return reflect.ValueOf(a | b).Convert(typ), nil
+		case token.XOR:
+			return reflect.ValueOf(a ^ b).Convert(typ), nil
+		case token.LSS, token.GTR, token.LEQ, token.GEQ:
+			return reflect.ValueOf(compareOrdered(op, a < b, a > b)), nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		a, b := x.Float(), y.Float()
+		switch op {
+		case token.ADD:
+			return reflect.ValueOf(a + b).Convert(typ), nil
+		case token.SUB:
+			return reflect.ValueOf(a - b).Convert(typ), nil
+		case token.MUL:
+			return reflect.ValueOf(a * b).Convert(typ), nil
+		case token.QUO:
+			return reflect.ValueOf(a / b).Convert(typ), nil
+		case token.LSS, token.GTR, token.LEQ, token.GEQ:
+			return reflect.ValueOf(compareOrdered(op, a < b, a > b)), nil
+		}
+
+	case reflect.String:
+		a, b := x.String(), y.String()
+		switch op {
+		case token.ADD:
+			return reflect.ValueOf(a + b).Convert(typ), nil
+		case token.LSS, token.GTR, token.LEQ, token.GEQ:
+			return reflect.ValueOf(compareOrdered(op, a < b, a > b)), nil
+		}
+	}
+	return reflect.Value{}, in.errorf(node, "Invalid operation: %s %s %s", typ, op, typ)
+}
+
+// compare returns whether x & y are equal, or not equal for NEQ.
+func (in *interp) compare(node ast.Node, op token.Token, x, y reflect.Value) (reflect.Value, error) {
+	a, b := x.Interface(), y.Interface()
+	if a != nil && !reflect.TypeOf(a).Comparable() {
+		return reflect.Value{}, in.errorf(node, "Cannot compare %s", reflect.TypeOf(a))
+	} else if b != nil && !reflect.TypeOf(b).Comparable() {
+		return reflect.Value{}, in.errorf(node, "Cannot compare %s", reflect.TypeOf(b))
+	}
+	return reflect.ValueOf((a == b) == (op == token.EQL)), nil
+}
+
+// compareOrdered returns the result of an ordered comparison operator given
+// whether the operands are less or greater than each other.
+func compareOrdered(op token.Token, less, greater bool) bool {
+	switch op {
+	case token.LSS:
+		return less
+	case token.GTR:
+		return greater
+	case token.LEQ:
+		return !greater
+	default:
+		return !less
+	}
+}
+
+// call evaluates a call expression and returns its results.
+func (in *interp) call(s *scope, expr *ast.CallExpr) ([]reflect.Value, error) {
+	if ident, ok := expr.Fun.(*ast.Ident); ok {
+		if _, ok := s.lookup(ident.Name); !ok {
+			if results, ok, err := in.builtin(s, ident.Name, expr); ok {
+				return results, err
+			}
+		}
+	}
+
+	// Conversions to composite types, such as []byte(s).
+	switch expr.Fun.(type) {
+	case *ast.ArrayType, *ast.MapType, *ast.FuncType, *ast.InterfaceType:
+		typ, err := in.typeOf(expr.Fun)
+		if err != nil {
+			return nil, err
+		}
+		v, err := in.conversion(s, expr, typ)
+		return []reflect.Value{v}, err
+	}
+
+	fn, err := in.eval(s, expr.Fun)
+	if err != nil {
+		return nil, err
+	} else if !fn.IsValid() {
+		return nil, in.errorf(expr, "Call of nil function")
+	}
+	args := make([]reflect.Value, len(expr.Args))
+	for i, arg := range expr.Args {
+		if args[i], err = in.eval(s, arg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Call functions declared by the template & function literals.
+	if c, ok := fn.Interface().(*closure); ok {
+		return in.callClosure(expr, c, args)
+	}
+
+	if fn.Kind() != reflect.Func {
+		return nil, in.errorf(expr, "Cannot call non-function %s", typeString(fn))
+	} else if fn.IsNil() {
+		return nil, in.errorf(expr, "Call of nil function")
+	}
+
+	// Convert arguments to the parameter types.
+	typ := fn.Type()
+	if expr.Ellipsis.IsValid() {
+		if !typ.IsVariadic() || len(args) != typ.NumIn() {
+			return nil, in.errorf(expr, "Invalid use of ... in call")
+		}
+		for i := range args {
+			if args[i], err = in.convert(expr.Args[i], args[i], typ.In(i)); err != nil {
+				return nil, err
+			}
+		}
+		return in.callFunc(expr, fn, args, true)
+	}
+	if n := typ.NumIn(); len(args) < n-1 || (!typ.IsVariadic() && len(args) != n) {
+		return nil, in.errorf(expr, "Wrong number of arguments in call: got %d, want %d", len(args), n)
+	}
+	for i := range args {
+		var ptyp reflect.Type
+		if typ.IsVariadic() && i >= typ.NumIn()-1 {
+			ptyp = typ.In(typ.NumIn() - 1).Elem()
+		} else {
+			ptyp = typ.In(i)
+		}
+		if args[i], err = in.convert(expr.Args[i], args[i], ptyp); err != nil {
+			return nil, err
+		}
+	}
+	return in.callFunc(expr, fn, args, false)
+}
+
+// callFunc calls a Go function with args, which are passed as a slice to a
+// variadic function if slice is true. Runtime panics of the function, such as
+// a method called on a nil pointer, are returned as errors at node. Errors of
+// closures called by the function are returned as-is.
+func (in *interp) callFunc(node ast.Node, fn reflect.Value, args []reflect.Value, slice bool) (results []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if p, ok := r.(execPanic); ok {
+				err = p.err
+			} else {
+				err = in.errorf(node, "Panic in call: %v", r)
+			}
+		}
+	}()
+	if slice {
+		return fn.CallSlice(args), nil
+	}
+	return fn.Call(args), nil
+}
+
+// closure is a function declared by the template or a function literal with
+// the scope it was declared in.
+type closure struct {
+	name string
+	typ  *ast.FuncType
+	body *ast.BlockStmt
+	s    *scope
+}
+
+// callClosure calls a function declared by the template or a function literal.
+func (in *interp) callClosure(node ast.Node, c *closure, args []reflect.Value) ([]reflect.Value, error) {
+	s := newScope(c.s)
+	var i int
+	for _, field := range c.typ.Params.List {
+		if _, ok := field.Type.(*ast.Ellipsis); ok {
+			return nil, in.errorf(node, "Variadic functions are not supported by Execute: %s", c.name)
+		}
+		names := field.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{{Name: "_"}}
+		}
+		for _, name := range names {
+			if i >= len(args) {
+				return nil, in.errorf(node, "Not enough arguments in call to %s", c.name)
+			}
+			s.define(name.Name, args[i])
+			i++
+		}
+	}
+	if i != len(args) {
+		return nil, in.errorf(node, "Too many arguments in call to %s", c.name)
+	}
+
+	in.ret = nil
+	if _, err := in.execBlock(s, c.body.List); err != nil {
+		return nil, err
+	}
+	ret := in.ret
+	in.ret = nil
+	return ret, nil
+}
+
+// makeFunc returns a function of type typ that calls c so that closures can be
+// passed to Go functions and set to the closure fields of components. Errors
+// are panicked and recovered by Execute.
+func (in *interp) makeFunc(node ast.Node, c *closure, typ reflect.Type) reflect.Value {
+	return reflect.MakeFunc(typ, func(args []reflect.Value) []reflect.Value {
+		results, err := in.callClosure(node, c, args)
+		if err != nil {
+			panic(execPanic{err})
+		}
+
+		// Results that are not returned, such as the nil error of a closure
+		// of a component, are zero.
+		out := make([]reflect.Value, typ.NumOut())
+		for i := range out {
+			if i >= len(results) {
+				out[i] = reflect.Zero(typ.Out(i))
+			} else if out[i], err = in.convert(node, results[i], typ.Out(i)); err != nil {
+				panic(execPanic{err})
+			}
+		}
+		return out
+	})
+}
+
+// execPanic holds an error returned by panicking from a closure called by Go.
+type execPanic struct {
+	err error
+}
+
+// builtin evaluates a call to a builtin function, a conversion to a basic type,
+// or the output functions of the template source. Returns false if name is
+// not a builtin.
+func (in *interp) builtin(s *scope, name string, expr *ast.CallExpr) ([]reflect.Value, bool, error) {
+	args := make([]reflect.Value, len(expr.Args))
+	eval := func() error {
+		for i, arg := range expr.Args {
+			v, err := in.eval(s, arg)
+			if err != nil {
+				return err
+			}
+			args[i] = v
+		}
+		return nil
+	}
+
+	switch name {
+	case "EGO_TEXT":
+		i, _ := strconv.Atoi(expr.Args[0].(*ast.BasicLit).Value)
+		return []reflect.Value{reflect.ValueOf(in.text[i])}, true, nil
+
+	case "EGO_COMPONENT":
+		name, _ := strconv.Unquote(expr.Args[0].(*ast.BasicLit).Value)
+		registry.mu.RLock()
+		fn := registry.m[name]
+		registry.mu.RUnlock()
+		if fn == nil {
+			return nil, true, in.errorf(expr, "Component not registered: %s", name)
+		}
+		return []reflect.Value{reflect.ValueOf(fn())}, true, nil
+
+	case "EGO_RENDER":
+		if err := eval(); err != nil {
+			return nil, true, err
+		}
+		render, err := in.selector(expr, args[0], "Render")
+		if err != nil {
+			return nil, true, err
+		} else if typ := render.Type(); typ.NumIn() != 2 || typ.NumOut() > 1 {
+			return nil, true, in.errorf(expr, "Invalid Render method of component: %s", typ)
+		}
+		results, err := in.callFunc(expr, render, args[1:], false)
+		if err != nil {
+			return nil, true, err
+		}
+		var v error
+		if len(results) == 1 && !results[0].IsNil() {
+			if err, ok := results[0].Interface().(error); ok && err != ErrSkip {
+				v = err
+			}
+		}
+		return []reflect.Value{reflect.ValueOf(&v).Elem()}, true, nil
+
+	case "EGO_FORMAT":
+		format, _ := strconv.Unquote(expr.Args[0].(*ast.BasicLit).Value)
+		return []reflect.Value{reflect.ValueOf(ContextFormat(in.ctx) == format)}, true, nil
+
+	case "len", "cap":
+		if len(expr.Args) != 1 {
+			return nil, true, in.errorf(expr, "Expected 1 argument to %s, got %d", name, len(expr.Args))
+		} else if err := eval(); err != nil {
+			return nil, true, err
+		}
+		x := indirect(args[0])
+		switch x.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Chan:
+		case reflect.String, reflect.Map:
+			if name == "len" {
+				break
+			}
+			fallthrough
+		default:
+			return nil, true, in.errorf(expr, "Invalid argument for %s: %s", name, typeString(args[0]))
+		}
+		if name == "cap" {
+			return []reflect.Value{reflect.ValueOf(x.Cap())}, true, nil
+		}
+		return []reflect.Value{reflect.ValueOf(x.Len())}, true, nil
+
+	case "append":
+		if len(expr.Args) == 0 {
+			return nil, true, in.errorf(expr, "Missing arguments to append")
+		} else if err := eval(); err != nil {
+			return nil, true, err
+		}
+		x := args[0]
+		if x.Kind() != reflect.Slice {
+			return nil, true, in.errorf(expr, "Invalid argument for append: %s", typeString(x))
+		}
+		if expr.Ellipsis.IsValid() {
+			y, err := in.convert(expr.Args[1], args[1], x.Type())
+			if err != nil {
+				return nil, true, err
+			}
+			return []reflect.Value{reflect.AppendSlice(x, y)}, true, nil
+		}
+		for i := 1; i < len(args); i++ {
+			v, err := in.convert(expr.Args[i], args[i], x.Type().Elem())
+			if err != nil {
+				return nil, true, err
+			}
+			x = reflect.Append(x, v)
+		}
+		return []reflect.Value{x}, true, nil
+	}
+
+	// Conversions to predeclared types.
+	if typ, ok := predeclaredTypes[name]; ok {
+		v, err := in.conversion(s, expr, typ)
+		return []reflect.Value{v}, true, err
+	}
+	return nil, false, nil
+}
+
+// conversion evaluates the conversion of the argument of expr to typ.
+func (in *interp) conversion(s *scope, expr *ast.CallExpr, typ reflect.Type) (reflect.Value, error) {
+	if len(expr.Args) != 1 {
+		return reflect.Value{}, in.errorf(expr, "Expected 1 argument to conversion, got %d", len(expr.Args))
+	}
+	x, err := in.eval(s, expr.Args[0])
+	if err != nil {
+		return reflect.Value{}, err
+	} else if !x.IsValid() {
+		return in.convert(expr, x, typ)
+	} else if c, ok := x.Interface().(*closure); ok && typ.Kind() == reflect.Func {
+		return in.makeFunc(expr, c, typ), nil
+	} else if !x.Type().ConvertibleTo(typ) {
+		return reflect.Value{}, in.errorf(expr, "Cannot convert %s to %s", typeString(x), typ)
+	}
+	return x.Convert(typ), nil
+}
+
+// convert converts v to typ. Nil is converted to the zero value of types that
+// can be nil, numbers are converted between numeric types, and closures are
+// converted to functions.
+func (in *interp) convert(node ast.Node, v reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	if !v.IsValid() {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(typ), nil
+		}
+	} else if c, ok := v.Interface().(*closure); ok && typ.Kind() == reflect.Func {
+		return in.makeFunc(node, c, typ), nil
+	} else if v.Type().AssignableTo(typ) {
+		return v, nil
+	} else if isNumber(v) && isNumberType(typ) {
+		return v.Convert(typ), nil
+	} else if v.Kind() == reflect.String && typ.Kind() == reflect.String {
+		return v.Convert(typ), nil
+	}
+	return reflect.Value{}, in.errorf(node, "Cannot use %s as %s", typeString(v), typ)
+}
+
+// isUntyped returns true if expr is a literal constant, which is converted to
+// the type of the other operand of a binary expression.
+func isUntyped(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isUntyped(expr.X)
+	case *ast.UnaryExpr:
+		return expr.Op == token.SUB && isUntyped(expr.X)
+	default:
+		return false
+	}
+}
+
+// predeclaredTypes are the types of the predeclared type names.
+var predeclaredTypes = map[string]reflect.Type{
+	"error": reflect.TypeOf((*error)(nil)).Elem(), "any": reflect.TypeOf((*interface{})(nil)).Elem(),
+	"bool": reflect.TypeOf(false), "string": reflect.TypeOf(""),
+	"int": reflect.TypeOf(int(0)), "int8": reflect.TypeOf(int8(0)),
+	"int16": reflect.TypeOf(int16(0)), "int32": reflect.TypeOf(int32(0)),
+	"int64": reflect.TypeOf(int64(0)), "uint": reflect.TypeOf(uint(0)),
+	"uint8": reflect.TypeOf(uint8(0)), "uint16": reflect.TypeOf(uint16(0)),
+	"uint32": reflect.TypeOf(uint32(0)), "uint64": reflect.TypeOf(uint64(0)),
+	"float32": reflect.TypeOf(float32(0)), "float64": reflect.TypeOf(float64(0)),
+	"byte": reflect.TypeOf(byte(0)), "rune": reflect.TypeOf(rune(0)),
+}
+
+// typeOf returns the type of a type expression. Only predeclared types and
+// the composite types of them are known to the interpreter.
+func (in *interp) typeOf(expr ast.Expr) (reflect.Type, error) {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if typ, ok := predeclaredTypes[expr.Name]; ok {
+			return typ, nil
+		}
+
+	case *ast.ParenExpr:
+		return in.typeOf(expr.X)
+
+	case *ast.StarExpr:
+		elem, err := in.typeOf(expr.X)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.PtrTo(elem), nil
+
+	case *ast.ArrayType:
+		elem, err := in.typeOf(expr.Elt)
+		if err != nil {
+			return nil, err
+		} else if expr.Len == nil {
+			return reflect.SliceOf(elem), nil
+		}
+		if lit, ok := expr.Len.(*ast.BasicLit); ok && lit.Kind == token.INT {
+			if n, err := strconv.Atoi(lit.Value); err == nil {
+				return reflect.ArrayOf(n, elem), nil
+			}
+		}
+
+	case *ast.MapType:
+		key, err := in.typeOf(expr.Key)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := in.typeOf(expr.Value)
+		if err != nil {
+			return nil, err
+		} else if !key.Comparable() {
+			return nil, in.errorf(expr, "Invalid map key type %s", key)
+		}
+		return reflect.MapOf(key, elem), nil
+
+	case *ast.InterfaceType:
+		if len(expr.Methods.List) == 0 {
+			return predeclaredTypes["any"], nil
+		}
+
+	case *ast.FuncType:
+		var params, results []reflect.Type
+		var variadic bool
+		for i, list := range []*ast.FieldList{expr.Params, expr.Results} {
+			if list == nil {
+				continue
+			}
+			for _, field := range list.List {
+				ftyp := field.Type
+				if ellipsis, ok := ftyp.(*ast.Ellipsis); ok {
+					ftyp, variadic = &ast.ArrayType{Elt: ellipsis.Elt}, true
+				}
+				typ, err := in.typeOf(ftyp)
+				if err != nil {
+					return nil, err
+				}
+				for n := 0; n < len(field.Names) || n == 0; n++ {
+					if i == 0 {
+						params = append(params, typ)
+					} else {
+						results = append(results, typ)
+					}
+				}
+			}
+		}
+		return reflect.FuncOf(params, results, variadic), nil
+	}
+	return nil, in.errorf(expr, "Type is not supported by Execute: %s", types.ExprString(expr))
+}
+
+// indirect dereferences pointers & interfaces until a non-nil value is found.
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// isNil returns true if v is nil or a value of a type that can be nil.
+func isNil(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isNumber returns true if v is an integer or floating-point number.
+func isNumber(v reflect.Value) bool {
+	return v.IsValid() && isNumberType(v.Type())
+}
+
+func isNumberType(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// intValue returns the value of an integer.
+func intValue(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint()), true
+	}
+	return 0, false
+}
+
+// lessValue orders map keys of the same basic kind. Other keys are ordered by
+// their formatted value.
+func lessValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	}
+	return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
+
+// typeString returns the type of v for error messages.
+func typeString(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
@@ -0,0 +1,303 @@
+package ego_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+type execUser struct {
+	Name  string
+	Admin bool
+}
+
+type execPage struct {
+	Title string
+	Users []*execUser
+	Tags  map[string]int
+}
+
+func (p *execPage) Count() int { return len(p.Users) }
+
+// ExecCard is a component rendered by Execute.
+type ExecCard struct {
+	Title  string
+	Attrs  map[string]string
+	Header func()
+	Yield  func()
+}
+
+func (c *ExecCard) Render(ctx context.Context, w io.Writer) {
+	fmt.Fprintf(w, "<div class=%q>", c.Attrs["class"])
+	if c.Header != nil {
+		c.Header()
+	}
+	fmt.Fprintf(w, "[%s]", c.Title)
+	if c.Yield != nil {
+		c.Yield()
+	}
+	fmt.Fprint(w, "</div>")
+}
+
+func init() {
+	ego.Register("ExecCard", func() ego.Component { return &ExecCard{} })
+}
+
+// Ensure that a template can be rendered without generating code.
+func TestTemplate_Execute(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(`<%
+package main
+
+import "strings"
+
+func label(u *User) string {
+	if u.Admin {
+		return u.Name + " (admin)"
+	}
+	return u.Name
+}
+
+func (p *Page) Render(ctx context.Context, w io.Writer) {
+	total := 0
+%><h1><%= strings.ToUpper(p.Title) %></h1>
+<% for i, u := range p.Users { total += i %><li><%= label(u) %></li><% } %>
+<% switch n := p.Count(); { case n > 1: %>many<% default: %>few<% } %> <%= total %>
+<% for k, v := range p.Tags { if v == 0 { continue } %><%== k %>=<%= v * 2 %>;<% } %>
+<% } %>`), "page.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(context.Background(), &buf, &execPage{
+		Title: "<Users>",
+		Users: []*execUser{{Name: "bob"}, {Name: "<alice>", Admin: true}, {Name: "eve"}},
+		Tags:  map[string]int{"b": 2, "a": 1, "c": 0},
+	}); err != nil {
+		t.Fatal(err)
+	} else if exp := "<h1>&lt;USERS&gt;</h1>\n<li>bob</li><li>&lt;alice&gt; (admin)</li><li>eve</li>\nmany 3\na=2;b=4;\n"; buf.String() != exp {
+		t.Fatalf("unexpected output:\n%s\n\nexpected:\n%s", buf.String(), exp)
+	}
+}
+
+// Ensure that multiple parameters are bound by name and functions can be
+// registered on the template.
+func TestTemplate_Execute_Params(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(`<%
+package main
+
+func Render(ctx context.Context, w io.Writer, name string, n int64) error {
+	if n < 0 {
+		return fail(name)
+	}
+%><%= greet(name) %> x<%= n + 1 %><% return nil } %>`), "greet.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Funcs = map[string]interface{}{
+		"greet": func(s string) string { return "Hello, " + s },
+		"fail":  func(s string) error { return errors.New("failed: " + s) },
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(context.Background(), &buf, map[string]interface{}{"name": "bob", "n": int64(2)}); err != nil {
+		t.Fatal(err)
+	} else if exp := "Hello, bob x3"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+
+	if err := tmpl.Execute(context.Background(), &buf, map[string]interface{}{"name": "bob", "n": int64(-1)}); err == nil || err.Error() != "failed: bob" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that the render function can be declared with a function directive.
+func TestTemplate_Execute_FuncDirective(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% package main %>\n<%: func Render(ctx context.Context, w io.Writer, name string) error %>\nHello, <%= name %>!\n"), "greet.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(context.Background(), &buf, "<bob>"); err != nil {
+		t.Fatal(err)
+	} else if exp := "Hello, &lt;bob&gt;!\n"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
+
+// Ensure that unsupported code & runtime errors are reported at their
+// template positions.
+func TestTemplate_Execute_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		src, err string
+	}{
+		{
+			src: "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>\n<ego:Card/>\n<% } %>",
+			err: "Component not registered: Card at tmpl.ego:6",
+		},
+		{
+			src: "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>\n<%= missing %>\n<% } %>",
+			err: "Undefined: missing at tmpl.ego:6",
+		},
+		{
+			src: "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n\tx := struct{}{}\n%><%= x %><% } %>",
+			err: "Type is not supported by Execute: struct{} at tmpl.ego:5",
+		},
+		{
+			src: "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%><%= 1 + %><% } %>",
+			err: "expected operand, found ')' at tmpl.ego:5",
+		},
+		{
+			src: "<%\npackage main\n\nfunc Page(ctx context.Context, w io.Writer) {\n%><% } %>",
+			err: "Render function not found at tmpl.ego:0",
+		},
+		{
+			src: "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n\tvar err error\n%><%= err.(error) %><% } %>",
+			err: "Type assertions are not supported by Execute at tmpl.ego:6",
+		},
+		{
+			src: "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) {\n%><%= strings.Repeat(\"x\", -1) %><% } %>",
+			err: "Panic in call: strings: negative Repeat count at tmpl.ego:5",
+		},
+	} {
+		tmpl, err := ego.Parse(strings.NewReader(tt.src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(context.Background(), &buf, nil); err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error: %v, expected %s", err, tt.err)
+		}
+	}
+}
+
+// Ensure that runtime panics of called methods are returned as errors.
+func TestTemplate_Execute_NilPointer(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer, p *execPage) {\n%>\n<%= p.Count() %>\n<% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(context.Background(), &buf, (*execPage)(nil)); err == nil || err.Error() != "Panic in call: runtime error: invalid memory address or nil pointer dereference at tmpl.ego:6" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that values are escaped the same as by generated code.
+func TestTemplate_Execute_Escape(t *testing.T) {
+	src := "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer, v interface{}) {\n%><a href=\"<%= v %>\" title=<%= v %>><%= v %></a><script>var x = <%= v %>;</script><% } %>"
+	for _, tt := range []struct {
+		name string
+		fn   func(*ego.Template)
+		v    interface{}
+		exp  string
+	}{
+		{
+			name: "Default",
+			v:    "<b>",
+			exp:  `<a href="&lt;b&gt;" title=&lt;b&gt;>&lt;b&gt;</a><script>var x = &lt;b&gt;;</script>`,
+		},
+		{
+			name: "ContextEscape",
+			fn:   func(tmpl *ego.Template) { tmpl.ContextEscape = true },
+			v:    "javascript:<b>",
+			exp:  `<a href="about:invalid#ego" title=javascript:&lt;b&gt;>javascript:&lt;b&gt;</a><script>var x = "javascript:\u003cb\u003e";</script>`,
+		},
+		{
+			name: "SafeTypes",
+			fn:   func(tmpl *ego.Template) { tmpl.SafeTypes = true },
+			v:    ego.HTML("<b>"),
+			exp:  `<a href="&lt;b&gt;" title=&lt;b&gt;><b></a><script>var x = &lt;b&gt;;</script>`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+			if err != nil {
+				t.Fatal(err)
+			} else if tt.fn != nil {
+				tt.fn(tmpl)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(context.Background(), &buf, tt.v); err != nil {
+				t.Fatal(err)
+			} else if buf.String() != tt.exp {
+				t.Fatalf("unexpected output:\n%s\n\nexpected:\n%s", buf.String(), tt.exp)
+			}
+		})
+	}
+}
+
+// Ensure that write errors are returned with WriteErrors.
+func TestTemplate_Execute_WriteErrors(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer) error {\n%>Hello<% return nil } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.WriteErrors = true
+	if err := tmpl.Execute(context.Background(), &errorWriter{err: errors.New("write failed")}, nil); err == nil || err.Error() != "write failed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that composite types, constants & closures are supported.
+func TestTemplate_Execute_Types(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(`<%
+package main
+
+func exclaim(s string) string { return s + "!" }
+
+func Render(ctx context.Context, w io.Writer) error {
+	const sep = ", "
+	var names []string
+	var err error
+	counts := map[string][]int{"a": {1, 2}, "b": nil}
+	for k, v := range counts {
+		names = append(names, k+"="+strconv.Itoa(len(v)))
+	}
+	upper := func(s string) string { return strings.ToUpper(s) }
+	if err != nil {
+		return err
+	}
+%><%= strings.Join(names, sep) %> <%= apply(upper, "x") %> <%= apply(exclaim, "y") %> <%= string([]byte{'o', 'k'}) %><% return nil } %>`), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Funcs = map[string]interface{}{
+		"apply":        func(fn func(string) string, s string) string { return fn(s) },
+		"strconv.Itoa": func(i int) string { return fmt.Sprint(i) },
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(context.Background(), &buf, nil); err != nil {
+		t.Fatal(err)
+	} else if exp := "a=2, b=0 X y! ok"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
+
+// Ensure that registered components are rendered with their fields, attributes
+// & closures.
+func TestTemplate_Execute_Component(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(`<%
+package main
+
+func Render(ctx context.Context, w io.Writer, titles []string) {
+%><% for _, title := range titles { %><ego:ExecCard Title=title class="c"><ego::Header><h1></ego::Header><%= title %>!</ego:ExecCard><% } %><% } %>`), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(context.Background(), &buf, []string{"a", "<b>"}); err != nil {
+		t.Fatal(err)
+	} else if exp := `<div class="c"><h1>[a]a!</div><div class="c"><h1>[<b>]&lt;b&gt;!</div>`; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
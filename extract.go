@@ -0,0 +1,187 @@
+package ego
+
+import (
+	"html"
+	"strings"
+)
+
+// TextSpan represents static text within a template.
+type TextSpan struct {
+	Pos  Pos
+	Text string
+}
+
+// ExtractText returns the content of each text block in the template, such as
+// for spell-checking. Text within components, attribute blocks & regions is
+// included. Dynamic output from print & code blocks is not.
+func ExtractText(t *Template) []TextSpan {
+	var a []TextSpan
+	inspectBlocks(t.Blocks, func(blk Block) bool {
+		if blk, ok := blk.(*TextBlock); ok && blk.Content != "" {
+			a = append(a, TextSpan{Pos: blk.Pos, Text: blk.Content})
+		}
+		return true
+	})
+	return a
+}
+
+// Message represents the message key of a translate directive.
+type Message struct {
+	Pos Pos
+	Key string
+}
+
+// ExtractMessages returns the message key of each translate directive in the
+// template, such as for writing translation catalogs. Keys are returned in
+// template order and may repeat.
+func ExtractMessages(t *Template) []Message {
+	var a []Message
+	inspectBlocks(t.Blocks, func(blk Block) bool {
+		if blk, ok := blk.(*TranslateBlock); ok {
+			a = append(a, Message{Pos: blk.Pos, Key: blk.Key})
+		}
+		return true
+	})
+	return a
+}
+
+// ExtractProse returns the human-readable text of the template with HTML tags,
+// comments, and the content of script & style elements removed. Entities are
+// decoded and whitespace is collapsed to single spaces.
+//
+// Text is split into spans at block-level elements, such as paragraphs, and at
+// the end of each text block since dynamic output may follow. Inline elements,
+// such as links, do not split text. Each span is positioned at the line of its
+// first character.
+func ExtractProse(t *Template) []TextSpan {
+	var e proseExtractor
+	for _, span := range ExtractText(t) {
+		e.extract(span)
+	}
+	return e.spans
+}
+
+// proseExtractor strips markup from text spans. Its state is kept across spans
+// since a tag may be split by a print block, such as an attribute value.
+type proseExtractor struct {
+	spans []TextSpan
+
+	text strings.Builder // prose of the current span
+	pos  Pos             // position of the first character of text
+
+	inTag     bool
+	inComment bool
+	quote     byte            // quote character of the current attribute value
+	tag       strings.Builder // source of the current tag
+	skip      string          // name of the script or style element being skipped
+}
+
+// extract appends the prose within span to the extractor's spans.
+func (e *proseExtractor) extract(span TextSpan) {
+	s, lineNo := span.Text, span.Pos.LineNo
+
+	// Text blocks are positioned after their first character so the line of
+	// a leading newline is the previous line.
+	if strings.HasPrefix(s, "\n") {
+		lineNo--
+	}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+
+		switch {
+		case e.inComment:
+			if strings.HasPrefix(s[i:], "-->") {
+				e.inComment, i = false, i+2
+			}
+
+		case e.inTag:
+			if e.quote != 0 {
+				if ch == e.quote {
+					e.quote = 0
+				}
+			} else if ch == '"' || ch == '\'' {
+				e.quote = ch
+			} else if ch == '>' {
+				e.inTag = false
+				e.endTag(e.tag.String())
+				e.tag.Reset()
+				break
+			}
+			e.tag.WriteByte(ch)
+
+		case e.skip != "":
+			// Skip to the closing tag of the script or style element.
+			j := strings.Index(strings.ToLower(s[i:]), "</"+e.skip)
+			if j == -1 {
+				lineNo += strings.Count(s[i:], "\n")
+				i = len(s)
+				continue
+			}
+			lineNo += strings.Count(s[i:i+j], "\n")
+			e.skip, i = "", i+j-1
+			continue
+
+		case strings.HasPrefix(s[i:], "<!--"):
+			e.flush()
+			e.inComment, i = true, i+3
+
+		case ch == '<' && i+1 < len(s) && (isASCIILetter(s[i+1]) || s[i+1] == '/'):
+			e.inTag = true
+
+		default:
+			if e.text.Len() == 0 {
+				if isWhitespace(rune(ch)) {
+					break
+				}
+				e.pos = Pos{Path: span.Pos.Path, LineNo: lineNo}
+			}
+			e.text.WriteByte(ch)
+		}
+
+		if s[i] == '\n' {
+			lineNo++
+		}
+	}
+	e.flush()
+}
+
+// endTag handles a tag given its source between the angle brackets.
+func (e *proseExtractor) endTag(src string) {
+	closing := strings.HasPrefix(src, "/")
+	name := tagName(src)
+
+	if blockElements[name] {
+		e.flush()
+	}
+	if (name == "script" || name == "style") && !closing && !strings.HasSuffix(src, "/") {
+		e.skip = name
+	}
+}
+
+// flush appends the current prose as a span, if it is not blank.
+func (e *proseExtractor) flush() {
+	text := strings.Join(strings.Fields(html.UnescapeString(e.text.String())), " ")
+	e.text.Reset()
+	if text != "" {
+		e.spans = append(e.spans, TextSpan{Pos: e.pos, Text: text})
+	}
+}
+
+// blockElements are the elements whose tags separate spans of prose.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "br": true, "caption": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "head": true,
+	"header": true, "hr": true, "html": true, "legend": true, "li": true,
+	"main": true, "nav": true, "ol": true, "option": true, "p": true,
+	"pre": true, "script": true, "section": true, "style": true,
+	"table": true, "td": true, "th": true, "title": true, "tr": true,
+	"ul": true,
+}
+
+// isASCIILetter returns true if ch is an ASCII letter.
+func isASCIILetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
@@ -0,0 +1,79 @@
+package ego_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+const extractSrc = `<% func Render(ctx context.Context, w io.Writer) { %>
+<h1>Hello, <%= name %>!</h1>
+<ego:Card>
+	<ego::Footer>Sent &amp; received</ego::Footer>
+	<p>Welcome to <a href="<%= url %>" title="x > y">our   site</a>.
+	Enjoy!</p>
+</ego:Card>
+<script>var s = "<p>not prose</p>";</script>
+<!-- TODO: fix
+this --><p>Bye</p>
+<% } %>`
+
+// Ensure that the content of all text blocks is extracted with positions.
+func TestExtractText(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(extractSrc), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := ego.ExtractText(tmpl)
+	if len(spans) != 6 {
+		t.Fatalf("unexpected span count: %#v", spans)
+	} else if s := spans[0]; s.Text != "\n<h1>Hello, " || s.Pos.LineNo != 2 {
+		t.Fatalf("unexpected span: %#v", s)
+	} else if s := spans[2]; s.Text != "Sent &amp; received" || s.Pos.LineNo != 4 {
+		t.Fatalf("unexpected span: %#v", s)
+	}
+}
+
+// Ensure that the message keys of translate directives are extracted.
+func TestExtractMessages(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% func Render(ctx context.Context, w io.Writer) { %>\n<h1><%t \"title\" %></h1>\n<ego:Card><%t \"greeting\", name %></ego:Card>\n<% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := ego.ExtractMessages(tmpl)
+	if len(a) != 2 {
+		t.Fatalf("unexpected message count: %#v", a)
+	} else if m := a[0]; m.Key != "title" || m.Pos.LineNo != 2 {
+		t.Fatalf("unexpected message: %#v", m)
+	} else if m := a[1]; m.Key != "greeting" || m.Pos.LineNo != 3 {
+		t.Fatalf("unexpected message: %#v", m)
+	}
+}
+
+// Ensure that prose is extracted without markup.
+func TestExtractProse(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(extractSrc), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a []string
+	for _, span := range ego.ExtractProse(tmpl) {
+		a = append(a, fmt.Sprintf("%s:%d %s", span.Pos.Path, span.Pos.LineNo, span.Text))
+	}
+	if exp := []string{
+		"tmpl.ego:2 Hello,",
+		"tmpl.ego:2 !",
+		"tmpl.ego:4 Sent & received",
+		"tmpl.ego:5 Welcome to",
+		"tmpl.ego:5 our site. Enjoy!",
+		"tmpl.ego:10 Bye",
+	}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected prose:\n%s", strings.Join(a, "\n"))
+	}
+}
@@ -0,0 +1,113 @@
+package ego
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// DefaultFilters are common filters that can be added to a parser's Filters.
+var DefaultFilters = map[string]string{
+	"upper":    "ego.Upper",
+	"lower":    "ego.Lower",
+	"trim":     "ego.TrimSpace",
+	"truncate": "ego.Truncate",
+}
+
+// expandFilters replaces the filters of print blocks, such as
+// "user.Name | upper | truncate(20)", with nested calls to their functions,
+// such as "ego.Truncate(ego.Upper(user.Name), 20)".
+func expandFilters(a []Block, filters map[string]string) {
+	inspectBlocks(a, func(blk Block) bool {
+		switch blk := blk.(type) {
+		case *PrintBlock:
+			blk.Content = expandFilterExpr(blk.Content, filters)
+		case *RawPrintBlock:
+			blk.Content = expandFilterExpr(blk.Content, filters)
+		}
+		return true
+	})
+}
+
+// expandFilterExpr returns expr with its trailing filters replaced by calls.
+// A "|" operator is only a pipe if every operand after it is a registered
+// filter, optionally called with arguments. Otherwise it is Go's bitwise or.
+func expandFilterExpr(expr string, filters map[string]string) string {
+	// Find the offsets of "|" operators outside of brackets.
+	var pipes []int
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", -1, len(expr)), []byte(expr), nil, 0)
+	for depth := 0; ; {
+		pos, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.OR:
+			if depth == 0 {
+				pipes = append(pipes, fset.Position(pos).Offset)
+			}
+		}
+	}
+
+	// Collect filters from the end until an operand is not a filter.
+	var calls []string
+	value := expr
+	for len(pipes) > 0 {
+		i := pipes[len(pipes)-1]
+		call, ok := filterCall(strings.TrimSpace(value[i+1:]), filters)
+		if !ok {
+			break
+		}
+		calls = append(calls, call)
+		value, pipes = value[:i], pipes[:len(pipes)-1]
+	}
+	if len(calls) == 0 {
+		return expr
+	}
+
+	// Wrap the operand with each filter, starting with the first.
+	value = strings.TrimSpace(value)
+	for i := len(calls) - 1; i >= 0; i-- {
+		value = strings.Replace(calls[i], "\x00", value, 1)
+	}
+	return value
+}
+
+// filterCall returns the call of a filter operand, such as "truncate(20)",
+// with a NUL character in place of the filtered value. Returns false if the
+// operand is not a registered filter.
+func filterCall(operand string, filters map[string]string) (string, bool) {
+	x, err := parser.ParseExpr(operand)
+	if err != nil {
+		return "", false
+	}
+
+	switch x := x.(type) {
+	case *ast.Ident:
+		if fn, ok := filters[x.Name]; ok {
+			return fn + "(\x00)", true
+		}
+	case *ast.CallExpr:
+		ident, ok := x.Fun.(*ast.Ident)
+		if !ok || x.Ellipsis.IsValid() {
+			return "", false
+		}
+		fn, ok := filters[ident.Name]
+		if !ok {
+			return "", false
+		} else if len(x.Args) == 0 {
+			return fn + "(\x00)", true
+		}
+		args := operand[x.Lparen : x.Rparen-1]
+		return fn + "(\x00, " + strings.TrimSpace(args) + ")", true
+	}
+	return "", false
+}
@@ -0,0 +1,166 @@
+package ego
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Format returns the canonical formatting of a template's source. The Go code
+// of code & print blocks is formatted with gofmt and blocks on a single line
+// have a single space inside their delimiters. Text, components, comments &
+// directives are written unchanged. Code that cannot be parsed on its own,
+// such as several lines that close a block, only has its delimiter spacing
+// normalized.
+func Format(src []byte, path string) ([]byte, error) {
+	return (&Parser{}).Format(src, path)
+}
+
+// Format returns the canonical formatting of a template's source written with
+// the parser's syntax, such as its delimiters.
+func (p *Parser) Format(src []byte, path string) ([]byte, error) {
+	s := NewScanner(bytes.NewReader(src), path)
+	s.Interpolate, s.Delims = p.Interpolate, p.Delims
+	open, close := s.openDelim(), s.closeDelim()
+	var buf bytes.Buffer
+	for {
+		start := s.i
+		blk, err := s.Scan()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		raw := string(s.b[start:s.i])
+
+		// Print blocks from interpolation & other blocks are unchanged.
+		switch blk := blk.(type) {
+		case *CodeBlock:
+			if strings.HasPrefix(raw, open) {
+				writeFormattedBlock(&buf, open, blk.TrimLeft, formatCode(blk.Content), blk.TrimRight, close)
+				continue
+			}
+		case *PrintBlock:
+			if strings.HasPrefix(raw, open+"=") {
+				writeFormattedBlock(&buf, open+"=", blk.TrimLeft, formatExpr(blk.Content), blk.TrimRight, close)
+				continue
+			}
+		case *RawPrintBlock:
+			writeFormattedBlock(&buf, open+"==", blk.TrimLeft, formatExpr(blk.Content), blk.TrimRight, close)
+			continue
+		}
+		buf.WriteString(raw)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFormattedBlock writes a block with its open tag, trim markers, and
+// formatted content, which includes the whitespace inside its delimiters.
+func writeFormattedBlock(buf *bytes.Buffer, open string, left Trim, content string, right Trim, close string) {
+	buf.WriteString(open)
+	buf.WriteString(trimMarker(left))
+	buf.WriteString(content)
+	buf.WriteString(trimMarker(right))
+	buf.WriteString(close)
+}
+
+// trimMarker returns the marker of a trim mode.
+func trimMarker(trim Trim) string {
+	switch trim {
+	case TrimAll:
+		return "-"
+	case TrimLine:
+		return "~"
+	default:
+		return ""
+	}
+}
+
+// formatExpr returns the formatted expression of a print block surrounded by
+// single spaces. Expressions with comments are only trimmed since comments
+// are not kept by the parser.
+func formatExpr(content string) string {
+	expr := strings.TrimSpace(content)
+	if expr == "" {
+		return " "
+	} else if strings.Contains(expr, "//") || strings.Contains(expr, "/*") {
+		return " " + expr + " "
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseExprFrom(fset, "", expr, 0)
+	if err != nil {
+		return " " + expr + " "
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, node); err != nil {
+		return " " + expr + " "
+	}
+	return " " + buf.String() + " "
+}
+
+// codeWrappers are the code added around a fragment of Go code so that it
+// can be formatted on its own.
+var codeWrappers = []struct {
+	prefix, suffix string
+	multiline      bool // true if the wrapper can be used for multiple lines
+}{
+	{"", "", true},
+	{"", "\n}", true},            // opens a block, such as a loop or function
+	{"if EGO {\n", "", false},    // closes a block
+	{"if EGO {\n", "\n}", false}, // closes & opens a block, such as an else
+	{"switch {\n", "\n}", false}, // starts a case clause
+}
+
+// formatCode returns the formatted code of a code block including the
+// whitespace inside its delimiters. Code on a single line is surrounded by
+// single spaces. The leading & trailing whitespace of multiple lines is kept
+// and the code is indented by the indentation of its first line.
+func formatCode(content string) string {
+	code := strings.TrimSpace(content)
+	if code == "" {
+		return " "
+	} else if !strings.Contains(content, "\n") {
+		if s, ok := formatGo(code, "", false); ok && !strings.Contains(s, "\n") {
+			code = s
+		}
+		return " " + code + " "
+	}
+
+	lead := content[:len(content)-len(strings.TrimLeftFunc(content, unicode.IsSpace))]
+	trail := content[len(lead)+len(code):]
+	indent := lead[strings.LastIndex(lead, "\n")+1:]
+	if s, ok := formatGo(code, indent, strings.Contains(code, "\n")); ok {
+		code = strings.TrimPrefix(s, indent)
+	}
+	return lead + code + trail
+}
+
+// formatGo formats a fragment of Go code with gofmt. Each line is indented by
+// indent, unless the fragment is a complete file. Returns false if the
+// fragment cannot be formatted with any wrapper.
+func formatGo(code, indent string, multiline bool) (string, bool) {
+	for _, w := range codeWrappers {
+		if multiline && !w.multiline {
+			continue
+		}
+		out, err := format.Source([]byte(indent + w.prefix + code + w.suffix))
+		if err != nil {
+			continue
+		}
+
+		// Remove the lines of the wrapper.
+		lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+		lines = lines[strings.Count(w.prefix, "\n"):]
+		lines = lines[:len(lines)-strings.Count(w.suffix, "\n")]
+		if len(lines) == 0 {
+			continue
+		}
+		return strings.Join(lines, "\n"), true
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package ego_test
+
+import (
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that the Go code of templates is formatted & other content is kept.
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct {
+		src, exp string
+	}{
+		{
+			src: "<%\npackage foo\nimport \"fmt\"\ntype Page struct{Title string}\nfunc (p *Page) Render(ctx context.Context,w io.Writer) {\n%>",
+			exp: "<%\npackage foo\n\nimport \"fmt\"\n\ntype Page struct{ Title string }\n\nfunc (p *Page) Render(ctx context.Context, w io.Writer) {\n%>",
+		},
+		{
+			src: "<h1><%=p.Title%></h1><%==  strings.Join(a,\",\")   %>",
+			exp: "<h1><%= p.Title %></h1><%== strings.Join(a, \",\") %>",
+		},
+		{
+			src: "<%for _,x:=range xs{%><%-  x:=x+1 -%><%}else if y{ %><%} %>",
+			exp: "<% for _, x := range xs { %><%- x := x + 1 -%><% } else if y { %><% } %>",
+		},
+		{
+			src: "<%switch x{%><%case 1,2:%><%~ default:  ~%>",
+			exp: "<% switch x { %><% case 1, 2: %><%~ default: ~%>",
+		},
+		{
+			src: "<%\n\tx:=1\n\tif x>0{\n%>\n<%= a /* note */+b %><%}}%>",
+			exp: "<%\n\tx := 1\n\tif x > 0 {\n%>\n<%= a /* note */+b %><% }} %>",
+		},
+		{
+			src: "<ego:Card  x=1>  <%# keep  this %><%build  linux %></ego:Card>",
+			exp: "<ego:Card  x=1>  <%# keep  this %><%build  linux %></ego:Card>",
+		},
+	} {
+		out, err := ego.Format([]byte(tt.src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if string(out) != tt.exp {
+			t.Errorf("unexpected output:\n%s\n\nexpected:\n%s", out, tt.exp)
+		}
+	}
+
+	t.Run("Delims", func(t *testing.T) {
+		p := &ego.Parser{Delims: [2]string{"{{%", "%}}"}}
+		if out, err := p.Format([]byte("<%x%>{{%=p.Title%}}{{%- for _,x:=range xs{ -%}}"), "tmpl.ego"); err != nil {
+			t.Fatal(err)
+		} else if exp := "<%x%>{{%= p.Title %}}{{%- for _, x := range xs { -%}}"; string(out) != exp {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("ErrSyntax", func(t *testing.T) {
+		if _, err := ego.Format([]byte("<p><%= x"), "tmpl.ego"); err == nil || err.Error() != "Expected close tag, found EOF at tmpl.ego:1" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
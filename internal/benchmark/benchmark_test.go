@@ -0,0 +1,42 @@
+package benchmark
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func BenchmarkPage_Render(b *testing.B) {
+	ctx, page := context.Background(), &Page{Title: "Home"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		page.Render(ctx, &buf)
+	}
+}
+
+func BenchmarkPage_RenderInto(b *testing.B) {
+	ctx, page := context.Background(), &Page{Title: "Home"}
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		page.RenderInto(ctx, &buf)
+	}
+}
+
+func BenchmarkText(b *testing.B) {
+	ctx, w := context.Background(), &byteWriter{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		renderText(ctx, w)
+	}
+}
+
+// byteWriter only implements io.Writer so io.WriteString must copy strings.
+type byteWriter struct{ n int }
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
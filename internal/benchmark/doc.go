@@ -0,0 +1,8 @@
+// Package benchmark contains templates generated with options that change the
+// cost of rendering and benchmarks that compare them. Run "go generate" to
+// regenerate the templates after changing the code generator.
+package benchmark
+
+//go:generate go run ../../cmd/ego -cache= -into-method page.ego
+//go:generate go run ../../cmd/ego -cache= text.ego
+//go:generate go run ../../cmd/ego -cache= -unsafe-bytes text_unsafe.ego
@@ -0,0 +1,33 @@
+// Generated by ego.
+// DO NOT EDIT
+
+//line page.ego:1
+
+package benchmark
+
+import "fmt"
+import "html"
+import "io"
+import "context"
+import "bytes"
+
+type Page struct {
+	Title string
+}
+
+func (p *Page) Render(ctx context.Context, w io.Writer) {
+
+//line page.ego:9
+	_, _ = io.WriteString(w, "<html><head><title>")
+//line page.ego:9
+	_, _ = io.WriteString(w, html.EscapeString(fmt.Sprint(p.Title)))
+//line page.ego:9
+	_, _ = io.WriteString(w, "</title></head><body>Hello, world!</body></html>")
+//line page.ego:9
+}
+
+// RenderInto renders Page by appending to buf. The buffer is owned by the
+// caller and can be reset & reused across renders to avoid allocations.
+func (p *Page) RenderInto(ctx context.Context, buf *bytes.Buffer) {
+	p.Render(ctx, buf)
+}
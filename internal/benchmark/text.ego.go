@@ -0,0 +1,16 @@
+// Generated by ego.
+// DO NOT EDIT
+
+//line text.ego:1
+
+package benchmark
+
+import "io"
+import "context"
+
+func renderText(ctx context.Context, w io.Writer) {
+
+//line text.ego:5
+	_, _ = io.WriteString(w, "<html><head><title>Home</title></head><body>Hello, world!</body></html>")
+//line text.ego:5
+}
@@ -0,0 +1,23 @@
+//go:build go1.20
+// +build go1.20
+
+// Generated by ego.
+// DO NOT EDIT
+
+//line text_unsafe.ego:2
+
+package benchmark
+
+import "io"
+import "context"
+import "unsafe"
+
+func renderTextUnsafe(ctx context.Context, w io.Writer) {
+
+//line text_unsafe.ego:6
+	{
+		const EGO_TEXT = "<html><head><title>Home</title></head><body>Hello, world!</body></html>"
+		_, _ = w.Write(unsafe.Slice(unsafe.StringData(EGO_TEXT), len(EGO_TEXT)))
+	}
+//line text_unsafe.ego:6
+}
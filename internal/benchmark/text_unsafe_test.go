@@ -0,0 +1,17 @@
+//go:build go1.20
+// +build go1.20
+
+package benchmark
+
+import (
+	"context"
+	"testing"
+)
+
+func BenchmarkText_UnsafeBytes(b *testing.B) {
+	ctx, w := context.Background(), &byteWriter{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		renderTextUnsafe(ctx, w)
+	}
+}
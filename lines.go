@@ -0,0 +1,122 @@
+package ego
+
+import (
+	"bytes"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// LineDirectives represents how template positions are written in generated
+// code.
+type LineDirectives int
+
+const (
+	// LineDirectivesOn writes //line directives so that compiler errors,
+	// panics & stack traces point at the template.
+	LineDirectivesOn LineDirectives = iota
+
+	// LineDirectivesOff writes no template positions.
+	LineDirectivesOff
+
+	// LineDirectivesComment writes template positions as plain comments at
+	// the end of the first line of each block's code, such as
+	// "// index.ego:12", which tools do not interpret.
+	LineDirectivesComment
+)
+
+// rewriteLineDirectives removes the //line directives of formatted source. If
+// comments is true then each directive is written as a plain comment at the
+// end of the next line of code instead. Directives followed by another
+// directive are removed since their blocks have no code. Lines that end within
+// a multi-line literal or with a comment keep the plain comment on a line of
+// its own. Directives are found in the comments of the parsed source so that
+// lines of multi-line literals are never mistaken for directives.
+func rewriteLineDirectives(src []byte, comments bool) ([]byte, error) {
+	directives, err := lineDirectives(src)
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	unsafe := unsafeLineEnds(src)
+
+	var buf bytes.Buffer
+	var pending string
+	for i, line := range lines {
+		text := bytes.TrimSpace(line)
+		if directive, ok := directives[i+1]; ok && string(text) == directive {
+			if comments {
+				pending = "// " + strings.TrimPrefix(directive, "//line ")
+			}
+			continue
+		} else if pending == "" || len(text) == 0 {
+			buf.Write(line)
+			continue
+		}
+
+		if unsafe[i+1] {
+			indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+			buf.Write(indent)
+			buf.WriteString(pending + "\n")
+			buf.Write(line)
+		} else {
+			end := len(bytes.TrimRight(line, "\r\n"))
+			buf.Write(line[:end])
+			buf.WriteString(" " + pending)
+			buf.Write(line[end:])
+		}
+		pending = ""
+	}
+	return buf.Bytes(), nil
+}
+
+// lineDirectives returns the //line directives of src by their 1-based line
+// numbers. Directives are written on lines of their own.
+func lineDirectives(src []byte) (map[int]string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int]string)
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			if strings.HasPrefix(c.Text, "//line ") {
+				m[fset.PositionFor(c.Pos(), false).Line] = c.Text
+			}
+		}
+	}
+	return m, nil
+}
+
+// unsafeLineEnds returns the 1-based numbers of the lines of src where a
+// trailing comment cannot be added, which are lines that end within a raw
+// string or comment and lines that end with a comment.
+func unsafeLineEnds(src []byte) map[int]bool {
+	m := make(map[int]bool)
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		} else if tok != token.COMMENT && tok != token.STRING {
+			continue
+		}
+
+		start := file.PositionFor(pos, false).Line
+		end := start + strings.Count(lit, "\n")
+		for line := start; line < end; line++ {
+			m[line] = true
+		}
+		if tok == token.COMMENT {
+			m[end] = true
+		}
+	}
+	return m
+}
@@ -0,0 +1,223 @@
+package ego
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// Warning represents a non-fatal problem found in a template.
+type Warning struct {
+	Pos     Pos
+	Message string
+}
+
+// String returns the warning message with its position.
+func (w *Warning) String() string {
+	return fmt.Sprintf("%s at %s:%d", w.Message, w.Pos.Path, w.Pos.LineNo)
+}
+
+// Purity represents how strictly print block expressions are checked for
+// side effects.
+type Purity int
+
+const (
+	// PurityIgnore disables side effect checks on print blocks.
+	PurityIgnore Purity = iota
+
+	// PurityMutations reports assignments, increments, decrements, and
+	// channel operations within print block expressions.
+	PurityMutations
+
+	// PurityCalls additionally reports function and method calls. Calls to
+	// the len & cap builtins and conversions to predeclared types are allowed.
+	PurityCalls
+)
+
+// Linter checks templates for suspicious but valid constructs.
+type Linter struct {
+	// Purity sets how strictly print expressions are checked for side effects.
+	// Print expressions should be pure so that future optimizations may
+	// reorder, coalesce, or skip their evaluation.
+	Purity Purity
+}
+
+// Lint returns a list of warnings for the template.
+func (l *Linter) Lint(t *Template) []*Warning {
+	var a []*Warning
+	inspectBlocks(t.Blocks, func(blk Block) bool {
+		switch blk := blk.(type) {
+		case *PrintBlock:
+			a = append(a, l.lintPrintExpr(blk.Pos, blk.Content)...)
+		case *RawPrintBlock:
+			a = append(a, l.lintPrintExpr(blk.Pos, blk.Content)...)
+		case *SanitizeBlock:
+			a = append(a, l.lintPrintExpr(blk.Pos, blk.Content)...)
+		case *JSONPrintBlock:
+			a = append(a, l.lintPrintExpr(blk.Pos, blk.Content)...)
+		case *URLPrintBlock:
+			a = append(a, l.lintPrintExpr(blk.Pos, blk.Content)...)
+		}
+		return true
+	})
+	a = append(a, lintUnreachable(t.Blocks)...)
+	return a
+}
+
+// lintUnreachable reports blocks that follow a code block ending with a
+// return, break, continue, or goto in the same scope. The generated code for
+// these blocks can never run and is usually a misplaced closing brace.
+func lintUnreachable(blocks []Block) []*Warning {
+	var a []*Warning
+	var term token.Token
+	for _, blk := range blocks {
+		if term != token.ILLEGAL && !isReachableAfterTerm(blk) {
+			a = append(a, &Warning{Pos: Position(blk), Message: fmt.Sprintf("Unreachable code after %s", term)})
+			term = token.ILLEGAL
+		}
+
+		switch blk := blk.(type) {
+		case *CodeBlock:
+			term = trailingTerm(tokenize(blk.Content))
+		case *TextBlock:
+			if strings.TrimSpace(blk.Content) != "" {
+				term = token.ILLEGAL
+			}
+		case *CommentBlock:
+		case *ComponentStartBlock:
+			for _, attrBlock := range blk.AttrBlocks {
+				a = append(a, lintUnreachable(attrBlock.Yield)...)
+			}
+			a = append(a, lintUnreachable(blk.Yield)...)
+			term = token.ILLEGAL
+		case *AppendStartBlock:
+			a = append(a, lintUnreachable(blk.Yield)...)
+			term = token.ILLEGAL
+		case *FormatStartBlock:
+			a = append(a, lintUnreachable(blk.Yield)...)
+			term = token.ILLEGAL
+		case *YieldStartBlock:
+			a = append(a, lintUnreachable(blk.Yield)...)
+			term = token.ILLEGAL
+		default:
+			term = token.ILLEGAL
+		}
+	}
+	return a
+}
+
+// isReachableAfterTerm returns true if blk can follow a terminating statement
+// without being unreachable. This includes whitespace, closing braces, and
+// switch cases & labels that start a new branch.
+func isReachableAfterTerm(blk Block) bool {
+	switch blk := blk.(type) {
+	case *TextBlock:
+		return strings.TrimSpace(blk.Content) == ""
+	case *LoadStateBlock, *CommentBlock, *FuncBlock:
+		return true
+	case *CodeBlock:
+		toks := tokenize(blk.Content)
+		if len(toks) == 0 {
+			return true
+		}
+		switch toks[0] {
+		case token.RBRACE, token.CASE, token.DEFAULT:
+			return true
+		case token.IDENT:
+			return len(toks) > 1 && toks[1] == token.COLON
+		}
+	}
+	return false
+}
+
+// trailingTerm returns the terminating statement keyword whose scope is still
+// open at the end of toks. Returns ILLEGAL if there is none.
+func trailingTerm(toks []token.Token) token.Token {
+	term, depth, termDepth := token.ILLEGAL, 0, 0
+	for _, tok := range toks {
+		switch tok {
+		case token.RETURN, token.BREAK, token.CONTINUE, token.GOTO:
+			term, termDepth = tok, depth
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth--; depth < termDepth {
+				term = token.ILLEGAL
+			}
+		}
+	}
+	return term
+}
+
+// lintPrintExpr reports side effects within a print expression.
+func (l *Linter) lintPrintExpr(pos Pos, content string) []*Warning {
+	if l.Purity == PurityIgnore {
+		return nil
+	}
+
+	// Invalid expressions are reported by the Go compiler instead.
+	expr, err := parser.ParseExpr(content)
+	if err != nil {
+		return nil
+	}
+
+	var a []*Warning
+	warn := func(format string, args ...interface{}) {
+		a = append(a, &Warning{Pos: pos, Message: fmt.Sprintf(format, args...)})
+	}
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.AssignStmt:
+			warn("Assignment in print expression: prints should be pure")
+		case *ast.IncDecStmt:
+			warn("%s in print expression: prints should be pure", node.Tok)
+		case *ast.SendStmt:
+			warn("Channel send in print expression: prints should be pure")
+		case *ast.UnaryExpr:
+			if node.Op == token.ARROW {
+				warn("Channel receive in print expression: prints should be pure")
+			}
+		case *ast.CallExpr:
+			if l.Purity >= PurityCalls && !isPureCall(node) {
+				warn("Call to %s in print expression: prints should be pure", exprString(node.Fun))
+			}
+		}
+		return true
+	})
+	return a
+}
+
+// isPureCall returns true if the call is a builtin without side effects or a
+// conversion to a predeclared type.
+func isPureCall(call *ast.CallExpr) bool {
+	ident, ok := call.Fun.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	switch ident.Name {
+	case "len", "cap",
+		"string", "bool", "byte", "rune", "error",
+		"int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"float32", "float64", "complex64", "complex128":
+		return true
+	default:
+		return false
+	}
+}
+
+// exprString returns a short representation of a called expression.
+func exprString(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name
+	case *ast.SelectorExpr:
+		return exprString(expr.X) + "." + expr.Sel.Name
+	case *ast.ParenExpr:
+		return exprString(expr.X)
+	default:
+		return "function"
+	}
+}
@@ -0,0 +1,75 @@
+package ego_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that print expressions with side effects are reported.
+func TestLinter_Lint_Purity(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(`<%= user.Name %>
+<%= len(items) %>
+<%= counter.Increment() %>
+<ego:Card><%== <-ch %></ego:Card>
+<%= func() int { n++; return n }() %>
+`), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Ignore", func(t *testing.T) {
+		if a := (&ego.Linter{}).Lint(tmpl); len(a) != 0 {
+			t.Fatalf("unexpected warnings: %v", a)
+		}
+	})
+
+	t.Run("Mutations", func(t *testing.T) {
+		a := (&ego.Linter{Purity: ego.PurityMutations}).Lint(tmpl)
+		if len(a) != 2 {
+			t.Fatalf("unexpected warnings: %v", a)
+		} else if s := a[0].String(); s != `Channel receive in print expression: prints should be pure at tmpl.ego:4` {
+			t.Fatalf("unexpected warning(0): %s", s)
+		} else if s := a[1].String(); s != `++ in print expression: prints should be pure at tmpl.ego:5` {
+			t.Fatalf("unexpected warning(1): %s", s)
+		}
+	})
+
+	t.Run("Calls", func(t *testing.T) {
+		a := (&ego.Linter{Purity: ego.PurityCalls}).Lint(tmpl)
+		if len(a) != 4 {
+			t.Fatalf("unexpected warnings: %v", a)
+		} else if s := a[0].String(); s != `Call to counter.Increment in print expression: prints should be pure at tmpl.ego:3` {
+			t.Fatalf("unexpected warning(0): %s", s)
+		} else if s := a[2].String(); s != `Call to function in print expression: prints should be pure at tmpl.ego:5` {
+			t.Fatalf("unexpected warning(2): %s", s)
+		}
+	})
+}
+
+// Ensure that blocks following a terminating statement are reported.
+func TestLinter_Lint_Unreachable(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(`<% if err != nil { %>
+	<% return %>
+<% } %>
+<% for _, v := range a { %><% if v { continue } %><%= v %><% } %>
+<% switch x { %><% case 1: return %><% default: %>ok<% } %>
+<ego:Card><% if ok { %><% return } %><% return %>
+	<%= x %>
+</ego:Card>
+<% } else { break %>done<% } %>
+`), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := (&ego.Linter{}).Lint(tmpl)
+	if len(a) != 2 {
+		t.Fatalf("unexpected warnings: %v", a)
+	} else if s := a[0].String(); s != `Unreachable code after return at tmpl.ego:7` {
+		t.Fatalf("unexpected warning(0): %s", s)
+	} else if s := a[1].String(); s != `Unreachable code after break at tmpl.ego:9` {
+		t.Fatalf("unexpected warning(1): %s", s)
+	}
+}
@@ -0,0 +1,129 @@
+package ego
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Load states of the regions within the body of a component with a loader.
+const (
+	LoadStateLoading = "loading"
+	LoadStateError   = "error"
+	LoadStateSuccess = "success"
+)
+
+// loadStateRegex matches a load state tag with an optional variable name, such
+// as "<%error err %>". Code blocks such as "<%success := true %>" do not match.
+var loadStateRegex = newLoadStateRegex("<%", "%>")
+
+// newLoadStateRegex returns a regex matching load state tags with the given
+// open & close delimiters.
+func newLoadStateRegex(open, close string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(open) + `(loading|error|success)(?:\s+([\pL_][\pL\pN_]*))?\s*` + regexp.QuoteMeta(close))
+}
+
+// checkLoadStates returns an error if a load state block is not directly
+// within the body of a component with a loader or if the body of such a
+// component has content outside of a load state region or repeats a state.
+func checkLoadStates(a []Block, load *ComponentStartBlock) error {
+	states := make(map[string]bool)
+	for _, blk := range a {
+		switch blk := blk.(type) {
+		case *LoadStateBlock:
+			if load == nil {
+				return NewSyntaxError(blk.Pos, "Load state found outside of component with loader: <%%%s %%>", blk.State)
+			} else if states[blk.State] {
+				return NewSyntaxError(blk.Pos, "Duplicate load state in component: <%%%s %%>", blk.State)
+			} else if blk.State == LoadStateLoading && blk.Name != "" {
+				return NewSyntaxError(blk.Pos, "Unexpected variable in load state: <%%%s %s %%>", blk.State, blk.Name)
+			}
+			states[blk.State] = true
+
+		case *TextBlock:
+			if load != nil && len(states) == 0 && strings.TrimSpace(blk.Content) != "" {
+				return NewSyntaxError(blk.Pos, "Expected load state before content: %s", shortComponentBlockString(load))
+			}
+
+		case *ComponentStartBlock:
+			if load != nil && len(states) == 0 {
+				return NewSyntaxError(blk.Pos, "Expected load state before content: %s", shortComponentBlockString(load))
+			}
+			for _, attrBlock := range blk.AttrBlocks {
+				if err := checkLoadStates(attrBlock.Yield, nil); err != nil {
+					return err
+				}
+			}
+			var inner *ComponentStartBlock
+			if blk.Load != "" {
+				inner = blk
+			}
+			if err := checkLoadStates(blk.Yield, inner); err != nil {
+				return err
+			}
+
+		case *CommentBlock:
+
+		case *AppendStartBlock:
+			if err := checkLoadStates(blk.Yield, nil); err != nil {
+				return err
+			}
+		case *FormatStartBlock:
+			if err := checkLoadStates(blk.Yield, nil); err != nil {
+				return err
+			}
+		case *YieldStartBlock:
+			if err := checkLoadStates(blk.Yield, nil); err != nil {
+				return err
+			}
+
+		default:
+			if load != nil && len(states) == 0 {
+				return NewSyntaxError(Position(blk), "Expected load state before content: %s", shortComponentBlockString(load))
+			}
+		}
+	}
+	return nil
+}
+
+// writeLoadStates writes the body of a component with a loader. The loader is
+// called and its result selects the region that is rendered. ErrLoading
+// selects the loading region, any other error selects the error region, and
+// otherwise the success region is rendered. Without an error region, the
+// error is returned if the template returns errors and is ignored otherwise.
+func (t *Template) writeLoadStates(buf *bytes.Buffer, blk *ComponentStartBlock) {
+	fmt.Fprintf(buf, "EGO_DATA, EGO_ERR := (%s)(ctx)\n", blk.Load)
+	fmt.Fprintf(buf, "_ = EGO_DATA\n")
+
+	// Split body into regions by state.
+	regions := make(map[string][]Block)
+	states := make(map[string]*LoadStateBlock)
+	var state string
+	for _, b := range blk.Yield {
+		if sb, ok := b.(*LoadStateBlock); ok {
+			state, states[sb.State] = sb.State, sb
+		} else if state != "" {
+			regions[state] = append(regions[state], b)
+		}
+	}
+
+	fmt.Fprintf(buf, "switch {\n")
+	fmt.Fprintf(buf, "case EGO_ERR == ego.ErrLoading:\n")
+	t.writeBlocksTo(buf, regions[LoadStateLoading])
+
+	fmt.Fprintf(buf, "case EGO_ERR != nil:\n")
+	if b := states[LoadStateError]; b == nil && t.returnErrors() {
+		fmt.Fprintf(buf, "return EGO_ERR\n")
+	} else if b != nil && b.Name != "" {
+		fmt.Fprintf(buf, "%s := EGO_ERR\n_ = %s\n", b.Name, b.Name)
+	}
+	t.writeBlocksTo(buf, regions[LoadStateError])
+
+	fmt.Fprintf(buf, "default:\n")
+	if b := states[LoadStateSuccess]; b != nil && b.Name != "" {
+		fmt.Fprintf(buf, "%s := EGO_DATA\n_ = %s\n", b.Name, b.Name)
+	}
+	t.writeBlocksTo(buf, regions[LoadStateSuccess])
+	fmt.Fprintf(buf, "}\n")
+}
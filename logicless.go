@@ -0,0 +1,111 @@
+package ego
+
+import (
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// checkLogicLess returns an error if a code block within a function body
+// contains anything other than a conditional or loop. Code outside of
+// function bodies, such as package & type declarations, is always allowed.
+func checkLogicLess(t *Template) (err error) {
+	depth, inFunc := 0, false
+	inspectBlocks(t.Blocks, func(blk Block) bool {
+		blk0, isCode := blk.(*CodeBlock)
+		if !isCode || err != nil {
+			return err == nil
+		}
+
+		// Check each run of tokens within a function body. Other top-level
+		// declarations, such as types & variables, are skipped.
+		toks, i0, ok := tokenize(blk0.Content), 0, inFunc && depth > 0
+		for i, tok := range toks {
+			switch tok {
+			case token.FUNC:
+				if depth == 0 {
+					inFunc = true
+				}
+			case token.LBRACE:
+				if depth++; depth == 1 && inFunc && i > 0 && toks[i-1] != token.STRUCT && toks[i-1] != token.INTERFACE {
+					i0, ok = i+1, true
+				}
+			case token.RBRACE:
+				if depth > 0 {
+					depth--
+				}
+				if depth > 0 {
+					continue
+				} else if ok && !isLogicLessStmt(toks[i0:i+1]) {
+					err = NewSyntaxError(blk0.Pos, "Code not allowed in logic-less template: %s", strings.TrimSpace(blk0.Content))
+					return false
+				}
+				inFunc, ok = false, false
+			}
+		}
+		if ok && !isLogicLessStmt(toks[i0:]) {
+			err = NewSyntaxError(blk0.Pos, "Code not allowed in logic-less template: %s", strings.TrimSpace(blk0.Content))
+		}
+		return true
+	})
+	return err
+}
+
+// isLogicLessStmt returns true if toks is empty or consists of closing braces
+// optionally followed by an "else", "else if", "if" or "for" clause that opens
+// a new block.
+func isLogicLessStmt(toks []token.Token) bool {
+	for len(toks) > 0 && toks[0] == token.RBRACE {
+		toks = toks[1:]
+	}
+	if len(toks) == 0 {
+		return true
+	}
+
+	if toks[0] == token.ELSE {
+		if toks = toks[1:]; len(toks) == 1 && toks[0] == token.LBRACE {
+			return true
+		} else if len(toks) == 0 || toks[0] != token.IF {
+			return false
+		}
+	}
+	if toks[0] != token.IF && toks[0] != token.FOR {
+		return false
+	}
+
+	// Clause must end by opening a block and all other braces must balance.
+	if toks[len(toks)-1] != token.LBRACE {
+		return false
+	}
+	depth := 0
+	for _, tok := range toks[:len(toks)-1] {
+		switch tok {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth--; depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// tokenize returns the Go tokens in src, excluding comments and automatically
+// inserted semicolons.
+func tokenize(src string) []token.Token {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", -1, len(src)), []byte(src), nil, 0)
+
+	var a []token.Token
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return a
+		} else if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		a = append(a, tok)
+	}
+}
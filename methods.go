@@ -0,0 +1,941 @@
+package ego
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hoistedDecl represents a file-scope declaration generated from a template.
+type hoistedDecl struct {
+	Pos  Pos      // template position the declaration is generated from
+	Kind declKind // orders declarations generated from the same position
+	Src  string
+}
+
+// declKind is the kind of a hoisted declaration.
+type declKind int
+
+const (
+	declHTMLMethod declKind = iota
+	declCountedMethod
+	declWriteToMethod
+	declStringMethod
+	declIntoMethod
+	declRenderStringMethods
+	declBoundedMethod
+	declTruncateMethod
+	declAttrMethods
+	declResponseMethod
+	declOptions
+	declValidateMethod
+	declQueryMethod
+	declSetFieldMethod
+)
+
+// writeHoistedDecls writes declarations ordered by template position and then
+// by kind. The order only depends on the template code that generates each
+// declaration so unrelated edits to a template do not reorder them.
+func writeHoistedDecls(buf *bytes.Buffer, decls []*hoistedDecl) {
+	sort.SliceStable(decls, func(i, j int) bool {
+		a, b := decls[i], decls[j]
+		if a.Pos.Path != b.Pos.Path {
+			return a.Pos.Path < b.Pos.Path
+		} else if a.Pos.LineNo != b.Pos.LineNo {
+			return a.Pos.LineNo < b.Pos.LineNo
+		}
+		return a.Kind < b.Kind
+	})
+	for _, decl := range decls {
+		buf.WriteString(decl.Src)
+	}
+}
+
+// renderer represents a type declared in the generated file with a Render method.
+type renderer struct {
+	Name     string          // type name
+	Recv     string          // receiver type, e.g. "*T" or "T"
+	RecvName string          // receiver variable name
+	Methods  map[string]bool // declared method names
+	Decl     *ast.FuncDecl   // Render method declaration
+	Err      bool            // true if Render returns an error
+	Struct   *ast.StructType // struct type declaration, if in the same file
+	Attrs    []string        // sorted known attributes, if declared
+	Typed    bool            // true if the Attrs field has typed attributes
+	Validate bool            // true if a Validate() error method is declared
+}
+
+// findRenderers returns all types in f that declare a Render method, in
+// declaration order of their Render method.
+func findRenderers(f *ast.File) []*renderer {
+	var a []*renderer
+	methods := make(map[string]map[string]bool)
+	validators := make(map[string]bool)
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.FuncDecl)
+		if !ok || decl.Recv == nil || len(decl.Recv.List) != 1 {
+			continue
+		}
+
+		// Determine receiver type name & whether it is a pointer.
+		field := decl.Recv.List[0]
+		typ, star := field.Type, ""
+		if expr, ok := typ.(*ast.StarExpr); ok {
+			typ, star = expr.X, "*"
+		}
+		ident, ok := typ.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		if methods[ident.Name] == nil {
+			methods[ident.Name] = make(map[string]bool)
+		}
+		methods[ident.Name][decl.Name.Name] = true
+
+		if decl.Name.Name == "Validate" {
+			validators[ident.Name] = isValidateFunc(decl.Type)
+		}
+		if decl.Name.Name != "Render" {
+			continue
+		}
+
+		recvName := "r"
+		if len(field.Names) == 1 && field.Names[0].Name != "_" {
+			recvName = field.Names[0].Name
+		}
+		a = append(a, &renderer{
+			Name:     ident.Name,
+			Recv:     star + ident.Name,
+			RecvName: recvName,
+			Decl:     decl,
+			Err:      decl.Type.Results.NumFields() == 1,
+		})
+	}
+
+	structs := findStructTypes(f)
+	for _, r := range a {
+		r.Methods = methods[r.Name]
+		r.Struct = structs[r.Name]
+		r.Attrs = knownAttrs(r.Struct)
+		r.Typed = attrsMapType(r.Struct) == "interface{}"
+		r.Validate = validators[r.Name]
+	}
+	return a
+}
+
+// isValidateFunc returns true if typ has no parameters & only an error result.
+func isValidateFunc(typ *ast.FuncType) bool {
+	if typ.Params.NumFields() != 0 || typ.Results.NumFields() != 1 {
+		return false
+	}
+	return isIdent(typ.Results.List[0].Type, "error")
+}
+
+// findStructTypes returns the struct types declared in f, keyed by name.
+func findStructTypes(f *ast.File) map[string]*ast.StructType {
+	m := make(map[string]*ast.StructType)
+	ast.Inspect(f, func(node ast.Node) bool {
+		spec, ok := node.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		if st, ok := spec.Type.(*ast.StructType); ok {
+			m[spec.Name.Name] = st
+		}
+		return false
+	})
+	return m
+}
+
+// knownAttrs returns the sorted known attributes of a struct type. Known
+// attributes are declared with an "ego" tag on the Attrs field as a
+// comma-separated list of names:
+//
+//	Attrs map[string]string `ego:"id,class,title"`
+//
+// An empty tag declares that there are no known attributes and returns an
+// empty, non-nil slice. Returns nil if the struct does not declare known
+// attributes.
+func knownAttrs(st *ast.StructType) []string {
+	if st == nil {
+		return nil
+	}
+	for _, field := range st.Fields.List {
+		if field.Tag == nil || len(field.Names) != 1 || field.Names[0].Name != "Attrs" {
+			continue
+		}
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			continue
+		}
+		value, ok := reflect.StructTag(tag).Lookup("ego")
+		if !ok {
+			continue
+		}
+
+		names := []string{}
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" && !stringSliceContains(names, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	return nil
+}
+
+// requiredFields returns the names of the fields of a struct type that are
+// declared as required with a "validate" tag:
+//
+//	Title string `validate:"required"`
+func requiredFields(st *ast.StructType) []string {
+	if st == nil {
+		return nil
+	}
+
+	var a []string
+	for _, field := range st.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			continue
+		} else if value, _ := reflect.StructTag(tag).Lookup("validate"); value != "required" {
+			continue
+		}
+		for _, name := range field.Names {
+			a = append(a, name.Name)
+		}
+	}
+	return a
+}
+
+// queryField represents a struct field that can be set from a URL query.
+type queryField struct {
+	Name string // field name
+	Key  string // query parameter name
+	Type string // string, int, int64, float64, or bool
+}
+
+// queryFields returns the exported fields of a struct type with a type that
+// can be parsed from a URL query parameter. The parameter name is the field
+// name unless it is set with a "query" tag:
+//
+//	Page int `query:"page"`
+func queryFields(st *ast.StructType) []queryField {
+	var a []queryField
+	for _, field := range st.Fields.List {
+		ident, ok := field.Type.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		switch ident.Name {
+		case "string", "int", "int64", "float64", "bool":
+		default:
+			continue
+		}
+
+		var key string
+		if field.Tag != nil {
+			if tag, err := strconv.Unquote(field.Tag.Value); err == nil {
+				key = reflect.StructTag(tag).Get("query")
+			}
+		}
+		for _, name := range field.Names {
+			if !name.IsExported() || key == "-" {
+				continue
+			}
+			f := queryField{Name: name.Name, Key: key, Type: ident.Name}
+			if f.Key == "" {
+				f.Key = name.Name
+			}
+			a = append(a, f)
+		}
+	}
+	return a
+}
+
+// insertValidateCalls inserts a call to Validate at the start of the Render
+// method of each renderer that returns an error and has required fields or
+// declares a Validate method. A declared Validate method is only called if it
+// has the signature Validate() error. Returns nil if no calls are inserted.
+func insertValidateCalls(fset *token.FileSet, renderers []*renderer, src []byte) []byte {
+	var edits []edit
+	for _, r := range renderers {
+		generated := len(requiredFields(r.Struct)) > 0 && !r.Methods["Validate"]
+		if !r.Err || r.Decl.Body == nil || (!generated && !r.Validate) {
+			continue
+		}
+
+		// Insert on the same line as the brace so line numbers are unchanged.
+		lbrace := fset.File(r.Decl.Body.Lbrace).Offset(r.Decl.Body.Lbrace) + 1
+		text := fmt.Sprintf(" if err := %s.Validate(); err != nil { return err };", r.RecvName)
+		edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(text)})
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyEdits(src, edits)
+}
+
+// insertAttrsCopies inserts a copy of the Attrs map at the start of the Render
+// method of each renderer whose struct has an Attrs map field. A value
+// receiver's field is replaced by the copy. With a pointer receiver, the copy
+// is assigned to a local variable which replaces the uses of the receiver's
+// field in the body so the shared component is not modified. Returns nil if no
+// copies are inserted.
+func insertAttrsCopies(fset *token.FileSet, renderers []*renderer, src []byte) []byte {
+	var edits []edit
+	for _, r := range renderers {
+		copyFunc := "CopyAttrs"
+		switch attrsMapType(r.Struct) {
+		case "":
+			continue
+		case "interface{}":
+			copyFunc = "CopyTypedAttrs"
+		}
+		if r.Decl.Body == nil {
+			continue
+		} else if names := r.Decl.Recv.List[0].Names; len(names) != 1 || names[0].Name == "_" {
+			continue
+		}
+
+		// Insert on the same line as the brace so line numbers are unchanged.
+		file := fset.File(r.Decl.Body.Lbrace)
+		lbrace := file.Offset(r.Decl.Body.Lbrace) + 1
+		if strings.HasPrefix(r.Recv, "*") {
+			text := fmt.Sprintf(" EGO_ATTRS := ego.%s(%s.Attrs); _ = EGO_ATTRS;", copyFunc, r.RecvName)
+			edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(text)})
+			ast.Inspect(r.Decl.Body, func(n ast.Node) bool {
+				if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == "Attrs" && isIdent(sel.X, r.RecvName) {
+					edits = append(edits, edit{Start: file.Offset(sel.Pos()), End: file.Offset(sel.End()), Text: []byte("EGO_ATTRS")})
+				}
+				return true
+			})
+		} else {
+			text := fmt.Sprintf(" %s.Attrs = ego.%s(%s.Attrs);", r.RecvName, copyFunc, r.RecvName)
+			edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(text)})
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyEdits(src, edits)
+}
+
+// insertPoolBuffers wraps the body of the Render method of each renderer in a
+// function literal whose writer parameter shadows the method's writer with a
+// pooled buffer. The buffer is written to the method's writer after the
+// literal returns. The first write error is returned by a Render method that
+// returns an error. Returns nil if no bodies are wrapped.
+func insertPoolBuffers(fset *token.FileSet, renderers []*renderer, src []byte) []byte {
+	var edits []edit
+	for _, r := range renderers {
+		params := r.Decl.Type.Params.List
+		if r.Decl.Body == nil || len(params) != 2 || len(params[1].Names) != 1 || !isSelector(params[1].Type, "io", "Writer") {
+			continue
+		}
+		w := params[1].Names[0].Name
+		if w == "_" {
+			continue
+		}
+
+		// Insert on the same lines as the braces so line numbers are unchanged.
+		file := fset.File(r.Decl.Body.Lbrace)
+		lbrace := file.Offset(r.Decl.Body.Lbrace) + 1
+		rbrace := file.Offset(r.Decl.Body.Rbrace)
+		if r.Err {
+			text := fmt.Sprintf(" EGO_POOL := ego.AcquireBuffer(%s); EGO_POOL_ERR := func(%s io.Writer) error {", w, w)
+			edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(text)})
+			text = fmt.Sprintf("}(EGO_POOL); if err := ego.ReleaseBuffer(EGO_POOL, %s); EGO_POOL_ERR == nil { EGO_POOL_ERR = err }; return EGO_POOL_ERR ", w)
+			edits = append(edits, edit{Start: rbrace, End: rbrace, Text: []byte(text)})
+		} else {
+			text := fmt.Sprintf(" EGO_POOL := ego.AcquireBuffer(%s); func(%s io.Writer) {", w, w)
+			edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(text)})
+			text = fmt.Sprintf("}(EGO_POOL); _ = ego.ReleaseBuffer(EGO_POOL, %s) ", w)
+			edits = append(edits, edit{Start: rbrace, End: rbrace, Text: []byte(text)})
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyEdits(src, edits)
+}
+
+// insertPanicRecovery inserts a deferred call at the start of the Render method
+// of each renderer that recovers a panic and passes it to handler. The body of
+// a Render method that returns an error is wrapped in a function literal with
+// a named result so the handler's error can be returned. Other Render methods
+// panic again with the handler's error, if any. Returns nil if no recovery is
+// inserted.
+func insertPanicRecovery(fset *token.FileSet, renderers []*renderer, src []byte, handler string) []byte {
+	var edits []edit
+	for _, r := range renderers {
+		params := r.Decl.Type.Params.List
+		if r.Decl.Body == nil || len(params) != 2 || len(params[0].Names) != 1 || len(params[1].Names) != 1 {
+			continue
+		}
+		ctx, w := params[0].Names[0].Name, params[1].Names[0].Name
+		if ctx == "_" || w == "_" {
+			continue
+		}
+
+		// Insert on the same lines as the braces so line numbers are unchanged.
+		file := fset.File(r.Decl.Body.Lbrace)
+		lbrace := file.Offset(r.Decl.Body.Lbrace) + 1
+		if r.Err {
+			text := fmt.Sprintf(" return func() (EGO_ERR error) { defer func() { if EGO_PANIC := recover(); EGO_PANIC != nil { EGO_ERR = %s(%s, %s, EGO_PANIC) } }();", handler, ctx, w)
+			rbrace := file.Offset(r.Decl.Body.Rbrace)
+			edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(text)})
+			edits = append(edits, edit{Start: rbrace, End: rbrace, Text: []byte("}() ")})
+		} else {
+			text := fmt.Sprintf(" defer func() { if EGO_PANIC := recover(); EGO_PANIC != nil { if err := %s(%s, %s, EGO_PANIC); err != nil { panic(err) } } }();", handler, ctx, w)
+			edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(text)})
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyEdits(src, edits)
+}
+
+// attrsMapType returns the value type of the Attrs field of a struct type,
+// either "string" for map[string]string or "interface{}" for typed
+// attributes. Returns an empty string if there is no such field.
+func attrsMapType(st *ast.StructType) string {
+	if st == nil {
+		return ""
+	}
+	for _, field := range st.Fields.List {
+		typ, ok := field.Type.(*ast.MapType)
+		if !ok || !isIdent(typ.Key, "string") {
+			continue
+		}
+		var value string
+		if isIdent(typ.Value, "string") {
+			value = "string"
+		} else if iface, ok := typ.Value.(*ast.InterfaceType); (ok && len(iface.Methods.List) == 0) || isIdent(typ.Value, "any") {
+			value = "interface{}"
+		} else {
+			continue
+		}
+		for _, name := range field.Names {
+			if name.Name == "Attrs" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// insertSpans inserts the start of a tracing span at the start of the Render
+// method of each renderer. The span is named after the component and replaces
+// the method's context so components rendered by the method start child spans.
+// It is ended by a deferred call. Returns nil if no spans are inserted.
+func insertSpans(fset *token.FileSet, renderers []*renderer, src []byte, tracer string) []byte {
+	var edits []edit
+	for _, r := range renderers {
+		params := r.Decl.Type.Params.List
+		if r.Decl.Body == nil || len(params) == 0 || len(params[0].Names) != 1 || params[0].Names[0].Name == "_" {
+			continue
+		}
+		ctx := params[0].Names[0].Name
+
+		// Insert on the same line as the brace so line numbers are unchanged.
+		file := fset.File(r.Decl.Body.Lbrace)
+		lbrace := file.Offset(r.Decl.Body.Lbrace) + 1
+		text := fmt.Sprintf(" %s, EGO_SPAN := %s.Start(%s, %q); defer EGO_SPAN.End();", ctx, tracer, ctx, "ego:"+r.Name)
+		edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(text)})
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyEdits(src, edits)
+}
+
+// isIdent returns true if expr is an identifier with the given name.
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+// isSelector returns true if expr is a selector of name from package pkg,
+// such as io.Writer.
+func isSelector(expr ast.Expr, pkg, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, pkg) && sel.Sel.Name == name
+}
+
+// checkAttrCalls returns an error if a renderer with known attributes calls
+// its Attr method on its receiver with a constant name that is not known.
+func checkAttrCalls(fset *token.FileSet, renderers []*renderer) (err error) {
+	for _, r := range renderers {
+		if r.Attrs == nil || r.Decl.Body == nil {
+			continue
+		}
+		ast.Inspect(r.Decl.Body, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || err != nil || len(call.Args) != 1 {
+				return err == nil
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "Attr" {
+				return true
+			} else if ident, ok := sel.X.(*ast.Ident); !ok || ident.Name != r.RecvName {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+
+			if name, _ := strconv.Unquote(lit.Value); !stringSliceContains(r.Attrs, name) {
+				pos := fset.Position(lit.Pos())
+				err = NewSyntaxError(Pos{Path: pos.Filename, LineNo: pos.Line}, "Unknown attribute for %s: %s", r.Name, name)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMethodsTo writes optional helper methods for each renderer and returns
+// the import paths required by the generated code.
+func (t *Template) writeMethodsTo(buf *bytes.Buffer, fset *token.FileSet, renderers []*renderer) (imports []string) {
+	var decls []*hoistedDecl
+	hoist := func(r *renderer, kind declKind, fn func(*bytes.Buffer, *renderer)) {
+		var b bytes.Buffer
+		fn(&b, r)
+		p := fset.Position(r.Decl.Pos())
+		decls = append(decls, &hoistedDecl{Pos: Pos{Path: p.Filename, LineNo: p.Line}, Kind: kind, Src: b.String()})
+	}
+
+	for _, r := range renderers {
+		if t.HTMLMethod && !r.Methods["HTML"] {
+			hoist(r, declHTMLMethod, writeHTMLMethod)
+			imports = appendImport(imports, "bytes", "html/template")
+		}
+		if t.CountedMethod && !r.Methods["RenderCounted"] {
+			hoist(r, declCountedMethod, writeCountedMethod)
+			r.Methods["RenderCounted"] = true
+			imports = appendImport(imports, RuntimePath)
+		}
+		if t.StdMethods && !r.Methods["WriteTo"] {
+			hoist(r, declWriteToMethod, writeWriteToMethod)
+			if !r.Methods["RenderCounted"] {
+				imports = appendImport(imports, RuntimePath)
+			}
+			r.Methods["WriteTo"] = true
+		}
+		if t.StdMethods && !r.Methods["String"] {
+			hoist(r, declStringMethod, writeStringMethod)
+			imports = appendImport(imports, "bytes")
+		}
+		if t.IntoMethod && !r.Methods["RenderInto"] {
+			hoist(r, declIntoMethod, writeIntoMethod)
+			imports = appendImport(imports, "bytes")
+			if r.Err {
+				imports = appendImport(imports, RuntimePath)
+			}
+		}
+		if t.StringMethods && !r.Methods["RenderString"] && !r.Methods["RenderBytes"] {
+			hoist(r, declRenderStringMethods, writeStringMethods)
+			imports = appendImport(imports, RuntimePath)
+		}
+		if t.BoundedMethod && !r.Methods["RenderBounded"] {
+			hoist(r, declBoundedMethod, writeBoundedMethod)
+			imports = appendImport(imports, RuntimePath)
+		}
+		if t.TruncateMethod && !r.Methods["RenderTruncated"] {
+			hoist(r, declTruncateMethod, writeTruncateMethod)
+			imports = appendImport(imports, RuntimePath)
+		}
+		if t.ResponseMethod && !r.Methods["RenderResponse"] {
+			contentType := t.contentType()
+			hoist(r, declResponseMethod, func(buf *bytes.Buffer, r *renderer) { writeResponseMethod(buf, r, contentType) })
+			imports = appendImport(imports, "net/http", RuntimePath)
+		}
+		if fields := requiredFields(r.Struct); len(fields) > 0 && !r.Methods["Validate"] {
+			hoist(r, declValidateMethod, func(buf *bytes.Buffer, r *renderer) { writeValidateMethod(buf, r, fields) })
+			imports = appendImport(imports, RuntimePath)
+		}
+		if t.QueryMethod && r.Struct != nil && !r.Methods["FromQuery"] {
+			fields := queryFields(r.Struct)
+			hoist(r, declQueryMethod, func(buf *bytes.Buffer, r *renderer) { writeQueryMethod(buf, r, fields) })
+			imports = appendImport(imports, "net/url")
+			for _, field := range fields {
+				if field.Type != "string" {
+					imports = appendImport(imports, "strconv")
+					break
+				}
+			}
+		}
+		if t.SetFieldMethod && r.Struct != nil && !r.Methods["SetField"] {
+			hoist(r, declSetFieldMethod, func(buf *bytes.Buffer, r *renderer) { writeSetFieldMethod(buf, fset, r) })
+		}
+		if t.FunctionalOptions && r.Struct != nil {
+			hoist(r, declOptions, func(buf *bytes.Buffer, r *renderer) { writeOptions(buf, fset, r) })
+		}
+		if r.Attrs != nil && !r.Methods["Attr"] && !r.Methods["RenderAttrs"] {
+			hoist(r, declAttrMethods, writeAttrMethods)
+			imports = appendImport(imports, RuntimePath)
+		}
+	}
+
+	writeHoistedDecls(buf, decls)
+	return imports
+}
+
+// writeHTMLMethod writes a method that renders to trusted HTML.
+func writeHTMLMethod(buf *bytes.Buffer, r *renderer) {
+	fmt.Fprintf(buf, "\n// HTML renders %s as trusted HTML for use in an html/template.\n", r.Name)
+	fmt.Fprintf(buf, "func (%s %s) HTML(ctx context.Context) template.HTML {\n", r.RecvName, r.Recv)
+	fmt.Fprintf(buf, "var buf bytes.Buffer\n")
+	fmt.Fprintf(buf, "%s.Render(ctx, &buf)\n", r.RecvName)
+	fmt.Fprintf(buf, "return template.HTML(buf.String())\n")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeCountedMethod writes a method that renders and returns the byte count.
+func writeCountedMethod(buf *bytes.Buffer, r *renderer) {
+	fmt.Fprintf(buf, "\n// RenderCounted renders %s to w and returns the number of bytes written\n", r.Name)
+	fmt.Fprintf(buf, "// and the first write error.\n")
+	fmt.Fprintf(buf, "func (%s %s) RenderCounted(ctx context.Context, w io.Writer) (int64, error) {\n", r.RecvName, r.Recv)
+	writeCountedBody(buf, r, "ctx")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeCountedBody writes statements that render to w with the given context
+// expression and return the byte count and first write error. If Render
+// returns an error other than ErrSkip then it is returned instead.
+func writeCountedBody(buf *bytes.Buffer, r *renderer, ctx string) {
+	fmt.Fprintf(buf, "cw := &ego.CountWriter{W: w}\n")
+	if r.Err {
+		fmt.Fprintf(buf, "if err := %s.Render(%s, cw); err != nil && err != ego.ErrSkip {\n", r.RecvName, ctx)
+		fmt.Fprintf(buf, "return cw.N, err\n")
+		fmt.Fprintf(buf, "}\n")
+	} else {
+		fmt.Fprintf(buf, "%s.Render(%s, cw)\n", r.RecvName, ctx)
+	}
+	fmt.Fprintf(buf, "return cw.N, cw.Err\n")
+}
+
+// writeWriteToMethod writes a method implementing io.WriterTo. It delegates to
+// RenderCounted, if available, so the rendering logic is not duplicated.
+func writeWriteToMethod(buf *bytes.Buffer, r *renderer) {
+	fmt.Fprintf(buf, "\n// WriteTo renders %s to w using a background context.\n", r.Name)
+	fmt.Fprintf(buf, "func (%s %s) WriteTo(w io.Writer) (int64, error) {\n", r.RecvName, r.Recv)
+	if r.Methods["RenderCounted"] {
+		fmt.Fprintf(buf, "return %s.RenderCounted(context.Background(), w)\n", r.RecvName)
+	} else {
+		writeCountedBody(buf, r, "context.Background()")
+	}
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeStringMethod writes a method implementing fmt.Stringer using WriteTo.
+func writeStringMethod(buf *bytes.Buffer, r *renderer) {
+	fmt.Fprintf(buf, "\n// String returns %s rendered using a background context.\n", r.Name)
+	fmt.Fprintf(buf, "func (%s %s) String() string {\n", r.RecvName, r.Recv)
+	fmt.Fprintf(buf, "var buf bytes.Buffer\n")
+	fmt.Fprintf(buf, "%s.WriteTo(&buf)\n", r.RecvName)
+	fmt.Fprintf(buf, "return buf.String()\n")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeIntoMethod writes a method that renders into a caller-provided buffer.
+func writeIntoMethod(buf *bytes.Buffer, r *renderer) {
+	fmt.Fprintf(buf, "\n// RenderInto renders %s by appending to buf. The buffer is owned by the\n", r.Name)
+	fmt.Fprintf(buf, "// caller and can be reset & reused across renders to avoid allocations.\n")
+	if r.Err {
+		fmt.Fprintf(buf, "func (%s %s) RenderInto(ctx context.Context, buf *bytes.Buffer) error {\n", r.RecvName, r.Recv)
+		fmt.Fprintf(buf, "if err := %s.Render(ctx, buf); err != nil && err != ego.ErrSkip {\n", r.RecvName)
+		fmt.Fprintf(buf, "return err\n")
+		fmt.Fprintf(buf, "}\n")
+		fmt.Fprintf(buf, "return nil\n")
+	} else {
+		fmt.Fprintf(buf, "func (%s %s) RenderInto(ctx context.Context, buf *bytes.Buffer) {\n", r.RecvName, r.Recv)
+		fmt.Fprintf(buf, "%s.Render(ctx, buf)\n", r.RecvName)
+	}
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeStringMethods writes methods that render into a pooled buffer
+// and return its contents as a string or a copied byte slice.
+func writeStringMethods(buf *bytes.Buffer, r *renderer) {
+	for _, m := range []struct{ name, typ, zero, result string }{
+		{"RenderString", "string", `""`, "buf.String()"},
+		{"RenderBytes", "[]byte", "nil", "append([]byte(nil), buf.Bytes()...)"},
+	} {
+		fmt.Fprintf(buf, "\n// %s renders %s and returns its output as a %s.\n", m.name, r.Name, m.typ)
+		fmt.Fprintf(buf, "func (%s %s) %s(ctx context.Context) (%s, error) {\n", r.RecvName, r.Recv, m.name, m.typ)
+		fmt.Fprintf(buf, "buf := ego.GetBuffer()\n")
+		fmt.Fprintf(buf, "defer ego.PutBuffer(buf)\n")
+		if r.Err {
+			fmt.Fprintf(buf, "if err := %s.Render(ctx, buf); err != nil && err != ego.ErrSkip {\n", r.RecvName)
+			fmt.Fprintf(buf, "return %s, err\n", m.zero)
+			fmt.Fprintf(buf, "}\n")
+		} else {
+			fmt.Fprintf(buf, "%s.Render(ctx, buf)\n", r.RecvName)
+		}
+		fmt.Fprintf(buf, "return %s, nil\n", m.result)
+		fmt.Fprintf(buf, "}\n")
+	}
+}
+
+// writeBoundedMethod writes a method that renders to a bounded writer and then
+// closes it so its consumer sees the end of the output.
+func writeBoundedMethod(buf *bytes.Buffer, r *renderer) {
+	fmt.Fprintf(buf, "\n// RenderBounded renders %s to w and then closes it. Writes block while w is\n", r.Name)
+	fmt.Fprintf(buf, "// full so this must be called in a separate goroutine from the reader of w.\n")
+	if r.Err {
+		fmt.Fprintf(buf, "// The rendering error, if any, is returned and passed to the reader.\n")
+		fmt.Fprintf(buf, "func (%s %s) RenderBounded(ctx context.Context, w ego.BoundedWriter) error {\n", r.RecvName, r.Recv)
+		fmt.Fprintf(buf, "err := %s.Render(ctx, w)\n", r.RecvName)
+		fmt.Fprintf(buf, "if err == ego.ErrSkip {\n")
+		fmt.Fprintf(buf, "err = nil\n")
+		fmt.Fprintf(buf, "}\n")
+		fmt.Fprintf(buf, "w.CloseWithError(err)\n")
+		fmt.Fprintf(buf, "return err\n")
+	} else {
+		fmt.Fprintf(buf, "func (%s %s) RenderBounded(ctx context.Context, w ego.BoundedWriter) {\n", r.RecvName, r.Recv)
+		fmt.Fprintf(buf, "%s.Render(ctx, w)\n", r.RecvName)
+		fmt.Fprintf(buf, "w.Close()\n")
+	}
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeTruncateMethod writes a method that renders within a byte budget.
+func writeTruncateMethod(buf *bytes.Buffer, r *renderer) {
+	fmt.Fprintf(buf, "\n// RenderTruncated renders %s to w using at most budget bytes. If the output\n", r.Name)
+	fmt.Fprintf(buf, "// does not fit then it is cut at a block boundary and ellipsis is appended.\n")
+	fmt.Fprintf(buf, "// Returns true if the output was truncated and the first write error.\n")
+	fmt.Fprintf(buf, "func (%s %s) RenderTruncated(ctx context.Context, w io.Writer, budget int, ellipsis string) (bool, error) {\n", r.RecvName, r.Recv)
+	fmt.Fprintf(buf, "tw := &ego.TruncateWriter{W: w, Budget: budget, Ellipsis: ellipsis}\n")
+	if r.Err {
+		fmt.Fprintf(buf, "if err := %s.Render(ctx, tw); err != nil && err != ego.ErrSkip {\n", r.RecvName)
+		fmt.Fprintf(buf, "return tw.Truncated, err\n")
+		fmt.Fprintf(buf, "}\n")
+	} else {
+		fmt.Fprintf(buf, "%s.Render(ctx, tw)\n", r.RecvName)
+	}
+	fmt.Fprintf(buf, "err := tw.Close()\n")
+	fmt.Fprintf(buf, "return tw.Truncated, err\n")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeResponseMethod writes a method that renders the response to an HTTP
+// request using the request's context.
+func writeResponseMethod(buf *bytes.Buffer, r *renderer, contentType string) {
+	req := "req"
+	if r.RecvName == req {
+		req = "httpReq"
+	}
+
+	fmt.Fprintf(buf, "\n// RenderResponse renders %s as the response to an HTTP request.\n", r.Name)
+	if r.Err {
+		fmt.Fprintf(buf, "// If rendering fails before any output is written then a 500 error is sent.\n")
+	}
+	fmt.Fprintf(buf, "func (%s %s) RenderResponse(w http.ResponseWriter, %s *http.Request) {\n", r.RecvName, r.Recv, req)
+	fmt.Fprintf(buf, "rw := &ego.ResponseWriter{W: w, ContentType: %q}\n", contentType)
+	if r.Err {
+		fmt.Fprintf(buf, "err := %s.Render(%s.Context(), rw)\n", r.RecvName, req)
+		fmt.Fprintf(buf, "if err == ego.ErrSkip {\n")
+		fmt.Fprintf(buf, "err = nil\n")
+		fmt.Fprintf(buf, "}\n")
+		fmt.Fprintf(buf, "rw.End(err)\n")
+	} else {
+		fmt.Fprintf(buf, "%s.Render(%s.Context(), rw)\n", r.RecvName, req)
+		fmt.Fprintf(buf, "rw.End(nil)\n")
+	}
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeSetFieldMethod writes a method that sets an exported field by name from
+// an interface value. A nil value sets the field to its zero value. Unknown
+// fields and values of another type are errors.
+func writeSetFieldMethod(buf *bytes.Buffer, fset *token.FileSet, r *renderer) {
+	fmt.Fprintf(buf, "\n// SetField sets the field of %s with the given name to value. A nil value\n", r.Name)
+	fmt.Fprintf(buf, "// sets the zero value. Returns an error if the field is unknown or if value\n")
+	fmt.Fprintf(buf, "// does not have the field's type.\n")
+	recv := r.RecvName
+	switch recv {
+	case "name", "value", "v", "ok":
+		recv = "c"
+	}
+	fmt.Fprintf(buf, "func (%s *%s) SetField(name string, value interface{}) error {\n", recv, r.Name)
+	fmt.Fprintf(buf, "switch name {\n")
+	for _, field := range r.Struct.Fields.List {
+		var ftyp bytes.Buffer
+		if err := printer.Fprint(&ftyp, fset, field.Type); err != nil {
+			continue
+		}
+		for _, name := range field.Names {
+			if !name.IsExported() {
+				continue
+			}
+			fmt.Fprintf(buf, "case %q:\n", name.Name)
+			fmt.Fprintf(buf, "v, ok := value.(%s)\n", ftyp.String())
+			fmt.Fprintf(buf, "if !ok && value != nil {\n")
+			fmt.Fprintf(buf, "return fmt.Errorf(\"%s: invalid type for field %s: got %%T, want %%s\", value, %q)\n", r.Name, name.Name, ftyp.String())
+			fmt.Fprintf(buf, "}\n")
+			fmt.Fprintf(buf, "%s.%s = v\n", recv, name.Name)
+		}
+	}
+	fmt.Fprintf(buf, "default:\n")
+	fmt.Fprintf(buf, "return fmt.Errorf(\"%s: unknown field: %%s\", name)\n", r.Name)
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "return nil\n")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeOptions writes a functional option type for a renderer along with a
+// constructor and an option for each exported field. Options are prefixed by
+// the type name so components in the same package do not conflict.
+func writeOptions(buf *bytes.Buffer, fset *token.FileSet, r *renderer) {
+	typ := r.Name + "Option"
+	fmt.Fprintf(buf, "\n// %s configures a %s.\n", typ, r.Name)
+	fmt.Fprintf(buf, "type %s func(*%s)\n", typ, r.Name)
+
+	fmt.Fprintf(buf, "\n// New%s returns a new %s configured by opts.\n", r.Name, r.Name)
+	if r.Recv[0] == '*' {
+		fmt.Fprintf(buf, "func New%s(opts ...%s) *%s {\n", r.Name, typ, r.Name)
+		fmt.Fprintf(buf, "v := &%s{}\n", r.Name)
+	} else {
+		fmt.Fprintf(buf, "func New%s(opts ...%s) %s {\n", r.Name, typ, r.Name)
+		fmt.Fprintf(buf, "var v %s\n", r.Name)
+	}
+	fmt.Fprintf(buf, "for _, opt := range opts {\n")
+	if r.Recv[0] == '*' {
+		fmt.Fprintf(buf, "opt(v)\n")
+	} else {
+		fmt.Fprintf(buf, "opt(&v)\n")
+	}
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "return v\n")
+	fmt.Fprintf(buf, "}\n")
+
+	for _, field := range r.Struct.Fields.List {
+		var ftyp bytes.Buffer
+		if err := printer.Fprint(&ftyp, fset, field.Type); err != nil {
+			continue
+		}
+		for _, name := range field.Names {
+			if !name.IsExported() {
+				continue
+			}
+			fmt.Fprintf(buf, "\n// With%s%s sets the %s field of a %s.\n", r.Name, name.Name, name.Name, r.Name)
+			fmt.Fprintf(buf, "func With%s%s(v %s) %s {\n", r.Name, name.Name, ftyp.String(), typ)
+			fmt.Fprintf(buf, "return func(c *%s) { c.%s = v }\n", r.Name, name.Name)
+			fmt.Fprintf(buf, "}\n")
+		}
+	}
+}
+
+// writeValidateMethod writes a method that returns an error for the first
+// required field that is not set.
+func writeValidateMethod(buf *bytes.Buffer, r *renderer, fields []string) {
+	fmt.Fprintf(buf, "\n// Validate returns an error if a required field of %s is not set.\n", r.Name)
+	fmt.Fprintf(buf, "func (%s %s) Validate() error {\n", r.RecvName, r.Recv)
+	for _, name := range fields {
+		fmt.Fprintf(buf, "if ego.IsZero(&%s.%s) {\n", r.RecvName, name)
+		fmt.Fprintf(buf, "return fmt.Errorf(%q)\n", fmt.Sprintf("%s: required field %s is not set", r.Name, name))
+		fmt.Fprintf(buf, "}\n")
+	}
+	fmt.Fprintf(buf, "return nil\n")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeQueryMethod writes a method that sets fields from URL query parameters.
+// Missing parameters leave their field unchanged. The first value is used if
+// a parameter is repeated.
+func writeQueryMethod(buf *bytes.Buffer, r *renderer, fields []queryField) {
+	fmt.Fprintf(buf, "\n// FromQuery sets the fields of %s from URL query parameters. Fields without\n", r.Name)
+	fmt.Fprintf(buf, "// a parameter are unchanged. Returns an error if a value cannot be parsed.\n")
+	recv := r.RecvName
+	switch recv {
+	case "q", "v", "x", "err":
+		recv = "c"
+	}
+	fmt.Fprintf(buf, "func (%s *%s) FromQuery(q url.Values) error {\n", recv, r.Name)
+	for _, f := range fields {
+		fmt.Fprintf(buf, "if v := q[%q]; len(v) > 0 {\n", f.Key)
+		switch f.Type {
+		case "string":
+			fmt.Fprintf(buf, "%s.%s = v[0]\n", recv, f.Name)
+			fmt.Fprintf(buf, "}\n")
+			continue
+		case "int":
+			fmt.Fprintf(buf, "x, err := strconv.Atoi(v[0])\n")
+		case "int64":
+			fmt.Fprintf(buf, "x, err := strconv.ParseInt(v[0], 10, 64)\n")
+		case "float64":
+			fmt.Fprintf(buf, "x, err := strconv.ParseFloat(v[0], 64)\n")
+		case "bool":
+			// HTML checkboxes are submitted with a value of "on".
+			fmt.Fprintf(buf, "x, err := v[0] == \"on\", error(nil)\n")
+			fmt.Fprintf(buf, "if !x {\n")
+			fmt.Fprintf(buf, "x, err = strconv.ParseBool(v[0])\n")
+			fmt.Fprintf(buf, "}\n")
+		}
+		fmt.Fprintf(buf, "if err != nil {\n")
+		fmt.Fprintf(buf, "return fmt.Errorf(\"%s: invalid query parameter %%s: %%q\", %q, v[0])\n", r.Name, f.Key)
+		fmt.Fprintf(buf, "}\n")
+		fmt.Fprintf(buf, "%s.%s = x\n", recv, f.Name)
+		fmt.Fprintf(buf, "}\n")
+	}
+	fmt.Fprintf(buf, "return nil\n")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// writeAttrMethods writes accessor methods for the known attributes of a
+// renderer.
+func writeAttrMethods(buf *bytes.Buffer, r *renderer) {
+	fmt.Fprintf(buf, "\n// Attr returns the value of a known attribute of %s.\n", r.Name)
+	typ := "string"
+	if r.Typed {
+		typ = "interface{}"
+	}
+	fmt.Fprintf(buf, "func (%s %s) Attr(name string) %s {\n", r.RecvName, r.Recv, typ)
+	fmt.Fprintf(buf, "return %s.Attrs[name]\n", r.RecvName)
+	fmt.Fprintf(buf, "}\n")
+
+	fmt.Fprintf(buf, "\n// RenderAttrs writes the known attributes of %s that are set, in sorted\n", r.Name)
+	fmt.Fprintf(buf, "// order, as escaped HTML attributes. Each attribute has a leading space.\n")
+	fmt.Fprintf(buf, "func (%s %s) RenderAttrs(w io.Writer) {\n", r.RecvName, r.Recv)
+	fmt.Fprintf(buf, "for _, name := range [...]string{")
+	for i, name := range r.Attrs {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(buf, "%q", name)
+	}
+	fmt.Fprintf(buf, "} {\n")
+	fmt.Fprintf(buf, "if v, ok := %s.Attrs[name]; ok {\n", r.RecvName)
+	fmt.Fprintf(buf, "_ = ego.WriteAttr(w, name, v)\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// appendImport appends import paths to a if they do not already exist.
+func appendImport(a []string, paths ...string) []string {
+	for _, path := range paths {
+		if !stringSliceContains(a, path) {
+			a = append(a, path)
+		}
+	}
+	return a
+}
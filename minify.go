@@ -0,0 +1,160 @@
+package ego
+
+import (
+	"strings"
+)
+
+// minifyBlocks collapses insignificant whitespace and removes HTML comments
+// from the content of text blocks. Dynamic blocks are unchanged. Blocks left
+// empty are removed by trimBlocks.
+func minifyBlocks(a []Block) {
+	var m minifier
+	inspectBlocks(a, func(blk Block) bool {
+		if blk, ok := blk.(*TextBlock); ok {
+			blk.Content = m.minify(blk.Content)
+		}
+		return true
+	})
+}
+
+// minifier minifies the HTML of text blocks. Its state is kept across blocks
+// since a tag may be split by a print block, such as an attribute value.
+type minifier struct {
+	inTag     bool
+	inComment bool
+	quote     byte            // quote character of the current attribute value
+	tag       strings.Builder // source of the current tag
+	raw       string          // name of the element whose content is kept as-is
+}
+
+// minify returns the minified content of a text block.
+//
+// Runs of whitespace are collapsed to a single space, which is removed next
+// to block-level tags, such as paragraphs. Whitespace at the start & end of
+// the block is kept as a single space since dynamic output may follow it.
+// The content of pre, textarea, script & style elements and conditional
+// comments are unchanged.
+func (m *minifier) minify(s string) string {
+	var buf strings.Builder
+	space := false      // true if whitespace precedes the next output
+	afterBlock := false // true if the last output was a block-level tag
+
+	// writeSpace writes pending whitespace unless it is next to a block tag.
+	writeSpace := func(beforeBlock bool) {
+		if space && !afterBlock && !beforeBlock {
+			buf.WriteByte(' ')
+		}
+		space = false
+	}
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+
+		switch {
+		case m.inComment:
+			if strings.HasPrefix(s[i:], "-->") {
+				m.inComment = false
+				buf.WriteString("-->")
+				i += 2
+				continue
+			}
+			buf.WriteByte(ch)
+
+		case m.inTag:
+			if m.quote != 0 {
+				if ch == m.quote {
+					m.quote = 0
+				}
+			} else if ch == '"' || ch == '\'' {
+				m.quote = ch
+			} else if isWhitespace(rune(ch)) {
+				// Collapse whitespace between attributes.
+				if str := m.tag.String(); !strings.HasSuffix(str, " ") {
+					m.tag.WriteByte(' ')
+					buf.WriteByte(' ')
+				}
+				continue
+			} else if ch == '>' {
+				m.inTag = false
+				buf.WriteByte(ch)
+				afterBlock = m.endTag(m.tag.String())
+				m.tag.Reset()
+				continue
+			}
+			m.tag.WriteByte(ch)
+			buf.WriteByte(ch)
+
+		case m.raw != "":
+			// Copy up to the closing tag of the element.
+			j := strings.Index(strings.ToLower(s[i:]), "</"+m.raw)
+			if j == -1 {
+				buf.WriteString(s[i:])
+				i = len(s)
+				continue
+			}
+			buf.WriteString(s[i : i+j])
+			m.raw, i = "", i+j-1
+
+		case strings.HasPrefix(s[i:], "<!--"):
+			// Remove comments within the block. Conditional comments and
+			// comments containing dynamic output are kept.
+			j := strings.Index(s[i+4:], "-->")
+			if strings.HasPrefix(s[i:], "<!--[") || j == -1 {
+				writeSpace(false)
+				m.inComment = true
+				buf.WriteString("<!--")
+				i += 3
+				continue
+			}
+			i += 4 + j + 2
+
+		case ch == '<' && i+1 < len(s) && (isASCIILetter(s[i+1]) || s[i+1] == '/' || s[i+1] == '!'):
+			writeSpace(isBlockTag(s[i+1:]))
+			m.inTag = true
+			buf.WriteByte(ch)
+
+		case isWhitespace(rune(ch)):
+			space = true
+
+		default:
+			writeSpace(false)
+			afterBlock = false
+			buf.WriteByte(ch)
+		}
+	}
+	writeSpace(false)
+	return buf.String()
+}
+
+// endTag handles a tag given its source between the angle brackets. Returns
+// true if the tag is a block-level tag.
+func (m *minifier) endTag(src string) bool {
+	closing := strings.HasPrefix(src, "/")
+	name := tagName(src)
+	switch name {
+	case "pre", "textarea", "script", "style":
+		if !closing && !strings.HasSuffix(src, "/") {
+			m.raw = name
+		}
+	}
+	return blockElements[name] || strings.HasPrefix(name, "!")
+}
+
+// isBlockTag returns true if s starts with the source of a block-level tag,
+// excluding its opening angle bracket.
+func isBlockTag(s string) bool {
+	if i := strings.IndexByte(s, '>'); i != -1 {
+		s = s[:i]
+	}
+	name := tagName(s)
+	return blockElements[name] || strings.HasPrefix(name, "!")
+}
+
+// tagName returns the lowercase name of a tag given its source.
+func tagName(src string) string {
+	name := strings.TrimPrefix(src, "/")
+	if i := strings.IndexAny(name, " \t\r\n/>"); i != -1 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
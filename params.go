@@ -0,0 +1,128 @@
+package ego
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// paramTypePrefix is the prefix of the placeholder types of attribute block
+// parameters whose types are inferred.
+const paramTypePrefix = "EGO_PARAM_TYPE_"
+
+// inferredParam is an attribute block parameter whose type is inferred from
+// the field of its component.
+type inferredParam struct {
+	component *ComponentStartBlock
+	attr      *AttrStartBlock
+	index     int // index of the parameter within the field's function type
+}
+
+// paramList returns the parameter list of the function of an attribute block.
+// Parameters without a type are given a placeholder type which is replaced
+// by inferParamTypes.
+func (t *Template) paramList(blk *ComponentStartBlock, attrBlock *AttrStartBlock) string {
+	a := make([]string, len(attrBlock.Params))
+	for i, p := range attrBlock.Params {
+		typ := p.Type
+		if typ == "" {
+			typ = fmt.Sprintf("%s%d", paramTypePrefix, len(t.inferredParams))
+			t.inferredParams = append(t.inferredParams, &inferredParam{component: blk, attr: attrBlock, index: i})
+		}
+		a[i] = p.Name + " " + typ
+	}
+	return strings.Join(a, ", ")
+}
+
+// inferParamTypes replaces the placeholder types of attribute block parameters
+// with the types of the parameters of the component's field. The component's
+// struct type must be declared in the file. Returns nil if there are no
+// placeholders.
+func inferParamTypes(fset *token.FileSet, f *ast.File, src []byte, params []*inferredParam) ([]byte, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	// Find struct types declared in the file.
+	structs := make(map[string]*ast.StructType)
+	for _, decl := range f.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.TYPE {
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				if st, ok := spec.Type.(*ast.StructType); ok {
+					structs[spec.Name.Name] = st
+				}
+			}
+		}
+	}
+
+	var edits []edit
+	var err error
+	ast.Inspect(f, func(node ast.Node) bool {
+		ident, ok := node.(*ast.Ident)
+		if !ok || err != nil || !strings.HasPrefix(ident.Name, paramTypePrefix) {
+			return err == nil
+		}
+		i, _ := strconv.Atoi(strings.TrimPrefix(ident.Name, paramTypePrefix))
+		p := params[i]
+
+		typ := fieldParamType(structs, p)
+		if typ == nil {
+			param := p.attr.Params[p.index]
+			err = NewSyntaxError(param.Pos, "Cannot infer type of attribute block parameter: %s", param.Name)
+			return false
+		}
+		var buf bytes.Buffer
+		if err = printer.Fprint(&buf, fset, typ); err != nil {
+			return false
+		}
+		edits = append(edits, edit{Start: fset.Position(ident.Pos()).Offset, End: fset.Position(ident.End()).Offset, Text: buf.Bytes()})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applyEdits(src, edits), nil
+}
+
+// fieldParamType returns the type of the parameter of the component's field
+// for an inferred parameter. Returns nil if the component is not declared in
+// the file or its field is not a function with enough parameters.
+func fieldParamType(structs map[string]*ast.StructType, p *inferredParam) ast.Expr {
+	if p.component.Package != "" {
+		return nil
+	}
+	st := structs[p.component.Name]
+	if st == nil {
+		return nil
+	}
+	for _, field := range st.Fields.List {
+		for _, name := range field.Names {
+			if name.Name != p.attr.Name {
+				continue
+			}
+			ft, ok := field.Type.(*ast.FuncType)
+			if !ok {
+				return nil
+			}
+			var types []ast.Expr
+			for _, param := range ft.Params.List {
+				for range param.Names {
+					types = append(types, param.Type)
+				}
+				if len(param.Names) == 0 {
+					types = append(types, param.Type)
+				}
+			}
+			if p.index >= len(types) {
+				return nil
+			}
+			return types[p.index]
+		}
+	}
+	return nil
+}
@@ -1,25 +1,152 @@
 package ego
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
 )
 
 // ParseFile parses an Ego template from a file.
 func ParseFile(path string) (*Template, error) {
+	return (&Parser{}).ParseFile(path)
+}
+
+// Parse parses an Ego template from a reader.
+// The path specifies the path name used in the compiled template's pragmas.
+func Parse(r io.Reader, path string) (*Template, error) {
+	return (&Parser{}).Parse(r, path)
+}
+
+// Parser parses Ego templates with optional syntax extensions.
+// The zero value parses the standard template syntax.
+type Parser struct {
+	// Interpolate enables "${expr}" print blocks within text. This is
+	// disabled by default as "${" is common in literal text such as scripts.
+	Interpolate bool
+
+	// Delims are the open & close delimiters of ego blocks, such as "{{%"
+	// and "%}}" for templates of PHP or ERB content where "<%" is literal
+	// text. Blank delimiters default to "<%" and "%>". Directives keep their
+	// markers after the open delimiter, such as "{{%= x %}}".
+	Delims [2]string
+
+	// LogicLess restricts code blocks within function bodies to conditionals
+	// and loops (e.g. "if", "else", "for" and closing braces). Any other
+	// statement is a syntax error. Top-level declarations are unaffected.
+	LogicLess bool
+
+	// PreserveWhitespace keeps all text byte-for-byte, including whitespace
+	// at the end of component bodies that is removed by default and
+	// whitespace next to trim markers, which are ignored. It also sets
+	// the template's PreserveWhitespace field. Text after the last top-level
+	// block is still removed as it is outside of any function.
+	PreserveWhitespace bool
+
+	// Minify collapses insignificant whitespace and removes HTML comments in
+	// text, such as the indentation between tags. Whitespace within pre,
+	// textarea, script & style elements is kept. Dynamic output is unchanged.
+	// It is ignored if PreserveWhitespace is set.
+	Minify bool
+
+	// Filters maps filter names to the Go functions they call, such as
+	// "upper" to "strings.ToUpper". Print blocks may pipe their value through
+	// filters, such as "<%= user.Name | upper | truncate(20) %>", which calls
+	// each function with the value and the filter's arguments. A "|" is only
+	// a pipe if every operand after it is a filter. See DefaultFilters.
+	Filters map[string]string
+
+	// PlainText parses templates whose print blocks are not HTML-escaped,
+	// such as emails & configuration files. It sets the PlainText field of
+	// templates without a mode directive and disables Minify.
+	PlainText bool
+
+	// StrictUTF8 reports text containing invalid UTF-8 as a syntax error.
+	// Otherwise it is reported as a warning on the template. Invalid bytes
+	// are written as-is, which usually indicates a source encoding problem.
+	StrictUTF8 bool
+
+	// IncludeRoot enables include & extends directives and is the directory
+	// that their files must be within. Files are read relative to the path
+	// of the file containing the directive. Directives are syntax errors if
+	// it is blank so that parsing does not read files by default, such as
+	// when parsing untrusted templates.
+	IncludeRoot string
+}
+
+// ParseFile parses an Ego template from a file.
+func (p *Parser) ParseFile(path string) (*Template, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	return Parse(f, path)
+	return p.Parse(f, path)
 }
 
 // Parse parses an Ego template from a reader.
 // The path specifies the path name used in the compiled template's pragmas.
-func Parse(r io.Reader, path string) (*Template, error) {
-	s := NewScanner(r, path)
-	t := &Template{Path: path}
+// If IncludeRoot is set, files of include & extends directives are read
+// relative to path and their blocks replace the directive. Parsing continues
+// after recoverable errors, such as mismatched end tags, and all syntax errors
+// are returned together as an ErrorList. A single syntax error is returned as
+// a *SyntaxError.
+func (p *Parser) Parse(r io.Reader, path string) (*Template, error) {
+	t := &Template{Path: path, PreserveWhitespace: p.PreserveWhitespace, PlainText: p.PlainText}
+	var errs ErrorList
+	blocks, err := p.parseBlocks(t, r, path, nil, &errs)
+	if err != nil {
+		return nil, errs.with(err)
+	}
+	t.Blocks = unwrapContentBlocks(blocks)
+	t.Blocks = normalizeBlocks(t.Blocks)
+	if len(p.Filters) > 0 {
+		expandFilters(t.Blocks, p.Filters)
+	}
+	if p.Minify && !t.PlainText && !p.PreserveWhitespace {
+		minifyBlocks(t.Blocks)
+	}
+	if !p.PreserveWhitespace {
+		t.Blocks = trimBlocks(t.Blocks, true)
+	}
+
+	if err := checkLoadStates(t.Blocks, nil); err != nil {
+		errs.add(err)
+	}
+
+	// Report text with invalid UTF-8 after adjacent text is joined.
+	for _, w := range checkUTF8(t.Blocks) {
+		if p.StrictUTF8 {
+			errs.add(NewSyntaxError(w.Pos, "%s", w.Message))
+			continue
+		}
+		t.Warnings = append(t.Warnings, w)
+	}
+
+	if p.LogicLess {
+		if err := checkLogicLess(t); err != nil {
+			errs.add(err)
+		}
+	}
+
+	if len(errs) > 0 {
+		errs.sort()
+		return nil, errs.err()
+	}
+	return t, nil
+}
+
+// parseBlocks parses the blocks of a template file. The extends directive of
+// the file, if any, is replaced by the blocks of its layout. Layouts are the
+// paths of the files which extend the file, which are used to detect cycles.
+func (p *Parser) parseBlocks(t *Template, r io.Reader, path string, layouts []string, errs *ErrorList) ([]Block, error) {
+	s := &blockScanner{p: p, t: t}
+	s.push(NewScanner(r, path), path)
+	var blocks []Block
+	var hasContent, hasMode bool
 	for {
 		blk, err := s.Scan()
 		if err == io.EOF {
@@ -30,97 +157,676 @@ func Parse(r io.Reader, path string) (*Template, error) {
 
 		switch blk := blk.(type) {
 		case *ComponentStartBlock:
-			if err := parseComponentBlock(s, blk); err != nil {
+			if err := p.parseComponentBlock(s, blk, errs); err != nil {
 				return nil, err
 			}
 		case *ComponentEndBlock:
-			return nil, NewSyntaxError(blk.Pos, "Component end block found without matching start block: %s", shortComponentBlockString(blk))
+			errs.add(NewSyntaxError(blk.Pos, "Component end block found without matching start block: %s", shortComponentBlockString(blk)))
+			continue
 		case *AttrStartBlock:
-			return nil, NewSyntaxError(blk.Pos, "Attribute start block found outside of component: %s", shortComponentBlockString(blk))
+			errs.add(NewSyntaxError(blk.Pos, "Attribute start block found outside of component: %s", shortComponentBlockString(blk)))
+			if err := p.parseAttrBlock(s, blk, errs); err != nil {
+				return nil, err
+			}
+			continue
 		case *AttrEndBlock:
-			return nil, NewSyntaxError(blk.Pos, "Attribute end block found outside of component: %s", shortComponentBlockString(blk))
+			errs.add(NewSyntaxError(blk.Pos, "Attribute end block found outside of component: %s", shortComponentBlockString(blk)))
+			continue
+		case *BuildBlock:
+			if hasContent {
+				errs.add(NewSyntaxError(blk.Pos, "Build directive must appear at the top of the template"))
+				continue
+			}
+		case *ModeBlock:
+			if hasContent {
+				errs.add(NewSyntaxError(blk.Pos, "Mode directive must appear at the top of the template"))
+				continue
+			} else if hasMode {
+				errs.add(NewSyntaxError(blk.Pos, "Duplicate mode directive"))
+				continue
+			}
+			hasMode, t.PlainText = true, blk.Mode == ModeText
+		case *FuncBlock:
+			// Whitespace between functions is not written by either function.
+			for n := len(blocks); n > 0; n-- {
+				text, ok := blocks[n-1].(*TextBlock)
+				if !ok {
+					break
+				} else if text.Content = strings.TrimRight(text.Content, " \t\r\n"); text.Content != "" {
+					break
+				}
+				blocks = blocks[:n-1]
+			}
+		case *AppendStartBlock:
+			if err := p.parseAppendBlock(s, blk, errs); err != nil {
+				return nil, err
+			}
+		case *FormatStartBlock:
+			if err := p.parseFormatBlock(s, blk, errs); err != nil {
+				return nil, err
+			}
+		case *FormatEndBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Format region end found without matching start: <%%/%s %%>", blk.Format))
+			continue
+		case *ContentStartBlock:
+			if err := p.parseContentBlock(s, blk, errs); err != nil {
+				return nil, err
+			}
+		case *ContentEndBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Block end found without matching start"))
+			continue
+		case *YieldStartBlock:
+			if err := p.parseYieldBlock(s, blk, errs); err != nil {
+				return nil, err
+			}
+		case *YieldEndBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Yield region end found without matching start"))
+			continue
 		}
 
-		t.Blocks = append(t.Blocks, blk)
+		// Only whitespace, comments & other file directives may precede a
+		// build or mode directive.
+		switch blk := blk.(type) {
+		case *TextBlock:
+			if strings.TrimSpace(blk.Content) != "" {
+				hasContent = true
+			}
+		case *CommentBlock, *BuildBlock, *ModeBlock:
+		default:
+			hasContent = true
+		}
+
+		blocks = append(blocks, blk)
 	}
-	t.Blocks = normalizeBlocks(t.Blocks)
-	return t, nil
+
+	checkContentBlocks(blocks, false, errs)
+	return p.extend(t, blocks, path, layouts, errs)
+}
+
+// extend replaces the extends directive in blocks with the blocks of its
+// layout. Content blocks next to the directive replace the layout's content
+// blocks of the same name. Whitespace-only text is removed as the layout
+// determines the output. Returns blocks as-is if there is no directive.
+func (p *Parser) extend(t *Template, blocks []Block, path string, layouts []string, errs *ErrorList) ([]Block, error) {
+	var ext *ExtendsBlock
+	for _, blk := range blocks {
+		if blk, ok := blk.(*ExtendsBlock); ok {
+			if ext != nil {
+				errs.add(NewSyntaxError(blk.Pos, "Duplicate extends directive"))
+				continue
+			}
+			ext = blk
+		}
+	}
+	if ext == nil {
+		return blocks, nil
+	}
+
+	layoutPath, err := p.resolveInclude(ext.Pos, "extends", ext.Path)
+	if err != nil {
+		return nil, err
+	}
+	layouts = append(append([]string{}, layouts...), filepath.Clean(path))
+	for i := range layouts {
+		if layouts[i] == filepath.Clean(layoutPath) {
+			cycle := append(append([]string{}, layouts[i:]...), layoutPath)
+			return nil, NewSyntaxError(ext.Pos, "Extends cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	f, err := os.Open(layoutPath)
+	if err != nil {
+		return nil, NewSyntaxError(ext.Pos, "Cannot read layout file: %s", ext.Path)
+	}
+	defer f.Close()
+	if !stringSliceContains(t.Includes, layoutPath) {
+		t.Includes = append(t.Includes, layoutPath)
+	}
+	layout, err := p.parseBlocks(t, f, layoutPath, layouts, errs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect content blocks & remove whitespace between directives.
+	var other []Block
+	defs := make(map[string]*ContentStartBlock)
+	for _, blk := range blocks {
+		switch blk := blk.(type) {
+		case *ContentStartBlock:
+			if defs[blk.Name] != nil {
+				errs.add(NewSyntaxError(blk.Pos, "Duplicate block: %s", blk.Name))
+			}
+			defs[blk.Name] = blk
+		case *TextBlock:
+			if strings.TrimSpace(blk.Content) != "" {
+				other = append(other, blk)
+			}
+		case *ExtendsBlock:
+			if blk == ext {
+				other = append(other, layout...)
+			}
+		default:
+			other = append(other, blk)
+		}
+	}
+
+	// Replace the layout's content blocks.
+	used := make(map[string]bool)
+	inspectBlocks(layout, func(blk Block) bool {
+		if blk, ok := blk.(*ContentStartBlock); ok && defs[blk.Name] != nil {
+			blk.Yield, used[blk.Name] = defs[blk.Name].Yield, true
+			return false
+		}
+		return true
+	})
+	for name, def := range defs {
+		if !used[name] {
+			errs.add(NewSyntaxError(def.Pos, "Block not found in layout: %s", name))
+		}
+	}
+	return other, nil
+}
+
+// checkContentBlocks reports content directives nested within other blocks,
+// such as components. They may only be nested within content blocks. Nested
+// extends directives are reported as they are parsed.
+func checkContentBlocks(a []Block, nested bool, errs *ErrorList) {
+	for _, blk := range a {
+		switch blk := blk.(type) {
+		case *ContentStartBlock:
+			if nested {
+				errs.add(NewSyntaxError(blk.Pos, "Block directive must appear at the top level of the template: %s", blk.Name))
+			}
+			checkContentBlocks(blk.Yield, nested, errs)
+		case *ComponentStartBlock:
+			for _, attrBlock := range blk.AttrBlocks {
+				checkContentBlocks(attrBlock.Yield, true, errs)
+			}
+			checkContentBlocks(blk.Yield, true, errs)
+		case *AppendStartBlock:
+			checkContentBlocks(blk.Yield, true, errs)
+		case *FormatStartBlock:
+			checkContentBlocks(blk.Yield, true, errs)
+		case *YieldStartBlock:
+			checkContentBlocks(blk.Yield, true, errs)
+		}
+	}
+}
+
+// unwrapContentBlocks replaces content blocks with their yielded blocks.
+func unwrapContentBlocks(a []Block) []Block {
+	var other []Block
+	for _, blk := range a {
+		if blk, ok := blk.(*ContentStartBlock); ok {
+			other = append(other, unwrapContentBlocks(blk.Yield)...)
+			continue
+		}
+		other = append(other, blk)
+	}
+	return other
+}
+
+// resolvePath returns the path of a file referenced by a directive in the
+// file at base. Relative paths are relative to the directory of base.
+func resolvePath(base, path string) string {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(base), path)
+}
+
+// resolveInclude returns the path of the file of an include or extends
+// directive at pos. Returns an error if IncludeRoot is blank or the file is
+// not within it.
+func (p *Parser) resolveInclude(pos Pos, directive, path string) (string, error) {
+	if p.IncludeRoot == "" {
+		return "", NewSyntaxError(pos, "Include root required for %s directive: %s", directive, path)
+	}
+	resolved := resolvePath(pos.Path, path)
+
+	root, err := filepath.Abs(p.IncludeRoot)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(resolved)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, abs); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", NewSyntaxError(pos, "File of %s directive is outside of the include root: %s", directive, path)
+	}
+	return resolved, nil
+}
+
+// blockScanner reads blocks from a template's scanner and replaces include
+// directives with the blocks of the included files. Included blocks keep the
+// positions within their own files.
+type blockScanner struct {
+	p     *Parser
+	t     *Template
+	stack []*Scanner
+	paths []string // cleaned path of each scanner in stack
+}
+
+// Scan returns the next block from the innermost included file.
+func (s *blockScanner) Scan() (Block, error) {
+	for {
+		blk, err := s.stack[len(s.stack)-1].Scan()
+		if err == io.EOF && len(s.stack) > 1 {
+			s.stack, s.paths = s.stack[:len(s.stack)-1], s.paths[:len(s.paths)-1]
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		inc, ok := blk.(*IncludeBlock)
+		if !ok {
+			return blk, nil
+		} else if err := s.include(inc); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// push adds the scanner of a file to the stack.
+func (s *blockScanner) push(scanner *Scanner, path string) {
+	scanner.Interpolate = s.p.Interpolate
+	scanner.Delims = s.p.Delims
+	s.stack = append(s.stack, scanner)
+	s.paths = append(s.paths, filepath.Clean(path))
+}
+
+// include reads the file of an include directive and scans its blocks next.
+// Returns an error if the file includes itself, directly or indirectly.
+func (s *blockScanner) include(blk *IncludeBlock) error {
+	path, err := s.p.resolveInclude(blk.Pos, "include", blk.Path)
+	if err != nil {
+		return err
+	}
+
+	for i := range s.paths {
+		if s.paths[i] == filepath.Clean(path) {
+			cycle := append(append([]string{}, s.paths[i:]...), path)
+			return NewSyntaxError(blk.Pos, "Include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NewSyntaxError(blk.Pos, "Cannot read included file: %s", blk.Path)
+	}
+	if !stringSliceContains(s.t.Includes, path) {
+		s.t.Includes = append(s.t.Includes, path)
+	}
+	s.push(NewScanner(bytes.NewReader(buf), path), path)
+	return nil
+}
+
+// normalizeBlocks joins adjacent text blocks and, unless whitespace is
+// preserved, removes trailing whitespace-only text blocks.
+func (p *Parser) normalizeBlocks(a []Block) []Block {
+	if p.PreserveWhitespace {
+		return joinAdjacentTextBlocks(a)
+	}
+	return normalizeBlocks(a)
 }
 
-func parseComponentBlock(s *Scanner, start *ComponentStartBlock) error {
+func (p *Parser) parseComponentBlock(s *blockScanner, start *ComponentStartBlock, errs *ErrorList) error {
 	if start.Closed {
-		start.Yield = normalizeBlocks(start.Yield)
+		start.Yield = p.normalizeBlocks(start.Yield)
 		return nil
 	}
 
+	return p.parseRegion(s, &region{
+		start:    start,
+		yield:    &start.Yield,
+		expected: "component close tag",
+		suffix:   ": " + shortComponentBlockString(start),
+		end: func(blk Block, errs *ErrorList) bool {
+			end, ok := blk.(*ComponentEndBlock)
+			if ok && end.Name != start.Name {
+				errs.add(NewSyntaxError(end.Pos, "Component end block mismatch: %s != %s", shortComponentBlockString(start), shortComponentBlockString(end)))
+			}
+			return ok
+		},
+	}, errs)
+}
+
+func (p *Parser) parseAttrBlock(s *blockScanner, start *AttrStartBlock, errs *ErrorList) error {
+	return p.parseRegion(s, &region{
+		start:    start,
+		yield:    &start.Yield,
+		expected: "attribute close tag",
+		suffix:   ": " + shortComponentBlockString(start),
+		end: func(blk Block, errs *ErrorList) bool {
+			end, ok := blk.(*AttrEndBlock)
+			if ok && end.Name != start.Name {
+				errs.add(NewSyntaxError(end.Pos, "Attribute end block mismatch: %s != %s", shortComponentBlockString(start), shortComponentBlockString(end)))
+			}
+			return ok
+		},
+	}, errs)
+}
+
+func (p *Parser) parseAppendBlock(s *blockScanner, start *AppendStartBlock, errs *ErrorList) error {
+	return p.parseRegion(s, &region{
+		start:    start,
+		yield:    &start.Yield,
+		expected: "close of append directive",
+		end: func(blk Block, errs *ErrorList) bool {
+			_, ok := blk.(*AppendEndBlock)
+			return ok
+		},
+	}, errs)
+}
+
+func (p *Parser) parseFormatBlock(s *blockScanner, start *FormatStartBlock, errs *ErrorList) error {
+	return p.parseRegion(s, &region{
+		start:    start,
+		yield:    &start.Yield,
+		expected: "close of format region",
+		suffix:   ": <%" + start.Format + " %>",
+		end: func(blk Block, errs *ErrorList) bool {
+			end, ok := blk.(*FormatEndBlock)
+			if ok && end.Format != start.Format {
+				errs.add(NewSyntaxError(end.Pos, "Format region end mismatch: <%%%s %%> != <%%/%s %%>", start.Format, end.Format))
+			}
+			return ok
+		},
+	}, errs)
+}
+
+// parseYieldBlock collects the default content of a yield region until its
+// close tag.
+func (p *Parser) parseYieldBlock(s *blockScanner, start *YieldStartBlock, errs *ErrorList) error {
+	return p.parseRegion(s, &region{
+		start:    start,
+		yield:    &start.Yield,
+		expected: "close of yield region",
+		end: func(blk Block, errs *ErrorList) bool {
+			_, ok := blk.(*YieldEndBlock)
+			return ok
+		},
+	}, errs)
+}
+
+// parseContentBlock collects the blocks of a named content block until its
+// end directive. Content blocks may be nested.
+func (p *Parser) parseContentBlock(s *blockScanner, start *ContentStartBlock, errs *ErrorList) error {
+	return p.parseRegion(s, &region{
+		start:    start,
+		yield:    &start.Yield,
+		expected: "end of block",
+		suffix:   ": " + start.Name,
+		end: func(blk Block, errs *ErrorList) bool {
+			_, ok := blk.(*ContentEndBlock)
+			return ok
+		},
+	}, errs)
+}
+
+// region represents a block whose nested blocks are parsed until its end
+// block, such as a component or a format region.
+type region struct {
+	start    Block
+	yield    *[]Block
+	expected string // the end block, used in errors
+	suffix   string // identifies the start block in errors, if set
+
+	// end returns true if blk is the end block of the region. Mismatched end
+	// blocks of the same kind are reported and also end the region.
+	end func(blk Block, errs *ErrorList) bool
+}
+
+// parseRegion collects the nested blocks of a region until its end block.
+// Nested regions are parsed recursively. End blocks of other regions either
+// return an error, if they may close an enclosing region, or are reported.
+func (p *Parser) parseRegion(s *blockScanner, r *region, errs *ErrorList) error {
 	for {
 		blk, err := s.Scan()
 		if err == io.EOF {
-			return NewSyntaxError(start.Pos, "Expected component close tag, found EOF: %s", shortComponentBlockString(start))
+			return NewSyntaxError(Position(r.start), "Expected %s, found EOF%s", r.expected, r.suffix)
 		} else if err != nil {
 			return err
 		}
 
+		if r.end(blk, errs) {
+			*r.yield = p.normalizeBlocks(*r.yield)
+			return nil
+		}
+
 		switch blk := blk.(type) {
 		case *ComponentStartBlock:
-			if err := parseComponentBlock(s, blk); err != nil {
+			if err := p.parseComponentBlock(s, blk, errs); err != nil {
 				return err
 			}
-			start.Yield = append(start.Yield, blk)
 
 		case *ComponentEndBlock:
-			if blk.Name != start.Name {
-				return NewSyntaxError(blk.Pos, "Component end block mismatch: %s != %s", shortComponentBlockString(start), shortComponentBlockString(blk))
+			if _, ok := r.start.(*AttrStartBlock); ok {
+				return NewSyntaxError(blk.Pos, "Expected attribute close block, found %s", shortComponentBlockString(blk))
 			}
-			start.Yield = normalizeBlocks(start.Yield)
-			return nil
+			errs.add(NewSyntaxError(blk.Pos, "Component end block found without matching start block: %s", shortComponentBlockString(blk)))
+			continue
 
 		case *AttrStartBlock:
-			if err := parseAttrBlock(s, blk); err != nil {
+			switch start := r.start.(type) {
+			case *ComponentStartBlock:
+				if err := p.parseAttrBlock(s, blk, errs); err != nil {
+					return err
+				}
+				start.AttrBlocks = append(start.AttrBlocks, blk)
+				continue
+			case *AttrStartBlock:
+				errs.add(NewSyntaxError(blk.Pos, "Attribute block found within attribute block: %s", shortComponentBlockString(blk)))
+			default:
+				errs.add(NewSyntaxError(blk.Pos, "Attribute start block found outside of component: %s", shortComponentBlockString(blk)))
+			}
+			if err := p.parseAttrBlock(s, blk, errs); err != nil {
 				return err
 			}
-			start.AttrBlocks = append(start.AttrBlocks, blk)
+			continue
 
 		case *AttrEndBlock:
-			return NewSyntaxError(blk.Pos, "Attribute end block found without start block: %s", shortComponentBlockString(blk))
+			if _, ok := r.start.(*ComponentStartBlock); ok {
+				errs.add(NewSyntaxError(blk.Pos, "Attribute end block found without start block: %s", shortComponentBlockString(blk)))
+			} else {
+				errs.add(NewSyntaxError(blk.Pos, "Attribute end block found outside of component: %s", shortComponentBlockString(blk)))
+			}
+			continue
 
-		default:
-			start.Yield = append(start.Yield, blk)
+		case *BuildBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Build directive must appear at the top of the template"))
+			continue
+
+		case *ModeBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Mode directive must appear at the top of the template"))
+			continue
+
+		case *FuncBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Function directive must appear at the top level of the template"))
+			continue
+
+		case *ExtendsBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Extends directive must appear at the top level of the template"))
+			continue
+
+		case *AppendStartBlock:
+			if err := p.parseAppendBlock(s, blk, errs); err != nil {
+				return err
+			}
+
+		case *AppendEndBlock:
+			return NewSyntaxError(blk.Pos, "Expected %s, found end of append directive%s", r.expected, r.suffix)
+
+		case *FormatStartBlock:
+			if _, ok := r.start.(*FormatStartBlock); ok {
+				errs.add(NewSyntaxError(blk.Pos, "Format region found within format region: <%%%s %%>", blk.Format))
+				if err := p.parseFormatBlock(s, blk, errs); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := p.parseFormatBlock(s, blk, errs); err != nil {
+				return err
+			}
+
+		case *FormatEndBlock:
+			return NewSyntaxError(blk.Pos, "Expected %s, found end of format region%s", r.expected, r.suffix)
+
+		case *ContentStartBlock:
+			if err := p.parseContentBlock(s, blk, errs); err != nil {
+				return err
+			}
+
+		case *ContentEndBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Block end found without matching start"))
+			continue
+
+		case *YieldStartBlock:
+			if err := p.parseYieldBlock(s, blk, errs); err != nil {
+				return err
+			}
+
+		case *YieldEndBlock:
+			errs.add(NewSyntaxError(blk.Pos, "Yield region end found without matching start"))
+			continue
 		}
+
+		*r.yield = append(*r.yield, blk)
 	}
 }
 
-func parseAttrBlock(s *Scanner, start *AttrStartBlock) error {
-	for {
-		blk, err := s.Scan()
-		if err == io.EOF {
-			return NewSyntaxError(start.Pos, "Expected attribute close tag, found EOF: %s", shortComponentBlockString(start))
-		} else if err != nil {
-			return err
+// checkUTF8 returns a warning for each text block containing invalid UTF-8.
+// Each warning is positioned at the line of the first invalid byte.
+func checkUTF8(a []Block) []*Warning {
+	var warnings []*Warning
+	inspectBlocks(a, func(blk Block) bool {
+		text, ok := blk.(*TextBlock)
+		if !ok || utf8.ValidString(text.Content) {
+			return true
+		}
+
+		// The position of text is after its first character.
+		pos, i := text.Pos, 0
+		for {
+			r, n := utf8.DecodeRuneInString(text.Content[i:])
+			if r == utf8.RuneError && n == 1 {
+				break
+			}
+			i += n
+		}
+		if i > 0 {
+			pos.LineNo += strings.Count(text.Content[1:i], "\n")
+		}
+		warnings = append(warnings, &Warning{Pos: pos, Message: "Invalid UTF-8 in text"})
+		return true
+	})
+	return warnings
+}
+
+// trimBlocks removes whitespace from text next to blocks with trim markers in
+// a and its nested block lists. Text blocks that become empty are removed. If
+// top is true then a is the top-level block list, which starts & ends its
+// first & last lines. Nested block lists start & end next to other tags.
+func trimBlocks(a []Block, top bool) []Block {
+	for i, blk := range a {
+		left, right := blockTrim(blk)
+		if text, ok := prevTextBlock(a, i); ok && left != TrimNone {
+			text.Content = trimTextRight(text.Content, left, top && i == 1)
+		}
+		if text, ok := nextTextBlock(a, i); ok && right != TrimNone {
+			content := trimTextLeft(text.Content, right, top && i+2 == len(a))
+			if removed := text.Content[:len(text.Content)-len(content)]; removed != "" {
+				// The position of text is after its first character.
+				text.Pos.LineNo += strings.Count(removed[1:], "\n")
+			}
+			text.Content = content
 		}
 
 		switch blk := blk.(type) {
 		case *ComponentStartBlock:
-			if err := parseComponentBlock(s, blk); err != nil {
-				return err
+			for _, attrBlock := range blk.AttrBlocks {
+				attrBlock.Yield = trimBlocks(attrBlock.Yield, false)
 			}
-			start.Yield = append(start.Yield, blk)
+			blk.Yield = trimBlocks(blk.Yield, false)
+		case *AppendStartBlock:
+			blk.Yield = trimBlocks(blk.Yield, false)
+		case *FormatStartBlock:
+			blk.Yield = trimBlocks(blk.Yield, false)
+		case *YieldStartBlock:
+			blk.Yield = trimBlocks(blk.Yield, false)
+		}
+	}
 
-		case *ComponentEndBlock:
-			return NewSyntaxError(blk.Pos, "Expected attribute close block, found %s", shortComponentBlockString(blk))
+	other := a[:0]
+	for _, blk := range a {
+		if text, ok := blk.(*TextBlock); !ok || text.Content != "" {
+			other = append(other, blk)
+		}
+	}
+	return other
+}
 
-		case *AttrStartBlock:
-			return NewSyntaxError(blk.Pos, "Attribute block found within attribute block: %s", shortComponentBlockString(blk))
+// blockTrim returns the trim markers of a block.
+func blockTrim(blk Block) (left, right Trim) {
+	switch blk := blk.(type) {
+	case *CodeBlock:
+		return blk.TrimLeft, blk.TrimRight
+	case *PrintBlock:
+		return blk.TrimLeft, blk.TrimRight
+	case *RawPrintBlock:
+		return blk.TrimLeft, blk.TrimRight
+	case *CommentBlock:
+		return blk.TrimLeft, blk.TrimRight
+	default:
+		return TrimNone, TrimNone
+	}
+}
 
-		case *AttrEndBlock:
-			if blk.Name != start.Name {
-				return NewSyntaxError(blk.Pos, "Attribute end block mismatch: %s != %s", shortComponentBlockString(start), shortComponentBlockString(blk))
-			}
-			start.Yield = normalizeBlocks(start.Yield)
-			return nil
+func prevTextBlock(a []Block, i int) (*TextBlock, bool) {
+	if i == 0 {
+		return nil, false
+	}
+	text, ok := a[i-1].(*TextBlock)
+	return text, ok
+}
 
-		default:
-			start.Yield = append(start.Yield, blk)
-		}
+func nextTextBlock(a []Block, i int) (*TextBlock, bool) {
+	if i+1 >= len(a) {
+		return nil, false
+	}
+	text, ok := a[i+1].(*TextBlock)
+	return text, ok
+}
+
+// trimTextRight removes whitespace from the end of text before a block. For
+// line trims, the whitespace is only removed if it starts a line. The start
+// of the text is the start of a line if first is true.
+func trimTextRight(s string, trim Trim, first bool) string {
+	if trim == TrimAll {
+		return strings.TrimRightFunc(s, isWhitespace)
+	}
+	if other := strings.TrimRight(s, " \t"); strings.HasSuffix(other, "\n") || (other == "" && first) {
+		return other
+	}
+	return s
+}
+
+// trimTextLeft removes whitespace from the start of text after a block. For
+// line trims, the whitespace is only removed if it ends a line, along with the
+// newline. The end of the text is the end of a line if last is true.
+func trimTextLeft(s string, trim Trim, last bool) string {
+	if trim == TrimAll {
+		return strings.TrimLeftFunc(s, isWhitespace)
+	}
+	other := strings.TrimLeft(s, " \t")
+	if strings.HasPrefix(other, "\r\n") {
+		return other[2:]
+	} else if strings.HasPrefix(other, "\n") {
+		return other[1:]
+	} else if other == "" && last {
+		return other
 	}
+	return s
 }
@@ -0,0 +1,634 @@
+package ego_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that interpolations are parsed into print blocks when enabled.
+func TestParser_Parse_Interpolate(t *testing.T) {
+	p := &ego.Parser{Interpolate: true}
+	tmpl, err := p.Parse(strings.NewReader(`<ego:Greeting>Hello ${name}, costs $${price}</ego:Greeting>`), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blk := tmpl.Blocks[0].(*ego.ComponentStartBlock)
+	var a []string
+	for _, b := range blk.Yield {
+		switch b := b.(type) {
+		case *ego.TextBlock:
+			a = append(a, "text:"+b.Content)
+		case *ego.PrintBlock:
+			a = append(a, "print:"+b.Content)
+		}
+	}
+	if exp := []string{"text:Hello ", "print:name", "text:, costs ${price}"}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected blocks: %q", a)
+	}
+}
+
+// Ensure that logic-less templates only allow conditionals and loops.
+func TestParser_Parse_LogicLess(t *testing.T) {
+	p := &ego.Parser{LogicLess: true}
+
+	t.Run("OK", func(t *testing.T) {
+		if _, err := p.Parse(strings.NewReader(`<%
+package main
+
+type Page struct {
+	Items []string
+}
+
+func (p *Page) Render(ctx context.Context, w io.Writer) {
+%><% if len(p.Items) == 0 { %>none<% } else if len(p.Items) == 1 { %>one<% } else { %><% for _, item := range p.Items { %><ego:Item Name=item><%= item %></ego:Item><% } %><% } %><% } %>`), "tmpl.ego"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, tt := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "Assignment",
+			src:  "<% package main\n\nfunc render() { %><% x := 1 %><% } %>",
+			err:  "Code not allowed in logic-less template: x := 1 at tmpl.ego:3",
+		},
+		{
+			name: "SameBlock",
+			src:  "<% package main\n\nfunc render() { doSomething() %><% } %>",
+			err:  "Code not allowed in logic-less template: package main\n\nfunc render() { doSomething() at tmpl.ego:1",
+		},
+		{
+			name: "InlineIf",
+			src:  "<% package main\n\nfunc render() { %><% if ok { doSomething() } %><% } %>",
+			err:  "Code not allowed in logic-less template: if ok { doSomething() } at tmpl.ego:3",
+		},
+		{
+			name: "Yield",
+			src:  "<% package main\n\nfunc render() { %><ego:Item><% x++ %></ego:Item><% } %>",
+			err:  "Code not allowed in logic-less template: x++ at tmpl.ego:3",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.Parse(strings.NewReader(tt.src), "tmpl.ego"); err == nil || err.Error() != tt.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader("<% package main\n\nfunc render() { %><% x := 1 %><% } %>"), "tmpl.ego"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Ensure that build directives are only allowed at the top of a template.
+func TestParse_BuildBlock(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader("\n<%build linux %>\n<% package foo %>"), "tmpl.ego"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrNotTop", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader("<% package foo %>\n<%build linux %>"), "tmpl.ego"); err == nil || err.Error() != "Build directive must appear at the top of the template at tmpl.ego:2" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrInComponent", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader("<ego:Foo><%build linux %></ego:Foo>"), "tmpl.ego"); err == nil || err.Error() != "Build directive must appear at the top of the template at tmpl.ego:1" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that mode directives set the template's mode and are only allowed at
+// the top of a template.
+func TestParse_ModeBlock(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<%build linux %>\n<%@ mode text %>\n<% package foo %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if !tmpl.PlainText {
+			t.Fatal("expected plain text template")
+		}
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		tmpl, err := (&ego.Parser{PlainText: true}).Parse(strings.NewReader("<%@ mode html %>\n<% package foo %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if tmpl.PlainText {
+			t.Fatal("expected HTML template")
+		}
+	})
+
+	for _, tt := range []struct {
+		name, src, err string
+	}{
+		{"ErrNotTop", "<% package foo %>\n<%@ mode text %>", "Mode directive must appear at the top of the template at tmpl.ego:2"},
+		{"ErrInComponent", "<ego:Foo><%@ mode text %></ego:Foo>", "Mode directive must appear at the top of the template at tmpl.ego:1"},
+		{"ErrDuplicate", "<%@ mode text %>\n<%@ mode html %>", "Duplicate mode directive at tmpl.ego:2"},
+		{"ErrUnknown", "<%@ mode xml %>", "Unknown template mode: xml at tmpl.ego:1"},
+		{"ErrNoMode", "<%@ mode %>", "Expected mode in mode directive at tmpl.ego:1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ego.Parse(strings.NewReader(tt.src), "tmpl.ego"); err == nil || err.Error() != tt.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// Ensure that format regions must be balanced and cannot be nested.
+func TestParse_FormatBlock(t *testing.T) {
+	for _, tt := range []struct {
+		src, err string
+	}{
+		{"<%json %>x", "Expected close of format region, found EOF: <%json %> at tmpl.ego:1"},
+		{"x<%/json %>", "Format region end found without matching start: <%/json %> at tmpl.ego:1"},
+		{"<%json %><%/html %>", "Format region end mismatch: <%json %> != <%/html %> at tmpl.ego:1"},
+		{"<%json %><%html %><%/html %><%/json %>", "Format region found within format region: <%html %> at tmpl.ego:1"},
+		{"<ego:Card><%/json %></ego:Card>", "Expected component close tag, found end of format region: <ego:Card> at tmpl.ego:1"},
+	} {
+		if _, err := ego.Parse(strings.NewReader(tt.src), "tmpl.ego"); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: unexpected error: %v", tt.src, err)
+		}
+	}
+}
+
+// Ensure that load states are only allowed in the body of a component with a loader.
+func TestParse_LoadState(t *testing.T) {
+	for _, tt := range []struct {
+		src, err string
+	}{
+		{"<%success %>x", "Load state found outside of component with loader: <%success %> at tmpl.ego:1"},
+		{"<ego:Card><%loading %></ego:Card>", "Load state found outside of component with loader: <%loading %> at tmpl.ego:1"},
+		{"<ego:Card load=f>x<%success %></ego:Card>", "Expected load state before content: <ego:Card> at tmpl.ego:1"},
+		{"<ego:Card load=f><%error %><%error e %></ego:Card>", "Duplicate load state in component: <%error %> at tmpl.ego:1"},
+		{"<ego:Card load=f><%loading x %></ego:Card>", "Unexpected variable in load state: <%loading x %> at tmpl.ego:1"},
+		{"<ego:Card load=f load=g></ego:Card>", "Duplicate loader on component: <ego:Card> at tmpl.ego:1"},
+		{"<ego:Card load></ego:Card>", "Expected expression for component loader at tmpl.ego:1"},
+	} {
+		if _, err := ego.Parse(strings.NewReader(tt.src), "tmpl.ego"); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: unexpected error: %v", tt.src, err)
+		}
+	}
+}
+
+// Ensure that include directives are replaced by the blocks of the included
+// files, positioned within those files.
+func TestParse_Include(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"page.ego":            "<h1><%@ include \"partials/header.ego\" %></h1>",
+		"partials/header.ego": "Hello, <%= name %>\n<%@ include \"../footer.ego\" %>",
+		"footer.ego":          "Bye",
+		"a.ego":               "<%@ include \"b.ego\" %>",
+		"b.ego":               "\n<%@ include \"a.ego\" %>",
+		"z.ego":               "</ego:A><%@ include \"partials/bad.ego\" %>",
+		"partials/bad.ego":    "x\n</ego:B>",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0777); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		tmpl, err := (&ego.Parser{IncludeRoot: dir}).ParseFile(filepath.Join(dir, "page.ego"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var a []string
+		for _, blk := range tmpl.Blocks {
+			pos := ego.Position(blk)
+			rel, _ := filepath.Rel(dir, pos.Path)
+			a = append(a, fmt.Sprintf("%T@%s:%d", blk, filepath.ToSlash(rel), pos.LineNo))
+		}
+		if exp := []string{
+			"*ego.TextBlock@page.ego:1",
+			"*ego.TextBlock@partials/header.ego:1",
+			"*ego.PrintBlock@partials/header.ego:1",
+			"*ego.TextBlock@partials/header.ego:2",
+			"*ego.TextBlock@footer.ego:1",
+			"*ego.TextBlock@page.ego:1",
+		}; !reflect.DeepEqual(a, exp) {
+			t.Fatalf("unexpected blocks: %q", a)
+		}
+		if exp := []string{filepath.Join(dir, "partials", "header.ego"), filepath.Join(dir, "footer.ego")}; !reflect.DeepEqual(tmpl.Includes, exp) {
+			t.Fatalf("unexpected includes: %q", tmpl.Includes)
+		}
+	})
+
+	t.Run("ErrCycle", func(t *testing.T) {
+		a, b := filepath.Join(dir, "a.ego"), filepath.Join(dir, "b.ego")
+		if _, err := (&ego.Parser{IncludeRoot: dir}).ParseFile(a); err == nil || err.Error() != fmt.Sprintf("Include cycle: %s -> %s -> %s at %s:2", a, b, a, b) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrorsByPath", func(t *testing.T) {
+		_, err := (&ego.Parser{IncludeRoot: dir}).ParseFile(filepath.Join(dir, "z.ego"))
+		if errs, ok := err.(ego.ErrorList); !ok || len(errs) != 2 {
+			t.Fatalf("unexpected error: %v", err)
+		} else if rel, _ := filepath.Rel(dir, errs[0].Pos.Path); filepath.ToSlash(rel) != "partials/bad.ego" {
+			t.Fatalf("unexpected first error: %s", errs[0])
+		}
+	})
+
+	t.Run("ErrNoRoot", func(t *testing.T) {
+		if _, err := ego.ParseFile(filepath.Join(dir, "page.ego")); err == nil || err.Error() != fmt.Sprintf("Include root required for include directive: partials/header.ego at %s:1", filepath.Join(dir, "page.ego")) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrOutsideRoot", func(t *testing.T) {
+		path := filepath.Join(dir, "partials", "header.ego")
+		if _, err := (&ego.Parser{IncludeRoot: filepath.Join(dir, "partials")}).ParseFile(path); err == nil || err.Error() != fmt.Sprintf("File of include directive is outside of the include root: ../footer.ego at %s:2", path) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNotFound", func(t *testing.T) {
+		if _, err := (&ego.Parser{IncludeRoot: dir}).Parse(strings.NewReader(`<%@ include "missing.ego" %>`), filepath.Join(dir, "tmpl.ego")); err == nil || !strings.HasPrefix(err.Error(), "Cannot read included file: missing.ego at ") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNoPath", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader(`<%@ include header.ego %>`), "tmpl.ego"); err == nil || err.Error() != "Expected string literal in include directive: header.ego at tmpl.ego:1" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrEmptyPath", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader(`<%@ include %>`), "tmpl.ego"); err == nil || err.Error() != "Expected file path in include directive at tmpl.ego:1" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that extends directives are replaced by their layouts with content
+// blocks filled by the extending template.
+func TestParse_Extends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"layouts/base.ego":   "<html><head><title><%@ block \"title\" %>Site<%@ end %></title><%@ block \"head\" %><%@ end %></head>\n<body><%@ block \"body\" %><%@ end %></body></html>\n",
+		"layouts/layout.ego": "<%@ extends \"base.ego\" %>\n<%@ block \"body\" %><main><%@ block \"content\" %>Empty<%@ end %></main><%@ end %>\n",
+		"page.ego":           "<%\npackage main\n\nfunc Render(ctx context.Context, w io.Writer, name string) {\n%>\n<%@ extends \"layouts/layout.ego\" %>\n<%@ block \"title\" %>Home<%@ end %>\n<%@ block \"content\" %>\n  Hello, <%= name %>!\n<%@ end %>\n<% } %>",
+		"a.ego":              "<%@ extends \"b.ego\" %>",
+		"b.ego":              "<%@ extends \"a.ego\" %>",
+		"missing.ego":        "<%@ extends \"layouts/base.ego\" %><%@ block \"footer\" %>x<%@ end %>",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0777); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		tmpl, err := (&ego.Parser{IncludeRoot: dir}).ParseFile(filepath.Join(dir, "page.ego"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(context.Background(), &buf, "bob"); err != nil {
+			t.Fatal(err)
+		} else if exp := "<html><head><title>Home</title></head>\n<body><main>\n  Hello, bob!\n</main></body></html>\n"; buf.String() != exp {
+			t.Fatalf("unexpected output: %q", buf.String())
+		}
+		if exp := []string{filepath.Join(dir, "layouts", "layout.ego"), filepath.Join(dir, "layouts", "base.ego")}; !reflect.DeepEqual(tmpl.Includes, exp) {
+			t.Fatalf("unexpected includes: %q", tmpl.Includes)
+		}
+	})
+
+	t.Run("ErrCycle", func(t *testing.T) {
+		a, b := filepath.Join(dir, "a.ego"), filepath.Join(dir, "b.ego")
+		if _, err := (&ego.Parser{IncludeRoot: dir}).ParseFile(a); err == nil || err.Error() != fmt.Sprintf("Extends cycle: %s -> %s -> %s at %s:1", a, b, a, b) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrBlockNotFound", func(t *testing.T) {
+		path := filepath.Join(dir, "missing.ego")
+		if _, err := (&ego.Parser{IncludeRoot: dir}).ParseFile(path); err == nil || err.Error() != fmt.Sprintf("Block not found in layout: footer at %s:1", path) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNested", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader(`<ego:Card><%@ block "body" %><%@ end %></ego:Card>`), "tmpl.ego"); err == nil || err.Error() != "Block directive must appear at the top level of the template: body at tmpl.ego:1" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNestedEnd", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader("<ego:Card>\n<%@ end %></ego:Card>"), "tmpl.ego"); err == nil || err.Error() != "Block end found without matching start at tmpl.ego:2" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNestedExtends", func(t *testing.T) {
+		if _, err := ego.Parse(strings.NewReader("<%json %>\n<%@ extends \"base.ego\" %><%/json %>"), "tmpl.ego"); err == nil || err.Error() != "Extends directive must appear at the top level of the template at tmpl.ego:2" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that recoverable errors are collected and reported together.
+func TestParse_ErrorList(t *testing.T) {
+	t.Run("Multiple", func(t *testing.T) {
+		_, err := ego.Parse(strings.NewReader("<% package foo %>\n</ego:Card>\n<ego:Foo><%build linux %></ego:Bar>\n<%/json %>\n<ego:Baz>"), "tmpl.ego")
+		errs, ok := err.(ego.ErrorList)
+		if !ok {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		var a []string
+		for _, e := range errs {
+			a = append(a, e.Error())
+		}
+		if s, exp := strings.Join(a, "\n"), strings.Join([]string{
+			"Component end block found without matching start block: </ego:Card> at tmpl.ego:2",
+			"Build directive must appear at the top of the template at tmpl.ego:3",
+			"Component end block mismatch: <ego:Foo> != </ego:Bar> at tmpl.ego:3",
+			"Format region end found without matching start: <%/json %> at tmpl.ego:4",
+			"Expected component close tag, found EOF: <ego:Baz> at tmpl.ego:5",
+		}, "\n"); s != exp {
+			t.Fatalf("unexpected errors:\n%s\n\nexpected:\n%s", s, exp)
+		} else if s := err.Error(); s != "Component end block found without matching start block: </ego:Card> at tmpl.ego:2 (and 4 more errors)" {
+			t.Fatalf("unexpected error: %s", s)
+		}
+
+		// The first error of the list can be found with errors.As.
+		var e *ego.SyntaxError
+		if !errors.As(err, &e) || e != errs[0] {
+			t.Fatalf("unexpected first error: %v", e)
+		}
+	})
+
+	t.Run("Single", func(t *testing.T) {
+		_, err := ego.Parse(strings.NewReader("x\n</ego:Card>"), "tmpl.ego")
+		if _, ok := err.(*ego.SyntaxError); !ok || err.Error() != "Component end block found without matching start block: </ego:Card> at tmpl.ego:2" {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	})
+}
+
+// Ensure that text with invalid UTF-8 is reported as a warning or an error.
+func TestParser_Parse_InvalidUTF8(t *testing.T) {
+	src := "<ego:Card>\nok\nbad \xff\xfe text</ego:Card><%= x %>caf\xe9"
+
+	t.Run("Warn", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if len(tmpl.Warnings) != 2 {
+			t.Fatalf("unexpected warnings: %v", tmpl.Warnings)
+		} else if s := tmpl.Warnings[0].String(); s != "Invalid UTF-8 in text at tmpl.ego:3" {
+			t.Fatalf("unexpected warning: %s", s)
+		} else if s := tmpl.Warnings[1].String(); s != "Invalid UTF-8 in text at tmpl.ego:3" {
+			t.Fatalf("unexpected warning: %s", s)
+		}
+
+		// Invalid bytes are kept so they are written as-is.
+		if s := tmpl.Blocks[2].(*ego.TextBlock).Content; s != "caf\xe9" {
+			t.Fatalf("unexpected text: %q", s)
+		}
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		_, err := (&ego.Parser{StrictUTF8: true}).Parse(strings.NewReader(src), "tmpl.ego")
+		if err == nil || err.Error() != "Invalid UTF-8 in text at tmpl.ego:3 (and 1 more errors)" {
+			t.Fatalf("unexpected error: %v", err)
+		} else if errs, ok := err.(ego.ErrorList); !ok || len(errs) != 2 {
+			t.Fatalf("unexpected error list: %#v", err)
+		}
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		tmpl, err := (&ego.Parser{StrictUTF8: true}).Parse(strings.NewReader("caf\u00e9 \ufffd"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if len(tmpl.Warnings) != 0 {
+			t.Fatalf("unexpected warnings: %v", tmpl.Warnings)
+		}
+	})
+}
+
+// Ensure that whitespace is kept byte-for-byte when preserved.
+func TestParser_Parse_PreserveWhitespace(t *testing.T) {
+	src := "<ego:Pre>\n  <%= x %>\n\t\r\n</ego:Pre><ego:Pre>  \n</ego:Pre>"
+
+	t.Run("Default", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if n := len(tmpl.Blocks[0].(*ego.ComponentStartBlock).Yield); n != 2 {
+			t.Fatalf("unexpected block count: %d", n)
+		} else if n := len(tmpl.Blocks[1].(*ego.ComponentStartBlock).Yield); n != 0 {
+			t.Fatalf("unexpected block count: %d", n)
+		}
+	})
+
+	t.Run("Preserve", func(t *testing.T) {
+		tmpl, err := (&ego.Parser{PreserveWhitespace: true}).Parse(strings.NewReader(src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if !tmpl.PreserveWhitespace {
+			t.Fatal("expected template to preserve whitespace")
+		}
+
+		if blk := tmpl.Blocks[0].(*ego.ComponentStartBlock); len(blk.Yield) != 3 {
+			t.Fatalf("unexpected block count: %d", len(blk.Yield))
+		} else if s := blk.Yield[2].(*ego.TextBlock).Content; s != "\n\t\r\n" {
+			t.Fatalf("unexpected text: %q", s)
+		}
+		if blk := tmpl.Blocks[1].(*ego.ComponentStartBlock); len(blk.Yield) != 1 {
+			t.Fatalf("unexpected block count: %d", len(blk.Yield))
+		} else if s := blk.Yield[0].(*ego.TextBlock).Content; s != "  \n" {
+			t.Fatalf("unexpected text: %q", s)
+		}
+	})
+
+	t.Run("LineEnding", func(t *testing.T) {
+		tmpl, err := (&ego.Parser{PreserveWhitespace: true}).Parse(strings.NewReader("<%\npackage foo\n\nfunc f(ctx context.Context, w io.Writer) {\n%>a\r\nb\n<% } %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.LineEnding = ego.LineEndingLF
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if s := buf.String(); !strings.Contains(s, `_, _ = io.WriteString(w, "a\r\nb\n")`) {
+			t.Fatalf("unexpected output: %s", s)
+		}
+	})
+}
+
+// Ensure that text is minified without changing dynamic blocks.
+func TestParser_Parse_Minify(t *testing.T) {
+	src := "<ego:Page>\n  <div  class=\"a  b\"\n    id=\"<%= id %>\">\n    <!-- note -->\n    Hello,   <b>  <%= name %>  </b>!\n  </div>\n" +
+		"  <pre>\n  x  y\n</pre>\n  <!--[if IE]> ie <![endif]-->\n</ego:Page>"
+	tmpl, err := (&ego.Parser{Minify: true}).Parse(strings.NewReader(src), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a []string
+	for _, b := range tmpl.Blocks[0].(*ego.ComponentStartBlock).Yield {
+		switch b := b.(type) {
+		case *ego.TextBlock:
+			a = append(a, "text:"+b.Content)
+		case *ego.PrintBlock:
+			a = append(a, "print:"+b.Content)
+		}
+	}
+	if exp := []string{
+		`text:<div class="a  b" id="`,
+		"print: id ",
+		`text:">Hello, <b> `,
+		"print: name ",
+		"text: </b>!</div><pre>\n  x  y\n</pre><!--[if IE]> ie <![endif]-->",
+	}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected blocks: %q", a)
+	}
+
+	// Preserved whitespace is not minified.
+	tmpl, err = (&ego.Parser{Minify: true, PreserveWhitespace: true}).Parse(strings.NewReader("<p>\n  a  <!-- b -->\n</p>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	} else if text, ok := tmpl.Blocks[0].(*ego.TextBlock); !ok || text.Content != "<p>\n  a  <!-- b -->\n</p>" {
+		t.Fatalf("unexpected blocks: %#v", tmpl.Blocks)
+	}
+}
+
+// Ensure that print block filters are expanded into nested calls.
+func TestParser_Parse_Filters(t *testing.T) {
+	src := `<%= user.Name | upper | truncate(20) %><%== body | trim %><%= a | b %><%= f(a | b) | lower %><%= x | upper(1, "|") %><%= flags | mask | upper %>`
+	tmpl, err := (&ego.Parser{Filters: ego.DefaultFilters}).Parse(strings.NewReader(src), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a []string
+	for _, b := range tmpl.Blocks {
+		switch b := b.(type) {
+		case *ego.PrintBlock:
+			a = append(a, "print:"+b.Content)
+		case *ego.RawPrintBlock:
+			a = append(a, "raw:"+b.Content)
+		}
+	}
+	if exp := []string{
+		"print:ego.Truncate(ego.Upper(user.Name), 20)",
+		"raw:ego.TrimSpace(body)",
+		"print: a | b ",
+		"print:ego.Lower(f(a | b))",
+		`print:ego.Upper(x, 1, "|")`,
+		"print:ego.Upper(flags | mask)",
+	}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected blocks: %q", a)
+	}
+}
+
+// Ensure that whitespace next to trim markers is removed.
+func TestParser_Parse_Trim(t *testing.T) {
+	src := "<ul>\n  <%~ for _, v := range a { ~%>\n  <li><%= v %></li>\n  <%~ } ~%>\n</ul>\n<p>  <%=- x -%>  \n  done</p><b> <%==~ y ~%> z</b>"
+
+	blocks := func(p *ego.Parser) []string {
+		tmpl, err := p.Parse(strings.NewReader(src), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a []string
+		for _, b := range tmpl.Blocks {
+			switch b := b.(type) {
+			case *ego.TextBlock:
+				a = append(a, fmt.Sprintf("text@%d:%s", b.Pos.LineNo, b.Content))
+			case *ego.CodeBlock:
+				a = append(a, "code:"+b.Content)
+			case *ego.PrintBlock:
+				a = append(a, "print:"+b.Content)
+			case *ego.RawPrintBlock:
+				a = append(a, "raw:"+b.Content)
+			}
+		}
+		return a
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		if a, exp := blocks(&ego.Parser{}), []string{
+			"text@1:<ul>\n",
+			"code: for _, v := range a { ",
+			"text@3:  <li>",
+			"print: v ",
+			"text@3:</li>\n",
+			"code: } ",
+			"text@5:</ul>\n<p>",
+			"print: x ",
+			"text@7:done</p><b> ",
+			"raw: y ",
+			"text@7: z</b>",
+		}; !reflect.DeepEqual(a, exp) {
+			t.Fatalf("unexpected blocks: %q", a)
+		}
+	})
+
+	t.Run("PreserveWhitespace", func(t *testing.T) {
+		if a := blocks(&ego.Parser{PreserveWhitespace: true}); a[0] != "text@1:<ul>\n  " || a[2] != "text@3:\n  <li>" {
+			t.Fatalf("unexpected blocks: %q", a)
+		}
+	})
+
+	// Line trims only remove whitespace at the start of a component body if
+	// it starts a line.
+	t.Run("ComponentBody", func(t *testing.T) {
+		tmpl, err := (&ego.Parser{}).Parse(strings.NewReader("<ego:Card>  <%~ x ~%>b</ego:Card><ego:Card>\n  <%~ y ~%>\n</ego:Card>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a []string
+		for _, b := range tmpl.Blocks {
+			for _, b := range b.(*ego.ComponentStartBlock).Yield {
+				if b, ok := b.(*ego.TextBlock); ok {
+					a = append(a, b.Content)
+				}
+			}
+		}
+		if exp := []string{"  ", "b", "\n"}; !reflect.DeepEqual(a, exp) {
+			t.Fatalf("unexpected text: %q", a)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package ego
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// regionsIdent is the identifier of the region buffers of a function that
+// contains append or flush directives.
+const regionsIdent = "EGO_REGIONS"
+
+// declareRegions declares the region buffers at the start of each function
+// declaration that uses them. Append & flush directives within closures share
+// the buffers of their enclosing function. Returns nil if no regions are used.
+func declareRegions(fset *token.FileSet, f *ast.File, src []byte) (_ []byte, err error) {
+	var edits []edit
+	declared := make(map[*ast.FuncDecl]bool)
+	ast.Inspect(f, func(node ast.Node) bool {
+		ident, ok := node.(*ast.Ident)
+		if !ok || ident.Name != regionsIdent || err != nil {
+			return err == nil
+		}
+
+		decl := enclosingFuncDecl(f, ident.Pos())
+		if decl == nil {
+			pos := fset.Position(ident.Pos())
+			err = NewSyntaxError(Pos{Path: pos.Filename, LineNo: pos.Line}, "Region directive used outside of a function")
+			return false
+		} else if declared[decl] {
+			return true
+		}
+		declared[decl] = true
+
+		// Insert on the same line as the brace so line numbers are unchanged.
+		lbrace := fset.File(decl.Body.Lbrace).Offset(decl.Body.Lbrace) + 1
+		edits = append(edits, edit{Start: lbrace, End: lbrace, Text: []byte(" var " + regionsIdent + " ego.Regions;")})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	} else if len(edits) == 0 {
+		return nil, nil
+	}
+	return applyEdits(src, edits), nil
+}
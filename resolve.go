@@ -0,0 +1,272 @@
+package ego
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CheckComponents reports components whose types are not declared, along
+// with a similarly named type if there is one. Components without a package
+// are looked up in the Go files & templates of the package in dir. Other
+// components are looked up in the package imported with their package name.
+// Packages that cannot be found are not checked since the compiler reports
+// them. Returns an ErrorList if any component is unknown.
+//
+// The other templates in dir are parsed with the default syntax. Use
+// CheckComponentTypes with the types returned by Parser.PackageTypes for
+// templates with other syntax or to check several templates of a package.
+func (t *Template) CheckComponents(dir string) error {
+	return t.CheckComponentTypes(dir, (&Parser{}).PackageTypes(dir))
+}
+
+// CheckComponentTypes is like CheckComponents but looks up components without
+// a package in local, the types declared by the package in dir, and in the
+// types declared by the template itself.
+func (t *Template) CheckComponentTypes(dir string, local map[string]bool) error {
+	var buf bytes.Buffer
+	if _, err := t.WriteTo(&buf); err != nil {
+		return err
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", buf.Bytes(), 0)
+	if err != nil {
+		return err
+	}
+
+	// Look up each package of the template once.
+	pkgs := make(map[string]map[string]bool)
+	lookup := func(name string) map[string]bool {
+		if types, ok := pkgs[name]; ok {
+			return types
+		}
+		var types map[string]bool
+		if name == "" {
+			types = make(map[string]bool, len(local))
+			for name := range local {
+				types[name] = true
+			}
+			declaredTypes(f, false, types)
+		} else {
+			types = importedTypes(dir, f, name)
+		}
+		pkgs[name] = types
+		return types
+	}
+
+	var errs ErrorList
+	Inspect(t.Blocks, func(blk Block) bool {
+		blk2, ok := blk.(*ComponentStartBlock)
+		if !ok {
+			return true
+		} else if blk2.isDynamic() {
+			if types := lookup(""); types[DynamicComponent] {
+				errs = append(errs, NewSyntaxError(blk2.Pos, "Component ego:%s is reserved for dynamic components, rename type %s", DynamicComponent, DynamicComponent))
+			}
+			return true
+		}
+
+		types := lookup(blk2.Package)
+		if types == nil || types[blk2.Name] {
+			return true
+		}
+		if name := suggestName(blk2.Name, types); name != "" {
+			errs = append(errs, NewSyntaxError(blk2.Pos, "Unknown component %s:%s, did you mean %s?", blk2.Namespace(), blk2.Name, name))
+		} else {
+			errs = append(errs, NewSyntaxError(blk2.Pos, "Unknown component %s:%s", blk2.Namespace(), blk2.Name))
+		}
+		return true
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// PackageTypes returns the types declared by the templates & Go files of the
+// package in dir. Templates are parsed by p and those that cannot be parsed
+// are skipped. Generated files are skipped for templates that can be
+// generated since they may be stale.
+func (p *Parser) PackageTypes(dir string) map[string]bool {
+	var templates []*Template
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.ego"))
+	for _, path := range paths {
+		if tmpl, err := p.ParseFile(path); err == nil {
+			templates = append(templates, tmpl)
+		}
+	}
+
+	// Files that cannot be parsed are skipped.
+	files, _ := packageFiles(token.NewFileSet(), dir, templates, 0)
+	types := make(map[string]bool)
+	for _, f := range files {
+		declaredTypes(f, false, types)
+	}
+	return types
+}
+
+// packageFiles parses the generated code of templates and the remaining Go
+// files of the package in dir. Previously generated files of the templates
+// are skipped since they may be stale. Files that cannot be generated or
+// parsed are skipped and the first error is returned with the other files.
+func packageFiles(fset *token.FileSet, dir string, templates []*Template, mode parser.Mode) ([]*ast.File, error) {
+	var files []*ast.File
+	var firstErr error
+	generated := make(map[string]bool)
+	for _, tmpl := range templates {
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		// The generated file is named without its directory so that the
+		// paths of its line directives are not made relative to it.
+		filename := filepath.Base(tmpl.Path) + ".go"
+		f, err := parser.ParseFile(fset, filename, buf.Bytes(), mode)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		files, generated[filename] = append(files, f), true
+	}
+
+	pkg, err := build.ImportDir(dir, 0)
+	if _, ok := err.(*build.NoGoError); err != nil && !ok && firstErr == nil {
+		firstErr = err
+	}
+	if pkg == nil {
+		return files, firstErr
+	}
+	for _, name := range pkg.GoFiles {
+		if generated[name] {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, mode)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, firstErr
+}
+
+// importedTypes returns the exported types of the package imported by f with
+// the given name. Returns nil if the package is not imported or not found.
+func importedTypes(dir string, f *ast.File, name string) map[string]bool {
+	pkg := importedPackage(dir, f, name)
+	if pkg == nil {
+		return nil
+	}
+
+	types := make(map[string]bool)
+	for _, filename := range pkg.GoFiles {
+		src, err := ioutil.ReadFile(filepath.Join(pkg.Dir, filename))
+		if err != nil {
+			continue
+		}
+		if f, err := parser.ParseFile(token.NewFileSet(), filename, src, 0); err == nil {
+			declaredTypes(f, true, types)
+		}
+	}
+	return types
+}
+
+// importedPackage returns the package imported by f with the given name.
+// Returns nil if the package is not imported or not found.
+func importedPackage(dir string, f *ast.File, name string) *build.Package {
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		} else if spec.Name != nil && spec.Name.Name != name {
+			continue
+		}
+
+		pkg, err := build.Import(path, dir, 0)
+		if err != nil || (spec.Name == nil && pkg.Name != name) {
+			continue
+		}
+		return pkg
+	}
+	return nil
+}
+
+// declaredTypes adds the names of the package-level types of f to types.
+func declaredTypes(f *ast.File, exported bool, types map[string]bool) {
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range decl.Specs {
+			if spec := spec.(*ast.TypeSpec); !exported || spec.Name.IsExported() {
+				types[spec.Name.Name] = true
+			}
+		}
+	}
+}
+
+// suggestName returns the name in names closest to name. Names that only
+// differ by case are preferred. Returns blank if no name is close enough.
+func suggestName(name string, names map[string]bool) string {
+	a := make([]string, 0, len(names))
+	for other := range names {
+		a = append(a, other)
+	}
+	sort.Strings(a)
+
+	best, bestDist := "", len(name)/3+1
+	for _, other := range a {
+		if strings.EqualFold(name, other) {
+			return other
+		} else if d := editDistance(name, other); d < bestDist {
+			best, bestDist = other, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a & b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
+
+// min3 returns the smallest of a, b & c.
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,81 @@
+package ego_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that components whose types are not declared are reported.
+func TestTemplate_CheckComponents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"sidebar.go": "package main\n\ntype SideBar struct{}\n\ntype Dynamic struct{}\n",
+		"card.ego":   "<%\npackage main\n\ntype Card struct{}\n%>",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tmpl, err := ego.Parse(strings.NewReader("<%\npackage main\n\nimport \"strings\"\n\ntype Header struct{}\n\nfunc Page(ctx context.Context, w io.Writer) {\n%>"+
+		"<ego:Header /><ego:Card /><ego:Sidebar />\n<ego:Heder />\n<ego:Footer />\n<strings:Bilder />\n<strings:Reader />\n<ego:Dynamic name=\"x\" />\n<% } %>"), filepath.Join(dir, "page.ego"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Package = "main"
+
+	errs, ok := tmpl.CheckComponents(dir).(ego.ErrorList)
+	if !ok {
+		t.Fatalf("expected error list")
+	}
+	var a []string
+	for _, e := range errs {
+		a = append(a, e.Error())
+	}
+	if exp := []string{
+		"Unknown component ego:Sidebar, did you mean SideBar? at " + filepath.Join(dir, "page.ego") + ":9",
+		"Unknown component ego:Heder, did you mean Header? at " + filepath.Join(dir, "page.ego") + ":10",
+		"Unknown component ego:Footer at " + filepath.Join(dir, "page.ego") + ":11",
+		"Unknown component strings:Bilder, did you mean Builder? at " + filepath.Join(dir, "page.ego") + ":12",
+		"Component ego:Dynamic is reserved for dynamic components, rename type Dynamic at " + filepath.Join(dir, "page.ego") + ":14",
+	}; strings.Join(a, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected errors:\n%s", strings.Join(a, "\n"))
+	}
+}
+
+// Ensure that the other templates of a package are parsed with the parser's
+// syntax when looking up components.
+func TestParser_PackageTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "card.ego"), []byte("{{%\npackage main\n\ntype Card struct{}\n%}}"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &ego.Parser{Delims: [2]string{"{{%", "%}}"}}
+	types := p.PackageTypes(dir)
+	if !types["Card"] {
+		t.Fatalf("unexpected types: %v", types)
+	}
+
+	tmpl, err := p.Parse(strings.NewReader("{{%\npackage main\n\nfunc Page(ctx context.Context, w io.Writer) {\n%}}<ego:Card />{{% } %}}"), filepath.Join(dir, "page.ego"))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := tmpl.CheckComponentTypes(dir, types); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,1460 @@
+package ego
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// RuntimePath is the import path of this package, used by generated code
+// that calls runtime helpers.
+const RuntimePath = "github.com/benbjohnson/ego"
+
+// ErrSkip can be returned from the Render method of a component generated with
+// Template.ReturnErrors to stop rendering it. It is not an error: the parent
+// keeps any output already written and continues rendering. Generated helper
+// methods, such as RenderCounted, return nil instead.
+var ErrSkip = errors.New("ego: skip")
+
+// ErrLoading can be returned by the loader of a component with a "load"
+// attribute to render the component's loading region, such as when data is
+// not yet available in a cache.
+var ErrLoading = errors.New("ego: loading")
+
+// IsZero returns true if the value that ptr points to is the zero value of
+// its type. It is used by generated Validate methods to check required fields.
+// A pointer is used so that an interface field holding a zero value, which
+// is not nil, is not considered zero.
+func IsZero(ptr interface{}) bool {
+	return reflect.ValueOf(ptr).Elem().IsZero()
+}
+
+// Diff returns a line diff of the expected & actual output of a render for
+// use in test failures. Lines only in want are prefixed with "-", lines only
+// in got with "+", and common lines with a space. Lines are quoted so that
+// differences in whitespace are visible:
+//
+//	-"<p>Hello</p>\n"
+//	+"<p>Hello!</p>\n"
+//	 "<footer/>"
+func Diff(want, got string) string {
+	a, b := splitLines(want), splitLines(got)
+
+	// Compute the length of the longest common subsequence of each suffix.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&buf, " %q\n", a[i])
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&buf, "-%q\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&buf, "+%q\n", b[j])
+			j++
+		}
+	}
+	return buf.String()
+}
+
+// splitLines splits s after each newline. A final empty line is omitted.
+func splitLines(s string) []string {
+	a := strings.SplitAfter(s, "\n")
+	if a[len(a)-1] == "" {
+		a = a[:len(a)-1]
+	}
+	return a
+}
+
+// PanicError is an error converted from a panic recovered while rendering.
+type PanicError struct {
+	Value interface{} // value passed to panic
+	Stack []byte      // stack trace of the panicking goroutine
+}
+
+// Error returns the panic value as an error message.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("ego: panic: %v", e.Value)
+}
+
+// RecoverPanic is the default handler of panics recovered by Render methods
+// generated with the RecoverPanics option. It returns a *PanicError with the
+// stack trace of the panic and writes nothing to w.
+func RecoverPanic(ctx context.Context, w io.Writer, v interface{}) error {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// CopyAttrs returns a copy of attrs, or nil if attrs is nil. It is called at
+// the start of Render methods generated with the CopyAttrs option.
+func CopyAttrs(attrs map[string]string) map[string]string {
+	if attrs == nil {
+		return nil
+	}
+	m := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		m[k] = v
+	}
+	return m
+}
+
+// MergeAttrs returns a copy of spread with the attributes in attrs set on it.
+// Attributes named in appendNames that are set in both are joined with a
+// space. Otherwise the value in attrs replaces the value in spread. It is used
+// by generated code when a component sets both its Attrs field and explicit
+// attributes.
+func MergeAttrs(spread, attrs map[string]string, appendNames ...string) map[string]string {
+	m := make(map[string]string, len(spread)+len(attrs))
+	for k, v := range spread {
+		m[k] = v
+	}
+	for k, v := range attrs {
+		if prev := m[k]; prev != "" && v != "" && stringSliceContains(appendNames, k) {
+			v = prev + " " + v
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// SpreadAttrs returns a copy of the attributes of v for spreading onto a
+// component, such as "<ego:Button ...r.Attrs>". v must be a map[string]string,
+// a map[string]interface{} whose values are formatted with fmt.Sprint, or a
+// []Attr whose values are used as-is. Other maps with string keys, such as
+// named map types, are also accepted and their values are formatted with
+// fmt.Sprint. Returns nil if v is nil and panics if v has another type.
+func SpreadAttrs(v interface{}) map[string]string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case map[string]string:
+		return CopyAttrs(v)
+	case map[string]interface{}:
+		m := make(map[string]string, len(v))
+		for k, v := range v {
+			m[k] = attrString(v)
+		}
+		return m
+	case []Attr:
+		m := make(map[string]string, len(v))
+		for _, attr := range v {
+			m[attr.Name] = attr.Value
+		}
+		return m
+	default:
+		rv, ok := attrsMap(v)
+		if !ok {
+			panic(fmt.Sprintf("ego: cannot spread attributes of type %T", v))
+		} else if rv.IsNil() {
+			return nil
+		}
+		m := make(map[string]string, rv.Len())
+		for iter := rv.MapRange(); iter.Next(); {
+			m[iter.Key().String()] = attrString(iter.Value().Interface())
+		}
+		return m
+	}
+}
+
+// SpreadTypedAttrs is the counterpart of SpreadAttrs for components with typed
+// attributes. Values of a map[string]string or []Attr are strings and values
+// of other maps with string keys are used as-is.
+func SpreadTypedAttrs(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		return CopyTypedAttrs(v)
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for k, v := range v {
+			m[k] = v
+		}
+		return m
+	case []Attr:
+		m := make(map[string]interface{}, len(v))
+		for _, attr := range v {
+			m[attr.Name] = attr.Value
+		}
+		return m
+	default:
+		rv, ok := attrsMap(v)
+		if !ok {
+			panic(fmt.Sprintf("ego: cannot spread attributes of type %T", v))
+		} else if rv.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, rv.Len())
+		for iter := rv.MapRange(); iter.Next(); {
+			m[iter.Key().String()] = iter.Value().Interface()
+		}
+		return m
+	}
+}
+
+// attrsMap returns the reflected value of v if it is a map with string keys,
+// such as a named map[string]string type.
+func attrsMap(v interface{}) (reflect.Value, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, false
+	}
+	return rv, true
+}
+
+// CopyTypedAttrs returns a copy of attrs, or nil if attrs is nil. It is the
+// counterpart of CopyAttrs for components with typed attributes.
+func CopyTypedAttrs(attrs map[string]interface{}) map[string]interface{} {
+	if attrs == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		m[k] = v
+	}
+	return m
+}
+
+// MergeTypedAttrs returns a copy of spread with the attributes in attrs set
+// on it. It is the counterpart of MergeAttrs for components with typed
+// attributes. Attributes named in appendNames that are set in both are
+// formatted with fmt.Sprint and joined with a space.
+func MergeTypedAttrs(spread, attrs map[string]interface{}, appendNames ...string) map[string]interface{} {
+	m := make(map[string]interface{}, len(spread)+len(attrs))
+	for k, v := range spread {
+		m[k] = v
+	}
+	for k, v := range attrs {
+		if stringSliceContains(appendNames, k) {
+			if prev, next := attrString(m[k]), attrString(v); prev != "" && next != "" {
+				v = prev + " " + next
+			}
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// attrString returns the formatted value of a typed attribute. Returns an
+// empty string for nil.
+func attrString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// MergeClass returns the classes of base followed by the classes of the
+// "class" attribute in attrs, without duplicates, and deletes the attribute
+// from attrs so it is not written again with the remaining attributes. Since
+// attrs is modified, components sharing their Attrs map should copy it first,
+// such as with Template.CopyAttrs.
+func MergeClass(base string, attrs map[string]string) string {
+	class, ok := attrs["class"]
+	if !ok {
+		return strings.Join(strings.Fields(base), " ")
+	}
+	delete(attrs, "class")
+
+	var a []string
+	for _, name := range append(strings.Fields(base), strings.Fields(class)...) {
+		if !stringSliceContains(a, name) {
+			a = append(a, name)
+		}
+	}
+	return strings.Join(a, " ")
+}
+
+// WriteAttrs writes attributes as HTML, in the sorted order of AttrNames, so a
+// component can write its passthrough attributes, such as
+// "<div<% ego.WriteAttrs(w, r.Attrs) %>>". Each attribute is written by
+// WriteAttr with a leading space.
+func WriteAttrs(w io.Writer, attrs map[string]interface{}) error {
+	for _, name := range AttrNames(attrs) {
+		if err := WriteAttr(w, name, attrs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteStringAttrs is the counterpart of WriteAttrs for components with
+// string attributes. Empty values are written as an empty string, such as
+// alt="".
+func WriteStringAttrs(w io.Writer, attrs map[string]string) error {
+	names := make([]string, 0, len(attrs))
+	for k := range attrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := WriteAttr(w, name, attrs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAttr writes an attribute as HTML with a leading space. The value is
+// formatted with fmt.Sprint and escaped. A true value is written as a boolean
+// attribute without a value, and false & nil values are omitted. Attributes
+// with names that are invalid in HTML, such as names containing spaces or
+// quotes, are omitted so a name cannot inject other attributes.
+func WriteAttr(w io.Writer, name string, v interface{}) error {
+	if !isAttrName(name) {
+		return nil
+	}
+
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		if !v {
+			return nil
+		}
+		_, err := io.WriteString(w, " "+name)
+		return err
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+	_, err := io.WriteString(w, " "+name+"=\""+html.EscapeString(s)+"\"")
+	return err
+}
+
+// isAttrName returns true if name is a valid HTML attribute name.
+func isAttrName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		switch {
+		case ch <= ' ', ch == 0x7f, ch == '"', ch == '\'', ch == '>', ch == '/', ch == '=', ch == '<', ch == '&':
+			return false
+		}
+	}
+	return true
+}
+
+// Formats of format regions.
+const (
+	FormatHTML = "html"
+	FormatJSON = "json"
+)
+
+// formatKey is the context key of the render format.
+type formatKey struct{}
+
+// WithFormat returns a context that renders format regions of the given
+// format, such as FormatJSON.
+func WithFormat(ctx context.Context, format string) context.Context {
+	return context.WithValue(ctx, formatKey{}, format)
+}
+
+// ContextFormat returns the render format of ctx. Defaults to FormatHTML if
+// no format is set.
+func ContextFormat(ctx context.Context) string {
+	if format, _ := ctx.Value(formatKey{}).(string); format != "" {
+		return format
+	}
+	return FormatHTML
+}
+
+// NegotiateFormat returns the format preferred by an HTTP Accept header.
+// Returns FormatJSON if "application/json", or a "+json" type, has a higher
+// quality than HTML. Otherwise returns FormatHTML, including for an empty
+// header or wildcards.
+func NegotiateFormat(accept string) string {
+	var htmlQ, jsonQ float64 = -1, -1
+	for _, part := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case typ == "text/html" || typ == "application/xhtml+xml":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		case typ == "application/json" || strings.HasSuffix(typ, "+json"):
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+	if jsonQ > htmlQ && jsonQ > 0 {
+		return FormatJSON
+	}
+	return FormatHTML
+}
+
+// WriteJSON writes the JSON encoding of v to w. It is used by print blocks
+// within JSON format regions.
+func WriteJSON(w io.Writer, v interface{}) (int, error) {
+	buf, err := marshalJSON(v)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(buf)
+}
+
+// ScriptJSON returns the JSON encoding of v for embedding in script elements.
+// It is used by JSON print blocks, which also escape the encoding when it is
+// written within an attribute value.
+func ScriptJSON(v interface{}) (string, error) {
+	buf, err := marshalJSON(v)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// marshalJSON returns the JSON encoding of v. json.Marshal escapes "<", ">" &
+// "&" within strings as \u003c, \u003e & \u0026 and the line & paragraph
+// separators as \u2028 & \u2029, so the encoding cannot close a script
+// element or start an HTML comment.
+func marshalJSON(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// MustScriptJSON is like ScriptJSON but panics if v cannot be encoded. It is
+// used by JSON print blocks in templates whose functions do not return errors.
+func MustScriptJSON(v interface{}) string {
+	s, err := ScriptJSON(v)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Upper returns s in upper case. It is the "upper" filter of DefaultFilters.
+func Upper(s string) string {
+	return strings.ToUpper(s)
+}
+
+// Lower returns s in lower case. It is the "lower" filter of DefaultFilters.
+func Lower(s string) string {
+	return strings.ToLower(s)
+}
+
+// TrimSpace returns s without leading & trailing whitespace. It is the "trim"
+// filter of DefaultFilters.
+func TrimSpace(s string) string {
+	return strings.TrimSpace(s)
+}
+
+// Truncate returns s cut to at most n characters. Cut strings end with an
+// ellipsis, which counts as a character. It is the "truncate" filter of
+// DefaultFilters.
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	} else if n <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+// Translator translates the message keys of translate directives, such as
+// for the locale of a request.
+type Translator interface {
+	Translate(key string, args ...interface{}) string
+}
+
+// TranslatorFunc is a function that implements Translator.
+type TranslatorFunc func(key string, args ...interface{}) string
+
+// Translate calls f(key, args...).
+func (f TranslatorFunc) Translate(key string, args ...interface{}) string {
+	return f(key, args...)
+}
+
+// translatorKey is the context key of the translator.
+type translatorKey struct{}
+
+// WithTranslator returns a context whose translate directives are translated
+// by tr.
+func WithTranslator(ctx context.Context, tr Translator) context.Context {
+	return context.WithValue(ctx, translatorKey{}, tr)
+}
+
+// ContextTranslator returns the translator of ctx. Returns nil if no
+// translator is set.
+func ContextTranslator(ctx context.Context) Translator {
+	tr, _ := ctx.Value(translatorKey{}).(Translator)
+	return tr
+}
+
+// Translate returns the translation of key by the translator of ctx. It is
+// used by translate directives. Returns the key if ctx has no translator.
+func Translate(ctx context.Context, key string, args ...interface{}) string {
+	if tr := ContextTranslator(ctx); tr != nil {
+		return tr.Translate(key, args...)
+	}
+	return key
+}
+
+// Renderer is implemented by components.
+type Renderer interface {
+	Render(ctx context.Context, w io.Writer)
+}
+
+// Renderers is an ordered list of components. It can be passed as a field to a
+// container component to render arbitrary children in a data-driven order.
+type Renderers []Renderer
+
+// Render renders each non-nil component in order.
+func (a Renderers) Render(ctx context.Context, w io.Writer) {
+	for _, r := range a {
+		if r != nil {
+			r.Render(ctx, w)
+		}
+	}
+}
+
+// Yield calls f, such as the Yield closure of a component, if it is not nil.
+// Otherwise it calls fallback, if it is not nil. It is used by yield regions,
+// such as "<%yield r.Yield %>default<%/yield %>", and lets a component render
+// default content when it is used without a body.
+func Yield(f, fallback func()) {
+	if f != nil {
+		f()
+	} else if fallback != nil {
+		fallback()
+	}
+}
+
+// YieldError is the counterpart of Yield for closures that return an error,
+// such as with Template.ReturnErrors. Returns the error of the called closure.
+func YieldError(f, fallback func() error) error {
+	if f != nil {
+		return f()
+	} else if fallback != nil {
+		return fallback()
+	}
+	return nil
+}
+
+// Component is a component created by a constructor passed to Register. It
+// must have a Render method with or without an error result, such as a
+// pointer to a component generated by ego.
+type Component interface{}
+
+// FieldSetter is implemented by components that can set their fields by name,
+// such as those generated with Template.SetFieldMethod.
+type FieldSetter interface {
+	SetField(name string, value interface{}) error
+}
+
+// registry holds the component constructors passed to Register.
+var registry struct {
+	mu sync.RWMutex
+	m  map[string]func() Component
+}
+
+// Register makes a component constructor available by name to dynamic
+// components, such as "<ego:Dynamic name=page.Kind>". It is typically called
+// from init functions. Panics if fn is nil or the name is already registered.
+func Register(name string, fn func() Component) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if fn == nil {
+		panic("ego: Register component constructor is nil: " + name)
+	} else if _, ok := registry.m[name]; ok {
+		panic("ego: Register called twice for component: " + name)
+	}
+	if registry.m == nil {
+		registry.m = make(map[string]func() Component)
+	}
+	registry.m[name] = fn
+}
+
+// Dynamic renders a registered component chosen by name at runtime. It is
+// used by generated code for <ego:Dynamic> blocks.
+type Dynamic struct {
+	Name   string
+	Fields map[string]interface{}
+}
+
+// Render creates a new instance of the named component, sets its fields, and
+// renders it. Fields are set in name order with the component's SetField
+// method. Returns an error if the name is not registered or a field cannot
+// be set. Errors returned by the component's Render method are returned.
+func (d Dynamic) Render(ctx context.Context, w io.Writer) error {
+	registry.mu.RLock()
+	fn := registry.m[d.Name]
+	registry.mu.RUnlock()
+	if fn == nil {
+		return fmt.Errorf("ego: component not registered: %q", d.Name)
+	}
+	c := fn()
+
+	if len(d.Fields) > 0 {
+		setter, ok := c.(FieldSetter)
+		if !ok {
+			return fmt.Errorf("ego: component %s does not have a SetField method", d.Name)
+		}
+		names := make([]string, 0, len(d.Fields))
+		for name := range d.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := setter.SetField(name, d.Fields[name]); err != nil {
+				return fmt.Errorf("ego: component %s: %s", d.Name, err)
+			}
+		}
+	}
+
+	switch c := c.(type) {
+	case interface {
+		Render(context.Context, io.Writer) error
+	}:
+		return c.Render(ctx, w)
+	case Renderer:
+		c.Render(ctx, w)
+		return nil
+	default:
+		return fmt.Errorf("ego: component %s does not have a Render method", d.Name)
+	}
+}
+
+// ErrRenderer is implemented by components generated with
+// Template.ReturnErrors, whose Render methods return an error.
+type ErrRenderer interface {
+	Render(ctx context.Context, w io.Writer) error
+}
+
+// ErrRenderers is an ordered list of components that return errors, the
+// counterpart of Renderers for templates generated with Template.ReturnErrors.
+type ErrRenderers []ErrRenderer
+
+// Render renders each non-nil component in order. A component that returns
+// ErrSkip is skipped and the next component is rendered. Other errors stop
+// rendering and are returned.
+func (a ErrRenderers) Render(ctx context.Context, w io.Writer) error {
+	for _, r := range a {
+		if r == nil {
+			continue
+		} else if err := r.Render(ctx, w); err != nil && err != ErrSkip {
+			return err
+		}
+	}
+	return nil
+}
+
+// Regions holds output collected into named regions so it can be written out
+// of document order, such as footnotes. The zero value is ready to use.
+type Regions struct {
+	m map[string]*bytes.Buffer
+}
+
+// Writer returns a writer that appends to the named region.
+func (r *Regions) Writer(name string) io.Writer {
+	if r.m == nil {
+		r.m = make(map[string]*bytes.Buffer)
+	}
+	buf := r.m[name]
+	if buf == nil {
+		buf = &bytes.Buffer{}
+		r.m[name] = buf
+	}
+	return buf
+}
+
+// Flush writes the output collected in the named region to w and clears the
+// region so later output is collected for the next flush.
+func (r *Regions) Flush(w io.Writer, name string) (int64, error) {
+	buf := r.m[name]
+	if buf == nil {
+		return 0, nil
+	}
+	return buf.WriteTo(w)
+}
+
+// maxPooledBufferSize is the capacity above which buffers are not returned to
+// the pool so a single large render does not pin its memory.
+const maxPooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+
+// GetBuffer returns an empty buffer from a pool. It is used by generated
+// RenderString & RenderBytes methods.
+func GetBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBuffer resets buf and returns it to the pool. The buffer must not be
+// used afterward.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// AcquireBuffer returns a buffer from the pool to render into before writing
+// to w. If w is already a *bytes.Buffer, such as when a component is rendered
+// by another pooled component, then it is returned instead. It is used by
+// code generated with Template.Pool.
+func AcquireBuffer(w io.Writer) *bytes.Buffer {
+	if buf, ok := w.(*bytes.Buffer); ok {
+		return buf
+	}
+	return GetBuffer()
+}
+
+// ReleaseBuffer writes the contents of a buffer from AcquireBuffer to w and
+// returns the buffer to the pool. Returns the write error, if any.
+func ReleaseBuffer(buf *bytes.Buffer, w io.Writer) error {
+	if w, ok := w.(*bytes.Buffer); ok && w == buf {
+		return nil
+	}
+	_, err := buf.WriteTo(w)
+	PutBuffer(buf)
+	return err
+}
+
+// CountWriter wraps a writer and counts the number of bytes written to it.
+// The first write error is retained and all subsequent writes are skipped.
+type CountWriter struct {
+	W   io.Writer
+	N   int64
+	Err error
+}
+
+// Write writes p to the underlying writer.
+func (w *CountWriter) Write(p []byte) (n int, err error) {
+	if w.Err != nil {
+		return 0, w.Err
+	}
+	n, err = w.W.Write(p)
+	w.N, w.Err = w.N+int64(n), err
+	return n, err
+}
+
+// WriteString writes s to the underlying writer without copying, if supported.
+func (w *CountWriter) WriteString(s string) (n int, err error) {
+	if w.Err != nil {
+		return 0, w.Err
+	}
+	n, err = io.WriteString(w.W, s)
+	w.N, w.Err = w.N+int64(n), err
+	return n, err
+}
+
+// BoundedWriter is a writer with a fixed capacity for output that has not yet
+// been read by a consumer. Writes block while it is full. Closing it with an
+// error passes the error to the consumer after the buffered output is read.
+// Both RingBuffer and the writer returned by io.Pipe implement it.
+type BoundedWriter interface {
+	io.WriteCloser
+	CloseWithError(err error) error
+}
+
+// RingBuffer is a BoundedWriter that holds up to a fixed number of bytes in a
+// circular buffer so that output of any size can be streamed from a writer
+// goroutine to a reader goroutine in constant memory.
+//
+// Write blocks while the buffer is full until the reader drains it. The reader
+// must keep reading until io.EOF, or call CloseRead, or the writer blocks
+// forever. Flush blocks until all buffered bytes have been read.
+type RingBuffer struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	buf  []byte
+	r    int   // offset of the first unread byte
+	n    int   // number of unread bytes
+	werr error // set when the writer closes, returned by Read after draining
+	rerr error // set when the reader closes, returned by Write
+}
+
+// NewRingBuffer returns a ring buffer with a capacity of size bytes.
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		panic("ego: ring buffer size must be positive")
+	}
+	b := &RingBuffer{buf: make([]byte, size)}
+	b.cond.L = &b.mu
+	return b
+}
+
+// Write copies p into the buffer, blocking while the buffer is full. Returns
+// an error if the reader is closed or the buffer is closed for writing.
+func (b *RingBuffer) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(p) > 0 {
+		for b.n == len(b.buf) && b.rerr == nil && b.werr == nil {
+			b.cond.Wait()
+		}
+		if b.rerr != nil {
+			return n, b.rerr
+		} else if b.werr != nil {
+			return n, io.ErrClosedPipe
+		}
+
+		// Copy into the contiguous free space after the unread bytes.
+		i := (b.r + b.n) % len(b.buf)
+		end := len(b.buf)
+		if i < b.r {
+			end = b.r
+		}
+		sz := copy(b.buf[i:end], p)
+		n, b.n, p = n+sz, b.n+sz, p[sz:]
+		b.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// Read reads unread bytes into p, blocking while the buffer is empty. Returns
+// io.EOF, or the error passed to CloseWithError, once the buffer is closed
+// for writing and all bytes have been read.
+func (b *RingBuffer) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.n == 0 && b.werr == nil && b.rerr == nil {
+		b.cond.Wait()
+	}
+	if b.rerr != nil {
+		return 0, io.ErrClosedPipe
+	} else if b.n == 0 {
+		return 0, b.werr
+	}
+
+	end := b.r + b.n
+	if end > len(b.buf) {
+		end = len(b.buf)
+	}
+	n = copy(p, b.buf[b.r:end])
+	b.r, b.n = (b.r+n)%len(b.buf), b.n-n
+	b.cond.Broadcast()
+	return n, nil
+}
+
+// Flush blocks until all buffered bytes have been read or the reader closes.
+// It lets generated flushes, such as with Template.FlushInterval, wait for
+// the consumer to catch up.
+func (b *RingBuffer) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.n > 0 && b.rerr == nil {
+		b.cond.Wait()
+	}
+}
+
+// Close closes the buffer for writing. Read returns io.EOF after all
+// buffered bytes have been read.
+func (b *RingBuffer) Close() error {
+	return b.CloseWithError(nil)
+}
+
+// CloseWithError closes the buffer for writing. Read returns err after all
+// buffered bytes have been read, or io.EOF if err is nil. Only the first
+// close has an effect.
+func (b *RingBuffer) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.werr == nil {
+		b.werr = err
+	}
+	b.cond.Broadcast()
+	return nil
+}
+
+// CloseRead closes the buffer for reading so that blocked and subsequent
+// writes return err, or io.ErrClosedPipe if err is nil. A consumer that stops
+// reading early must call it so the writer does not block forever.
+func (b *RingBuffer) CloseRead(err error) error {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rerr == nil {
+		b.rerr = err
+	}
+	b.cond.Broadcast()
+	return nil
+}
+
+// TruncateWriter wraps a writer and truncates output to at most Budget bytes,
+// including the Ellipsis. Each write is kept or dropped whole so output is cut
+// at a block boundary: generated templates write each text & print block in a
+// single write, including through the Writer methods used by fast writers.
+//
+// Once a write does not fit, it and all later writes are dropped without an
+// error and Close writes the Ellipsis. Writes that only fit if the Ellipsis is
+// not needed are held until Close so the Ellipsis always fits the budget. An
+// Ellipsis longer than the Budget is never written.
+type TruncateWriter struct {
+	W        io.Writer
+	Budget   int
+	Ellipsis string
+
+	N         int   // bytes written to W
+	Truncated bool  // true if any write was dropped
+	Err       error // first write error from W
+
+	pending []byte
+	scratch []byte
+}
+
+// Write writes p to the underlying writer if it fits within the budget.
+func (w *TruncateWriter) Write(p []byte) (int, error) {
+	if w.Err != nil {
+		return 0, w.Err
+	} else if w.Truncated {
+		return len(p), nil
+	}
+
+	if len(w.pending) == 0 && w.N+len(p) <= w.Budget-len(w.Ellipsis) {
+		n, err := w.W.Write(p)
+		w.N, w.Err = w.N+n, err
+		return n, err
+	} else if w.N+len(w.pending)+len(p) <= w.Budget {
+		w.pending = append(w.pending, p...)
+		return len(p), nil
+	}
+	w.Truncated, w.pending = true, nil
+	return len(p), nil
+}
+
+// WriteString writes s to the underlying writer if it fits within the budget.
+func (w *TruncateWriter) WriteString(s string) (int, error) {
+	w.scratch = append(w.scratch[:0], s...)
+	return w.Write(w.scratch)
+}
+
+// WriteHTMLEscaped writes s with the same escaping as html.EscapeString if the
+// escaped string fits within the budget.
+func (w *TruncateWriter) WriteHTMLEscaped(s string) (int, error) {
+	w.scratch = appendHTMLEscaped(w.scratch[:0], s)
+	return w.Write(w.scratch)
+}
+
+// WriteInt writes the decimal representation of n if it fits within the budget.
+func (w *TruncateWriter) WriteInt(n int64) (int, error) {
+	w.scratch = strconv.AppendInt(w.scratch[:0], n, 10)
+	return w.Write(w.scratch)
+}
+
+// WriteFloat writes f in the same format as fmt.Sprint if it fits within the
+// budget.
+func (w *TruncateWriter) WriteFloat(f float64) (int, error) {
+	w.scratch = strconv.AppendFloat(w.scratch[:0], f, 'g', -1, 64)
+	return w.Write(w.scratch)
+}
+
+// Close ends the output. If output was truncated then the Ellipsis is written.
+// Otherwise held writes are written. The underlying writer is not closed.
+func (w *TruncateWriter) Close() error {
+	if w.Err != nil {
+		return w.Err
+	}
+
+	b := w.pending
+	if w.Truncated {
+		if len(w.Ellipsis) > w.Budget {
+			return nil
+		}
+		b = []byte(w.Ellipsis)
+	}
+	w.pending = nil
+	if len(b) == 0 {
+		return nil
+	}
+	n, err := w.W.Write(b)
+	w.N, w.Err = w.N+n, err
+	return err
+}
+
+// ResponseWriter wraps an http.ResponseWriter for rendering a response. The
+// Content-Type header & 200 status are sent before the first write so that an
+// error can still be sent if rendering fails before any output is written.
+type ResponseWriter struct {
+	W           http.ResponseWriter
+	ContentType string
+
+	wroteHeader bool
+}
+
+// Write writes p to the response, sending the header first if needed.
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	w.writeHeader()
+	return w.W.Write(p)
+}
+
+// WriteString writes s to the response, sending the header first if needed.
+func (w *ResponseWriter) WriteString(s string) (int, error) {
+	w.writeHeader()
+	return io.WriteString(w.W, s)
+}
+
+// Flush sends buffered output to the client, if supported by the response.
+func (w *ResponseWriter) Flush() {
+	w.writeHeader()
+	if f, ok := w.W.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// End completes the response after rendering returns err. If err is non-nil
+// and nothing has been written then a 500 Internal Server Error is sent.
+// Otherwise, the header is sent if it has not been already. An error after
+// output has been written cannot be reported and the response is truncated.
+func (w *ResponseWriter) End(err error) {
+	if err != nil && !w.wroteHeader {
+		w.wroteHeader = true
+		http.Error(w.W, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.writeHeader()
+}
+
+func (w *ResponseWriter) writeHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.W.Header().Set("Content-Type", w.ContentType)
+	w.W.WriteHeader(http.StatusOK)
+}
+
+// Writer is a high-performance output target. Templates generated with the
+// FastWriter option write print block values through its specialized methods
+// so that values are formatted & escaped without intermediate strings.
+type Writer interface {
+	io.Writer
+	WriteString(s string) (int, error)
+	WriteHTMLEscaped(s string) (int, error)
+	WriteInt(n int64) (int, error)
+	WriteFloat(f float64) (int, error)
+}
+
+// WriteEscapedHTML writes v the same as WriteEscaped except that an HTML value
+// is written as-is. It is used by print blocks of templates generated with both
+// the FastWriter & SafeTypes options.
+func WriteEscapedHTML(w io.Writer, v interface{}) (int, error) {
+	if v, ok := v.(HTML); ok {
+		return io.WriteString(w, string(v))
+	}
+	return WriteEscaped(w, v)
+}
+
+// WriteEscaped writes the HTML-escaped, formatted value of v to w. The output
+// is the same as html.EscapeString(fmt.Sprint(v)) but strings, numbers,
+// booleans, errors & Stringers are written without allocating an intermediate
+// escaped string. If w implements Writer then the most specific method for the
+// type of v is used. Otherwise strings are written with EscapeTo.
+func WriteEscaped(w io.Writer, v interface{}) (int, error) {
+	fw, _ := w.(Writer)
+	switch v := v.(type) {
+	case string:
+		return writeEscapedString(w, fw, v)
+	case int:
+		return writeInt(w, fw, int64(v))
+	case int8:
+		return writeInt(w, fw, int64(v))
+	case int16:
+		return writeInt(w, fw, int64(v))
+	case int32:
+		return writeInt(w, fw, int64(v))
+	case int64:
+		return writeInt(w, fw, v)
+	case uint:
+		return io.WriteString(w, strconv.FormatUint(uint64(v), 10))
+	case uint8:
+		return io.WriteString(w, strconv.FormatUint(uint64(v), 10))
+	case uint16:
+		return io.WriteString(w, strconv.FormatUint(uint64(v), 10))
+	case uint32:
+		return io.WriteString(w, strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		return io.WriteString(w, strconv.FormatUint(v, 10))
+	case float32:
+		// Formatted with 32-bit precision, the same as fmt, so it cannot use
+		// Writer.WriteFloat.
+		return io.WriteString(w, strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		if fw != nil {
+			return fw.WriteFloat(v)
+		}
+		return io.WriteString(w, strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		return io.WriteString(w, strconv.FormatBool(v))
+	case fmt.Formatter:
+		// Formatters take precedence over the Error & String methods.
+	case error:
+		if !isNilPointer(v) {
+			return writeEscapedString(w, fw, v.Error())
+		}
+	case fmt.Stringer:
+		if !isNilPointer(v) {
+			return writeEscapedString(w, fw, v.String())
+		}
+	}
+	return writeEscapedString(w, fw, fmt.Sprint(v))
+}
+
+// writeEscapedString writes the HTML-escaped s to w, or to fw if it is set.
+func writeEscapedString(w io.Writer, fw Writer, s string) (int, error) {
+	if fw != nil {
+		return fw.WriteHTMLEscaped(s)
+	}
+	return EscapeTo(w, s)
+}
+
+// writeInt writes the decimal representation of n to w, or to fw if it is
+// set. Numbers do not need to be escaped.
+func writeInt(w io.Writer, fw Writer, n int64) (int, error) {
+	if fw != nil {
+		return fw.WriteInt(n)
+	}
+	return io.WriteString(w, strconv.FormatInt(n, 10))
+}
+
+// isNilPointer returns true if v is a nil pointer. The methods of nil
+// pointers are not called so that fmt can format them as "<nil>".
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// EscapeTo writes s to w with the same escaping as html.EscapeString. Runs of
+// unescaped bytes and replacements are written directly to w so, unlike
+// html.EscapeString, no escaped copy of s is allocated. Writers should
+// implement io.StringWriter, otherwise each write copies its string.
+func EscapeTo(w io.Writer, s string) (n int, err error) {
+	last := 0
+	for i := 0; i < len(s); i++ {
+		esc := htmlEscape(s[i])
+		if esc == "" {
+			continue
+		}
+
+		var sz int
+		if last < i {
+			if sz, err = io.WriteString(w, s[last:i]); err != nil {
+				return n + sz, err
+			}
+			n += sz
+		}
+		if sz, err = io.WriteString(w, esc); err != nil {
+			return n + sz, err
+		}
+		n += sz
+		last = i + 1
+	}
+
+	if last == len(s) {
+		return n, nil
+	}
+	sz, err := io.WriteString(w, s[last:])
+	return n + sz, err
+}
+
+// HTML is a trusted fragment of HTML, such as the output of a sanitizer. Print
+// blocks of templates generated with the SafeTypes option write it without
+// escaping in element content. It is still escaped in attribute values.
+type HTML string
+
+// JS is a trusted JavaScript expression. Print blocks within a script element
+// or an event handler attribute write it as-is instead of as a JSON value.
+type JS string
+
+// URL is a trusted URL. Print blocks within URL attributes write it without
+// checking its scheme so URLs such as "data:" URLs can be used.
+type URL string
+
+// EscapeHTML returns the HTML-escaped, formatted value of v. An HTML value is
+// returned as-is. It is used by print blocks of templates generated with the
+// SafeTypes option.
+func EscapeHTML(v interface{}) string {
+	switch v := v.(type) {
+	case HTML:
+		return string(v)
+	case string:
+		return html.EscapeString(v)
+	default:
+		return html.EscapeString(fmt.Sprint(v))
+	}
+}
+
+// EscapeAttr returns the HTML-escaped, formatted value of v for use in a
+// quoted attribute value. Unlike EscapeHTML, HTML values are also escaped.
+func EscapeAttr(v interface{}) string {
+	return html.EscapeString(fmt.Sprint(v))
+}
+
+// EscapeJS returns the JSON encoding of v for use as a JavaScript value, such
+// as in a script element. The characters <, > and & are escaped so the value
+// cannot end the element. A JS value is returned as-is. Returns "null" if v
+// cannot be encoded.
+func EscapeJS(v interface{}) string {
+	if v, ok := v.(JS); ok {
+		return string(v)
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(buf)
+}
+
+// EscapeJSString returns the formatted value of v escaped for use within a
+// quoted JavaScript string literal.
+func EscapeJSString(v interface{}) string {
+	s := fmt.Sprint(v)
+	var buf strings.Builder
+	for _, ch := range s {
+		switch {
+		case ch == '\\':
+			buf.WriteString(`\\`)
+		case ch == '\n':
+			buf.WriteString(`\n`)
+		case ch == '\r':
+			buf.WriteString(`\r`)
+		case ch == '\t':
+			buf.WriteString(`\t`)
+		case ch < 0x20 || ch == 0x2028 || ch == 0x2029 || strings.ContainsRune("\"'`<>&=/", ch):
+			fmt.Fprintf(&buf, `\u%04x`, ch)
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+	return buf.String()
+}
+
+// EscapeCSS returns the formatted value of v with the characters that can end
+// a CSS value, string or comment replaced by CSS hex escapes.
+func EscapeCSS(v interface{}) string {
+	s := fmt.Sprint(v)
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 0x20 && !strings.ContainsRune("\"&'()+/:;<>\\{}`", rune(ch)) {
+			buf.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&buf, `\%x`, ch)
+
+		// Terminate the escape if the next character would continue it.
+		if i+1 < len(s) && (isHexDigit(s[i+1]) || s[i+1] == ' ') {
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.String()
+}
+
+// EscapeURL returns the formatted value of v for use as a URL attribute value.
+// URLs with a scheme other than http, https or mailto, such as "javascript:",
+// are replaced by "about:invalid#ego" unless v is a URL. Characters that are
+// not valid in a URL are percent-encoded. The result must still be HTML-escaped.
+func EscapeURL(v interface{}) string {
+	s := fmt.Sprint(v)
+	if _, ok := v.(URL); ok {
+		// Trusted URLs can have any scheme.
+	} else if i := strings.IndexAny(s, ":/?#"); i != -1 && s[i] == ':' {
+		switch strings.ToLower(s[:i]) {
+		case "http", "https", "mailto":
+		default:
+			return "about:invalid#ego"
+		}
+	}
+	return NormalizeURL(s)
+}
+
+// NormalizeURL returns the formatted value of v for use within a URL attribute
+// value after its scheme, such as in its path. Characters that are not valid in
+// a URL are percent-encoded. The result must still be HTML-escaped.
+func NormalizeURL(v interface{}) string {
+	s := fmt.Sprint(v)
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if ch := s[i]; isURLChar(ch) {
+			buf.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", ch)
+		}
+	}
+	return buf.String()
+}
+
+// EscapeQuery returns the formatted value of v escaped for use in a URL query
+// or fragment.
+func EscapeQuery(v interface{}) string {
+	return url.QueryEscape(fmt.Sprint(v))
+}
+
+// EscapePath returns the formatted value of v escaped for use as a URL path
+// segment. The result must still be HTML-escaped.
+func EscapePath(v interface{}) string {
+	return url.PathEscape(fmt.Sprint(v))
+}
+
+// unquotedAttrReplacer escapes unquoted attribute values.
+var unquotedAttrReplacer = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;",
+	" ", "&#32;", "\t", "&#9;", "\n", "&#10;", "\f", "&#12;", "\r", "&#13;",
+	"=", "&#61;", "`", "&#96;",
+)
+
+// EscapeUnquotedAttr returns s escaped for use as an unquoted attribute value.
+// In addition to HTML escaping, whitespace and characters that can end or
+// change the meaning of the value are escaped.
+func EscapeUnquotedAttr(s string) string {
+	return unquotedAttrReplacer.Replace(s)
+}
+
+// isURLChar returns true if ch can be written in a URL without encoding.
+func isURLChar(ch byte) bool {
+	return isHexDigit(ch) || (ch >= 'g' && ch <= 'z') || (ch >= 'G' && ch <= 'Z') ||
+		strings.IndexByte("!#$%&'()*+,-./:;=?@[]_~", ch) != -1
+}
+
+// isHexDigit returns true if ch is a hexadecimal digit.
+func isHexDigit(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+// Buffer is a byte buffer that implements Writer.
+// The zero value is an empty buffer ready to use.
+type Buffer struct {
+	B []byte
+}
+
+// Bytes returns the contents of the buffer.
+func (b *Buffer) Bytes() []byte { return b.B }
+
+// String returns the contents of the buffer as a string.
+func (b *Buffer) String() string { return string(b.B) }
+
+// Len returns the number of bytes in the buffer.
+func (b *Buffer) Len() int { return len(b.B) }
+
+// Reset empties the buffer but retains its capacity.
+func (b *Buffer) Reset() { b.B = b.B[:0] }
+
+// Write appends p to the buffer.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.B = append(b.B, p...)
+	return len(p), nil
+}
+
+// WriteString appends s to the buffer.
+func (b *Buffer) WriteString(s string) (int, error) {
+	b.B = append(b.B, s...)
+	return len(s), nil
+}
+
+// WriteHTMLEscaped appends s to the buffer with the same escaping as
+// html.EscapeString.
+func (b *Buffer) WriteHTMLEscaped(s string) (int, error) {
+	n := len(b.B)
+	b.B = appendHTMLEscaped(b.B, s)
+	return len(b.B) - n, nil
+}
+
+// WriteInt appends the decimal representation of n to the buffer.
+func (b *Buffer) WriteInt(n int64) (int, error) {
+	sz := len(b.B)
+	b.B = strconv.AppendInt(b.B, n, 10)
+	return len(b.B) - sz, nil
+}
+
+// WriteFloat appends f to the buffer in the same format as fmt.Sprint.
+func (b *Buffer) WriteFloat(f float64) (int, error) {
+	sz := len(b.B)
+	b.B = strconv.AppendFloat(b.B, f, 'g', -1, 64)
+	return len(b.B) - sz, nil
+}
+
+// appendHTMLEscaped appends s to dst, escaping the same characters as
+// html.EscapeString.
+func appendHTMLEscaped(dst []byte, s string) []byte {
+	last := 0
+	for i := 0; i < len(s); i++ {
+		esc := htmlEscape(s[i])
+		if esc == "" {
+			continue
+		}
+		dst = append(dst, s[last:i]...)
+		dst = append(dst, esc...)
+		last = i + 1
+	}
+	return append(dst, s[last:]...)
+}
+
+// htmlEscape returns the replacement for ch when escaped by html.EscapeString
+// or an empty string if ch is not escaped.
+func htmlEscape(ch byte) string {
+	switch ch {
+	case '<':
+		return "&lt;"
+	case '>':
+		return "&gt;"
+	case '&':
+		return "&amp;"
+	case '\'':
+		return "&#39;"
+	case '"':
+		return "&#34;"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,492 @@
+package ego_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that a diff marks removed, added & common lines.
+func TestDiff(t *testing.T) {
+	for _, tt := range []struct {
+		want, got, diff string
+	}{
+		{"", "", ""},
+		{"a\nb\n", "a\nb\n", " \"a\\n\"\n \"b\\n\"\n"},
+		{"a\nb\nc", "a\nx\nc", " \"a\\n\"\n-\"b\\n\"\n+\"x\\n\"\n \"c\"\n"},
+		{"a\n", "a", "-\"a\\n\"\n+\"a\"\n"},
+		{"", "b ", "+\"b \"\n"},
+	} {
+		if diff := ego.Diff(tt.want, tt.got); diff != tt.diff {
+			t.Errorf("Diff(%q, %q)=%q, expected %q", tt.want, tt.got, diff, tt.diff)
+		}
+	}
+}
+
+// Ensure that the preferred format is negotiated from an Accept header.
+func TestNegotiateFormat(t *testing.T) {
+	for _, tt := range []struct {
+		accept, format string
+	}{
+		{"", ego.FormatHTML},
+		{"*/*", ego.FormatHTML},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", ego.FormatHTML},
+		{"application/json", ego.FormatJSON},
+		{"application/problem+json", ego.FormatJSON},
+		{"text/html;q=0.5, application/json;q=0.8", ego.FormatJSON},
+		{"application/json, text/html", ego.FormatHTML},
+		{"application/json;q=0", ego.FormatHTML},
+		{"application/json;q=x, text/plain", ego.FormatHTML},
+	} {
+		if format := ego.NegotiateFormat(tt.accept); format != tt.format {
+			t.Errorf("NegotiateFormat(%q)=%s, expected %s", tt.accept, format, tt.format)
+		}
+	}
+}
+
+// Ensure that a list of renderers is rendered in order.
+func TestRenderers_Render(t *testing.T) {
+	var buf bytes.Buffer
+	ego.Renderers{textRenderer("a"), nil, textRenderer("b")}.Render(context.Background(), &buf)
+	if buf.String() != "ab" {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
+
+type textRenderer string
+
+func (r textRenderer) Render(ctx context.Context, w io.Writer) { io.WriteString(w, string(r)) }
+
+// Ensure that classes are merged without duplicates and the class attribute
+// is removed.
+func TestTruncate(t *testing.T) {
+	for _, tt := range []struct {
+		s   string
+		n   int
+		exp string
+	}{
+		{"hello", 5, "hello"},
+		{"hello", 4, "hel…"},
+		{"héllo wörld", 6, "héllo…"},
+		{"hello", 0, ""},
+	} {
+		if s := ego.Truncate(tt.s, tt.n); s != tt.exp {
+			t.Errorf("Truncate(%q, %d)=%q, expected %q", tt.s, tt.n, s, tt.exp)
+		}
+	}
+}
+
+func TestMergeClass(t *testing.T) {
+	for _, tt := range []struct {
+		base  string
+		attrs map[string]string
+		class string
+	}{
+		{"btn", nil, "btn"},
+		{" btn  btn-lg ", map[string]string{"id": "x"}, "btn btn-lg"},
+		{"btn", map[string]string{"class": "primary  btn", "id": "x"}, "btn primary"},
+		{"", map[string]string{"class": "primary"}, "primary"},
+	} {
+		if class := ego.MergeClass(tt.base, tt.attrs); class != tt.class {
+			t.Errorf("MergeClass(%q, %v)=%q, expected %q", tt.base, tt.attrs, class, tt.class)
+		} else if _, ok := tt.attrs["class"]; ok {
+			t.Errorf("MergeClass(%q, %v): expected class to be removed", tt.base, tt.attrs)
+		}
+	}
+}
+
+// Ensure that attributes are spread from maps with string keys, including
+// named map types, and that other types panic.
+func TestSpreadAttrs(t *testing.T) {
+	type Attrs map[string]string
+	type Key string
+
+	if m := ego.SpreadAttrs(Attrs{"id": "x"}); !reflect.DeepEqual(m, map[string]string{"id": "x"}) {
+		t.Fatalf("unexpected attrs: %v", m)
+	} else if m := ego.SpreadAttrs(map[Key]int{"tabindex": 2}); !reflect.DeepEqual(m, map[string]string{"tabindex": "2"}) {
+		t.Fatalf("unexpected attrs: %v", m)
+	} else if m := ego.SpreadAttrs(Attrs(nil)); m != nil {
+		t.Fatalf("unexpected attrs: %v", m)
+	} else if m := ego.SpreadTypedAttrs(map[Key]int{"tabindex": 2}); !reflect.DeepEqual(m, map[string]interface{}{"tabindex": 2}) {
+		t.Fatalf("unexpected typed attrs: %v", m)
+	} else if m := ego.SpreadTypedAttrs(Attrs{"id": "x"}); !reflect.DeepEqual(m, map[string]interface{}{"id": "x"}) {
+		t.Fatalf("unexpected typed attrs: %v", m)
+	}
+
+	defer func() {
+		if r := recover(); r != "ego: cannot spread attributes of type map[int]string" {
+			t.Fatalf("unexpected panic: %v", r)
+		}
+	}()
+	ego.SpreadAttrs(map[int]string{1: "x"})
+}
+
+// Ensure that attributes are written in sorted order with escaped values.
+func TestWriteAttrs(t *testing.T) {
+	t.Run("Typed", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := ego.WriteAttrs(&buf, map[string]interface{}{
+			"title":     `a "b" <c>`,
+			"disabled":  true,
+			"hidden":    false,
+			"data-x":    nil,
+			"tabindex":  2,
+			"x onclick": "alert(1)",
+		}); err != nil {
+			t.Fatal(err)
+		} else if exp := ` disabled tabindex="2" title="a &#34;b&#34; &lt;c&gt;"`; buf.String() != exp {
+			t.Fatalf("unexpected output: %s", buf.String())
+		}
+	})
+
+	t.Run("String", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := ego.WriteStringAttrs(&buf, map[string]string{"id": "a&b", "alt": ""}); err != nil {
+			t.Fatal(err)
+		} else if exp := ` alt="" id="a&amp;b"`; buf.String() != exp {
+			t.Fatalf("unexpected output: %s", buf.String())
+		}
+	})
+}
+
+// Ensure that a list of error renderers skips components returning ErrSkip
+// and stops at the first other error.
+func TestErrRenderers_Render(t *testing.T) {
+	var buf bytes.Buffer
+	marker := errors.New("marker")
+	err := ego.ErrRenderers{
+		errRenderer{"a", nil},
+		errRenderer{"b", ego.ErrSkip},
+		nil,
+		errRenderer{"c", marker},
+		errRenderer{"d", nil},
+	}.Render(context.Background(), &buf)
+	if err != marker {
+		t.Fatalf("unexpected error: %v", err)
+	} else if buf.String() != "abc" {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
+
+type errRenderer struct {
+	s   string
+	err error
+}
+
+func (r errRenderer) Render(ctx context.Context, w io.Writer) error {
+	io.WriteString(w, r.s)
+	return r.err
+}
+
+// Ensure that bytes written are counted and the first error is retained.
+func TestCountWriter(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := &ego.CountWriter{W: &buf}
+		w.Write([]byte("foo"))
+		w.WriteString("barbaz")
+		if w.N != 9 || w.Err != nil {
+			t.Fatalf("unexpected count: n=%d err=%v", w.N, w.Err)
+		} else if buf.String() != "foobarbaz" {
+			t.Fatalf("unexpected output: %s", buf.String())
+		}
+	})
+
+	t.Run("Err", func(t *testing.T) {
+		errMarker := errors.New("marker")
+		w := &ego.CountWriter{W: &errorWriter{n: 4, err: errMarker}}
+		if _, err := w.WriteString("foo"); err != nil {
+			t.Fatal(err)
+		} else if _, err := w.WriteString("bar"); err != errMarker {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := w.WriteString("baz"); err != errMarker {
+			t.Fatalf("unexpected error: %v", err)
+		} else if w.N != 4 {
+			t.Fatalf("unexpected count: %d", w.N)
+		}
+	})
+}
+
+// Ensure that output is truncated at write boundaries to fit the budget.
+func TestTruncateWriter(t *testing.T) {
+	for _, tt := range []struct {
+		budget    int
+		ellipsis  string
+		out       string
+		truncated bool
+	}{
+		{budget: 100, ellipsis: "...", out: "<p>Hello, &lt;Bob&gt;!</p>"},
+		{budget: 26, ellipsis: "...", out: "<p>Hello, &lt;Bob&gt;!</p>"},
+		{budget: 25, ellipsis: "...", out: "<p>Hello, &lt;Bob&gt;!...", truncated: true},
+		{budget: 24, ellipsis: "...", out: "<p>Hello, &lt;Bob&gt;...", truncated: true},
+		{budget: 21, ellipsis: "...", out: "<p>Hello, ...", truncated: true},
+		{budget: 12, ellipsis: "...", out: "<p>...", truncated: true},
+		{budget: 5, ellipsis: "...", out: "...", truncated: true},
+		{budget: 2, ellipsis: "...", out: "", truncated: true},
+		{budget: 25, out: "<p>Hello, &lt;Bob&gt;!", truncated: true},
+		{budget: 0, out: "", truncated: true},
+	} {
+		var buf bytes.Buffer
+		w := &ego.TruncateWriter{W: &buf, Budget: tt.budget, Ellipsis: tt.ellipsis}
+		w.WriteString("<p>")
+		w.Write([]byte("Hello, "))
+		ego.WriteEscaped(w, "<Bob>")
+		w.WriteString("!")
+		w.WriteString("</p>")
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		} else if buf.String() != tt.out || w.N != len(tt.out) || w.Truncated != tt.truncated {
+			t.Errorf("budget=%d ellipsis=%q: unexpected output: %q (n=%d, truncated=%v)", tt.budget, tt.ellipsis, buf.String(), w.N, w.Truncated)
+		}
+	}
+
+	t.Run("Err", func(t *testing.T) {
+		errMarker := errors.New("marker")
+		w := &ego.TruncateWriter{W: &errorWriter{n: 2, err: errMarker}, Budget: 10}
+		if _, err := w.WriteString("foo"); err != errMarker {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := w.Close(); err != errMarker {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that values are escaped for JavaScript, CSS, URL & attribute contexts.
+func TestEscapeContexts(t *testing.T) {
+	for _, tt := range []struct {
+		got, exp string
+	}{
+		{ego.EscapeJS("</script>"), `"\u003c/script\u003e"`},
+		{ego.EscapeJS(map[string]int{"a": 1}), `{"a":1}`},
+		{ego.EscapeJS(func() {}), "null"},
+		{ego.EscapeJSString("it's \"x\"\n\\</script>"), `it\u0027s \u0022x\u0022\n\\\u003c\u002fscript\u003e`},
+		{ego.EscapeCSS("red;}body{x:y"), `red\3b\7d body\7bx\3ay`},
+		{ego.EscapeCSS("a(b"), `a\28 b`},
+		{ego.EscapeURL("/search?q=a b&x=<y>"), "/search?q=a%20b&x=%3Cy%3E"},
+		{ego.EscapeURL("https://example.com/é"), "https://example.com/%C3%A9"},
+		{ego.EscapeURL(" javascript:alert(1)"), "about:invalid#ego"},
+		{ego.EscapeURL("JavaScript:alert(1)"), "about:invalid#ego"},
+		{ego.EscapeURL("data:text/html,x"), "about:invalid#ego"},
+		{ego.EscapeURL("mailto:bob@example.com"), "mailto:bob@example.com"},
+		{ego.NormalizeURL("a:b <c>"), "a:b%20%3Cc%3E"},
+		{ego.EscapeQuery("a b&c=d"), "a+b%26c%3Dd"},
+		{ego.EscapeUnquotedAttr("a b=c>'`"), "a&#32;b&#61;c&gt;&#39;&#96;"},
+		{ego.EscapeHTML(ego.HTML("<b>x</b>")), "<b>x</b>"},
+		{ego.EscapeHTML(ego.JS("a<b")), "a&lt;b"},
+		{ego.EscapeHTML(12), "12"},
+		{ego.EscapeAttr(ego.HTML(`"><b>`)), "&#34;&gt;&lt;b&gt;"},
+		{ego.EscapeJS(ego.JS("f(1)")), "f(1)"},
+		{ego.EscapeURL(ego.URL("data:image/png;base64,AA==")), "data:image/png;base64,AA=="},
+	} {
+		if tt.got != tt.exp {
+			t.Errorf("unexpected escaping: got %q, expected %q", tt.got, tt.exp)
+		}
+	}
+}
+
+// errorWriter accepts n bytes and then returns err.
+type errorWriter struct {
+	n   int
+	err error
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.n {
+		w.n -= len(p)
+		return len(p), nil
+	}
+	n := w.n
+	w.n = 0
+	return n, w.err
+}
+
+// Ensure that a ring buffer streams more data than its capacity and reports
+// errors from either side.
+func TestRingBuffer(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		rb := ego.NewRingBuffer(7)
+		want := bytes.Repeat([]byte("0123456789"), 1000)
+		go func() {
+			for p := want; len(p) > 0; p = p[5:] {
+				rb.Write(p[:5])
+			}
+			rb.Write([]byte("x"))
+			rb.Close()
+		}()
+		if got, err := ioutil.ReadAll(rb); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, append(want, 'x')) {
+			t.Fatalf("unexpected output: len=%d", len(got))
+		}
+	})
+
+	t.Run("CloseWithError", func(t *testing.T) {
+		errMarker := errors.New("marker")
+		rb := ego.NewRingBuffer(8)
+		rb.Write([]byte("foo"))
+		rb.CloseWithError(errMarker)
+		if got, err := ioutil.ReadAll(rb); err != errMarker {
+			t.Fatalf("unexpected error: %v", err)
+		} else if string(got) != "foo" {
+			t.Fatalf("unexpected output: %s", got)
+		} else if _, err := rb.Write([]byte("bar")); err != io.ErrClosedPipe {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("CloseRead", func(t *testing.T) {
+		errMarker := errors.New("marker")
+		rb := ego.NewRingBuffer(4)
+		errc := make(chan error)
+		go func() {
+			_, err := rb.Write([]byte("foobarbaz"))
+			errc <- err
+		}()
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(rb, buf); err != nil {
+			t.Fatal(err)
+		}
+		rb.CloseRead(errMarker)
+		if err := <-errc; err != errMarker {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		rb := ego.NewRingBuffer(8)
+		rb.Write([]byte("foo"))
+		done := make(chan struct{})
+		go func() {
+			rb.Flush()
+			close(done)
+		}()
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(rb, buf); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+	})
+}
+
+// Ensure that values are escaped the same with and without a fast writer.
+func TestWriteEscaped(t *testing.T) {
+	for _, v := range []interface{}{
+		"", "plain", `<a href="x">'&'</a>`, "héllo <wörld>",
+		0, -12, int8(-8), int16(16), int32(32), int64(math.MinInt64),
+		1.5, float32(0.25), float32(0.1), 1e21, math.Inf(-1),
+		uint(7), uint8(8), uint64(math.MaxUint64),
+		true, false, nil, []string{"<a>"},
+		errors.New("<err>"), escapedStringer("<s>"), (*escapedStringer)(nil), time.Duration(1500) * time.Millisecond,
+		ego.HTML("<b>"), ego.JS("<j>"),
+	} {
+		var buf bytes.Buffer
+		var fbuf ego.Buffer
+		if _, err := ego.WriteEscaped(&buf, v); err != nil {
+			t.Fatal(err)
+		} else if n, err := ego.WriteEscaped(&fbuf, v); err != nil {
+			t.Fatal(err)
+		} else if exp := html.EscapeString(fmt.Sprint(v)); buf.String() != exp {
+			t.Fatalf("unexpected output for %#v: %s", v, buf.String())
+		} else if fbuf.String() != exp {
+			t.Fatalf("unexpected fast output for %#v: %s", v, fbuf.String())
+		} else if n != len(exp) {
+			t.Fatalf("unexpected n for %#v: %d", v, n)
+		}
+	}
+}
+
+// escapedStringer is a Stringer for testing WriteEscaped.
+type escapedStringer string
+
+func (s escapedStringer) String() string { return "[" + string(s) + "]" }
+
+func BenchmarkWriteEscaped(b *testing.B) {
+	values := []interface{}{"Hello, <world> & friends", 12345, 3.14159, "plain text"}
+
+	b.Run("Default", func(b *testing.B) {
+		var buf ego.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			for _, v := range values {
+				io.WriteString(&buf, html.EscapeString(fmt.Sprint(v)))
+			}
+		}
+	})
+
+	b.Run("Writer", func(b *testing.B) {
+		var buf ego.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			for _, v := range values {
+				ego.WriteEscaped(&buf, v)
+			}
+		}
+	})
+
+	b.Run("StringWriter", func(b *testing.B) {
+		var buf bytes.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			for _, v := range values {
+				ego.WriteEscaped(&buf, v)
+			}
+		}
+	})
+}
+
+// Ensure that text is escaped directly to a writer with the same output as
+// html.EscapeString and that write errors are returned.
+func TestEscapeTo(t *testing.T) {
+	for _, s := range []string{"", "plain", "<", `<a href="x">'&'</a>`, "héllo <wörld>&"} {
+		var buf bytes.Buffer
+		if n, err := ego.EscapeTo(&buf, s); err != nil {
+			t.Fatal(err)
+		} else if exp := html.EscapeString(s); buf.String() != exp {
+			t.Fatalf("unexpected output for %q: %s", s, buf.String())
+		} else if n != len(exp) {
+			t.Fatalf("unexpected n for %q: %d", s, n)
+		}
+	}
+
+	errMarker := errors.New("marker")
+	if n, err := ego.EscapeTo(&errorWriter{n: 6, err: errMarker}, "ab<cd>ef"); err != errMarker {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != 6 {
+		t.Fatalf("unexpected n: %d", n)
+	}
+}
+
+func BenchmarkEscapeTo(b *testing.B) {
+	const s = `<p class="greeting">Hello, <world> & "friends"</p>`
+
+	b.Run("EscapeString", func(b *testing.B) {
+		var buf bytes.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			io.WriteString(&buf, html.EscapeString(s))
+		}
+	})
+
+	b.Run("EscapeTo", func(b *testing.B) {
+		var buf bytes.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			ego.EscapeTo(&buf, s)
+		}
+	})
+}
@@ -3,9 +3,15 @@ package ego
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
 	"go/parser"
+	"go/token"
 	"io"
 	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -20,6 +26,21 @@ type Scanner struct {
 	i int
 
 	pos Pos
+
+	// Interpolate enables "${expr}" print blocks within text. A literal
+	// "${" can be written as "$${".
+	Interpolate bool
+
+	// Delims are the open & close delimiters of ego blocks, such as "{{%"
+	// and "%}}". Defaults to "<%" and "%>" if blank.
+	Delims [2]string
+
+	// Load state tags matched with the delimiters.
+	loadStateRegex *regexp.Regexp
+
+	// Number of open append directives. Their content is scanned as blocks
+	// until a close tag.
+	appendDepth int
 }
 
 // NewScanner initializes a new scanner with a given reader.
@@ -29,6 +50,7 @@ func NewScanner(r io.Reader, path string) *Scanner {
 		pos: Pos{
 			Path:   path,
 			LineNo: 1,
+			Column: 1,
 		},
 	}
 }
@@ -39,8 +61,25 @@ func (s *Scanner) Scan() (Block, error) {
 		return nil, err
 	}
 
-	switch s.peek() {
-	case '<':
+	blk, err := s.scan()
+	if err != nil {
+		return nil, err
+	}
+	_, end := blockPos(blk)
+	*end = s.pos
+	return blk, nil
+}
+
+// scan returns the next block from the source.
+func (s *Scanner) scan() (Block, error) {
+
+	// Close the innermost append directive.
+	if s.appendDepth > 0 && s.peekClose() {
+		return s.scanAppendEndBlock()
+	}
+
+	switch ch := s.peek(); {
+	case ch == '<' || s.peekOpen(""):
 		// Special handling for component/attr blocks.
 		if s.peekComponentStartBlock() {
 			return s.scanComponentStartBlock()
@@ -52,74 +91,683 @@ func (s *Scanner) Scan() (Block, error) {
 			return s.scanAttrEndBlock()
 		}
 
+		// Verbatim blocks are literal text.
+		if s.peekOpen("%") {
+			return s.scanVerbatimBlock()
+		}
+
+		// Special handling for ego directives.
+		if s.peekDirective("=", "scope") {
+			return s.scanScopeBlock()
+		} else if s.peekDirective("=", "sanitize") {
+			return s.scanSanitizeBlock()
+		} else if s.peekDirective("=", "json") {
+			return s.scanJSONPrintBlock()
+		} else if s.peekDirective("=", "url") {
+			return s.scanURLPrintBlock("url")
+		} else if s.peekDirective("=", "url:path") {
+			return s.scanURLPrintBlock("url:path")
+		} else if s.peekDirective("", "build") {
+			return s.scanBuildBlock()
+		} else if s.peekDirective("", "ctx") {
+			return s.scanCtxBlock()
+		} else if s.peekDirective("=", "append") {
+			return s.scanAppendStartBlock()
+		} else if s.peekDirective("=", "flush") {
+			return s.scanFlushBlock()
+		} else if s.peekTranslateDirective() {
+			return s.scanTranslateBlock()
+		} else if s.peekFileDirective("mode") {
+			return s.scanModeBlock()
+		} else if s.peekFileDirective("include") {
+			return s.scanIncludeBlock()
+		} else if s.peekFileDirective("extends") {
+			return s.scanExtendsBlock()
+		} else if s.peekFileDirective("block") {
+			return s.scanContentStartBlock()
+		} else if s.peekFileDirective("end") {
+			return s.scanContentEndBlock()
+		} else if s.peekYieldDirective() {
+			return s.scanYieldStartBlock()
+		} else if s.peekYieldEndDirective() {
+			return s.scanYieldEndBlock()
+		} else if name, ok := s.peekFormatDirective(); ok {
+			return s.scanFormatBlock(name)
+		} else if m := s.loadStates().FindSubmatch(s.b[s.i:]); m != nil {
+			return s.scanLoadStateBlock(len(m[0]), string(m[1]), string(m[2]))
+		}
+
 		// Special handling for ego blocks.
-		if s.peekN(4) == "<%==" {
+		if s.peekOpen("#") {
+			return s.scanCommentBlock()
+		} else if s.peekOpen(":") {
+			return s.scanFuncBlock()
+		} else if s.peekOpen("==") {
 			return s.scanRawPrintBlock()
-		} else if s.peekN(3) == "<%=" {
+		} else if s.peekOpen("=") {
 			return s.scanPrintBlock()
-		} else if s.peekN(2) == "<%" {
+		} else if s.peekOpen("") {
 			return s.scanCodeBlock()
 		}
 
-	case eof:
+	case ch == '$':
+		if s.Interpolate && s.peekN(3) == "$${" {
+			b := &TextBlock{Pos: s.pos}
+			s.readN(3)
+			b.Content = "${"
+			return b, nil
+		} else if s.Interpolate && s.peekN(2) == "${" {
+			return s.scanInterpolation()
+		}
+
+	case ch == eof:
 		return nil, io.EOF
 	}
 	return s.scanTextBlock()
 }
 
 func (s *Scanner) scanTextBlock() (*TextBlock, error) {
-	buf := bytes.NewBufferString(s.readN(1))
+	// Content is sliced from the source so that invalid UTF-8 is preserved.
+	// Text starting with a newline is positioned at the following line.
+	start := s.i
 	b := &TextBlock{Pos: s.pos}
+	if s.read() == '\n' {
+		b.Pos = s.pos
+	}
 
 	for {
-		if ch := s.peek(); ch == eof || ch == '<' {
+		if ch := s.peek(); ch == eof || ch == '<' || s.peekOpen("") {
+			break
+		} else if ch == '$' && s.Interpolate && (s.peekN(2) == "${" || s.peekN(3) == "$${") {
+			break
+		} else if s.appendDepth > 0 && s.peekClose() {
 			break
 		}
-		buf.WriteRune(s.read())
+		s.read()
 	}
 
-	b.Content = string(buf.Bytes())
+	b.Content = string(s.b[start:s.i])
 
 	return b, nil
 }
 
 func (s *Scanner) scanCodeBlock() (*CodeBlock, error) {
 	b := &CodeBlock{Pos: s.pos}
-	assert(s.readN(2) == "<%")
+	assert(s.readOpen(""))
+	b.TrimLeft = s.scanTrimMarker()
 
 	content, err := s.scanContent()
 	if err != nil {
 		return nil, err
 	}
-	b.Content = content
+	b.Content, b.TrimRight = trimCloseMarker(content)
+
+	return b, nil
+}
+
+func (s *Scanner) scanCommentBlock() (*CommentBlock, error) {
+	b := &CommentBlock{Pos: s.pos}
+	assert(s.readOpen("#"))
+	b.TrimLeft = s.scanTrimMarker()
+
+	content, err := s.scanContent()
+	if err != nil {
+		return nil, err
+	}
+	b.Content, b.TrimRight = trimCloseMarker(content)
+	return b, nil
+}
+
+func (s *Scanner) scanFuncBlock() (*FuncBlock, error) {
+	b := &FuncBlock{Pos: s.pos}
+	assert(s.readOpen(":"))
+
+	content, err := s.scanContent()
+	if err != nil {
+		return nil, err
+	}
+	b.Content = strings.TrimSpace(content)
+	if parseFuncSignature(b.Content) == nil {
+		return nil, NewSyntaxError(b.Pos, "Invalid function directive: %s", b.Content)
+	}
 
+	// Consume the rest of the line so it is not written as the function's
+	// first text.
+	if s.peekN(2) == "\r\n" {
+		s.readN(2)
+	} else if s.peek() == '\n' {
+		s.read()
+	}
 	return b, nil
 }
 
 func (s *Scanner) scanPrintBlock() (*PrintBlock, error) {
 	b := &PrintBlock{Pos: s.pos}
-	assert(s.readN(3) == "<%=")
+	assert(s.readOpen("="))
+	b.TrimLeft = s.scanTrimMarker()
 
 	content, err := s.scanContent()
 	if err != nil {
 		return nil, err
 	}
-	b.Content = content
+	b.Content, b.TrimRight = trimCloseMarker(content)
 	return b, nil
 }
 
 func (s *Scanner) scanRawPrintBlock() (*RawPrintBlock, error) {
 	b := &RawPrintBlock{Pos: s.pos}
-	assert(s.readN(4) == "<%==")
+	assert(s.readOpen("=="))
+	b.TrimLeft = s.scanTrimMarker()
 
 	content, err := s.scanContent()
 	if err != nil {
 		return nil, err
 	}
+	b.Content, b.TrimRight = trimCloseMarker(content)
+	return b, nil
+}
+
+// scanVerbatimBlock reads a "<%% ... %%>" block as text. Its content is not
+// interpreted so it can contain literal tags, such as "<%= x %>".
+func (s *Scanner) scanVerbatimBlock() (*TextBlock, error) {
+	b := &TextBlock{Pos: s.pos}
+	assert(s.readOpen("%"))
+
+	close := []byte("%" + s.closeDelim())
+	n := bytes.Index(s.b[s.i:], close)
+	if n == -1 {
+		return nil, NewSyntaxError(b.Pos, "Expected close of verbatim block, found EOF")
+	}
+	b.Content = string(s.b[s.i : s.i+n])
+	for end := s.i + n + len(close); s.i < end; {
+		s.read()
+	}
+	return b, nil
+}
+
+// scanTrimMarker reads a trim marker after an open tag, if any. A marker must
+// be followed by whitespace so that it is not confused with an expression
+// such as "-1".
+func (s *Scanner) scanTrimMarker() Trim {
+	str := s.peekN(2)
+	if len(str) != 2 || !isWhitespace(rune(str[1])) {
+		return TrimNone
+	}
+	switch str[0] {
+	case '-':
+		s.read()
+		return TrimAll
+	case '~':
+		s.read()
+		return TrimLine
+	default:
+		return TrimNone
+	}
+}
+
+// trimCloseMarker removes a trim marker before the close tag from a block's
+// content. A marker must be preceded by whitespace.
+func trimCloseMarker(content string) (string, Trim) {
+	n := len(content)
+	if n < 2 || !isWhitespace(rune(content[n-2])) {
+		return content, TrimNone
+	}
+	switch content[n-1] {
+	case '-':
+		return content[:n-1], TrimAll
+	case '~':
+		return content[:n-1], TrimLine
+	default:
+		return content, TrimNone
+	}
+}
+
+// peekDirective returns true if the next characters are the given prefix
+// immediately followed by the directive name and whitespace or a close tag.
+func (s *Scanner) peekDirective(prefix, name string) bool {
+	tag := s.openDelim() + prefix + name
+	if !s.peekOpen(prefix + name) {
+		return false
+	}
+	rest := s.b[s.i+len(tag):]
+	return len(rest) > 0 && (isWhitespace(rune(rest[0])) || bytes.HasPrefix(rest, []byte(s.closeDelim())))
+}
+
+// scanDirective reads a directive and returns its trimmed content.
+func (s *Scanner) scanDirective(prefix, name string) (string, error) {
+	assert(s.readOpen(prefix + name))
+
+	content, err := s.scanContent()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(content), nil
+}
+
+func (s *Scanner) scanScopeBlock() (*ScopeBlock, error) {
+	b := &ScopeBlock{Pos: s.pos}
+
+	content, err := s.scanDirective("=", "scope")
+	if err != nil {
+		return nil, err
+	} else if content != "" {
+		return nil, NewSyntaxError(b.Pos, "Unexpected content in scope directive: %s", content)
+	}
+	return b, nil
+}
+
+func (s *Scanner) scanSanitizeBlock() (*SanitizeBlock, error) {
+	b := &SanitizeBlock{Pos: s.pos}
+
+	content, err := s.scanDirective("=", "sanitize")
+	if err != nil {
+		return nil, err
+	} else if content == "" {
+		return nil, NewSyntaxError(b.Pos, "Expected expression in sanitize directive")
+	}
 	b.Content = content
 	return b, nil
 }
 
+func (s *Scanner) scanJSONPrintBlock() (*JSONPrintBlock, error) {
+	b := &JSONPrintBlock{Pos: s.pos}
+
+	content, err := s.scanDirective("=", "json")
+	if err != nil {
+		return nil, err
+	} else if content == "" {
+		return nil, NewSyntaxError(b.Pos, "Expected expression in json directive")
+	}
+	b.Content = content
+	return b, nil
+}
+
+// scanURLPrintBlock reads a URL print block with the given directive name,
+// which is "url:path" for path segments.
+func (s *Scanner) scanURLPrintBlock(name string) (*URLPrintBlock, error) {
+	b := &URLPrintBlock{Pos: s.pos, Path: name == "url:path"}
+
+	content, err := s.scanDirective("=", name)
+	if err != nil {
+		return nil, err
+	} else if content == "" {
+		return nil, NewSyntaxError(b.Pos, "Expected expression in %s directive", name)
+	}
+	b.Content = content
+	return b, nil
+}
+
+func (s *Scanner) scanBuildBlock() (*BuildBlock, error) {
+	b := &BuildBlock{Pos: s.pos}
+
+	content, err := s.scanDirective("", "build")
+	if err != nil {
+		return nil, err
+	} else if _, err := parseBuildConstraint(content); err != nil {
+		return nil, NewSyntaxError(b.Pos, "Invalid build constraint: %s", content)
+	}
+	b.Constraint = content
+
+	// Consume the rest of the line so it is not written as top-level text.
+	if s.peekN(2) == "\r\n" {
+		s.readN(2)
+	} else if s.peek() == '\n' {
+		s.read()
+	}
+	return b, nil
+}
+
+func (s *Scanner) scanCtxBlock() (*CtxBlock, error) {
+	b := &CtxBlock{Pos: s.pos}
+
+	content, err := s.scanDirective("", "ctx")
+	if err != nil {
+		return nil, err
+	} else if content == "" {
+		return nil, NewSyntaxError(b.Pos, "Expected statement in ctx directive")
+	}
+	b.Content = content
+	return b, nil
+}
+
+// peekTranslateDirective returns true if the next characters are a translate
+// directive. Its key must be a string literal so that code blocks starting
+// with a "t" variable are not mistaken for the directive.
+func (s *Scanner) peekTranslateDirective() bool {
+	if !s.peekDirective("", "t") {
+		return false
+	}
+	rest := bytes.TrimLeftFunc(s.b[s.i+len(s.openDelim()+"t"):], unicode.IsSpace)
+	return len(rest) > 0 && (rest[0] == '"' || rest[0] == '`')
+}
+
+func (s *Scanner) scanTranslateBlock() (*TranslateBlock, error) {
+	b := &TranslateBlock{Pos: s.pos}
+
+	content, err := s.scanDirective("", "t")
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the key & arguments as the arguments of a call.
+	expr, err := parser.ParseExpr("f(" + content + ")")
+	if err != nil {
+		return nil, NewSyntaxError(b.Pos, "Invalid arguments in translate directive: %s", content)
+	}
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) == 0 || call.Ellipsis.IsValid() {
+		return nil, NewSyntaxError(b.Pos, "Invalid arguments in translate directive: %s", content)
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return nil, NewSyntaxError(b.Pos, "Expected message key in translate directive: %s", content)
+	}
+	b.Key, _ = strconv.Unquote(lit.Value)
+	for _, arg := range call.Args[1:] {
+		b.Args = append(b.Args, content[arg.Pos()-3:arg.End()-3])
+	}
+	return b, nil
+}
+
+// scanAppendStartBlock reads the open tag & region name of an append
+// directive. The following blocks are collected into the region until the
+// directive's close tag.
+func (s *Scanner) scanAppendStartBlock() (*AppendStartBlock, error) {
+	b := &AppendStartBlock{Pos: s.pos}
+	assert(s.readOpen("=append"))
+	s.skipWhitespace()
+
+	// Read region name as a string literal.
+	var buf bytes.Buffer
+	if ch := s.peek(); ch != '"' && ch != '`' {
+		return nil, NewSyntaxError(b.Pos, "Expected region name in append directive")
+	} else if !s.readQuoted(&buf, s.read()) {
+		return nil, NewSyntaxError(b.Pos, "Expected close of append directive, found EOF")
+	}
+	b.Name = buf.String()
+	s.skipWhitespace()
+
+	s.appendDepth++
+	return b, nil
+}
+
+func (s *Scanner) scanAppendEndBlock() (*AppendEndBlock, error) {
+	b := &AppendEndBlock{Pos: s.pos}
+	assert(s.readClose())
+	s.appendDepth--
+	return b, nil
+}
+
+func (s *Scanner) scanFlushBlock() (*FlushBlock, error) {
+	b := &FlushBlock{Pos: s.pos}
+
+	content, err := s.scanDirective("=", "flush")
+	if err != nil {
+		return nil, err
+	} else if content == "" {
+		return nil, NewSyntaxError(b.Pos, "Expected region name in flush directive")
+	}
+	b.Name = content
+	return b, nil
+}
+
+// peekFileDirective returns true if the next block is a "<%@" directive with
+// the given name, such as "<%@ include "header.ego" %>". Whitespace is allowed
+// between "<%@" and the name.
+func (s *Scanner) peekFileDirective(name string) bool {
+	if !s.peekOpen("@") {
+		return false
+	}
+	rest := bytes.TrimLeft(s.b[s.i+len(s.openDelim())+1:], " \t")
+	if !bytes.HasPrefix(rest, []byte(name)) {
+		return false
+	}
+	rest = rest[len(name):]
+	return bytes.HasPrefix(rest, []byte(s.closeDelim())) || (len(rest) > 0 && isWhitespace(rune(rest[0])))
+}
+
+// scanFileDirective reads a "<%@" directive and returns its argument, which
+// is a string literal. Returns an empty string if the directive has no
+// argument.
+func (s *Scanner) scanFileDirective(name string) (string, error) {
+	pos := s.pos
+	assert(s.readOpen("@"))
+	s.skipWhitespace()
+	assert(s.readN(len(name)) == name)
+
+	content, err := s.scanContent()
+	if err != nil {
+		return "", err
+	} else if content = strings.TrimSpace(content); content == "" {
+		return "", nil
+	}
+	arg, err := strconv.Unquote(content)
+	if err != nil {
+		return "", NewSyntaxError(pos, "Expected string literal in %s directive: %s", name, content)
+	}
+	return arg, nil
+}
+
+func (s *Scanner) scanModeBlock() (*ModeBlock, error) {
+	b := &ModeBlock{Pos: s.pos}
+	assert(s.readOpen("@"))
+	s.skipWhitespace()
+	assert(s.readN(len("mode")) == "mode")
+
+	content, err := s.scanContent()
+	if err != nil {
+		return nil, err
+	}
+	switch b.Mode = strings.TrimSpace(content); b.Mode {
+	case ModeHTML, ModeText:
+	case "":
+		return nil, NewSyntaxError(b.Pos, "Expected mode in mode directive")
+	default:
+		return nil, NewSyntaxError(b.Pos, "Unknown template mode: %s", b.Mode)
+	}
+
+	// Consume the rest of the line so it is not written as top-level text.
+	if s.peekN(2) == "\r\n" {
+		s.readN(2)
+	} else if s.peek() == '\n' {
+		s.read()
+	}
+	return b, nil
+}
+
+func (s *Scanner) scanIncludeBlock() (*IncludeBlock, error) {
+	b := &IncludeBlock{Pos: s.pos}
+
+	path, err := s.scanFileDirective("include")
+	if err != nil {
+		return nil, err
+	} else if path == "" {
+		return nil, NewSyntaxError(b.Pos, "Expected file path in include directive")
+	}
+	b.Path = path
+	return b, nil
+}
+
+func (s *Scanner) scanExtendsBlock() (*ExtendsBlock, error) {
+	b := &ExtendsBlock{Pos: s.pos}
+
+	path, err := s.scanFileDirective("extends")
+	if err != nil {
+		return nil, err
+	} else if path == "" {
+		return nil, NewSyntaxError(b.Pos, "Expected file path in extends directive")
+	}
+	b.Path = path
+	return b, nil
+}
+
+func (s *Scanner) scanContentStartBlock() (*ContentStartBlock, error) {
+	b := &ContentStartBlock{Pos: s.pos}
+
+	name, err := s.scanFileDirective("block")
+	if err != nil {
+		return nil, err
+	} else if name == "" {
+		return nil, NewSyntaxError(b.Pos, "Expected name in block directive")
+	}
+	b.Name = name
+	return b, nil
+}
+
+func (s *Scanner) scanContentEndBlock() (*ContentEndBlock, error) {
+	b := &ContentEndBlock{Pos: s.pos}
+
+	if arg, err := s.scanFileDirective("end"); err != nil {
+		return nil, err
+	} else if arg != "" {
+		return nil, NewSyntaxError(b.Pos, "Unexpected content in end directive: %q", arg)
+	}
+	return b, nil
+}
+
+// peekFormatDirective returns the name of a format region tag, such as
+// "html" for "<%html %>" or "/json" for "<%/json %>". A format tag has no
+// other content so code blocks such as "<%html := x %>" are not affected.
+func (s *Scanner) peekFormatDirective() (string, bool) {
+	for _, name := range []string{FormatHTML, FormatJSON, "/" + FormatHTML, "/" + FormatJSON} {
+		tag := s.openDelim() + name
+		if !bytes.HasPrefix(s.b[s.i:], []byte(tag)) {
+			continue
+		}
+		if rest := bytes.TrimLeft(s.b[s.i+len(tag):], " \t\r\n"); bytes.HasPrefix(rest, []byte(s.closeDelim())) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// scanFormatBlock reads a format region open or close tag.
+func (s *Scanner) scanFormatBlock(name string) (Block, error) {
+	pos := s.pos
+	if _, err := s.scanDirective("", name); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(name, "/") {
+		return &FormatEndBlock{Pos: pos, Format: name[1:]}, nil
+	}
+	return &FormatStartBlock{Pos: pos, Format: name}, nil
+}
+
+// peekYieldDirective returns true if the next block opens a yield region, such
+// as "<%yield r.Yield %>". The content must be an expression so that code
+// blocks such as "<%yield := x %>" are not affected.
+func (s *Scanner) peekYieldDirective() bool {
+	tag := s.openDelim() + "yield"
+	if !bytes.HasPrefix(s.b[s.i:], []byte(tag)) {
+		return false
+	}
+	rest := s.b[s.i+len(tag):]
+	if len(rest) == 0 || !isWhitespace(rune(rest[0])) {
+		return false
+	}
+	i := bytes.Index(rest, []byte(s.closeDelim()))
+	if i == -1 {
+		return false
+	}
+	content := strings.TrimSpace(string(rest[:i]))
+	if content == "" {
+		return false
+	}
+	_, err := parser.ParseExpr(content)
+	return err == nil
+}
+
+// peekYieldEndDirective returns true if the next block closes a yield region.
+func (s *Scanner) peekYieldEndDirective() bool {
+	tag := s.openDelim() + "/yield"
+	if !bytes.HasPrefix(s.b[s.i:], []byte(tag)) {
+		return false
+	}
+	return bytes.HasPrefix(bytes.TrimLeft(s.b[s.i+len(tag):], " \t\r\n"), []byte(s.closeDelim()))
+}
+
+func (s *Scanner) scanYieldStartBlock() (*YieldStartBlock, error) {
+	b := &YieldStartBlock{Pos: s.pos}
+
+	content, err := s.scanDirective("", "yield")
+	if err != nil {
+		return nil, err
+	}
+	b.Content = content
+	return b, nil
+}
+
+func (s *Scanner) scanYieldEndBlock() (*YieldEndBlock, error) {
+	b := &YieldEndBlock{Pos: s.pos}
+	if _, err := s.scanDirective("", "/yield"); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// scanLoadStateBlock reads a load state tag of n bytes.
+func (s *Scanner) scanLoadStateBlock(n int, state, name string) (*LoadStateBlock, error) {
+	b := &LoadStateBlock{Pos: s.pos, State: state, Name: name}
+	for end := s.i + n; s.i < end; {
+		s.read()
+	}
+	return b, nil
+}
+
+// scanInterpolation reads a "${expr}" interpolation as a print block. Braces
+// within the expression are balanced and string literals are skipped over.
+func (s *Scanner) scanInterpolation() (*PrintBlock, error) {
+	b := &PrintBlock{Pos: s.pos}
+	assert(s.readN(2) == "${")
+
+	var buf bytes.Buffer
+	for depth := 0; ; {
+		ch := s.read()
+		switch ch {
+		case eof:
+			return nil, NewSyntaxError(b.Pos, "Expected close of interpolation, found EOF")
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				if b.Content = strings.TrimSpace(buf.String()); b.Content == "" {
+					return nil, NewSyntaxError(b.Pos, "Expected expression in interpolation")
+				}
+				return b, nil
+			}
+			depth--
+		case '"', '\'', '`':
+			if !s.readQuoted(&buf, ch) {
+				return nil, NewSyntaxError(b.Pos, "Expected close of interpolation, found EOF")
+			}
+			continue
+		}
+		buf.WriteRune(ch)
+	}
+}
+
+// readQuoted reads a Go string or rune literal after its opening quote has
+// been read and appends it to buf, including quotes. Returns false on EOF.
+func (s *Scanner) readQuoted(buf *bytes.Buffer, quote rune) bool {
+	buf.WriteRune(quote)
+	for {
+		ch := s.read()
+		if ch == eof {
+			return false
+		}
+		buf.WriteRune(ch)
+
+		if ch == '\\' && quote != '`' {
+			if ch = s.read(); ch == eof {
+				return false
+			}
+			buf.WriteRune(ch)
+		} else if ch == quote {
+			return true
+		}
+	}
+}
+
 func (s *Scanner) peekComponentStartBlock() bool {
 	pos, i := s.pos, s.i
 	defer func() { s.pos, s.i = pos, i }()
@@ -167,6 +815,24 @@ func (s *Scanner) scanComponentStartBlock() (_ *ComponentStartBlock, err error)
 			break
 		}
 
+		if s.peekN(5) == "for=(" {
+			if ok, err := s.scanLoopClause(b); err != nil {
+				return nil, err
+			} else if ok {
+				continue
+			}
+		}
+
+		// Spread attributes, such as "...r.Attrs".
+		if s.peekN(3) == "..." {
+			spread, err := s.scanSpread()
+			if err != nil {
+				return nil, err
+			}
+			b.Spreads = append(b.Spreads, spread)
+			continue
+		}
+
 		if ch := s.peek(); unicode.IsUpper(ch) {
 			field, err := s.scanField()
 			if err != nil {
@@ -180,12 +846,75 @@ func (s *Scanner) scanComponentStartBlock() (_ *ComponentStartBlock, err error)
 		if err != nil {
 			return nil, err
 		}
+
+		// A "flag" attribute gates rendering behind a feature flag.
+		if attr.Name == "flag" {
+			if attr.Value == "" {
+				return nil, NewSyntaxError(attr.NamePos, "Expected expression for component flag")
+			} else if b.Flag != "" {
+				return nil, NewSyntaxError(attr.NamePos, "Duplicate flag on component: %s", shortComponentBlockString(b))
+			}
+			b.Flag, b.FlagPos = attr.Value, attr.ValuePos
+			continue
+		}
+
+		// A "load" attribute calls a loader whose result selects the load
+		// state region of the body that is rendered.
+		if attr.Name == "load" {
+			if attr.Value == "" {
+				return nil, NewSyntaxError(attr.NamePos, "Expected expression for component loader")
+			} else if b.Load != "" {
+				return nil, NewSyntaxError(attr.NamePos, "Duplicate loader on component: %s", shortComponentBlockString(b))
+			}
+			b.Load, b.LoadPos = attr.Value, attr.ValuePos
+			continue
+		}
 		b.Attrs = append(b.Attrs, attr)
 	}
 
 	return b, nil
 }
 
+// scanLoopClause reads a parenthesized "for" loop clause into the component.
+// Returns false without reading if the value is a Go expression, in which
+// case it is a regular "for" attribute.
+func (s *Scanner) scanLoopClause(b *ComponentStartBlock) (bool, error) {
+	pos, i := s.pos, s.i
+	assert(s.readN(5) == "for=(")
+
+	var buf bytes.Buffer
+	for depth := 0; ; {
+		ch := s.read()
+		switch ch {
+		case eof:
+			return false, NewSyntaxError(pos, "Expected close of loop clause, found EOF")
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '"', '\'', '`':
+			if !s.readQuoted(&buf, ch) {
+				return false, NewSyntaxError(pos, "Expected close of loop clause, found EOF")
+			}
+			continue
+		}
+		if depth < 0 {
+			break
+		}
+		buf.WriteRune(ch)
+	}
+
+	// Treat parenthesized expressions as attributes.
+	if _, err := parser.ParseExpr("(" + buf.String() + ")"); err == nil {
+		s.pos, s.i = pos, i
+		return false, nil
+	} else if b.For != "" {
+		return false, NewSyntaxError(pos, "Duplicate loop clause on component: %s", shortComponentBlockString(b))
+	}
+	b.For, b.ForPos = strings.TrimSpace(buf.String()), pos
+	return true, nil
+}
+
 func (s *Scanner) peekComponentEndBlock() bool {
 	pos, i := s.pos, s.i
 	defer func() { s.pos, s.i = pos, i }()
@@ -267,7 +996,40 @@ func (s *Scanner) scanAttrStartBlock() (_ *AttrStartBlock, err error) {
 	if b.Name, err = s.scanIdent(); err != nil {
 		return nil, err
 	}
-	s.skipWhitespace()
+
+	// Scan optional condition & parameters.
+	for {
+		s.skipWhitespace()
+		if s.peek() == '>' || s.peek() == eof {
+			break
+		}
+
+		attr, err := s.scanAttr()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case attr.Name == "if":
+			if b.Cond != "" {
+				return nil, NewSyntaxError(attr.NamePos, "Duplicate condition on attribute block: %s", shortComponentBlockString(b))
+			} else if attr.Value == "" {
+				return nil, NewSyntaxError(attr.NamePos, "Expected expression for attribute block condition")
+			}
+			b.Cond, b.CondPos = attr.Value, attr.ValuePos
+
+		case token.IsIdentifier(attr.Name) && (attr.Value == "" || isTypeExpr(attr.Value)):
+			for _, p := range b.Params {
+				if p.Name == attr.Name {
+					return nil, NewSyntaxError(attr.NamePos, "Duplicate parameter on attribute block: %s", attr.Name)
+				}
+			}
+			b.Params = append(b.Params, &Param{Name: attr.Name, Type: attr.Value, Pos: attr.NamePos})
+
+		default:
+			return nil, NewSyntaxError(attr.NamePos, "Unexpected attribute on attribute block: %s", attr.Name)
+		}
+	}
 
 	// Scan close.
 	if ch := s.read(); ch != '>' {
@@ -327,23 +1089,12 @@ func (s *Scanner) scanAttrEndBlock() (_ *AttrEndBlock, err error) {
 // scans the reader until %> is reached.
 func (s *Scanner) scanContent() (string, error) {
 	var buf bytes.Buffer
-	for {
+	for !s.readClose() {
 		ch := s.read()
 		if ch == eof {
 			return "", &SyntaxError{Message: "Expected close tag, found EOF", Pos: s.pos}
-		} else if ch == '%' {
-			ch := s.read()
-			if ch == eof {
-				return "", &SyntaxError{Message: "Expected close tag, found EOF", Pos: s.pos}
-			} else if ch == '>' {
-				break
-			} else {
-				buf.WriteRune('%')
-				buf.WriteRune(ch)
-			}
-		} else {
-			buf.WriteRune(ch)
 		}
+		buf.WriteRune(ch)
 	}
 	return string(buf.Bytes()), nil
 }
@@ -387,6 +1138,22 @@ func (s *Scanner) scanField() (*Field, error) {
 	}, nil
 }
 
+// scanSpread reads the expression of spread attributes after "...".
+func (s *Scanner) scanSpread() (*Spread, error) {
+	pos := s.pos
+	assert(s.readN(3) == "...")
+	if ch := s.peek(); isWhitespace(ch) || ch == '>' || ch == '/' || ch == eof {
+		return nil, NewSyntaxError(pos, "Expected expression after spread operator")
+	}
+
+	valuePos := s.pos
+	value, err := s.scanExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &Spread{Value: value, ValuePos: valuePos}, nil
+}
+
 func (s *Scanner) scanAttr() (*Attr, error) {
 	s.skipWhitespace()
 
@@ -426,6 +1193,43 @@ func (s *Scanner) scanAttr() (*Attr, error) {
 	}, nil
 }
 
+// isTypeExpr returns true if s is a Go type expression.
+func isTypeExpr(s string) bool {
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return false
+	}
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.Ident, *ast.ArrayType, *ast.MapType, *ast.FuncType, *ast.ChanType, *ast.InterfaceType, *ast.StructType:
+			return true
+		case *ast.SelectorExpr:
+			_, ok := e.X.(*ast.Ident)
+			return ok
+		default:
+			return false
+		}
+	}
+}
+
+// parseFuncSignature parses the signature of a function directive. Returns nil
+// if sig is not a single function or method declaration without a body.
+func parseFuncSignature(sig string) *ast.FuncDecl {
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+sig, 0)
+	if err != nil || len(f.Decls) != 1 {
+		return nil
+	}
+	decl, ok := f.Decls[0].(*ast.FuncDecl)
+	if !ok || decl.Body != nil {
+		return nil
+	}
+	return decl
+}
+
 func (s *Scanner) peekIdent() bool {
 	ident, _ := s.scanIdent()
 	return ident != ""
@@ -527,8 +1331,12 @@ func (s *Scanner) read() rune {
 	ch, n := utf8.DecodeRune(s.b[s.i:])
 	s.i += n
 
+	s.pos.Offset = s.i
 	if ch == '\n' {
 		s.pos.LineNo++
+		s.pos.Column = 1
+	} else {
+		s.pos.Column += n
 	}
 	return ch
 }
@@ -546,6 +1354,63 @@ func (s *Scanner) readN(n int) string {
 	return buf.String()
 }
 
+// openDelim returns the delimiter that opens an ego block.
+func (s *Scanner) openDelim() string {
+	if s.Delims[0] == "" {
+		return "<%"
+	}
+	return s.Delims[0]
+}
+
+// closeDelim returns the delimiter that closes an ego block.
+func (s *Scanner) closeDelim() string {
+	if s.Delims[1] == "" {
+		return "%>"
+	}
+	return s.Delims[1]
+}
+
+// peekOpen returns true if the next characters are the open delimiter
+// followed by suffix, such as "<%=" for "=".
+func (s *Scanner) peekOpen(suffix string) bool {
+	return bytes.HasPrefix(s.b[s.i:], []byte(s.openDelim()+suffix))
+}
+
+// readOpen reads the open delimiter followed by suffix. Returns false and
+// reads nothing if the next characters do not match.
+func (s *Scanner) readOpen(suffix string) bool {
+	if !s.peekOpen(suffix) {
+		return false
+	}
+	s.readN(utf8.RuneCountInString(s.openDelim() + suffix))
+	return true
+}
+
+// peekClose returns true if the next characters are the close delimiter.
+func (s *Scanner) peekClose() bool {
+	return bytes.HasPrefix(s.b[s.i:], []byte(s.closeDelim()))
+}
+
+// readClose reads the close delimiter. Returns false and reads nothing if
+// the next characters do not match.
+func (s *Scanner) readClose() bool {
+	if !s.peekClose() {
+		return false
+	}
+	s.readN(utf8.RuneCountInString(s.closeDelim()))
+	return true
+}
+
+// loadStates returns the regex matching load state tags.
+func (s *Scanner) loadStates() *regexp.Regexp {
+	if s.Delims == [2]string{} {
+		return loadStateRegex
+	} else if s.loadStateRegex == nil {
+		s.loadStateRegex = newLoadStateRegex(s.openDelim(), s.closeDelim())
+	}
+	return s.loadStateRegex
+}
+
 // peek reads the next rune but does not move the position forward.
 func (s *Scanner) peek() rune {
 	if s.i >= len(s.b) {
@@ -617,6 +1482,79 @@ func (e *SyntaxError) Error() string {
 	return fmt.Sprintf("%s at %s:%d", e.Message, e.Pos.Path, e.Pos.LineNo)
 }
 
+// ErrorList is a list of syntax errors found while parsing a template. The
+// parser continues after recoverable errors so that all of them are reported
+// in one pass. A single error is returned as a *SyntaxError instead.
+type ErrorList []*SyntaxError
+
+// Error returns the first error and the number of remaining errors.
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Unwrap returns the first error so errors.As finds a *SyntaxError in a list.
+func (l ErrorList) Unwrap() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l[0]
+}
+
+// err returns nil for an empty list, the error of a list with one error, or
+// the list itself.
+func (l ErrorList) err() error {
+	switch len(l) {
+	case 0:
+		return nil
+	case 1:
+		return l[0]
+	}
+	return l
+}
+
+// add appends a syntax error or list of syntax errors to the list.
+func (l *ErrorList) add(err error) {
+	switch err := err.(type) {
+	case *SyntaxError:
+		*l = append(*l, err)
+	case ErrorList:
+		*l = append(*l, err...)
+	default:
+		*l = append(*l, &SyntaxError{Message: err.Error()})
+	}
+}
+
+// with returns the list with a final error appended. Errors that are not
+// syntax errors, such as read errors, are returned alone.
+func (l ErrorList) with(err error) error {
+	if _, ok := err.(*SyntaxError); !ok {
+		return err
+	}
+	l.add(err)
+	l.sort()
+	return l.err()
+}
+
+// sort sorts the errors by their path and then their position in the file so
+// errors of included files are not interleaved with errors of the template.
+func (l ErrorList) sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i].Pos, l[j].Pos
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		} else if a.LineNo != b.LineNo {
+			return a.LineNo < b.LineNo
+		}
+		return a.Column < b.Column
+	})
+}
+
 func isIdentStart(ch rune) bool {
 	return unicode.IsLetter(ch) || ch == '_'
 }
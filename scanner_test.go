@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/benbjohnson/ego"
@@ -20,7 +21,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected block type: %T", blk)
 			} else if blk.Content != "hello world" {
 				t.Fatalf("unexpected content: %s", blk.Content)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -57,7 +58,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected block type: %T", blk)
 			} else if blk.Content != " x := 1 " {
 				t.Fatalf("unexpected content: %s", blk.Content)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -91,6 +92,47 @@ func TestScanner(t *testing.T) {
 		})
 	})
 
+	t.Run("CommentBlock", func(t *testing.T) {
+		s := ego.NewScanner(bytes.NewBufferString("<%# TODO: <%= x %> -%>"), "tmpl.ego")
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.CommentBlock); !ok {
+			t.Fatalf("unexpected block type: %T", blk)
+		} else if blk.Content != " TODO: <%= x " || blk.TrimRight != ego.TrimNone {
+			t.Fatalf("unexpected block: %#v", blk)
+		} else if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != " -%>" {
+			t.Fatalf("unexpected block: %#v", blk)
+		}
+	})
+
+	t.Run("FuncBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%: func (r *Page) Render(ctx context.Context, w io.Writer) error %>\n<p>"), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.FuncBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != "func (r *Page) Render(ctx context.Context, w io.Writer) error" {
+				t.Fatalf("unexpected content: %q", blk.Content)
+			} else if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != "<p>" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("ErrInvalid", func(t *testing.T) {
+			for _, src := range []string{`<%: Render(w io.Writer) %>`, `<%: func Render(w io.Writer) {} %>`, `<%: func A(); func B() %>`} {
+				s := ego.NewScanner(bytes.NewBufferString(src), "tmpl.ego")
+				if _, err := s.Scan(); err == nil || !strings.HasPrefix(err.Error(), "Invalid function directive: ") {
+					t.Fatalf("unexpected error for %s: %v", src, err)
+				}
+			}
+		})
+	})
+
 	t.Run("PrintBlock", func(t *testing.T) {
 		t.Run("UnexpectedEOF", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`<%=`), "tmpl.ego")
@@ -100,6 +142,439 @@ func TestScanner(t *testing.T) {
 		})
 	})
 
+	t.Run("ScopeBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=scope %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.ScopeBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
+				t.Fatalf("unexpected pos: %#v", blk.Pos)
+			}
+		})
+
+		t.Run("NoSpace", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=scope%>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if _, ok := blk.(*ego.ScopeBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			}
+		})
+
+		t.Run("PrintBlock", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%= scope %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if _, ok := blk.(*ego.PrintBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			}
+		})
+
+		t.Run("ErrUnexpectedContent", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=scope x %>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `Unexpected content in scope directive: x at tmpl.ego:1` {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	})
+
+	t.Run("SanitizeBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=sanitize post.Body %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.SanitizeBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != "post.Body" {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
+				t.Fatalf("unexpected pos: %#v", blk.Pos)
+			}
+		})
+
+		t.Run("ErrNoExpr", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=sanitize %>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `Expected expression in sanitize directive at tmpl.ego:1` {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	})
+
+	t.Run("JSONPrintBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=json r.State %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.JSONPrintBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != "r.State" {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			}
+		})
+
+		t.Run("ErrNoExpr", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=json %>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `Expected expression in json directive at tmpl.ego:1` {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	})
+
+	t.Run("URLPrintBlock", func(t *testing.T) {
+		t.Run("Query", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=url q %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.URLPrintBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != "q" || blk.Path {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("Path", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=url:path user.Name %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.URLPrintBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != "user.Name" || !blk.Path {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("ErrNoExpr", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=url:path %>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `Expected expression in url:path directive at tmpl.ego:1` {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	})
+
+	t.Run("TranslateBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%t "welcome.message", user.Name, f(1, 2) %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TranslateBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Key != "welcome.message" {
+				t.Fatalf("unexpected key: %s", blk.Key)
+			} else if !reflect.DeepEqual(blk.Args, []string{"user.Name", "f(1, 2)"}) {
+				t.Fatalf("unexpected args: %#v", blk.Args)
+			}
+		})
+
+		t.Run("CodeBlock", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%t := 1 %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if _, ok := blk.(*ego.CodeBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			}
+		})
+
+		t.Run("ErrNoKey", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%t "a" + key %>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `Expected message key in translate directive: "a" + key at tmpl.ego:1` {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+
+		t.Run("ErrInvalidArgs", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%t "a", x y %>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `Invalid arguments in translate directive: "a", x y at tmpl.ego:1` {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	})
+
+	t.Run("BuildBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%build linux,!windows %>\n<% package foo %>"), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.BuildBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Constraint != "linux,!windows" {
+				t.Fatalf("unexpected constraint: %s", blk.Constraint)
+			}
+
+			// Trailing newline should be consumed.
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if _, ok := blk.(*ego.CodeBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			}
+		})
+
+		t.Run("ErrInvalid", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%build linux && %>"), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Invalid build constraint: linux && at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	})
+
+	t.Run("CtxBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%ctx user := auth.User(ctx) %>"), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.CtxBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != "user := auth.User(ctx)" {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			}
+		})
+
+		t.Run("ErrNoStmt", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%ctx %>"), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected statement in ctx directive at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	})
+
+	t.Run("TrimMarkers", func(t *testing.T) {
+		for _, tt := range []struct {
+			src         string
+			content     string
+			left, right ego.Trim
+		}{
+			{src: "<%~ x -%>", content: " x ", left: ego.TrimLine, right: ego.TrimAll},
+			{src: "<%- x ~%>", content: " x ", left: ego.TrimAll, right: ego.TrimLine},
+			{src: "<% i-- %>", content: " i-- "},
+			{src: "<%-1 %>", content: "-1 "},
+		} {
+			s := ego.NewScanner(bytes.NewBufferString(tt.src), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.CodeBlock); !ok {
+				t.Fatalf("%s: unexpected block type: %T", tt.src, blk)
+			} else if blk.Content != tt.content || blk.TrimLeft != tt.left || blk.TrimRight != tt.right {
+				t.Fatalf("%s: unexpected block: %#v", tt.src, blk)
+			}
+		}
+
+		s := ego.NewScanner(bytes.NewBufferString("<%= -1 %>"), "tmpl.ego")
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.PrintBlock); !ok || blk.Content != " -1 " || blk.TrimLeft != ego.TrimNone {
+			t.Fatalf("unexpected block: %#v", blk)
+		}
+	})
+
+	t.Run("AppendBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=append "notes" 100% done %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.AppendStartBlock); !ok || blk.Name != `"notes"` {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != "100% done " {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if _, ok := blk.(*ego.AppendEndBlock); !ok {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("ErrNoName", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%=append notes %>"), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected region name in append directive at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	})
+
+	t.Run("FormatBlock", func(t *testing.T) {
+		s := ego.NewScanner(bytes.NewBufferString("<%json %>x<%/json\n%><%html := 1 %>"), "tmpl.ego")
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.FormatStartBlock); !ok || blk.Format != "json" {
+			t.Fatalf("unexpected block: %#v", blk)
+		}
+		if _, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		}
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.FormatEndBlock); !ok || blk.Format != "json" {
+			t.Fatalf("unexpected block: %#v", blk)
+		}
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.CodeBlock); !ok || blk.Content != "html := 1 " {
+			t.Fatalf("unexpected block: %#v", blk)
+		}
+	})
+
+	t.Run("LoadStateBlock", func(t *testing.T) {
+		s := ego.NewScanner(bytes.NewBufferString("<%error err %><%success %><%error = nil %>"), "tmpl.ego")
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.LoadStateBlock); !ok || blk.State != "error" || blk.Name != "err" {
+			t.Fatalf("unexpected block: %#v", blk)
+		}
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.LoadStateBlock); !ok || blk.State != "success" || blk.Name != "" {
+			t.Fatalf("unexpected block: %#v", blk)
+		}
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.CodeBlock); !ok || blk.Content != "error = nil " {
+			t.Fatalf("unexpected block: %#v", blk)
+		}
+	})
+
+	t.Run("FlushBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%=flush "notes" %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.FlushBlock); !ok || blk.Name != `"notes"` {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("ErrNoName", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%=flush %>"), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected region name in flush directive at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	})
+
+	t.Run("Interpolation", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`Hello ${ name }!`), "tmpl.ego")
+			s.Interpolate = true
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != "Hello " {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.PrintBlock); !ok || blk.Content != "name" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != "!" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("NestedBraces", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("${ T{X: \"}\"}.X + `{` }"), "tmpl.ego")
+			s.Interpolate = true
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.PrintBlock); !ok || blk.Content != "T{X: \"}\"}.X + `{`" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("Escaped", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`$${name}`), "tmpl.ego")
+			s.Interpolate = true
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != "${" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != "name}" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("Disabled", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`Hello ${name}`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != "Hello ${name}" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("ErrNoExpr", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`${ }`), "tmpl.ego")
+			s.Interpolate = true
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected expression in interpolation at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		t.Run("UnexpectedEOF", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`${ name`), "tmpl.ego")
+			s.Interpolate = true
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected close of interpolation, found EOF at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	})
+
+	t.Run("VerbatimBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%% <%= name %> %%>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != " <%= name %> " {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+			if _, err := s.Scan(); err != io.EOF {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		t.Run("UnexpectedEOF", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%% <%= name %>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected close of verbatim block, found EOF at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	})
+
+	t.Run("Delims", func(t *testing.T) {
+		s := ego.NewScanner(bytes.NewBufferString(`<?php echo "<% x %>"; ?>{{%= name %}}{{% if ok { %}}%>{{% } %}}`), "tmpl.ego")
+		s.Delims = [2]string{"{{%", "%}}"}
+
+		var a []string
+		for {
+			blk, err := s.Scan()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			switch blk := blk.(type) {
+			case *ego.TextBlock:
+				a = append(a, "text:"+blk.Content)
+			case *ego.PrintBlock:
+				a = append(a, "print:"+blk.Content)
+			case *ego.CodeBlock:
+				a = append(a, "code:"+blk.Content)
+			default:
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		}
+		if exp := []string{`text:<?php echo "`, `text:<% x %>"; ?>`, "print: name ", "code: if ok { ", "text:%>", "code: } "}; !reflect.DeepEqual(a, exp) {
+			t.Fatalf("unexpected blocks: %q", a)
+		}
+	})
+
 	t.Run("ComponentStartBlock", func(t *testing.T) {
 		t.Run("TypeOnly", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`<ego:MyComponent123>`), "tmpl.ego")
@@ -111,7 +586,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected package: %s", blk.Package)
 			} else if blk.Name != "MyComponent123" {
 				t.Fatalf("unexpected name: %s", blk.Name)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -126,7 +601,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected package: %s", blk.Package)
 			} else if blk.Name != "myComponent123" {
 				t.Fatalf("unexpected name: %s", blk.Name)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -142,9 +617,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected field count: %d", len(blk.Fields))
 				} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 					Name:     "Foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 					Value:    "123",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected field: %#v", blk.Fields[0])
 				}
@@ -160,9 +635,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected field count: %d", len(blk.Fields))
 				} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 					Name:     "Foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 					Value:    "100.23",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected field: %#v", blk.Fields[0])
 				}
@@ -178,9 +653,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected field count: %d", len(blk.Fields))
 				} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 					Name:     "Foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 					Value:    "true",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected field: %#v", blk.Fields[0])
 				}
@@ -197,9 +672,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    `"hello \t foo!"`,
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -215,9 +690,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo123",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    "`hello \\t foo!`",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 23, Offset: 22}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -235,9 +710,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    "&util.T{X: x, Y: 12}",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -253,9 +728,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    `&util.T{X: x, Y: []V{{Z:"foo"},{Z:"bar"}}}`,
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -271,9 +746,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    "util.T {}",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -292,9 +767,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:     "foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 					Value:    "123",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -310,9 +785,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:     "foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 					Value:    "100.23",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -328,9 +803,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:     "foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 					Value:    "true",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -346,7 +821,7 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:    "foo",
-					NamePos: ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 				}) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -362,7 +837,7 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:    "foo-bar",
-					NamePos: ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 				}) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -379,9 +854,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    `"hello \t foo!"`,
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -397,9 +872,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "_foo123",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    "`hello \\t foo!`",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 24, Offset: 23}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -417,9 +892,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    "&util.T{X: x, Y: 12}",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -435,9 +910,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    `&util.T{X: x, Y: []V{{Z:"foo"},{Z:"bar"}}}`,
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -453,9 +928,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15},
 						Value:    "util.T {}",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -464,6 +939,63 @@ func TestScanner(t *testing.T) {
 		})
 	})
 
+	t.Run("ComponentLoop", func(t *testing.T) {
+		t.Run("Range", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Item for=(_, item := range p.Items[f(")"):]) Name=item />`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.ComponentStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.For != `_, item := range p.Items[f(")"):]` {
+				t.Fatalf("unexpected loop clause: %s", blk.For)
+			} else if len(blk.Fields) != 1 || len(blk.Attrs) != 0 {
+				t.Fatalf("unexpected fields/attrs: %d/%d", len(blk.Fields), len(blk.Attrs))
+			}
+		})
+
+		t.Run("Attr", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Label for=(id)>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.ComponentStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.For != "" {
+				t.Fatalf("unexpected loop clause: %s", blk.For)
+			} else if len(blk.Attrs) != 1 || blk.Attrs[0].Name != "for" || blk.Attrs[0].Value != "(id)" {
+				t.Fatalf("unexpected attrs: %#v", blk.Attrs)
+			}
+		})
+
+		t.Run("UnexpectedEOF", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Item for=(i := 0; i < n`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected close of loop clause, found EOF at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	})
+
+	t.Run("ComponentFlag", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:NewNav flag="new-nav" class="nav">`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.ComponentStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Flag != `"new-nav"` {
+				t.Fatalf("unexpected flag: %s", blk.Flag)
+			} else if len(blk.Attrs) != 1 || blk.Attrs[0].Name != "class" {
+				t.Fatalf("unexpected attrs: %#v", blk.Attrs)
+			}
+		})
+
+		t.Run("ErrNoExpr", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:NewNav flag>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected expression for component flag at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	})
+
 	t.Run("ComponentEndBlock", func(t *testing.T) {
 		t.Run("TypeOnly", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`</ego:MyComponent123>`), "tmpl.ego")
@@ -475,7 +1007,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected package: %s", blk.Package)
 			} else if blk.Name != "MyComponent123" {
 				t.Fatalf("unexpected name: %s", blk.Name)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -490,25 +1022,73 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected package: %s", blk.Package)
 			} else if blk.Name != "myComponent123" {
 				t.Fatalf("unexpected name: %s", blk.Name)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
 	})
 
 	t.Run("AttrStartBlock", func(t *testing.T) {
-		s := ego.NewScanner(bytes.NewBufferString(`<ego::MyField123>`), "tmpl.ego")
-		if blk, err := s.Scan(); err != nil {
-			t.Fatal(err)
-		} else if blk, ok := blk.(*ego.AttrStartBlock); !ok {
-			t.Fatalf("unexpected block type: %T", blk)
-		} else if blk.Package != "" {
-			t.Fatalf("unexpected package: %s", blk.Package)
-		} else if blk.Name != "MyField123" {
-			t.Fatalf("unexpected name: %s", blk.Name)
-		} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
-			t.Fatalf("unexpected pos: %#v", blk.Pos)
-		}
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego::MyField123>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.AttrStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Package != "" {
+				t.Fatalf("unexpected package: %s", blk.Package)
+			} else if blk.Name != "MyField123" {
+				t.Fatalf("unexpected name: %s", blk.Name)
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
+				t.Fatalf("unexpected pos: %#v", blk.Pos)
+			}
+		})
+
+		t.Run("Cond", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego::Footer if=(p.ShowFooter && !p.Compact) >`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.AttrStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Cond != "(p.ShowFooter && !p.Compact)" {
+				t.Fatalf("unexpected cond: %s", blk.Cond)
+			}
+		})
+
+		t.Run("Params", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego::Cell row col=*pkg.Col if=ok>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.AttrStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if !reflect.DeepEqual(blk.Params, []*ego.Param{
+				{Name: "row", Pos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 12, Offset: 11}},
+				{Name: "col", Type: "*pkg.Col", Pos: ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 16, Offset: 15}},
+			}) || blk.Cond != "ok" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("ErrDuplicateParam", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego::Row row row>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Duplicate parameter on attribute block: row at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		t.Run("ErrUnexpectedAttr", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego::Footer class="x">`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Unexpected attribute on attribute block: class at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		t.Run("ErrNoCond", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego::Footer if>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != "Expected expression for attribute block condition at tmpl.ego:1" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
 	})
 
 	t.Run("AttrEndBlock", func(t *testing.T) {
@@ -521,7 +1101,7 @@ func TestScanner(t *testing.T) {
 			t.Fatalf("unexpected package: %s", blk.Package)
 		} else if blk.Name != "_myField123" {
 			t.Fatalf("unexpected name: %s", blk.Name)
-		} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+		} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
 			t.Fatalf("unexpected pos: %#v", blk.Pos)
 		}
 	})
@@ -530,23 +1110,39 @@ func TestScanner(t *testing.T) {
 		s := ego.NewScanner(bytes.NewBufferString("hello\nworld<%== x \n\n %>goodbye"), "tmpl.ego")
 		if blk, err := s.Scan(); err != nil {
 			t.Fatal(err)
-		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, Column: 1}) {
 			t.Fatalf("unexpected pos(0): %#v", pos)
 		}
 
 		if blk, err := s.Scan(); err != nil {
 			t.Fatal(err)
-		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 2}) {
+		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 2, Column: 6, Offset: 11}) {
 			t.Fatalf("unexpected pos(1): %#v", pos)
 		}
 
 		if blk, err := s.Scan(); err != nil {
 			t.Fatal(err)
-		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 4}) {
+		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 4, Column: 4, Offset: 23}) {
 			t.Fatalf("unexpected pos(2): %#v", pos)
 		}
 	})
 
+	t.Run("End", func(t *testing.T) {
+		s := ego.NewScanner(bytes.NewBufferString("h\u00e9llo<%= x\n%>\n<ego:Card/>"), "tmpl.ego")
+		for i, exp := range [][2]ego.Pos{
+			{{Path: "tmpl.ego", LineNo: 1, Column: 1}, {Path: "tmpl.ego", LineNo: 1, Column: 7, Offset: 6}},
+			{{Path: "tmpl.ego", LineNo: 1, Column: 7, Offset: 6}, {Path: "tmpl.ego", LineNo: 2, Column: 3, Offset: 14}},
+			{{Path: "tmpl.ego", LineNo: 3, Column: 1, Offset: 15}, {Path: "tmpl.ego", LineNo: 3, Column: 1, Offset: 15}},
+			{{Path: "tmpl.ego", LineNo: 3, Column: 1, Offset: 15}, {Path: "tmpl.ego", LineNo: 3, Column: 12, Offset: 26}},
+		} {
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if pos, end := ego.Position(blk), ego.EndPosition(blk); pos != exp[0] || end != exp[1] {
+				t.Fatalf("%d. unexpected pos: %#v, %#v", i, pos, end)
+			}
+		}
+	})
+
 	t.Run("EOF", func(t *testing.T) {
 		s := ego.NewScanner(bytes.NewBuffer(nil), "tmpl.ego")
 		if blk, err := s.Scan(); err != io.EOF {
@@ -0,0 +1,47 @@
+package ego
+
+import (
+	"path"
+)
+
+// Schema describes a component for validation during code generation.
+type Schema struct {
+	// Attrs lists the passthrough attribute names accepted by the component.
+	// Names may contain wildcards as defined by path.Match (e.g. "data-*").
+	Attrs []string `json:"attrs"`
+}
+
+// HasAttr returns true if the schema accepts an attribute name.
+func (s *Schema) HasAttr(name string) bool {
+	for _, pattern := range s.Attrs {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSchemas validates that every component with a registered schema is
+// only passed known attributes. Returns an error at the position of the first
+// unknown attribute.
+func (t *Template) CheckSchemas() (err error) {
+	inspectBlocks(t.Blocks, func(blk Block) bool {
+		start, ok := blk.(*ComponentStartBlock)
+		if !ok || err != nil {
+			return err == nil
+		}
+
+		schema := t.Schemas[start.Namespace()+":"+start.Name]
+		if schema == nil {
+			return true
+		}
+		for _, attr := range start.Attrs {
+			if !schema.HasAttr(attr.Name) {
+				err = NewSyntaxError(attr.NamePos, "Unknown attribute %q on component %s", attr.Name, shortComponentBlockString(start))
+				return false
+			}
+		}
+		return true
+	})
+	return err
+}
@@ -0,0 +1,40 @@
+package ego_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that unknown attributes on components with a schema are rejected.
+func TestTemplate_CheckSchemas(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<ego:Button id=\"x\" data-id=1>\n</ego:Button>\n<ui:Card clas=\"x\"/>\n<ego:Link href=\"#\" />"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		tmpl.Schemas = map[string]*ego.Schema{
+			"ego:Button": {Attrs: []string{"id", "data-*"}},
+			"ui:Card":    {Attrs: []string{"clas"}},
+		}
+		if err := tmpl.CheckSchemas(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrUnknownAttr", func(t *testing.T) {
+		tmpl.Schemas = map[string]*ego.Schema{
+			"ego:Button": {Attrs: []string{"id", "data-*"}},
+			"ui:Card":    {Attrs: []string{"class"}},
+		}
+		if err := tmpl.CheckSchemas(); err == nil || err.Error() != `Unknown attribute "clas" on component <ui:Card> at tmpl.ego:3` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || err.Error() != `Unknown attribute "clas" on component <ui:Card> at tmpl.ego:3` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
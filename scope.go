@@ -0,0 +1,71 @@
+package ego
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"hash/fnv"
+	"strconv"
+)
+
+// scopeIdent is a placeholder identifier written for scope blocks. It is
+// replaced by the scope class of the enclosing method's receiver type.
+const scopeIdent = "EGO_SCOPE"
+
+// ScopeClass returns the CSS scope prefix for a component. The name is the
+// package name and type name joined by a dot, e.g. "views.Button".
+//
+// The prefix is "ego-" followed by the lowercase hex encoding of the 32-bit
+// FNV-1a hash of the name so it is stable across builds and can be computed
+// by CSS tooling.
+func ScopeClass(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("ego-%08x", h.Sum32())
+}
+
+// replaceScopes replaces scope placeholders within methods with the scope
+// class of the method's receiver type.
+func replaceScopes(fset *token.FileSet, f *ast.File) (err error) {
+	for _, decl := range f.Decls {
+		var lit *ast.BasicLit
+		if decl, ok := decl.(*ast.FuncDecl); ok && decl.Recv != nil && len(decl.Recv.List) == 1 {
+			if name := receiverTypeName(decl.Recv.List[0].Type); name != "" {
+				lit = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(ScopeClass(f.Name.Name + "." + name))}
+			}
+		}
+
+		ast.Inspect(decl, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || err != nil {
+				return err == nil
+			}
+			for i, arg := range call.Args {
+				if ident, ok := arg.(*ast.Ident); !ok || ident.Name != scopeIdent {
+					continue
+				} else if lit == nil {
+					pos := fset.Position(ident.Pos())
+					err = NewSyntaxError(Pos{Path: pos.Filename, LineNo: pos.Line}, "Scope directive used outside of a component method")
+					return false
+				}
+				call.Args[i] = &ast.BasicLit{ValuePos: arg.Pos(), Kind: token.STRING, Value: lit.Value}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiverTypeName returns the base type name of a method receiver.
+func receiverTypeName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
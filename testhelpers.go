@@ -0,0 +1,89 @@
+package ego
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+)
+
+// WriteTestHelpersTo writes a Go test file with an AssertRender method for each
+// type in the generated template with a Render method:
+//
+//	func (r *T) AssertRender(t *testing.T, ctx context.Context, want string)
+//
+// AssertRender renders the component and fails the test with a line diff, as
+// formatted by Diff, if the output is not want. Parameters & variables with
+// the same name as the receiver are renamed. The file should be written
+// with a "_test.go" suffix so the testing package is only imported by tests.
+// Nothing is written if the template has no renderer types.
+func (t *Template) WriteTestHelpersTo(w io.Writer) (n int64, err error) {
+	var src bytes.Buffer
+	if _, err := t.WriteTo(&src); err != nil {
+		return 0, err
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src.Bytes(), 0)
+	if err != nil {
+		return 0, err
+	}
+	renderers := findRenderers(f)
+	if len(renderers) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	if err := t.writeBuildConstraint(&buf); err != nil {
+		return 0, err
+	}
+	buf.WriteString("// Generated by ego.\n")
+	buf.WriteString("// DO NOT EDIT\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", f.Name.Name)
+	fmt.Fprintf(&buf, "import (\n\"bytes\"\n\"context\"\n\"testing\"\n\n%q\n)\n", RuntimePath)
+	for _, r := range renderers {
+		writeAssertRenderMethod(&buf, r)
+	}
+
+	if f, err = parser.ParseFile(fset, "", buf.Bytes(), parser.ParseComments); err != nil {
+		n, _ = buf.WriteTo(w)
+		return n, err
+	}
+	var result bytes.Buffer
+	if err := t.format(&result, fset, f); err != nil {
+		n, _ = buf.WriteTo(w)
+		return n, err
+	}
+	return result.WriteTo(w)
+}
+
+// writeAssertRenderMethod writes a test helper that renders and compares the
+// output against an expected string.
+func writeAssertRenderMethod(buf *bytes.Buffer, r *renderer) {
+	name := func(name, alt string) string {
+		if r.RecvName == name {
+			return alt
+		}
+		return name
+	}
+	tb, ctx, want := name("t", "tb"), name("ctx", "c"), name("want", "exp")
+	out, got, err := name("buf", "b"), name("got", "s"), name("err", "e")
+
+	fmt.Fprintf(buf, "\n// AssertRender renders %s and reports a test error with a diff if the\n", r.Name)
+	fmt.Fprintf(buf, "// output is not %s.\n", want)
+	fmt.Fprintf(buf, "func (%s %s) AssertRender(%s *testing.T, %s context.Context, %s string) {\n", r.RecvName, r.Recv, tb, ctx, want)
+	fmt.Fprintf(buf, "%s.Helper()\n", tb)
+	fmt.Fprintf(buf, "var %s bytes.Buffer\n", out)
+	if r.Err {
+		fmt.Fprintf(buf, "if %s := %s.Render(%s, &%s); %s != nil && %s != ego.ErrSkip {\n", err, r.RecvName, ctx, out, err, err)
+		fmt.Fprintf(buf, "%s.Fatalf(\"%s: render: %%s\", %s)\n", tb, r.Name, err)
+		fmt.Fprintf(buf, "}\n")
+	} else {
+		fmt.Fprintf(buf, "%s.Render(%s, &%s)\n", r.RecvName, ctx, out)
+	}
+	fmt.Fprintf(buf, "if %s := %s.String(); %s != %s {\n", got, out, got, want)
+	fmt.Fprintf(buf, "%s.Errorf(\"%s: unexpected output:\\n%%s\", ego.Diff(%s, %s))\n", tb, r.Name, want, got)
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "}\n")
+}
@@ -0,0 +1,90 @@
+package ego
+
+// DefaultMaxDepth is the maximum static nesting depth of components when no
+// limit is specified on the template.
+const DefaultMaxDepth = 100
+
+// Validate checks the template for errors that can be found before code is
+// generated. It is called by WriteTo.
+func (t *Template) Validate() error {
+	if err := t.CheckSchemas(); err != nil {
+		return err
+	}
+	if err := t.checkDepth(); err != nil {
+		return err
+	}
+	return checkDynamic(t.Blocks)
+}
+
+// checkDynamic returns an error if a dynamic component does not have a name
+// attribute with a value or has a loader, which is not supported.
+func checkDynamic(a []Block) (err error) {
+	inspectBlocks(a, func(blk Block) bool {
+		blk0, ok := blk.(*ComponentStartBlock)
+		if !ok || err != nil || !blk0.isDynamic() {
+			return err == nil
+		}
+
+		if name, _ := blk0.dynamicName(); name == "" {
+			err = NewSyntaxError(blk0.Pos, "Expected name attribute on dynamic component: %s", shortComponentBlockString(blk0))
+		} else if blk0.Load != "" {
+			err = NewSyntaxError(blk0.Pos, "Loader not supported on dynamic component: %s", shortComponentBlockString(blk0))
+		}
+		return err == nil
+	})
+	return err
+}
+
+// checkDepth returns an error at the position of the most deeply nested
+// component if the nesting depth exceeds the template's limit. Components
+// nest within the body & attribute blocks of other components.
+func (t *Template) checkDepth() error {
+	limit := t.MaxDepth
+	if limit == 0 {
+		limit = DefaultMaxDepth
+	}
+
+	depth, deepest := maxDepth(t.Blocks)
+	if depth > limit {
+		return NewSyntaxError(deepest.Pos, "Component nesting depth of %d exceeds limit of %d: %s", depth, limit, shortComponentBlockString(deepest))
+	}
+	return nil
+}
+
+// maxDepth returns the maximum component nesting depth within a and the most
+// deeply nested component.
+func maxDepth(a []Block) (depth int, deepest *ComponentStartBlock) {
+	for _, blk := range a {
+		var d int
+		var inner *ComponentStartBlock
+		switch blk := blk.(type) {
+		case *ComponentStartBlock:
+			d, inner = componentDepth(blk)
+		case *AppendStartBlock:
+			d, inner = maxDepth(blk.Yield)
+		case *FormatStartBlock:
+			d, inner = maxDepth(blk.Yield)
+		case *YieldStartBlock:
+			d, inner = maxDepth(blk.Yield)
+		}
+		if d > depth {
+			depth, deepest = d, inner
+		}
+	}
+	return depth, deepest
+}
+
+// componentDepth returns the nesting depth of a component, including itself,
+// and its most deeply nested component.
+func componentDepth(blk *ComponentStartBlock) (int, *ComponentStartBlock) {
+	depth, deepest := maxDepth(blk.Yield)
+	for _, attrBlock := range blk.AttrBlocks {
+		if d, inner := maxDepth(attrBlock.Yield); d > depth {
+			depth, deepest = d, inner
+		}
+	}
+	if deepest == nil {
+		deepest = blk
+	}
+	return depth + 1, deepest
+}
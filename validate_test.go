@@ -0,0 +1,44 @@
+package ego_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that deeply nested components are reported at the deepest position.
+func TestTemplate_Validate_MaxDepth(t *testing.T) {
+	nested := func(n int) string {
+		return strings.Repeat("<ego:Box>\n", n) + strings.Repeat("</ego:Box>", n)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(nested(ego.DefaultMaxDepth)), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := tmpl.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrDefaultLimit", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader(nested(150)), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := tmpl.Validate(); err == nil || err.Error() != "Component nesting depth of 150 exceeds limit of 100: <ego:Box> at tmpl.ego:150" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrAttrBlock", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<ego:Card />\n<ego:Card><ego::Header><ego:Title>\n<ego:Icon /></ego:Title></ego::Header>\n<ego:Body /></ego:Card>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.MaxDepth = 2
+		if err := tmpl.Validate(); err == nil || err.Error() != "Component nesting depth of 3 exceeds limit of 2: <ego:Icon> at tmpl.ego:3" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
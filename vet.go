@@ -0,0 +1,307 @@
+package ego
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// Vet checks generated Go source for likely mistakes such as unreachable code,
+// self-assignments, and printf format mismatches. Warning positions refer to
+// the template via the generated line directives.
+func Vet(src []byte) ([]*Warning, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var a []*Warning
+	warn := func(pos token.Pos, format string, args ...interface{}) {
+		p := fset.Position(pos)
+		a = append(a, &Warning{Pos: Pos{Path: p.Filename, LineNo: p.Line}, Message: fmt.Sprintf(format, args...)})
+	}
+
+	ast.Inspect(f, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.BlockStmt:
+			vetUnreachable(node.List, warn)
+		case *ast.CaseClause:
+			vetUnreachable(node.Body, warn)
+		case *ast.CommClause:
+			vetUnreachable(node.Body, warn)
+		case *ast.AssignStmt:
+			vetSelfAssign(node, warn)
+		case *ast.CallExpr:
+			vetPrintf(node, warn)
+		}
+		return true
+	})
+	return a, nil
+}
+
+// vetUnreachable reports the first statement following a return, goto, or
+// call to panic within a statement list.
+func vetUnreachable(stmts []ast.Stmt, warn func(token.Pos, string, ...interface{})) {
+	for i, stmt := range stmts {
+		if i == len(stmts)-1 || !isTerminating(stmt) {
+			continue
+		} else if _, ok := stmts[i+1].(*ast.LabeledStmt); ok {
+			continue
+		}
+		warn(stmts[i+1].Pos(), "Unreachable code")
+		return
+	}
+}
+
+// isTerminating returns true if stmt always transfers control elsewhere.
+func isTerminating(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return stmt.Tok == token.GOTO
+	case *ast.ExprStmt:
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		ident, ok := call.Fun.(*ast.Ident)
+		return ok && ident.Name == "panic"
+	default:
+		return false
+	}
+}
+
+// vetSelfAssign reports assignments of a variable to itself.
+func vetSelfAssign(stmt *ast.AssignStmt, warn func(token.Pos, string, ...interface{})) {
+	if stmt.Tok != token.ASSIGN || len(stmt.Lhs) != len(stmt.Rhs) {
+		return
+	}
+	for i := range stmt.Lhs {
+		if !isSimpleRef(stmt.Lhs[i]) || !isSimpleRef(stmt.Rhs[i]) {
+			continue
+		} else if lhs := exprString(stmt.Lhs[i]); lhs == exprString(stmt.Rhs[i]) {
+			warn(stmt.Pos(), "Self-assignment of %s to %s", lhs, lhs)
+		}
+	}
+}
+
+// isSimpleRef returns true if expr is an identifier or a selector of one.
+func isSimpleRef(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name != "_"
+	case *ast.SelectorExpr:
+		return isSimpleRef(expr.X)
+	case *ast.ParenExpr:
+		return isSimpleRef(expr.X)
+	default:
+		return false
+	}
+}
+
+// printfFuncs maps fmt printf-style functions to the index of their format.
+var printfFuncs = map[string]int{
+	"Errorf":  0,
+	"Fprintf": 1,
+	"Printf":  0,
+	"Sprintf": 0,
+}
+
+// vetPrintf reports calls to fmt printf-style functions whose constant format
+// string does not match the number of arguments.
+func vetPrintf(call *ast.CallExpr, warn func(token.Pos, string, ...interface{})) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return
+	} else if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "fmt" {
+		return
+	}
+	idx, ok := printfFuncs[sel.Sel.Name]
+	if !ok || len(call.Args) <= idx || call.Ellipsis.IsValid() {
+		return
+	}
+
+	lit, ok := call.Args[idx].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return
+	}
+	format, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return
+	}
+
+	n, ok := countVerbs(format)
+	if !ok {
+		return
+	} else if args := len(call.Args) - idx - 1; n != args {
+		warn(call.Pos(), "fmt.%s format %q reads %d arg(s), but call has %d", sel.Sel.Name, format, n, args)
+	}
+}
+
+// countVerbs returns the number of arguments consumed by a printf format.
+// Returns false if the format uses explicit argument indexes.
+func countVerbs(format string) (n int, ok bool) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+
+		// Skip flags, width & precision. Star widths consume an argument.
+		for i++; i < len(format) && strings.IndexByte("+-# .*[0123456789", format[i]) != -1; i++ {
+			switch format[i] {
+			case '*':
+				n++
+			case '[':
+				return 0, false
+			}
+		}
+
+		if i < len(format) && format[i] != '%' {
+			n++
+		}
+	}
+	return n, true
+}
+
+// VetFields type-checks the generated code of templates together with the
+// other Go files of the package in dir. Reports component fields that do not
+// exist on the component's type and field values that cannot be assigned to
+// them. Warning positions refer to the fields within the templates.
+//
+// Previously generated files of the templates are ignored. Imported packages
+// are type-checked from source.
+func VetFields(dir string, templates []*Template) ([]*Warning, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	fset := token.NewFileSet()
+
+	// Parse the package & collect the fields of each template.
+	files, err := packageFiles(fset, dir, templates, 0)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string][]vetField)
+	for _, tmpl := range templates {
+		Inspect(tmpl.Blocks, func(blk Block) bool {
+			if blk, ok := blk.(*ComponentStartBlock); ok && !blk.isDynamic() {
+				for _, field := range blk.Fields {
+					key := vetFieldKey(field.Name, field.Value)
+					fields[key] = append(fields[key], vetField{Field: field, pos: blk.Pos})
+				}
+			}
+			return true
+		})
+	}
+
+	// Type-check the package. Errors are collected so that checking continues
+	// and only those within field assignments are reported.
+	var errs []types.Error
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	config := types.Config{
+		Importer: vetImporter{importer.ForCompiler(fset, "source", nil).(types.ImporterFrom), dir},
+		Error:    func(err error) { errs = append(errs, err.(types.Error)) },
+	}
+	tpkg, _ := config.Check(templates[0].Package, fset, files, info)
+
+	var a []*Warning
+	for _, f := range files {
+		ast.Inspect(f, func(node ast.Node) bool {
+			stmt, ok := node.(*ast.AssignStmt)
+			if !ok || len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 {
+				return true
+			}
+			sel, ok := stmt.Lhs[0].(*ast.SelectorExpr)
+			if ident, ok2 := sel.X.(*ast.Ident); !ok || !ok2 || ident.Name != "EGO" {
+				return true
+			}
+			field := vetFindField(fields[vetFieldKey(sel.Sel.Name, types.ExprString(stmt.Rhs[0]))], fset.Position(stmt.Pos()))
+			if field == nil {
+				return true
+			}
+
+			// Ignore components whose type could not be resolved.
+			typ := info.Types[sel.X].Type
+			if typ == nil || typ == types.Typ[types.Invalid] {
+				return true
+			}
+			name := strings.TrimPrefix(types.TypeString(typ, types.RelativeTo(tpkg)), "*")
+
+			if obj, _, _ := types.LookupFieldOrMethod(typ, true, tpkg, field.Name); obj == nil {
+				a = append(a, &Warning{Pos: field.NamePos, Message: fmt.Sprintf("Unknown field on component %s: %s", name, field.Name)})
+			} else if _, ok := obj.(*types.Var); !ok {
+				a = append(a, &Warning{Pos: field.NamePos, Message: fmt.Sprintf("Component %s has method, not field: %s", name, field.Name)})
+			} else if tv := info.Types[stmt.Rhs[0]]; tv.Type == nil || tv.Type == types.Typ[types.Invalid] {
+				return true // value errors are reported by the compiler
+			} else if err := vetFindError(errs, stmt.Rhs[0]); err != nil {
+				a = append(a, &Warning{Pos: field.ValuePos, Message: fmt.Sprintf("Cannot assign %s to field %s.%s of type %s: %s", field.Value, name, field.Name, types.TypeString(obj.Type(), types.RelativeTo(tpkg)), err.Msg)})
+			}
+			return true
+		})
+	}
+	return a, nil
+}
+
+// vetFieldKey returns the key of a field by name & value expression. Values
+// are compared by their formatted expression since generated code is
+// formatted. Returns a key of the name & unformatted value if the value does
+// not parse.
+func vetFieldKey(name, value string) string {
+	if expr, err := parser.ParseExpr(value); err == nil {
+		value = types.ExprString(expr)
+	}
+	return name + "=" + value
+}
+
+// vetField represents a component field & the position of its component.
+type vetField struct {
+	*Field
+	pos Pos
+}
+
+// vetFindField returns the field of the last component in the template at or
+// before pos. Fields are written after the line directive of their component
+// so their generated position is never before the component.
+func vetFindField(a []vetField, pos token.Position) *Field {
+	var other *vetField
+	for i := range a {
+		if a[i].pos.Path != pos.Filename || a[i].pos.LineNo > pos.Line {
+			continue
+		} else if other == nil || a[i].pos.LineNo >= other.pos.LineNo {
+			other = &a[i]
+		}
+	}
+	if other == nil {
+		return nil
+	}
+	return other.Field
+}
+
+// vetFindError returns the first type error positioned within expr.
+func vetFindError(errs []types.Error, expr ast.Expr) *types.Error {
+	for i := range errs {
+		if errs[i].Pos >= expr.Pos() && errs[i].Pos <= expr.End() {
+			return &errs[i]
+		}
+	}
+	return nil
+}
+
+// vetImporter imports packages relative to the package directory instead of
+// the directory of each file, which line directives point at templates.
+type vetImporter struct {
+	types.ImporterFrom
+	dir string
+}
+
+// ImportFrom imports the package at path relative to the package directory.
+func (imp vetImporter) ImportFrom(path, _ string, mode types.ImportMode) (*types.Package, error) {
+	return imp.ImporterFrom.ImportFrom(path, imp.dir, mode)
+}
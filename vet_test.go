@@ -0,0 +1,102 @@
+package ego_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that generated code is checked for likely mistakes.
+func TestVet(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		exp  []string
+	}{
+		{
+			name: "OK",
+			src:  "package foo\n\nfunc f() { fmt.Printf(\"%d%% %*s %v\\n\", 1, 2, \"x\", y); x = y; return }",
+		},
+		{
+			name: "Unreachable",
+			src:  "package foo\n\nfunc f() {\n//line tmpl.ego:5\nreturn\n//line tmpl.ego:6\nx()\n}",
+			exp:  []string{"Unreachable code at tmpl.ego:6"},
+		},
+		{
+			name: "Panic",
+			src:  "package foo\n\nfunc f() {\nswitch {\ncase true:\npanic(1)\nx()\n}\n}",
+			exp:  []string{"Unreachable code at :7"},
+		},
+		{
+			name: "SelfAssign",
+			src:  "package foo\n\nfunc f() {\n//line tmpl.ego:2\nr.Name = r.Name\n}",
+			exp:  []string{"Self-assignment of r.Name to r.Name at tmpl.ego:2"},
+		},
+		{
+			name: "Printf",
+			src:  "package foo\n\nfunc f() {\n//line tmpl.ego:3\n_, _ = fmt.Fprintf(w, \"%s-%d\", x)\n}",
+			exp:  []string{`fmt.Fprintf format "%s-%d" reads 2 arg(s), but call has 1 at tmpl.ego:3`},
+		},
+		{
+			name: "PrintfIndexed",
+			src:  "package foo\n\nfunc f() { fmt.Printf(\"%[1]s %[1]s\", x) }",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := ego.Vet([]byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var a []string
+			for _, w := range warnings {
+				a = append(a, w.String())
+			}
+			if strings.Join(a, "\n") != strings.Join(tt.exp, "\n") {
+				t.Fatalf("unexpected warnings: %q", a)
+			}
+		})
+	}
+}
+
+// Ensure that component fields are type-checked against their components.
+func TestVetFields(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping type-checking in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "ego-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "button.go"), []byte("package main\n\nimport (\n\t\"context\"\n\t\"io\"\n)\n\ntype Button struct {\n\tTitle string\n\tCount int\n}\n\nfunc (r *Button) Render(ctx context.Context, w io.Writer) {}\n\nfunc (r *Button) Reset() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := ego.Parse(strings.NewReader("<%\npackage main\n\nfunc Page(ctx context.Context, w io.Writer, n int) {\n%>\n<ego:Button Title=\"ok\" Count=n />\n<ego:Button\n\tTitel=\"typo\"\n\tCount=\"many\" />\n<ego:Button Reset=nil Count=n+1 />\n<% } %>\n"), "page.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Package = "main"
+
+	warnings, err := ego.VetFields(dir, []*ego.Template{tmpl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a []string
+	for _, w := range warnings {
+		a = append(a, w.String())
+	}
+	if exp := []string{
+		"Unknown field on component Button: Titel at page.ego:8",
+		`Cannot assign "many" to field Button.Count of type int: cannot use "many" (untyped string constant) as int value in assignment at page.ego:9`,
+		"Component Button has method, not field: Reset at page.ego:10",
+	}; strings.Join(a, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected warnings: %q", a)
+	}
+}
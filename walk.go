@@ -0,0 +1,58 @@
+package ego
+
+// Visitor's Visit method is invoked for each block encountered by Walk. If
+// the result visitor w is not nil, Walk visits each of the nested blocks of
+// the block with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(blk Block) (w Visitor)
+}
+
+// Walk traverses the blocks in depth-first order. For each block, it calls
+// v.Visit(blk) and then walks the block's attribute blocks and yielded blocks
+// with the returned visitor, if it is not nil.
+func Walk(blocks []Block, v Visitor) {
+	for _, blk := range blocks {
+		walk(blk, v)
+	}
+}
+
+func walk(blk Block, v Visitor) {
+	if v = v.Visit(blk); v == nil {
+		return
+	}
+
+	switch blk := blk.(type) {
+	case *ComponentStartBlock:
+		for _, attrBlock := range blk.AttrBlocks {
+			walk(attrBlock, v)
+		}
+		Walk(blk.Yield, v)
+	case *AttrStartBlock:
+		Walk(blk.Yield, v)
+	case *AppendStartBlock:
+		Walk(blk.Yield, v)
+	case *FormatStartBlock:
+		Walk(blk.Yield, v)
+	case *ContentStartBlock:
+		Walk(blk.Yield, v)
+	case *YieldStartBlock:
+		Walk(blk.Yield, v)
+	}
+	v.Visit(nil)
+}
+
+type inspector func(Block) bool
+
+func (f inspector) Visit(blk Block) Visitor {
+	if f(blk) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the blocks in depth-first order. It starts by calling
+// f(blk) for each block. If f returns true, Inspect invokes f recursively for
+// each of the nested blocks of the block, followed by a call of f(nil).
+func Inspect(blocks []Block, f func(Block) bool) {
+	Walk(blocks, inspector(f))
+}
@@ -0,0 +1,78 @@
+package ego_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+const walkSrc = `<ego:Card><ego::Header>Title</ego::Header><%= body %><ego:Icon/></ego:Card><%json %><ego:Skipped>x</ego:Skipped><%/json %>`
+
+// visitor records the blocks it visits and skips the children of components
+// named "Skipped".
+type visitor struct {
+	a *[]string
+}
+
+func (v visitor) Visit(blk ego.Block) ego.Visitor {
+	switch blk := blk.(type) {
+	case nil:
+		*v.a = append(*v.a, "end")
+	case *ego.ComponentStartBlock:
+		*v.a = append(*v.a, "component:"+blk.Name)
+		if blk.Name == "Skipped" {
+			return nil
+		}
+	case *ego.AttrStartBlock:
+		*v.a = append(*v.a, "attr:"+blk.Name)
+	default:
+		*v.a = append(*v.a, fmt.Sprintf("%T", blk))
+	}
+	return v
+}
+
+// Ensure that blocks nested in components, attribute blocks & regions are walked.
+func TestWalk(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(walkSrc), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a []string
+	ego.Walk(tmpl.Blocks, visitor{a: &a})
+	if exp := []string{
+		"component:Card",
+		"attr:Header", "*ego.TextBlock", "end", "end",
+		"*ego.PrintBlock", "end",
+		"component:Icon", "end",
+		"end",
+		"*ego.FormatStartBlock",
+		"component:Skipped",
+		"end",
+	}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected blocks: %q", a)
+	}
+}
+
+// Ensure that Inspect skips the nested blocks of a block if f returns false.
+func TestInspect(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader(walkSrc), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a []string
+	ego.Inspect(tmpl.Blocks, func(blk ego.Block) bool {
+		if blk, ok := blk.(*ego.ComponentStartBlock); ok {
+			a = append(a, blk.Name)
+			return blk.Name != "Card"
+		}
+		return true
+	})
+	if exp := []string{"Card", "Skipped"}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected components: %q", a)
+	}
+}